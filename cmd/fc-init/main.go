@@ -6,9 +6,21 @@
 //  1. Mounts /proc, /sys, /dev/pts
 //  2. Reads /proc/cmdline and exports firework.env.KEY=VALUE and encoded
 //     firework.env64.KEY=VALUE pairs as environment variables for the child process.
-//  3. Execs the remainder of argv (os.Args[1:]), or falls back to
+//  3. Assigns the guest IPv6 address from firework.ipv6=ADDR/PREFIX,GATEWAY
+//     and the addresses of additional interfaces from firework.net.ethN=ADDR/PREFIX,
+//     which the kernel's ip= autoconfig cannot do.
+//  4. Execs the remainder of argv (os.Args[1:]), or falls back to
 //     /sbin/init if no arguments are given.
 //
+// When the kernel command line carries firework.exec_port=PORT (services with
+// an exec health check), fc-init instead stays PID 1: it starts the service as
+// a child, reaps orphans, forwards SIGTERM/SIGINT, and serves guest commands
+// for the agent over vsock on PORT. It exits with the service's exit code.
+//
+// With firework.job=1 (workloads of kind job) fc-init also supervises the
+// service, and once it exits prints firework.exit_status=CODE on the console
+// and reboots the guest, which stops the VM.
+//
 // Usage in kernel args:
 //
 //	init=/sbin/fc-init /path/to/service --flag
@@ -65,10 +77,16 @@ func main() {
 		os.Exit(1)
 	}
 	applyKernelSettings()
+	configureNetworks()
 	setHostname()
 	meta := loadRuntimeMetadata()
 	applyImageEnv(meta.Env)
 	exportFireworkEnv()
+	port, _ := execPortFromCmdline()
+	if job := jobFromCmdline(); port != 0 || job {
+		superviseService(meta, volumes, port, job)
+		return
+	}
 	execService(meta, volumes)
 }
 
@@ -291,12 +309,17 @@ func parseFireworkEnvArg(arg string) (key, val string, ok bool, err error) {
 	return key, val, true, nil
 }
 
-// execService execs argv[1:] if provided, otherwise /sbin/init.
-func execService(meta runtimeMetadata, volumes []guestVolume) {
-	argv := os.Args[1:]
-	if len(argv) == 0 {
-		argv = []string{"/sbin/init"}
+// serviceArgv returns argv[1:] if provided, otherwise /sbin/init.
+func serviceArgv() []string {
+	if len(os.Args) > 1 {
+		return os.Args[1:]
 	}
+	return []string{"/sbin/init"}
+}
+
+// execService execs the service, replacing fc-init.
+func execService(meta runtimeMetadata, volumes []guestVolume) {
+	argv := serviceArgv()
 
 	if meta.Workdir != "" {
 		if err := os.Chdir(meta.Workdir); err != nil {
@@ -335,21 +358,41 @@ func resolveBinary(bin string) string {
 }
 
 func applyUserSpec(spec string, writablePaths []string, volumes []guestVolume) error {
-	uid, gid, username, home, err := resolveUserSpec(spec)
+	uid, gid, err := prepareUserSpec(spec, writablePaths, volumes)
 	if err != nil {
 		return err
 	}
 
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid(%d): %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid(%d): %w", uid, err)
+	}
+	return nil
+}
+
+// prepareUserSpec resolves spec, hands writable paths and volume roots to the
+// user, and exports HOME and USER. It does not drop privileges itself.
+func prepareUserSpec(spec string, writablePaths []string, volumes []guestVolume) (uid, gid int, err error) {
+	uid, gid, username, home, err := resolveUserSpec(spec)
+	if err != nil {
+		return 0, 0, err
+	}
+
 	volumePaths := make([]string, 0, len(volumes))
 	for _, volume := range volumes {
 		volumePaths = append(volumePaths, volume.MountPath)
 	}
 	if err := ensureWritablePaths(writablePaths, volumePaths, uid, gid); err != nil {
-		return err
+		return 0, 0, err
 	}
 	for _, path := range volumePaths {
 		if err := os.Chown(path, uid, gid); err != nil {
-			return fmt.Errorf("chown volume root %s: %w", path, err)
+			return 0, 0, fmt.Errorf("chown volume root %s: %w", path, err)
 		}
 	}
 
@@ -359,17 +402,7 @@ func applyUserSpec(spec string, writablePaths []string, volumes []guestVolume) e
 	if username != "" {
 		_ = os.Setenv("USER", username)
 	}
-
-	if err := syscall.Setgroups([]int{gid}); err != nil {
-		return fmt.Errorf("setgroups: %w", err)
-	}
-	if err := syscall.Setgid(gid); err != nil {
-		return fmt.Errorf("setgid(%d): %w", gid, err)
-	}
-	if err := syscall.Setuid(uid); err != nil {
-		return fmt.Errorf("setuid(%d): %w", uid, err)
-	}
-	return nil
+	return uid, gid, nil
 }
 
 // ensureWritablePaths recursively changes ownership for paths that apps need
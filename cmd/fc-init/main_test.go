@@ -3,10 +3,13 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestResolveUserSpec_NumericUIDDefaultsGID(t *testing.T) {
@@ -37,6 +40,42 @@ func TestParseVolumePayload(t *testing.T) {
 	}
 }
 
+func TestParseIPv6Arg(t *testing.T) {
+	addr, gateway, ok, err := parseIPv6Arg("console=ttyS0 ip=172.16.0.2::172.16.0.1:255.255.255.0::eth0:off firework.ipv6=fd00:f1::2/64,fd00:f1::1 init=/sbin/fc-init")
+	if err != nil || !ok {
+		t.Fatalf("parseIPv6Arg returned ok=%v err=%v", ok, err)
+	}
+	if addr.String() != "fd00:f1::2/64" || gateway.String() != "fd00:f1::1" {
+		t.Fatalf("unexpected address %s via %s", addr, gateway)
+	}
+
+	if _, _, ok, err := parseIPv6Arg("console=ttyS0"); ok || err != nil {
+		t.Fatalf("expected no IPv6 argument, got ok=%v err=%v", ok, err)
+	}
+	if _, _, _, err := parseIPv6Arg("firework.ipv6=172.16.0.2/24,172.16.0.1"); err == nil {
+		t.Fatal("expected an IPv4 address to be rejected")
+	}
+}
+
+func TestParseNetArgs(t *testing.T) {
+	addrs, err := parseNetArgs("console=ttyS0 firework.net.eth1=10.50.0.2/24 firework.net.eth2=10.60.0.5/16 init=/sbin/fc-init")
+	if err != nil {
+		t.Fatalf("parseNetArgs: %v", err)
+	}
+	if len(addrs) != 2 || addrs[0].iface != "eth1" || addrs[0].addr.String() != "10.50.0.2/24" ||
+		addrs[1].iface != "eth2" || addrs[1].addr.String() != "10.60.0.5/16" {
+		t.Fatalf("unexpected addresses %+v", addrs)
+	}
+
+	addrs, err = parseNetArgs("firework.net.eth0=10.50.0.2/24 firework.net.eth1=fd00::2/64 firework.net.eth2=10.50.0.3/24")
+	if err == nil {
+		t.Fatal("expected eth0 and an IPv6 address to be rejected")
+	}
+	if len(addrs) != 1 || addrs[0].iface != "eth2" {
+		t.Fatalf("expected the valid argument to survive, got %+v", addrs)
+	}
+}
+
 func TestParseVolumePayloadRejectsUnsafePath(t *testing.T) {
 	payload := volumePayload{Version: 1, Volumes: []guestVolume{{Name: "data", Device: "/dev/vdb", MountPath: "relative", Type: "local"}}}
 	data, _ := json.Marshal(payload)
@@ -111,3 +150,53 @@ func TestParseFireworkEnvArg_IgnoresNonEnvArg(t *testing.T) {
 		t.Fatal("expected non-env arg to be ignored")
 	}
 }
+
+func TestParseExecPort(t *testing.T) {
+	port, ok, err := parseExecPort("console=ttyS0 firework.exec_port=52 init=/sbin/fc-init -- firework.exec_port=9")
+	if err != nil || !ok || port != 52 {
+		t.Fatalf("parseExecPort = %d, %v, %v; want 52, true, nil", port, ok, err)
+	}
+	if _, ok, _ := parseExecPort("console=ttyS0 -- firework.exec_port=52"); ok {
+		t.Fatal("application arguments must not enable the command server")
+	}
+	if _, _, err := parseExecPort("firework.exec_port=zero"); err == nil {
+		t.Fatal("expected invalid port to fail")
+	}
+}
+
+func TestParseJobMode(t *testing.T) {
+	if !parseJobMode("console=ttyS0 firework.job=1 init=/sbin/fc-init") {
+		t.Fatal("expected job mode")
+	}
+	if parseJobMode("console=ttyS0 -- firework.job=1") {
+		t.Fatal("application arguments must not enable job mode")
+	}
+}
+
+// testReaper is shared: every reaper waits on any child, so two in one process
+// would steal each other's exit statuses.
+var testReaper = sync.OnceValue(newReaper)
+
+func TestReaperRunReportsExitCodeAndOutput(t *testing.T) {
+	r := testReaper()
+	code, output, err := r.run(context.Background(), []string{"/bin/sh", "-c", "echo not ready; exit 3"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 || string(output) != "not ready\n" {
+		t.Fatalf("run = %d, %q; want 3, \"not ready\\n\"", code, output)
+	}
+}
+
+func TestReaperRunKillsCommandOnTimeout(t *testing.T) {
+	r := testReaper()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := r.run(ctx, []string{"/bin/sh", "-c", "sleep 30"}, nil); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("timed-out command was not killed promptly (%s)", elapsed)
+	}
+}
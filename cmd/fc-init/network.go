@@ -0,0 +1,225 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fireworkIPv6Prefix carries the guest's IPv6 address and gateway as
+// firework.ipv6=ADDR/PREFIX,GATEWAY. The kernel's ip= autoconfig only
+// handles IPv4, so fc-init configures IPv6 itself.
+const fireworkIPv6Prefix = "firework.ipv6="
+
+// fireworkNetPrefix carries the addresses of the additional guest interfaces
+// as firework.net.ethN=ADDR/PREFIX. These networks are node-local, so the
+// interfaces get an address but no route beyond their subnet.
+const fireworkNetPrefix = "firework.net."
+
+// guestInterface is the guest network device on the default network.
+const guestInterface = "eth0"
+
+// interfaceAddr is an address fc-init assigns to a guest interface.
+type interfaceAddr struct {
+	iface string
+	addr  *net.IPNet
+}
+
+// configureNetworks assigns the addresses from the kernel command line the
+// kernel's ip= autoconfig cannot: IPv6 on eth0, routed through its gateway,
+// and the IPv4 addresses of the additional interfaces. Failures are logged
+// but not fatal, like the kernel settings: the service may still be
+// reachable on its primary address.
+func configureNetworks() {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: read /proc/cmdline: %v\n", err)
+		return
+	}
+	cmdline := string(data)
+
+	attachments, err := parseNetArgs(cmdline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: %v\n", err)
+	}
+	for _, a := range attachments {
+		if err := addGuestAddress(a.iface, a.addr, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "fc-init: configure %s: %v\n", a.iface, err)
+		}
+	}
+
+	addr, gateway, ok, err := parseIPv6Arg(cmdline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: %v\n", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	// Duplicate address detection would hold the address tentative for a
+	// second after boot; the agent already hands out unique addresses.
+	for _, setting := range []string{"disable_ipv6=0", "accept_dad=0", "accept_ra=0"} {
+		key, val, _ := strings.Cut(setting, "=")
+		path := fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/%s", guestInterface, key)
+		if err := os.WriteFile(path, []byte(val+"\n"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "fc-init: set %s: %v\n", path, err)
+		}
+	}
+	if err := addGuestAddress(guestInterface, addr, gateway); err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: configure IPv6 on %s: %v\n", guestInterface, err)
+	}
+}
+
+// parseIPv6Arg returns the address and gateway of the firework.ipv6 argument.
+func parseIPv6Arg(cmdline string) (*net.IPNet, net.IP, bool, error) {
+	for _, arg := range strings.Fields(cmdline) {
+		value, ok := strings.CutPrefix(arg, fireworkIPv6Prefix)
+		if !ok {
+			continue
+		}
+		cidr, gw, _ := strings.Cut(value, ",")
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ip.To4() != nil {
+			return nil, nil, false, fmt.Errorf("invalid %s address %q", fireworkIPv6Prefix, cidr)
+		}
+		gateway := net.ParseIP(gw)
+		if gateway == nil || gateway.To4() != nil {
+			return nil, nil, false, fmt.Errorf("invalid %s gateway %q", fireworkIPv6Prefix, gw)
+		}
+		return &net.IPNet{IP: ip, Mask: ipNet.Mask}, gateway, true, nil
+	}
+	return nil, nil, false, nil
+}
+
+// parseNetArgs returns the firework.net.ethN addresses. Malformed arguments
+// are reported but do not stop the valid ones from being applied.
+func parseNetArgs(cmdline string) ([]interfaceAddr, error) {
+	var (
+		addrs []interfaceAddr
+		errs  []error
+	)
+	for _, arg := range strings.Fields(cmdline) {
+		value, ok := strings.CutPrefix(arg, fireworkNetPrefix)
+		if !ok {
+			continue
+		}
+		iface, cidr, _ := strings.Cut(value, "=")
+		if !strings.HasPrefix(iface, "eth") || iface == guestInterface {
+			errs = append(errs, fmt.Errorf("invalid %s interface %q", fireworkNetPrefix, iface))
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("invalid %s%s address %q", fireworkNetPrefix, iface, cidr))
+			continue
+		}
+		addrs = append(addrs, interfaceAddr{iface: iface, addr: &net.IPNet{IP: ip.To4(), Mask: ipNet.Mask}})
+	}
+	return addrs, errors.Join(errs...)
+}
+
+// addGuestAddress brings the interface up and assigns addr to it. A non-nil
+// gateway also installs a default route through it.
+func addGuestAddress(iface string, addr *net.IPNet, gateway net.IP) error {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("opening rtnetlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("binding rtnetlink socket: %w", err)
+	}
+
+	up := make([]byte, unix.SizeofIfInfomsg)
+	up[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(up[4:8], uint32(link.Index))
+	binary.NativeEndian.PutUint32(up[8:12], unix.IFF_UP)
+	binary.NativeEndian.PutUint32(up[12:16], unix.IFF_UP)
+	if err := netlinkRequest(fd, unix.RTM_NEWLINK, 0, up); err != nil {
+		return fmt.Errorf("setting %s up: %w", iface, err)
+	}
+
+	family, ip := byte(unix.AF_INET6), addr.IP.To16()
+	if v4 := addr.IP.To4(); v4 != nil {
+		family, ip = unix.AF_INET, v4
+	}
+	ones, _ := addr.Mask.Size()
+	msg := []byte{family, byte(ones), unix.IFA_F_NODAD, unix.RT_SCOPE_UNIVERSE}
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(link.Index))
+	msg = append(msg, rtattr(unix.IFA_LOCAL, ip)...)
+	msg = append(msg, rtattr(unix.IFA_ADDRESS, ip)...)
+	if err := netlinkRequest(fd, unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, msg); err != nil {
+		return fmt.Errorf("adding address %s: %w", addr, err)
+	}
+	if gateway == nil {
+		return nil
+	}
+
+	route := []byte{
+		family, 0, 0, 0, // family, dst_len, src_len, tos
+		unix.RT_TABLE_MAIN, unix.RTPROT_BOOT, unix.RT_SCOPE_UNIVERSE, unix.RTN_UNICAST,
+		0, 0, 0, 0, // flags
+	}
+	route = append(route, rtattr(unix.RTA_GATEWAY, gateway.To16())...)
+	route = append(route, rtattr(unix.RTA_OIF, binary.NativeEndian.AppendUint32(nil, uint32(link.Index)))...)
+	if err := netlinkRequest(fd, unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, route); err != nil {
+		return fmt.Errorf("adding default route via %s: %w", gateway, err)
+	}
+	return nil
+}
+
+// rtattr encodes a route attribute, padded to the netlink alignment.
+func rtattr(typ uint16, data []byte) []byte {
+	length := unix.SizeofRtAttr + len(data)
+	b := binary.NativeEndian.AppendUint16(nil, uint16(length))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, data...)
+	for len(b)%unix.NLMSG_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// netlinkRequest sends one rtnetlink request and waits for its acknowledgement.
+func netlinkRequest(fd int, typ uint16, flags uint16, body []byte) error {
+	msg := binary.NativeEndian.AppendUint32(nil, uint32(unix.NLMSG_HDRLEN+len(body)))
+	msg = binary.NativeEndian.AppendUint16(msg, typ)
+	msg = binary.NativeEndian.AppendUint16(msg, unix.NLM_F_REQUEST|unix.NLM_F_ACK|flags)
+	msg = binary.NativeEndian.AppendUint32(msg, 1)
+	msg = binary.NativeEndian.AppendUint32(msg, 0)
+	msg = append(msg, body...)
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+	replies, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if reply.Header.Type == unix.NLMSG_ERROR && len(reply.Data) >= 4 {
+			if errno := int32(binary.NativeEndian.Uint32(reply.Data[:4])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no acknowledgement for request %d", typ)
+}
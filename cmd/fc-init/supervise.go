@@ -0,0 +1,340 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/guestexec"
+	"golang.org/x/sys/unix"
+)
+
+// defaultCommandTimeout bounds guest commands whose request carries none.
+const defaultCommandTimeout = 30 * time.Second
+
+// The agent marks jobs with jobKernelArg. Firecracker exits cleanly on any
+// guest reboot, so fc-init reports a job's exit status on the console,
+// prefixed with exitStatusPrefix, before rebooting.
+const (
+	jobKernelArg     = "firework.job=1"
+	exitStatusPrefix = "firework.exit_status="
+)
+
+// execPortFromCmdline returns the vsock port of the command server when the
+// agent enabled it on the kernel command line.
+func execPortFromCmdline() (uint32, bool) {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: read /proc/cmdline: %v\n", err)
+		return 0, false
+	}
+	port, ok, err := parseExecPort(string(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: %v\n", err)
+		return 0, false
+	}
+	return port, ok
+}
+
+func parseExecPort(cmdline string) (uint32, bool, error) {
+	for _, arg := range strings.Fields(cmdline) {
+		if arg == "--" {
+			break
+		}
+		value, ok := strings.CutPrefix(arg, guestexec.KernelArg+"=")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || port == 0 {
+			return 0, false, fmt.Errorf("invalid %s %q", guestexec.KernelArg, value)
+		}
+		return uint32(port), true, nil
+	}
+	return 0, false, nil
+}
+
+// jobFromCmdline reports whether the agent runs the service as a job.
+func jobFromCmdline() bool {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: read /proc/cmdline: %v\n", err)
+		return false
+	}
+	return parseJobMode(string(data))
+}
+
+func parseJobMode(cmdline string) bool {
+	for _, arg := range strings.Fields(cmdline) {
+		if arg == "--" {
+			break
+		}
+		if arg == jobKernelArg {
+			return true
+		}
+	}
+	return false
+}
+
+// superviseService runs the service as a child of fc-init rather than
+// exec-ing it, so fc-init can keep serving guest commands and report how a
+// job ended. Commands run with the same user, environment, and working
+// directory as the service. A port of 0 serves no commands.
+func superviseService(meta runtimeMetadata, volumes []guestVolume, port uint32, job bool) {
+	argv := serviceArgv()
+
+	if meta.Workdir != "" {
+		if err := os.Chdir(meta.Workdir); err != nil {
+			fmt.Fprintf(os.Stderr, "fc-init: chdir %s: %v\n", meta.Workdir, err)
+		}
+	}
+
+	var cred *syscall.Credential
+	if spec := strings.TrimSpace(meta.User); spec != "" {
+		uid, gid, err := prepareUserSpec(spec, meta.WritablePaths, volumes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fc-init: apply user %q: %v\n", spec, err)
+			os.Exit(1)
+		}
+		cred = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{uint32(gid)}}
+	}
+	exit := os.Exit
+	if job {
+		exit = finishJob
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	r := newReaper()
+	bin := resolveBinary(argv[0])
+	pid, done, err := r.start(bin, argv, &os.ProcAttr{
+		Env:   os.Environ(),
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   &syscall.SysProcAttr{Credential: cred},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: start %s: %v\n", bin, err)
+		exit(127)
+	}
+
+	if port != 0 {
+		if ln, err := listenVsock(port); err != nil {
+			// The service still runs; exec health checks will report the failure.
+			fmt.Fprintf(os.Stderr, "fc-init: command server on vsock port %d: %v\n", port, err)
+		} else {
+			go serveCommands(ln, func(ctx context.Context, argv []string) (int, []byte, error) {
+				return r.run(ctx, argv, cred)
+			})
+		}
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			_ = syscall.Kill(pid, sig.(syscall.Signal))
+		case status := <-done:
+			exit(exitCode(status))
+		}
+	}
+}
+
+// finishJob reports the exit status of a job on the console and reboots the
+// guest, which stops the VM. The leading newline keeps the status on a line
+// of its own when the job's last output did not end with one.
+func finishJob(code int) {
+	fmt.Fprintf(os.Stdout, "\n%s%d\n", exitStatusPrefix, code)
+	unix.Sync()
+	if err := unix.Reboot(unix.LINUX_REBOOT_CMD_RESTART); err != nil {
+		fmt.Fprintf(os.Stderr, "fc-init: reboot: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+func serveCommands(ln *vsockListener, run guestexec.RunFunc) {
+	for {
+		conn, err := ln.accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fc-init: command server stopped: %v\n", err)
+			return
+		}
+		go guestexec.ServeConn(conn, run, defaultCommandTimeout)
+	}
+}
+
+// reaper is the only caller of wait in the guest. As PID 1, fc-init inherits
+// every orphan; children it started itself are handed their status through
+// a channel, all others are simply reaped.
+type reaper struct {
+	mu      sync.Mutex
+	waiters map[int]chan syscall.WaitStatus
+}
+
+func newReaper() *reaper {
+	r := &reaper{waiters: make(map[int]chan syscall.WaitStatus)}
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	go func() {
+		for range sigchld {
+			r.reap()
+		}
+	}()
+	return r
+}
+
+// start launches a process and registers it for its exit status. The lock is
+// held across the start so reap cannot discard the status of a process that
+// exits before it is registered.
+func (r *reaper) start(bin string, argv []string, attr *os.ProcAttr) (int, <-chan syscall.WaitStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	proc, err := os.StartProcess(bin, argv, attr)
+	if err != nil {
+		return 0, nil, err
+	}
+	done := make(chan syscall.WaitStatus, 1)
+	r.waiters[proc.Pid] = done
+	pid := proc.Pid
+	_ = proc.Release()
+	return pid, done, nil
+}
+
+// reap collects every exited child. SIGCHLD deliveries coalesce, so it loops
+// until no more children are waiting.
+func (r *reaper) reap() {
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return
+		}
+		r.mu.Lock()
+		done, ok := r.waiters[pid]
+		delete(r.waiters, pid)
+		r.mu.Unlock()
+		if ok {
+			done <- status
+		}
+	}
+}
+
+// run executes a guest command in its own process group and returns its exit
+// code and combined output. The group is killed when the command exits or
+// ctx expires, so stray background processes cannot hold the output pipe.
+func (r *reaper) run(ctx context.Context, argv []string, cred *syscall.Credential) (int, []byte, error) {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return -1, nil, err
+	}
+	defer devNull.Close()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return -1, nil, err
+	}
+	defer pr.Close()
+
+	bin := resolveBinary(argv[0])
+	pid, done, err := r.start(bin, argv, &os.ProcAttr{
+		Env:   os.Environ(),
+		Files: []*os.File{devNull, pw, pw},
+		Sys:   &syscall.SysProcAttr{Credential: cred, Setpgid: true},
+	})
+	pw.Close()
+	if err != nil {
+		return -1, nil, fmt.Errorf("start %s: %w", bin, err)
+	}
+
+	var output bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(&limitedWriter{buf: &output, max: guestexec.MaxOutputBytes}, pr)
+		close(copied)
+	}()
+
+	select {
+	case status := <-done:
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
+		<-copied
+		return exitCode(status), output.Bytes(), nil
+	case <-ctx.Done():
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
+		<-done
+		<-copied
+		return -1, output.Bytes(), fmt.Errorf("command timed out")
+	}
+}
+
+// exitCode maps a wait status to a shell-style exit code.
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// limitedWriter keeps the first max bytes and discards the rest, so a chatty
+// command cannot block on a full pipe.
+type limitedWriter struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if room := w.max - w.buf.Len(); room > 0 {
+		if len(p) > room {
+			w.buf.Write(p[:room])
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// vsockListener is a listening AF_VSOCK socket. The net package cannot wrap
+// vsock descriptors, so connections are returned as plain files.
+type vsockListener struct {
+	fd int
+}
+
+func listenVsock(port uint32) (*vsockListener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_ANY, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+	if err := unix.Listen(fd, 16); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return &vsockListener{fd: fd}, nil
+}
+
+func (l *vsockListener) accept() (*os.File, error) {
+	for {
+		nfd, _, err := unix.Accept4(l.fd, unix.SOCK_CLOEXEC)
+		if errors.Is(err, unix.EINTR) || errors.Is(err, unix.ECONNABORTED) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return os.NewFile(uintptr(nfd), "vsock"), nil
+	}
+}
@@ -13,10 +13,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 
+	"github.com/artemnikitin/firework/internal/config"
 	"github.com/artemnikitin/firework/internal/controlplane"
 	"github.com/artemnikitin/firework/internal/version"
 	"gopkg.in/yaml.v3"
@@ -36,7 +38,7 @@ type apiClient struct {
 
 var (
 	nodeStates    = []string{"ready", "draining", "down", "stale", "unknown"}
-	serviceStates = []string{"pending", "running", "stopped", "failed", "unknown"}
+	serviceStates = []string{"pending", "running", "scheduled", "succeeded", "stopped", "failed", "unknown"}
 	serviceHealth = []string{"healthy", "unhealthy", "unknown", "not_configured"}
 )
 
@@ -279,6 +281,24 @@ func runService(cfg cliConfig, args []string, out io.Writer) error {
 		}
 		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
 		fmt.Fprintf(w, "SERVICE\t%s\nNODE\t%s\nDESIRED NODE\t%s\nACTUAL NODE\t%s\nSTATE\t%s\nHEALTH\t%s\nVCPU\t%d\nMEMORY\t%d MB\nIMAGE\t%s\nKERNEL\t%s\nPID\t%d\nRESTARTS\t%d\nSERVICE OBSERVED\t%s\nLAST TRANSITION\t%s\nREASON\t%s\nMESSAGE\t%s\nNETWORK ADDRESS\t%s\nROUTING HOSTNAME\t%s\n", response.Name, valueOrDash(response.Node), valueOrDash(response.DesiredNode), valueOrDash(response.ActualNode), response.State, response.Health, response.VCPUs, response.MemoryMB, valueOrDash(response.DesiredImage), valueOrDash(response.DesiredKernel), response.PID, response.RestartCount, formatTime(response.ServiceObservedAt), formatTime(response.LastTransitionAt), valueOrDash(response.ReasonCode), valueOrDash(response.Message), valueOrDash(response.NetworkAddress), valueOrDash(response.RoutingHostname))
+		if response.ReplicaOf != "" {
+			fmt.Fprintf(w, "REPLICA OF\t%s\n", response.ReplicaOf)
+		}
+		if response.CPUPercent != nil {
+			fmt.Fprintf(w, "CPU\t%d%%\n", *response.CPUPercent)
+		}
+		if as := response.Autoscaling; as != nil {
+			fmt.Fprintf(w, "REPLICAS\t%d (min %d, max %d)\nTARGET CPU\t%d%%\nCOOLDOWN\t%s\nLAST SCALED\t%s\n", as.Replicas, as.MinReplicas, as.MaxReplicas, as.TargetCPUUtilization, as.Cooldown, formatTime(as.LastScaledAt))
+		}
+		if response.Schedule != "" {
+			fmt.Fprintf(w, "KIND\t%s\nSCHEDULE\t%s\nCONCURRENCY POLICY\t%s\nMISSED RUNS\t%d\n", response.Kind, response.Schedule, valueOrDash(response.ConcurrencyPolicy), response.MissedRuns)
+		} else if response.Kind == config.KindJob {
+			exitCode := "-"
+			if response.ExitCode != nil {
+				exitCode = strconv.Itoa(*response.ExitCode)
+			}
+			fmt.Fprintf(w, "KIND\t%s\nEXIT CODE\t%s\nFINISHED\t%s\n", response.Kind, exitCode, formatTime(response.FinishedAt))
+		}
 		hc := response.HealthCheck
 		fmt.Fprintf(w, "HEALTH CHECK\t%s\nHEALTH CHECK STATE\t%s\nHEALTH CHECKED\t%s\nHEALTH FAILURES\t%d\nHEALTH LAST ERROR\t%s\n", valueOrDash(hc.Type), valueOrUnknown(hc.State), formatTime(hc.LastCheckedAt), hc.Failures, valueOrDash(hc.LastError))
 		fmt.Fprintf(w, "DESIRED REVISION\t%s\nPLACEMENT REVISION\t%s\nRENDERED REVISION\t%s\nAPPLIED REVISION\t%s\n", valueOrDash(response.DesiredRevision), valueOrDash(response.PlacementRevision), valueOrDash(response.RenderedRevision), valueOrDash(response.AppliedRevision))
@@ -297,6 +317,22 @@ func runService(cfg cliConfig, args []string, out io.Writer) error {
 					volume.ResizeGeneration, volume.State, valueOrDash(volume.LastError))
 			}
 		}
+		if len(response.Runs) > 0 {
+			fmt.Fprintln(w, "\nRUN\tSTATE\tSCHEDULED\tNODE\tEXIT CODE\tFINISHED\tMESSAGE")
+			for _, run := range response.Runs {
+				exitCode := "-"
+				if run.ExitCode != nil {
+					exitCode = strconv.Itoa(*run.ExitCode)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", run.ID, run.State, formatTime(run.ScheduledAt), valueOrDash(run.Node), exitCode, formatTime(run.FinishedAt), valueOrDash(run.Message))
+			}
+		}
+		if response.Autoscaling != nil && len(response.Autoscaling.Decisions) > 0 {
+			fmt.Fprintln(w, "\nDECIDED\tFROM\tTO\tREASON\tAVERAGE CPU\tSAMPLES\tMESSAGE")
+			for _, decision := range response.Autoscaling.Decisions {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d%%\t%d\t%s\n", formatTime(decision.DecidedAt), decision.From, decision.To, decision.ReasonCode, decision.AverageCPU, decision.Samples, valueOrDash(decision.Message))
+			}
+		}
 		return w.Flush()
 	})
 }
@@ -519,7 +555,7 @@ func printCommandUsage(out io.Writer, command string) {
 	usage := map[string]string{
 		"nodes":    "Usage: fireworkctl nodes [--state ready|draining|down|stale|unknown] [--output table|json] [--watch 5s]\n",
 		"node":     "Usage: fireworkctl node <node-id> [--output table|json] [--watch 5s]\n",
-		"services": "Usage: fireworkctl services [--state pending|running|stopped|failed|unknown] [--health healthy|unhealthy|unknown|not_configured] [--node NODE] [--output table|json] [--watch 5s]\n",
+		"services": "Usage: fireworkctl services [--state pending|running|scheduled|succeeded|stopped|failed|unknown] [--health healthy|unhealthy|unknown|not_configured] [--node NODE] [--output table|json] [--watch 5s]\n",
 		"service":  "Usage: fireworkctl service <service-name> [--output table|json] [--watch 5s]\n",
 	}
 	if text, ok := usage[command]; ok {
@@ -5,9 +5,12 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -57,6 +60,7 @@ type Agent struct {
 	registryClient *registryClient
 
 	lastRevision         string
+	adoptionDone         bool
 	lastKnownCapacity    capacity.NodeCapacity
 	hasLastKnownCapacity bool
 
@@ -67,10 +71,23 @@ type Agent struct {
 	heartbeatUsed  capacity.NodeCapacity
 	heartbeatReady bool // true once capacity has been read at least once
 
+	// migrationSeen records when each snapshot migration onto this node was
+	// first seen waiting for its snapshot. Only the reconcile loop uses it.
+	migrationSeen map[string]time.Time
+
+	// ipam holds the guest address leases, loaded from state_dir on first
+	// use by addressLeases. Only the reconcile loop uses it.
+	ipam *ipam
+
 	statusMu       sync.RWMutex
 	currentStatus  statusmodel.AgentStatus
 	statusServices []config.ServiceConfig
 	restartCounts  map[string]int
+	// balloonStats holds the last balloon statistics of each running
+	// ballooned service, refreshed by runBalloons.
+	balloonStats map[string]vm.BalloonStats
+	// cpu samples the CPU time of running VMs for their status.
+	cpu *cpuSampler
 }
 
 // New creates a new Agent with all its dependencies.
@@ -78,6 +95,12 @@ func New(cfg config.AgentConfig, s store.Store, logger *slog.Logger) *Agent {
 	metrics := newRuntimeMetrics(cfg.NodeName)
 	volumeMgr := volume.NewManagerWithObserver(cfg.NodeID, cfg.Storage, metrics)
 	vmMgr := vm.NewManagerWithVolumes(cfg.FirecrackerBin, cfg.StateDir, logger, volumeMgr)
+	if cfg.Jailer != nil {
+		vmMgr.SetJailer(*cfg.Jailer)
+	}
+	if cfg.ServiceLogs.MaxSizeBytes > 0 {
+		vmMgr.SetLogLimits(cfg.ServiceLogs.MaxSizeBytes, cfg.ServiceLogs.MaxFiles)
+	}
 	var agentRef *Agent
 
 	// Set up optional health check monitor.
@@ -89,34 +112,72 @@ func New(cfg config.AgentConfig, s store.Store, logger *slog.Logger) *Agent {
 				return nil
 			}
 			metrics.recordServiceRestart(name, tenantForService(inst.Config))
+			restartNow := true
 			if agentRef != nil {
 				agentRef.recordRestart(name)
+				restartNow = agentRef.reconciler.RecordHealthFailure(inst.Config)
 			}
 			if err := vmMgr.Stop(name); err != nil {
 				logger.Warn("failed to stop service during health restart", "service", name, "error", err)
 			}
+			if !restartNow {
+				// The reconcile loop starts it again once the restart
+				// backoff has passed, registering a new health check.
+				logger.Warn("service is crash looping; backing off before restart", "service", name)
+				healthMon.Deregister(name)
+				return nil
+			}
 			return vmMgr.Start(ctx, inst.Config)
 		}
 		healthMon = healthcheck.NewMonitor(logger, restartFn)
+		healthMon.SetExecSocket(vmMgr.ExecSocketPath)
 	}
 
 	// Set up optional network manager.
 	var networkMgr *network.Manager
 	if cfg.EnableNetworkSetup == nil || *cfg.EnableNetworkSetup {
 		networkMgr = network.NewManager(logger)
+		if fw, err := network.NewFirewall(cfg.FirewallBackend, logger); err != nil {
+			logger.Error("failed to set up firewall backend; using iptables", "backend", cfg.FirewallBackend, "error", err)
+		} else {
+			networkMgr.SetFirewall(fw)
+		}
 	}
 
 	rec := reconciler.New(vmMgr, logger, healthMon, networkMgr, cfg.UpdateStrategy, cfg.UpdateDelay)
 
+	if networkMgr != nil {
+		for _, n := range cfg.Networks {
+			if err := networkMgr.AddNetwork(n.Name, n.Bridge, n.Gateway, n.Subnet); err != nil {
+				logger.Error("failed to initialize network", "network", n.Name, "error", err)
+			}
+		}
+	}
+
 	// Initialize shared bridge and masquerade if network setup is enabled.
 	if networkMgr != nil && cfg.VMBridge != "" {
 		if err := networkMgr.InitBridge(cfg.VMBridge, cfg.VMGateway, cfg.VMSubnet); err != nil {
 			logger.Error("failed to initialize shared bridge", "error", err)
 		}
+		if cfg.VMSubnetV6 != "" {
+			if err := networkMgr.InitBridgeIPv6(cfg.VMGatewayV6, cfg.VMSubnetV6); err != nil {
+				logger.Error("failed to add IPv6 to shared bridge", "error", err)
+			}
+		}
 		if cfg.OutInterface != "" {
 			if err := networkMgr.SetupMasquerade(cfg.VMSubnet, cfg.OutInterface); err != nil {
 				logger.Error("failed to setup masquerade", "error", err)
 			}
+			if cfg.VMSubnetV6 != "" {
+				if err := networkMgr.SetupMasquerade(cfg.VMSubnetV6, cfg.OutInterface); err != nil {
+					logger.Error("failed to setup IPv6 masquerade", "error", err)
+				}
+			}
+		}
+		if cfg.TenantNetworkIsolation {
+			if err := networkMgr.EnableTenantIsolation(); err != nil {
+				logger.Error("failed to enable tenant network isolation", "error", err)
+			}
 		}
 	}
 
@@ -153,7 +214,9 @@ func New(cfg config.AgentConfig, s store.Store, logger *slog.Logger) *Agent {
 	// (not a typed-nil) when disabled so the a.traefikMgr == nil checks hold.
 	var traefikMgr routeSyncer
 	if cfg.TraefikConfigDir != "" {
-		traefikMgr = traefik.NewManager(cfg.TraefikConfigDir, cfg.IngressDomain, logger)
+		mgr := traefik.NewManager(cfg.TraefikConfigDir, cfg.IngressDomain, logger)
+		mgr.SetReplicaHealth(func(name string) bool { return agentRef.replicaServing(name) })
+		traefikMgr = mgr
 	}
 
 	a := &Agent{
@@ -169,6 +232,8 @@ func New(cfg config.AgentConfig, s store.Store, logger *slog.Logger) *Agent {
 		capacityReader: capReader,
 		traefikMgr:     traefikMgr,
 		restartCounts:  make(map[string]int),
+		migrationSeen:  make(map[string]time.Time),
+		cpu:            newCPUSampler(),
 		currentStatus: statusmodel.AgentStatus{
 			SchemaVersion: statusmodel.SchemaVersion,
 			NodeID:        cfg.NodeID,
@@ -177,6 +242,9 @@ func New(cfg config.AgentConfig, s store.Store, logger *slog.Logger) *Agent {
 		},
 	}
 	agentRef = a
+	if cfg.UpdateStrategy == reconciler.StrategySurge {
+		rec.SetSurge(surgeHooks{a: a}, cfg.UpdateHealthTimeout)
+	}
 	if cfg.RegistryURL != "" {
 		a.registryClient = newRegistryClient(cfg, logger, time.Now().UTC().UnixNano())
 	}
@@ -187,7 +255,7 @@ func New(cfg config.AgentConfig, s store.Store, logger *slog.Logger) *Agent {
 		if healthMon != nil {
 			healthProvider = &healthAdapter{mon: healthMon}
 		}
-		a.apiServer = api.NewServer(cfg.APIListenAddr, logger, a, healthProvider, a)
+		a.apiServer = api.NewServer(cfg.APIListenAddr, logger, a, healthProvider, a, vmMgr)
 	}
 
 	return a
@@ -216,6 +284,7 @@ func (a *Agent) Run(ctx context.Context) error {
 	// Start the independent heartbeat goroutine after the first tick so that
 	// the registry client is already enrolled and capacity is known.
 	go a.runHeartbeat(ctx)
+	go a.runBalloons(ctx)
 
 	ticker := time.NewTicker(a.cfg.PollInterval)
 	defer ticker.Stop()
@@ -287,6 +356,9 @@ func (a *Agent) shutdown() {
 	if a.healthMon != nil {
 		a.healthMon.Stop()
 	}
+	if a.cfg.SuspendOnShutdown {
+		a.suspendSnapshotVMs()
+	}
 	if a.apiServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -294,6 +366,20 @@ func (a *Agent) shutdown() {
 	}
 }
 
+// suspendSnapshotVMs snapshots and stops every running snapshot-mode VM so
+// the next agent resumes them, which also survives a host reboot. VMs whose
+// snapshot fails keep running and are adopted as usual.
+func (a *Agent) suspendSnapshotVMs() {
+	for name, inst := range a.vmManager.List() {
+		if inst.State != vm.StateRunning || !inst.Config.Snapshot {
+			continue
+		}
+		if err := a.vmManager.Suspend(context.Background(), name); err != nil {
+			a.logger.Error("failed to suspend microVM on shutdown", "service", name, "error", err)
+		}
+	}
+}
+
 // tick performs a single reconciliation cycle.
 func (a *Agent) tick(ctx context.Context) {
 	a.logger.Debug("reconciliation tick starting")
@@ -317,6 +403,9 @@ func (a *Agent) tick(ctx context.Context) {
 
 	// Fetch and merge configs from all node labels.
 	merged := a.fetchAndMerge(ctx)
+	// A draining node has no config of its own; snapshot handoffs to its
+	// peers are exported regardless.
+	a.exportMigrations(ctx)
 	if merged == nil {
 		a.failAgentStatus("ConfigFetched", "config_fetch_failed", "all config fetches failed")
 		// No local config, but still sync Traefik with remote nodes so that
@@ -332,6 +421,13 @@ func (a *Agent) tick(ctx context.Context) {
 	// during Fetch (Git pull, object write token) are evaluated against fresh data.
 	// For multi-label nodes we skip this optimization because revision is
 	// store-scoped, not label-scoped.
+	// Services migrating in from a draining node wait for their snapshot.
+	held := a.receiveMigrations(merged)
+	if dropped := dropScheduledJobs(merged); len(dropped) > 0 {
+		a.logger.Warn("ignoring cron jobs; schedules are run by the controller", "services", dropped)
+	}
+	stampJobRevisions(merged)
+
 	var rev string
 	if len(a.cfg.NodeNames) == 1 {
 		var err error
@@ -341,12 +437,13 @@ func (a *Agent) tick(ctx context.Context) {
 		}
 		a.setStatusServices(*merged, rev)
 		a.setStatusCondition("ConfigFetched", statusmodel.ConditionTrue, "", "")
-		if rev != "" && rev == a.lastRevision {
+		if rev != "" && rev == a.lastRevision && !a.reconciler.RestartsDue(merged.Services) {
 			// A local revision does not describe the peer node configs used for
 			// remote Traefik routes. Refresh routes on every poll even when the
 			// local VM configuration is unchanged, but avoid the expensive image
 			// sync and reconcile work. assignNetworking is needed because fetched
-			// node configs do not persist the agent-assigned guest IPs.
+			// node configs do not persist the agent-assigned guest IPs. A VM
+			// that is down and due for a restart still needs the full pass.
 			a.assignNetworking(merged.Services)
 			a.setStatusServices(*merged, rev)
 			if err := a.syncTraefikConfigs(ctx, merged.Services); err != nil {
@@ -383,11 +480,18 @@ func (a *Agent) tick(ctx context.Context) {
 	// Resolve service links: look up each linked service's guest IP and
 	// inject the composed URL into the dependent service's Env map.
 	a.resolveLinks(merged.Services)
+	a.allowLinks(merged.Services)
 
 	// Inject environment variables into kernel boot args so fc-init can
 	// parse them from /proc/cmdline and export them inside the guest.
 	a.injectEnvVars(merged.Services)
 
+	// Adopt VMs that survived an agent restart before the first reconcile so
+	// unchanged services keep running instead of being recreated.
+	if !a.adoptionDone {
+		a.adoptSurvivors(ctx, merged.Services)
+	}
+
 	// Check node capacity before reconciling; skip if resources are exceeded.
 	used := sumResources(merged.Services)
 	// Update the heartbeat goroutine with the latest used resources now, before
@@ -396,11 +500,17 @@ func (a *Agent) tick(ctx context.Context) {
 	if hasCap {
 		a.setHeartbeatResources(nodeCap, used)
 	}
-	if !a.checkCapacity(nodeCap, used, hasCap) {
+	admitted, ok := a.checkCapacity(nodeCap, merged.Services, a.existingVMs(), hasCap)
+	if !ok {
 		a.failAgentStatus("Reconciled", "capacity_exceeded", "desired services exceed node capacity")
 		a.syncRegistry(ctx, nodeCap, used)
 		return
 	}
+	// Ballooned services held back for memory start on a later tick, so the
+	// revision stays unconverged until they do.
+	held = held || len(admitted) < len(merged.Services)
+	desired := *merged
+	desired.Services = admitted
 
 	// Sync images from S3 before reconciling (ensures rootfs/kernels are present).
 	if a.imageSyncer != nil {
@@ -420,15 +530,24 @@ func (a *Agent) tick(ctx context.Context) {
 
 	// Reconcile desired vs actual state.
 	reconcileStart := time.Now()
-	err := a.reconciler.Reconcile(ctx, *merged)
+	err := a.reconciler.Reconcile(ctx, desired)
 	a.metrics.observeReconcile(time.Since(reconcileStart), err != nil)
+	// Surge updates promote replacements at new guest addresses; routes and
+	// status must follow them for the rest of this tick.
+	moved := a.syncRunningNetworks(merged.Services)
 	if err != nil {
 		a.logger.Error("reconciliation failed", "error", err)
-		a.failAgentStatus("Reconciled", "reconcile_failed", err.Error())
+		code := "reconcile_failed"
+		var healthErr *reconciler.UpdateHealthError
+		if errors.As(err, &healthErr) {
+			code = "update_unhealthy"
+		}
+		a.failAgentStatus("Reconciled", code, err.Error())
 		a.syncRegistry(ctx, nodeCap, used)
 		return
 	}
 	a.setStatusCondition("Reconciled", statusmodel.ConditionTrue, "", "")
+	a.vmManager.PruneSnapshots(merged.Services)
 
 	// Sync Traefik dynamic config files with desired services. An error here
 	// means the local routes were not applied and must not advance the
@@ -443,8 +562,11 @@ func (a *Agent) tick(ctx context.Context) {
 	}
 	a.setStatusCondition("RoutesReady", statusmodel.ConditionTrue, "", "")
 
-	// Update the last known revision on success.
-	if rev != "" {
+	// Update the last known revision on success. A service that moved to a new
+	// guest address still has dependents whose links point at the old one, so
+	// the revision is left unconverged for a full pass on the next tick. The
+	// same holds while a migrating service waits for its snapshot.
+	if rev != "" && !moved && !held {
 		a.lastRevision = rev
 	}
 	appliedRevision := rev
@@ -459,12 +581,36 @@ func (a *Agent) tick(ctx context.Context) {
 	a.logger.Debug("reconciliation tick completed", "revision", rev)
 }
 
+// adoptSurvivors hands the fully resolved desired services to the VM manager
+// so Firecracker processes left running by a previous agent process are put
+// back into the inventory, then restores their port forwards and health
+// checks. It runs once per agent process, on the first tick that reaches
+// reconciliation with a desired config.
+func (a *Agent) adoptSurvivors(ctx context.Context, services []config.ServiceConfig) {
+	a.adoptionDone = true
+	adopted := a.vmManager.Adopt(services)
+	if len(adopted) == 0 {
+		return
+	}
+	byName := make(map[string]config.ServiceConfig, len(services))
+	for _, svc := range services {
+		byName[svc.Name] = svc
+	}
+	restore := make([]config.ServiceConfig, 0, len(adopted))
+	for _, name := range adopted {
+		restore = append(restore, byName[name])
+	}
+	a.reconciler.Restore(ctx, restore)
+	a.logger.Info("adopted surviving microVMs", "count", len(adopted), "services", adopted)
+}
+
 // fetchAndMerge fetches configs for all node labels and merges services.
 // Returns nil if all fetches fail.
 func (a *Agent) fetchAndMerge(ctx context.Context) *config.NodeConfig {
 	seen := make(map[string]config.ServiceConfig)
 	var fetchedAny bool
 	var desiredRevision, placementRevision, renderedRevision string
+	var migrations []config.SnapshotMigration
 
 	for _, name := range a.cfg.NodeNames {
 		data, err := a.store.Fetch(ctx, name)
@@ -496,6 +642,7 @@ func (a *Agent) fetchAndMerge(ctx context.Context) *config.NodeConfig {
 			}
 			seen[svc.Name] = svc
 		}
+		migrations = append(migrations, nc.Migrations...)
 	}
 
 	if !fetchedAny {
@@ -518,6 +665,7 @@ func (a *Agent) fetchAndMerge(ctx context.Context) *config.NodeConfig {
 		DesiredRevision:   usableRevisionMetadata(desiredRevision),
 		PlacementRevision: usableRevisionMetadata(placementRevision),
 		RenderedRevision:  usableRevisionMetadata(renderedRevision),
+		Migrations:        migrations,
 	}
 }
 
@@ -546,8 +694,9 @@ func usableRevisionMetadata(revision string) string {
 }
 
 // assignNetworking fills in IP, MAC, and kernel IP boot args for services
-// that have networking enabled. Services must be sorted by name for
-// deterministic IP allocation.
+// that have networking enabled. Services with a running VM keep its address
+// and the others their lease; new services are allocated in list order, so
+// services must be sorted by name for deterministic allocation.
 func (a *Agent) assignNetworking(services []config.ServiceConfig) {
 	if a.cfg.VMSubnet == "" {
 		return
@@ -569,35 +718,349 @@ func (a *Agent) assignNetworking(services []config.ServiceConfig) {
 	}
 	ipNet.IP = ip
 
-	idx := 0
+	// Addresses stay with running VMs: a service whose VM is already up keeps
+	// the guest IP, MAC, and TAP it booted with, even when a surge update moved
+	// it off its original slot. Other services keep their lease, and the rest
+	// take the lowest slot that is neither leased nor cooling down.
+	// An address only stays within its tenant: a service that moved to
+	// another tenant gets a fresh one, so its VM is recreated in the new
+	// tenant's segment and no flow admitted for the old tenant carries over;
+	// the old address stays reserved while the old VM still holds it.
+	leases := a.addressLeases()
+	leases.expire()
+	defer func() {
+		if err := leases.save(); err != nil {
+			a.logger.Warn("failed to persist guest address leases", "error", err)
+		}
+	}()
+
+	current := a.runningNetworks()
+	tenants := a.runningTenants()
+	usedIPs := make(map[string]bool)
+	usedMACs := make(map[string]bool)
+	kept := make([]bool, len(services))
 	for i := range services {
 		svc := &services[i]
 		if svc.Network == nil {
 			continue
 		}
+		cur, ok := current[svc.Name]
+		if !ok || cur.GuestIP == "" || cur.GuestMAC == "" || usedIPs[cur.GuestIP] || usedMACs[cur.GuestMAC] {
+			continue
+		}
+		if tenant, ok := tenants[svc.Name]; ok && tenant != strings.TrimSpace(svc.Metadata["tenant"]) {
+			usedIPs[cur.GuestIP] = true
+			usedMACs[cur.GuestMAC] = true
+			continue
+		}
+		if parsed := net.ParseIP(cur.GuestIP); parsed == nil || !ipNet.Contains(parsed) {
+			continue
+		}
+		usedIPs[cur.GuestIP] = true
+		usedMACs[cur.GuestMAC] = true
+		kept[i] = true
+		if cur.Interface != "" {
+			svc.Network.Interface = cur.Interface
+		}
+		leases.bind(svc.Name, lease{IP: cur.GuestIP, MAC: cur.GuestMAC, Tenant: strings.TrimSpace(svc.Metadata["tenant"])})
+		a.applyGuestAddress(svc, cur.GuestIP, cur.GuestMAC, gateway, netmask)
+	}
 
-		guestIP, ok := guestIPv4ForIndex(ipNet, idx)
-		if !ok {
-			a.logger.Error("vm_subnet has no available guest IPs",
-				"subnet", a.cfg.VMSubnet, "assigned_services", idx)
-			return
+	desired := make(map[string]bool, len(services))
+	for i := range services {
+		svc := &services[i]
+		if svc.Network == nil {
+			continue
+		}
+		desired[svc.Name] = true
+		if kept[i] {
+			continue
+		}
+		tenant := strings.TrimSpace(svc.Metadata["tenant"])
+		if l, ok := leases.lookup(svc.Name); ok {
+			if parsed := net.ParseIP(l.IP); l.Tenant == tenant && parsed != nil && ipNet.Contains(parsed) && !usedIPs[l.IP] && !usedMACs[l.MAC] {
+				usedIPs[l.IP] = true
+				usedMACs[l.MAC] = true
+				kept[i] = true
+				a.applyGuestAddress(svc, l.IP, l.MAC, gateway, netmask)
+				continue
+			}
+			leases.release(svc.Name)
+		}
+	}
+	a.assignAttachments(services, leases, desired)
+	leases.retain(desired)
+
+	idx := 0
+	for i := range services {
+		svc := &services[i]
+		if svc.Network == nil || kept[i] {
+			continue
+		}
+
+		var guestIP string
+		for {
+			ip, ok := guestIPv4ForIndex(ipNet, idx)
+			if !ok {
+				a.logger.Error("vm_subnet has no available guest IPs",
+					"subnet", a.cfg.VMSubnet, "assigned_services", idx)
+				return
+			}
+			mac := guestMACForIndex(idx)
+			if !usedIPs[ip] && !usedMACs[mac] && !leases.reserved(ip, mac) {
+				guestIP = ip
+				break
+			}
+			idx++
 		}
 		mac := guestMACForIndex(idx)
+		leases.bind(svc.Name, lease{IP: guestIP, MAC: mac, Tenant: strings.TrimSpace(svc.Metadata["tenant"])})
+		a.applyGuestAddress(svc, guestIP, mac, gateway, netmask)
+		idx++
+	}
+}
 
-		svc.Network.GuestIP = guestIP
-		svc.Network.GuestMAC = mac
+// assignAttachments gives every attachment to an additional network an
+// address in that network's subnet, the way assignNetworking does for the
+// default network: running VMs keep theirs, other services keep their lease,
+// and the rest take the lowest free slot. Leases are keyed by service and
+// network, and each key is added to keep. Attachments to networks this node
+// does not define get no address; Setup rejects them.
+func (a *Agent) assignAttachments(services []config.ServiceConfig, leases *ipam, keep map[string]bool) {
+	type attachment struct {
+		svc *config.ServiceConfig
+		idx int
+		key string
+	}
+	running := a.runningAttachments()
+	tenants := a.runningTenants()
+
+	for n, vmNet := range a.cfg.Networks {
+		_, subnet, err := net.ParseCIDR(vmNet.Subnet)
+		if err != nil {
+			a.logger.Error("invalid network subnet", "network", vmNet.Name, "subnet", vmNet.Subnet, "error", err)
+			continue
+		}
+		prefixLen, _ := subnet.Mask.Size()
+		usedIPs := make(map[string]bool)
+		usedMACs := make(map[string]bool)
+		apply := func(att attachment, l lease) {
+			usedIPs[l.IP] = true
+			usedMACs[l.MAC] = true
+			leases.bind(att.key, l)
+			applyAttachmentAddress(att.svc, att.idx, l.IP, l.MAC, prefixLen)
+		}
 
-		// Add kernel IP autoconfig so the guest configures networking
-		// before init runs (no fc-init changes needed).
-		ipArg := fmt.Sprintf("ip=%s::%s:%s::eth0:off", guestIP, gateway, netmask)
-		if !hasKernelArgPrefix(svc.KernelArgs, "ip=") {
-			svc.KernelArgs = insertKernelArg(svc.KernelArgs, ipArg)
+		var pending []attachment
+		for i := range services {
+			svc := &services[i]
+			if svc.Network == nil {
+				continue
+			}
+			tenant := strings.TrimSpace(svc.Metadata["tenant"])
+			for j := range svc.Networks {
+				if svc.Networks[j].Name != vmNet.Name {
+					continue
+				}
+				att := attachment{svc: svc, idx: j, key: svc.Name + "/" + vmNet.Name}
+				keep[att.key] = true
+				cur, ok := running[att.key]
+				if !ok || cur.GuestIP == "" || usedIPs[cur.GuestIP] || usedMACs[cur.GuestMAC] {
+					pending = append(pending, att)
+					continue
+				}
+				if runningTenant, ok := tenants[svc.Name]; ok && runningTenant != tenant {
+					usedIPs[cur.GuestIP] = true
+					usedMACs[cur.GuestMAC] = true
+					pending = append(pending, att)
+					continue
+				}
+				if parsed := net.ParseIP(cur.GuestIP); parsed == nil || !subnet.Contains(parsed) {
+					pending = append(pending, att)
+					continue
+				}
+				apply(att, lease{IP: cur.GuestIP, MAC: cur.GuestMAC, Tenant: tenant})
+			}
 		}
 
-		idx++
+		var fresh []attachment
+		for _, att := range pending {
+			tenant := strings.TrimSpace(att.svc.Metadata["tenant"])
+			if l, ok := leases.lookup(att.key); ok {
+				if parsed := net.ParseIP(l.IP); l.Tenant == tenant && parsed != nil && subnet.Contains(parsed) && !usedIPs[l.IP] && !usedMACs[l.MAC] {
+					apply(att, l)
+					continue
+				}
+				leases.release(att.key)
+			}
+			fresh = append(fresh, att)
+		}
+
+		idx := 0
+	allocate:
+		for _, att := range fresh {
+			for {
+				ip, ok := guestIPv4ForIndex(subnet, idx)
+				if !ok {
+					a.logger.Error("network has no available guest IPs", "network", vmNet.Name, "subnet", vmNet.Subnet)
+					break allocate
+				}
+				mac := guestMACForNetwork(n+1, idx)
+				idx++
+				if !usedIPs[ip] && !usedMACs[mac] && !leases.reserved(ip, mac) {
+					apply(att, lease{IP: ip, MAC: mac, Tenant: strings.TrimSpace(att.svc.Metadata["tenant"])})
+					break
+				}
+			}
+		}
 	}
 }
 
+// applyAttachmentAddress records the address of svc's idx-th additional
+// network and passes it to fc-init, which configures eth<idx+1>.
+func applyAttachmentAddress(svc *config.ServiceConfig, idx int, guestIP, mac string, prefixLen int) {
+	att := &svc.Networks[idx]
+	att.GuestIP = guestIP
+	att.GuestMAC = mac
+
+	prefix := fmt.Sprintf("%seth%d=", guestNetArgPrefix, idx+1)
+	if !hasKernelArgPrefix(svc.KernelArgs, prefix) {
+		svc.KernelArgs = insertKernelArg(svc.KernelArgs, fmt.Sprintf("%s%s/%d", prefix, guestIP, prefixLen))
+	}
+}
+
+// guestNetArgPrefix starts the kernel arguments fc-init reads the addresses of
+// additional guest interfaces from: firework.net.eth1=ADDR/PREFIX.
+const guestNetArgPrefix = "firework.net."
+
+// runningAttachments returns the additional network attachments of every VM
+// the agent runs, keyed by "<service>/<network>".
+func (a *Agent) runningAttachments() map[string]config.NetworkConfig {
+	out := make(map[string]config.NetworkConfig)
+	if a.vmManager == nil {
+		return out
+	}
+	for name, inst := range a.vmManager.List() {
+		for _, att := range inst.Config.Networks {
+			out[name+"/"+att.Name] = att
+		}
+	}
+	return out
+}
+
+// addressLeases returns the agent's guest address leases, loading them from
+// state_dir on first use.
+func (a *Agent) addressLeases() *ipam {
+	if a.ipam == nil {
+		a.ipam = loadIPAM(filepath.Join(a.cfg.StateDir, ipamStateFile), a.cfg.IPLeaseGrace, a.cfg.IPReleaseCooldown, a.logger)
+	}
+	return a.ipam
+}
+
+// applyGuestAddress records the guest IP and MAC on svc and adds the kernel IP
+// autoconfig argument so the guest configures networking before init runs
+// (no fc-init changes needed). With vm_subnet_v6 the guest also gets the
+// matching IPv6 address, which only fc-init can configure.
+func (a *Agent) applyGuestAddress(svc *config.ServiceConfig, guestIP, mac, gateway, netmask string) {
+	svc.Network.GuestIP = guestIP
+	svc.Network.GuestMAC = mac
+
+	ipArg := guestIPKernelArg(guestIP, gateway, netmask)
+	if !hasKernelArgPrefix(svc.KernelArgs, "ip=") {
+		svc.KernelArgs = insertKernelArg(svc.KernelArgs, ipArg)
+	}
+
+	svc.Network.GuestIPv6 = a.guestIPv6(guestIP)
+	if svc.Network.GuestIPv6 != "" && !hasKernelArgPrefix(svc.KernelArgs, guestIPv6ArgPrefix) {
+		svc.KernelArgs = insertKernelArg(svc.KernelArgs, a.guestIPv6KernelArg(svc.Network.GuestIPv6))
+	}
+}
+
+func guestIPKernelArg(guestIP, gateway, netmask string) string {
+	return fmt.Sprintf("ip=%s::%s:%s::eth0:off", guestIP, gateway, netmask)
+}
+
+// guestIPv6ArgPrefix is the kernel argument fc-init reads the guest IPv6
+// address and gateway from.
+const guestIPv6ArgPrefix = "firework.ipv6="
+
+func (a *Agent) guestIPv6KernelArg(guestIPv6 string) string {
+	prefixLen := 64
+	if _, subnet6, err := net.ParseCIDR(a.cfg.VMSubnetV6); err == nil {
+		prefixLen, _ = subnet6.Mask.Size()
+	}
+	return fmt.Sprintf("%s%s/%d,%s", guestIPv6ArgPrefix, guestIPv6, prefixLen, stripCIDR(a.cfg.VMGatewayV6))
+}
+
+// guestIPv6 returns the IPv6 address at guestIP's offset in vm_subnet within
+// vm_subnet_v6, or "" when guests are IPv4 only. Deriving it keeps the IPv4
+// lease the only one to track.
+func (a *Agent) guestIPv6(guestIP string) string {
+	if a.cfg.VMSubnetV6 == "" {
+		return ""
+	}
+	_, subnet4, err := net.ParseCIDR(a.cfg.VMSubnet)
+	if err != nil {
+		return ""
+	}
+	_, subnet6, err := net.ParseCIDR(a.cfg.VMSubnetV6)
+	if err != nil || subnet6.IP.To4() != nil {
+		return ""
+	}
+	ip := net.ParseIP(guestIP).To4()
+	base := subnet4.IP.To4()
+	if ip == nil || base == nil || !subnet4.Contains(ip) {
+		return ""
+	}
+	offset := binary.BigEndian.Uint32(ip) - binary.BigEndian.Uint32(base)
+
+	candidate := make(net.IP, net.IPv6len)
+	copy(candidate, subnet6.IP)
+	binary.BigEndian.PutUint32(candidate[12:], binary.BigEndian.Uint32(candidate[12:])+offset)
+	if !subnet6.Contains(candidate) {
+		return ""
+	}
+	return candidate.String()
+}
+
+// runningNetworks returns the guest network of every VM the agent knows about,
+// keyed by service name. Until survivors have been adopted, the networks they
+// recorded are included too, so an agent restart reproduces their addresses.
+// Suspended VMs keep theirs so they can resume from their snapshot.
+func (a *Agent) runningNetworks() map[string]config.NetworkConfig {
+	out := make(map[string]config.NetworkConfig)
+	if a.vmManager == nil {
+		return out
+	}
+	for name, network := range a.vmManager.SuspendedNetworks() {
+		out[name] = network
+	}
+	if !a.adoptionDone {
+		for name, network := range a.vmManager.SurvivorNetworks() {
+			out[name] = network
+		}
+	}
+	for name, inst := range a.vmManager.List() {
+		if inst.Config.Network != nil {
+			out[name] = *inst.Config.Network
+		}
+	}
+	return out
+}
+
+// runningTenants returns the tenant of every VM the agent runs, keyed by
+// service name. Platform services map to "".
+func (a *Agent) runningTenants() map[string]string {
+	out := make(map[string]string)
+	if a.vmManager == nil {
+		return out
+	}
+	for name, inst := range a.vmManager.List() {
+		out[name] = strings.TrimSpace(inst.Config.Metadata["tenant"])
+	}
+	return out
+}
+
 func guestIPv4ForIndex(ipNet *net.IPNet, idx int) (string, bool) {
 	if idx < 0 || ipNet == nil {
 		return "", false
@@ -631,6 +1094,15 @@ func guestMACForIndex(idx int) string {
 		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
 }
 
+// guestMACForNetwork returns the MAC of slot idx on the network-th additional
+// network. The third octet holds the network, so MACs differ from the
+// default network's and between a VM's interfaces.
+func guestMACForNetwork(network, idx int) string {
+	n := uint32(idx + 1)
+	return fmt.Sprintf("AA:FC:%02X:%02X:%02X:%02X",
+		byte(network), byte(n>>16), byte(n>>8), byte(n))
+}
+
 // resolveLinks iterates over each service's declared links and resolves them
 // to concrete URLs using the target service's assigned guest IP. The resolved
 // URL is injected into the service's Env map, which injectEnvVars later
@@ -638,12 +1110,18 @@ func guestMACForIndex(idx int) string {
 //
 // Must be called after assignNetworking (so guest IPs are set).
 func (a *Agent) resolveLinks(services []config.ServiceConfig) {
-	// Build a lookup map: service name → guest IP.
+	// Build a lookup map: service name → guest IP, and "<service>/<network>"
+	// → guest IP on an additional network.
 	ipByName := make(map[string]string, len(services))
 	for _, svc := range services {
 		if svc.Network != nil && svc.Network.GuestIP != "" {
 			ipByName[svc.Name] = svc.Network.GuestIP
 		}
+		for _, att := range svc.Networks {
+			if att.GuestIP != "" {
+				ipByName[svc.Name+"/"+att.Name] = att.GuestIP
+			}
+		}
 	}
 
 	for i := range services {
@@ -653,10 +1131,14 @@ func (a *Agent) resolveLinks(services []config.ServiceConfig) {
 		}
 
 		for _, link := range svc.Links {
-			targetIP, ok := ipByName[link.Service]
+			target := link.Service
+			if link.Network != "" && link.Network != config.DefaultNetwork {
+				target += "/" + link.Network
+			}
+			targetIP, ok := ipByName[target]
 			if !ok {
 				a.logger.Warn("linked service not found or has no network",
-					"service", svc.Name, "link_target", link.Service)
+					"service", svc.Name, "link_target", link.Service, "network", link.Network)
 				continue
 			}
 
@@ -678,6 +1160,48 @@ func (a *Agent) resolveLinks(services []config.ServiceConfig) {
 	}
 }
 
+// allowLinks lets every service reach the ports it links to when tenant
+// network isolation would otherwise drop the traffic between their tenants.
+// cross_node_links need no rule: they go through the host's port forwards,
+// which are routed rather than bridged.
+func (a *Agent) allowLinks(services []config.ServiceConfig) {
+	if a.networkMgr == nil || !a.cfg.TenantNetworkIsolation {
+		return
+	}
+	// Links on an additional network join the services' TAPs on that network.
+	tapByName := make(map[string]string, len(services))
+	for _, svc := range services {
+		if svc.Network != nil {
+			tapByName[svc.Name] = network.TAPName(svc)
+			for _, att := range svc.Networks {
+				tapByName[svc.Name+"/"+att.Name] = att.Interface
+			}
+		}
+	}
+
+	var links []network.Link
+	for _, svc := range services {
+		if svc.Network == nil {
+			continue
+		}
+		for _, link := range svc.Links {
+			from, to := svc.Name, link.Service
+			if link.Network != "" && link.Network != config.DefaultNetwork {
+				from += "/" + link.Network
+				to += "/" + link.Network
+			}
+			fromTAP, fromOK := tapByName[from]
+			toTAP, toOK := tapByName[to]
+			if fromOK && toOK {
+				links = append(links, network.Link{FromTAP: fromTAP, ToTAP: toTAP, Port: link.Port})
+			}
+		}
+	}
+	if err := a.networkMgr.SetLinks(links); err != nil {
+		a.logger.Warn("failed to allow service links across tenants", "error", err)
+	}
+}
+
 // injectEnvVars appends environment variables to each service's KernelArgs.
 // Values with whitespace are encoded as firework.env64.KEY=VALUE so they
 // remain a single kernel-argument token; fc-init also supports legacy raw
@@ -733,6 +1257,8 @@ func (a *Agent) syncTraefikConfigs(ctx context.Context, services []config.Servic
 	if a.traefikMgr == nil {
 		return nil
 	}
+	// Routes list only serving replicas, so pick up the latest health.
+	a.refreshAgentStatusFromRuntime()
 
 	var remoteNodes []config.NodeConfig
 	if lister, ok := a.store.(store.NodeConfigLister); ok {
@@ -764,6 +1290,21 @@ func (a *Agent) syncTraefikConfigs(ctx context.Context, services []config.Servic
 	return nil
 }
 
+// replicaServing reports whether a replica receives traffic, from the status
+// this agent reports for its own services and, for the replicas of its peers,
+// from what the registry last said about them.
+func (a *Agent) replicaServing(name string) bool {
+	a.statusMu.RLock()
+	for _, service := range a.currentStatus.Services {
+		if service.Name == name {
+			a.statusMu.RUnlock()
+			return service.Serving()
+		}
+	}
+	a.statusMu.RUnlock()
+	return a.registryClient == nil || a.registryClient.peerServing(name)
+}
+
 // preflightRouting validates user-authored routing metadata for the local
 // services before any reconciliation, so a missing ingress_domain or an invalid
 // direct node config fails fast without advancing the revision. Exact
@@ -784,23 +1325,70 @@ func (a *Agent) preflightRouting(services []config.ServiceConfig) error {
 	return nil
 }
 
-// checkCapacity compares desired usage against host capacity. Returns false
-// (skip reconcile) if resources would be exceeded.
-func (a *Agent) checkCapacity(cap, used capacity.NodeCapacity, hasCap bool) bool {
+// checkCapacity compares desired usage against host capacity and returns the
+// services to reconcile. vCPUs and memory_mb must fit, memory up to
+// memory_overcommit_ratio times capacity; otherwise it returns false (skip
+// reconcile). Observed usage never holds back services that already have a
+// VM on the node, so restarts and replacements go on while memory is tight.
+// Only a ballooned service without a VM yet is left out, as long as the
+// resident memory (see capacity.MemoryDemand) would not fit with it.
+func (a *Agent) checkCapacity(cap capacity.NodeCapacity, services []config.ServiceConfig, existing map[string]bool, hasCap bool) ([]config.ServiceConfig, bool) {
 	if !hasCap {
-		return true
+		return services, true
 	}
+	used := sumResources(services)
 	a.metrics.setCapacity(cap, used)
 
-	if used.VCPUs > cap.VCPUs || used.MemoryMB > cap.MemoryMB {
+	committed := capacity.MemoryDemand{CommittedMB: used.MemoryMB}
+	if used.VCPUs > cap.VCPUs || !committed.FitsCommitted(cap.MemoryMB, a.cfg.MemoryOvercommitRatio) {
 		a.logger.Warn("desired services exceed node capacity, skipping reconciliation",
 			"cap_vcpus", cap.VCPUs, "used_vcpus", used.VCPUs,
 			"cap_memory_mb", cap.MemoryMB, "used_memory_mb", used.MemoryMB,
+			"memory_overcommit_ratio", a.cfg.MemoryOvercommitRatio,
+		)
+		return nil, false
+	}
+
+	observed := a.observedMemory()
+	var resident capacity.MemoryDemand
+	var incoming []config.ServiceConfig
+	for _, svc := range services {
+		if svc.Balloon != nil && !existing[svc.Name] {
+			incoming = append(incoming, svc)
+			continue
+		}
+		resident = resident.Add(svc, observed[svc.Name])
+	}
+	held := make(map[string]bool)
+	for _, svc := range incoming {
+		next := resident.Add(svc, observed[svc.Name])
+		if next.Fits(cap.MemoryMB, a.cfg.MemoryOvercommitRatio) {
+			resident = next
+			continue
+		}
+		held[svc.Name] = true
+		a.logger.Warn("not starting ballooned service until resident memory fits",
+			"service", svc.Name, "cap_memory_mb", cap.MemoryMB, "resident_memory_mb", next.ResidentMB,
+			"memory_overcommit_ratio", a.cfg.MemoryOvercommitRatio,
 		)
-		return false
 	}
+	if len(held) == 0 {
+		return services, true
+	}
+	return slices.DeleteFunc(slices.Clone(services), func(svc config.ServiceConfig) bool { return held[svc.Name] }), true
+}
 
-	return true
+// existingVMs returns the services the VM manager has a VM for, in any
+// state.
+func (a *Agent) existingVMs() map[string]bool {
+	out := make(map[string]bool)
+	if a.vmManager == nil {
+		return out
+	}
+	for name := range a.vmManager.List() {
+		out[name] = true
+	}
+	return out
 }
 
 func (a *Agent) readNodeCapacity() (capacity.NodeCapacity, bool) {
@@ -895,7 +1483,9 @@ func (a *Agent) refreshRuntimeMetrics() {
 	if a.healthMon != nil {
 		results = a.healthMon.Results()
 	}
-	a.metrics.setServiceSnapshot(a.vmManager.List(), results)
+	instances := a.vmManager.List()
+	a.metrics.setServiceSnapshot(instances, results)
+	a.metrics.setVMMetrics(instances, a.vmManager.Metrics())
 }
 
 func (a *Agent) syncRegistry(ctx context.Context, cap, used capacity.NodeCapacity) {
@@ -903,7 +1493,8 @@ func (a *Agent) syncRegistry(ctx context.Context, cap, used capacity.NodeCapacit
 		return
 	}
 	status := a.agentStatusSnapshot()
-	a.registryClient.sync(ctx, a.cfg.NodeID, a.cfg.NodeNames, cap, used, &status)
+	labels := append(append([]string(nil), a.cfg.NodeNames...), a.cfg.Labels...)
+	a.registryClient.sync(ctx, a.cfg.NodeID, labels, cap, used, &status)
 }
 
 // healthAdapter wraps healthcheck.Monitor to satisfy api.HealthResultsProvider.
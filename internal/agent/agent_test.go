@@ -344,6 +344,81 @@ func TestAssignNetworking_InsertsIPBeforeAppSeparator(t *testing.T) {
 	}
 }
 
+func TestAssignNetworking_AssignsIPv6AtSameOffset(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	cfg.VMSubnetV6 = "fd00:f1::/64"
+	cfg.VMGatewayV6 = "fd00:f1::1"
+
+	a := &Agent{cfg: cfg, logger: testLogger()}
+	services := []config.ServiceConfig{
+		{Name: "api", KernelArgs: "console=ttyS0 init=/sbin/fc-init -- /bin/api", Network: &config.NetworkConfig{Interface: "tap-api"}},
+		{Name: "web", Network: &config.NetworkConfig{Interface: "tap-web"}},
+	}
+	a.assignNetworking(services)
+
+	if got := services[1].Network.GuestIPv6; got != "fd00:f1::3" {
+		t.Fatalf("expected web to get fd00:f1::3, got %q", got)
+	}
+	want := "console=ttyS0 init=/sbin/fc-init ip=172.16.0.2::172.16.0.1:255.255.255.0::eth0:off firework.ipv6=fd00:f1::2/64,fd00:f1::1 -- /bin/api"
+	if services[0].KernelArgs != want {
+		t.Fatalf("kernel args = %q, want %q", services[0].KernelArgs, want)
+	}
+	if got := networkAddress(*services[0].Network); got != "172.16.0.2,fd00:f1::2" {
+		t.Fatalf("expected both addresses to be reported, got %q", got)
+	}
+}
+
+func TestAssignNetworking_AddressesAdditionalNetworks(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	cfg.Networks = []config.VMNetwork{{Name: "data", Subnet: "10.50.0.0/24", Gateway: "10.50.0.1", Bridge: "br-data"}}
+
+	a := &Agent{cfg: cfg, logger: testLogger()}
+	services := []config.ServiceConfig{
+		{
+			Name:       "db",
+			KernelArgs: "console=ttyS0 -- /bin/db",
+			Network:    &config.NetworkConfig{Interface: "tap-db"},
+			Networks:   []config.NetworkConfig{{Name: "data", Interface: "fwn-db"}},
+		},
+		{
+			Name:     "replica",
+			Network:  &config.NetworkConfig{Interface: "tap-replica"},
+			Networks: []config.NetworkConfig{{Name: "data", Interface: "fwn-replica"}},
+			Links:    []config.ServiceLink{{Service: "db", EnvVar: "PRIMARY", Port: 5432, Protocol: "postgres", Network: "data"}},
+		},
+	}
+	a.assignNetworking(services)
+	a.resolveLinks(services)
+
+	db := services[0].Networks[0]
+	if db.GuestIP != "10.50.0.2" || db.GuestMAC != "AA:FC:01:00:00:01" {
+		t.Fatalf("unexpected data attachment %+v", db)
+	}
+	if got := services[1].Networks[0].GuestIP; got != "10.50.0.3" {
+		t.Fatalf("expected replica at 10.50.0.3, got %s", got)
+	}
+	want := "console=ttyS0 firework.net.eth1=10.50.0.2/24 ip=172.16.0.2::172.16.0.1:255.255.255.0::eth0:off -- /bin/db"
+	if services[0].KernelArgs != want {
+		t.Fatalf("kernel args = %q, want %q", services[0].KernelArgs, want)
+	}
+	if got := services[1].Env["PRIMARY"]; got != "postgres://10.50.0.2:5432" {
+		t.Fatalf("expected the link to resolve on the data network, got %q", got)
+	}
+
+	// The data lease survives the service list changing around it.
+	restarted := &Agent{cfg: cfg, logger: testLogger()}
+	services = services[1:]
+	services[0].Networks[0].GuestIP = ""
+	restarted.assignNetworking(services)
+	if got := services[0].Networks[0].GuestIP; got != "10.50.0.3" {
+		t.Fatalf("replica lost its data lease: got %s", got)
+	}
+}
+
 func TestAssignNetworking_AllocatesMACPastLastOctet(t *testing.T) {
 	cfg := testAgentConfig(t)
 	cfg.VMSubnet = "172.16.0.0/16"
@@ -583,6 +658,87 @@ func TestTick_StatusReportsVMProcessFailureWithoutExitedPID(t *testing.T) {
 	t.Fatalf("service did not report VM failure: %#v", a.agentStatusSnapshot().Services)
 }
 
+func TestTick_StatusReportsCrashLoopBackoff(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "fake-firecracker")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeStore{data: map[string][]byte{"web": []byte("node: web\nservices:\n- name: service\n  image: /img/service\n  kernel: /kern\n  vcpus: 1\n  memory_mb: 128\n")}, revision: "rev-1"}
+	cfg := testAgentConfig(t)
+	cfg.FirecrackerBin = binary
+	a := New(cfg, store, testLogger())
+
+	waitFailed := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if inst := a.vmManager.List()["service"]; inst != nil && inst.State == "failed" {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("VM did not fail")
+	}
+
+	// The first crash is restarted on the next tick even though the
+	// revision is unchanged; the second one backs off.
+	a.tick(context.Background())
+	waitFailed()
+	a.tick(context.Background())
+	waitFailed()
+	a.tick(context.Background())
+
+	status := a.agentStatusSnapshot()
+	if len(status.Services) != 1 {
+		t.Fatalf("unexpected services: %#v", status.Services)
+	}
+	service := status.Services[0]
+	if service.ReasonCode != "crash_loop_backoff" || !strings.Contains(service.Message, "2 consecutive failures") {
+		t.Fatalf("expected crash loop backoff, got %#v", service)
+	}
+}
+
+func TestTick_StatusReportsRestartLimitReached(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "fake-firecracker")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeStore{data: map[string][]byte{"web": []byte("node: web\nservices:\n- name: service\n  image: /img/service\n  kernel: /kern\n  vcpus: 1\n  memory_mb: 128\n  restart_policy:\n    max_attempts: 1\n")}, revision: "rev-1"}
+	cfg := testAgentConfig(t)
+	cfg.FirecrackerBin = binary
+	a := New(cfg, store, testLogger())
+
+	waitFailed := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if inst := a.vmManager.List()["service"]; inst != nil && inst.State == "failed" {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("VM did not fail")
+	}
+
+	// The single allowed restart fails as well, leaving the service down.
+	a.tick(context.Background())
+	waitFailed()
+	a.tick(context.Background())
+	waitFailed()
+	a.tick(context.Background())
+
+	status := a.agentStatusSnapshot()
+	if len(status.Services) != 1 {
+		t.Fatalf("unexpected services: %#v", status.Services)
+	}
+	service := status.Services[0]
+	if service.ReasonCode != "restart_limit_reached" || !strings.Contains(service.Message, "2 consecutive failures; restart limit of 1 attempts reached") {
+		t.Fatalf("expected the restart limit to be reported, got %#v", service)
+	}
+}
+
 func TestTick_CapacityCheck_ProceedsWhenSufficient(t *testing.T) {
 	nodeYAML := []byte("node: web\nservices:\n- name: light\n  image: /img/light\n  kernel: /kern\n  vcpus: 1\n  memory_mb: 256\n")
 	s := &fakeStore{
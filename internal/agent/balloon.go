@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/capacity"
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// balloonInterval is how often the agent reads balloon statistics and
+// resizes balloons.
+const balloonInterval = 10 * time.Second
+
+// Host memory pressure thresholds, as fractions of host memory available.
+// Below balloonPressureFraction the balloons inflate; above
+// balloonRelaxedFraction they deflate. In between they are left alone so
+// the balloons do not oscillate.
+const (
+	balloonPressureFraction = 0.10
+	balloonRelaxedFraction  = 0.25
+)
+
+// balloonMinStepMiB is the smallest balloon worth keeping inflated; a
+// deflating balloon below it is released completely.
+const balloonMinStepMiB = 16
+
+// balloonDriver is the part of vm.Manager the balloon loop uses.
+type balloonDriver interface {
+	BalloonStats(ctx context.Context, name string) (vm.BalloonStats, error)
+	SetBalloon(ctx context.Context, name string, amountMiB int) error
+}
+
+// runBalloons resizes balloons on a fixed interval, independent of the
+// reconciliation loop, so memory pressure is answered between polls.
+func (a *Agent) runBalloons(ctx context.Context) {
+	ticker := time.NewTicker(balloonInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.adjustBalloons(ctx, a.vmManager, capacity.ReadMemAvailableMB)
+		}
+	}
+}
+
+// adjustBalloons records the balloon statistics of every running ballooned
+// service and, depending on host memory pressure, inflates the balloons to
+// reclaim half of what each guest has available or deflates them by half.
+// A balloon never leaves its guest less than the service's min_memory_mb.
+func (a *Agent) adjustBalloons(ctx context.Context, vms balloonDriver, memAvailable func() (int, error)) {
+	a.statusMu.RLock()
+	var services []config.ServiceConfig
+	for _, svc := range a.statusServices {
+		if svc.Balloon != nil {
+			services = append(services, svc)
+		}
+	}
+	a.statusMu.RUnlock()
+
+	stats := make(map[string]vm.BalloonStats, len(services))
+	for _, svc := range services {
+		s, err := vms.BalloonStats(ctx, svc.Name)
+		if err != nil {
+			a.logger.Debug("balloon statistics unavailable", "service", svc.Name, "error", err)
+			continue
+		}
+		stats[svc.Name] = s
+	}
+	a.statusMu.Lock()
+	a.balloonStats = stats
+	a.statusMu.Unlock()
+	if len(stats) == 0 {
+		return
+	}
+
+	nodeCap, _, ready := a.getHeartbeatResources()
+	if !ready || nodeCap.MemoryMB <= 0 {
+		return
+	}
+	available, err := memAvailable()
+	if err != nil {
+		a.logger.Warn("cannot read host memory; leaving balloons as they are", "error", err)
+		return
+	}
+	inflate := float64(available) < float64(nodeCap.MemoryMB)*balloonPressureFraction
+	deflate := float64(available) > float64(nodeCap.MemoryMB)*balloonRelaxedFraction
+	if !inflate && !deflate {
+		return
+	}
+
+	for _, svc := range services {
+		s, ok := stats[svc.Name]
+		if !ok {
+			continue
+		}
+		target := s.TargetMiB / 2
+		if target < balloonMinStepMiB {
+			target = 0
+		}
+		if inflate {
+			target = min(svc.MemoryMB-svc.Balloon.MinMemoryMB, s.TargetMiB+s.AvailableMiB()/2)
+		}
+		target = max(target, 0)
+		if target == s.TargetMiB {
+			continue
+		}
+		if err := vms.SetBalloon(ctx, svc.Name, target); err != nil {
+			a.logger.Warn("failed to resize balloon", "service", svc.Name, "target_mib", target, "error", err)
+			continue
+		}
+		a.logger.Info("resized balloon", "service", svc.Name, "from_mib", s.TargetMiB, "target_mib", target,
+			"host_available_mb", available)
+	}
+}
+
+// observedMemory returns the memory each ballooned service was last seen
+// using, keyed by service name.
+func (a *Agent) observedMemory() map[string]int {
+	a.statusMu.RLock()
+	defer a.statusMu.RUnlock()
+	observed := make(map[string]int, len(a.balloonStats))
+	for name, s := range a.balloonStats {
+		observed[name] = s.UsedMiB()
+	}
+	return observed
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/capacity"
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// fakeBalloons serves fixed balloon statistics and records resize requests.
+type fakeBalloons struct {
+	stats   map[string]vm.BalloonStats
+	targets map[string]int
+}
+
+func (f *fakeBalloons) BalloonStats(_ context.Context, name string) (vm.BalloonStats, error) {
+	return f.stats[name], nil
+}
+
+func (f *fakeBalloons) SetBalloon(_ context.Context, name string, amountMiB int) error {
+	f.targets[name] = amountMiB
+	return nil
+}
+
+func balloonTestAgent(t *testing.T) *Agent {
+	t.Helper()
+	a := &Agent{cfg: testAgentConfig(t), logger: testLogger()}
+	a.statusServices = []config.ServiceConfig{
+		{Name: "cache", MemoryMB: 1024, Balloon: &config.BalloonConfig{MinMemoryMB: 512}},
+		{Name: "api", MemoryMB: 1024, Balloon: &config.BalloonConfig{MinMemoryMB: 256}},
+		{Name: "db", MemoryMB: 1024},
+	}
+	a.setHeartbeatResources(capacity.NodeCapacity{VCPUs: 4, MemoryMB: 4096}, capacity.NodeCapacity{})
+	return a
+}
+
+func hostAvailable(mb int) func() (int, error) {
+	return func() (int, error) { return mb, nil }
+}
+
+func TestAdjustBalloons_InflatesUnderPressureDownToFloor(t *testing.T) {
+	a := balloonTestAgent(t)
+	vms := &fakeBalloons{
+		stats: map[string]vm.BalloonStats{
+			"cache": {TargetMiB: 400, TotalMemory: 624 << 20, AvailableMemory: 400 << 20},
+			"api":   {TargetMiB: 0, TotalMemory: 1024 << 20, AvailableMemory: 600 << 20},
+		},
+		targets: map[string]int{},
+	}
+
+	a.adjustBalloons(context.Background(), vms, hostAvailable(200))
+
+	if vms.targets["cache"] != 512 {
+		t.Errorf("cache balloon = %d MiB, want it capped at memory_mb - min_memory_mb (512)", vms.targets["cache"])
+	}
+	if vms.targets["api"] != 300 {
+		t.Errorf("api balloon = %d MiB, want half of the available memory (300)", vms.targets["api"])
+	}
+	if _, ok := vms.targets["db"]; ok {
+		t.Error("services without a balloon must not be resized")
+	}
+	if got := a.observedMemory(); got["cache"] != 224 || got["api"] != 424 {
+		t.Errorf("unexpected observed memory: %v", got)
+	}
+}
+
+func TestAdjustBalloons_DeflatesWhenHostIsRelaxed(t *testing.T) {
+	a := balloonTestAgent(t)
+	vms := &fakeBalloons{
+		stats: map[string]vm.BalloonStats{
+			"cache": {TargetMiB: 400},
+			"api":   {TargetMiB: 20},
+		},
+		targets: map[string]int{},
+	}
+
+	a.adjustBalloons(context.Background(), vms, hostAvailable(2048))
+	if vms.targets["cache"] != 200 || vms.targets["api"] != 0 {
+		t.Fatalf("unexpected deflate targets: %v", vms.targets)
+	}
+
+	vms.targets = map[string]int{}
+	a.adjustBalloons(context.Background(), vms, hostAvailable(700))
+	if len(vms.targets) != 0 {
+		t.Fatalf("balloons must be left alone between the thresholds, got %v", vms.targets)
+	}
+}
+
+func TestCheckCapacity_ObservedUsageOnlyHoldsBackNewBalloonedServices(t *testing.T) {
+	a := balloonTestAgent(t)
+	a.metrics = newRuntimeMetrics("web")
+	a.cfg.MemoryOvercommitRatio = 2
+	// cache and api run and now use all of their memory_mb, more than the
+	// node has once db is counted.
+	a.balloonStats = map[string]vm.BalloonStats{
+		"cache": {TotalMemory: 1024 << 20},
+		"api":   {TotalMemory: 1024 << 20},
+	}
+	services := append([]config.ServiceConfig(nil), a.statusServices...)
+	services = append(services, config.ServiceConfig{Name: "queue", MemoryMB: 1024, Balloon: &config.BalloonConfig{MinMemoryMB: 256}})
+	existing := map[string]bool{"cache": true, "api": true, "db": true}
+
+	admitted, ok := a.checkCapacity(capacity.NodeCapacity{VCPUs: 4, MemoryMB: 2048}, services, existing, true)
+	if !ok {
+		t.Fatal("observed usage above capacity must not skip reconciliation of running services")
+	}
+	if len(admitted) != 3 || admitted[0].Name != "cache" || admitted[1].Name != "api" || admitted[2].Name != "db" {
+		t.Fatalf("expected running services admitted and queue held back, got %#v", admitted)
+	}
+
+	// Once the balloons free memory, queue starts.
+	a.balloonStats = map[string]vm.BalloonStats{
+		"cache": {TotalMemory: 512 << 20},
+		"api":   {TotalMemory: 256 << 20},
+	}
+	if admitted, ok := a.checkCapacity(capacity.NodeCapacity{VCPUs: 4, MemoryMB: 2048}, services, existing, true); !ok || len(admitted) != 4 {
+		t.Fatalf("expected queue admitted, got %#v ok=%v", admitted, ok)
+	}
+
+	// memory_mb beyond the ratio still skips reconciliation.
+	if _, ok := a.checkCapacity(capacity.NodeCapacity{VCPUs: 4, MemoryMB: 1024}, services, existing, true); ok {
+		t.Fatal("expected committed memory beyond the ratio to skip reconciliation")
+	}
+}
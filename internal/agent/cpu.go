@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cpuSampleInterval is the least time between two CPU time samples of a VM.
+// Status snapshots taken in between report the last utilisation.
+const cpuSampleInterval = 10 * time.Second
+
+// userHZ is the clock tick rate of the CPU times in /proc/<pid>/stat. The
+// kernel reports them in USER_HZ, which is 100 on every architecture
+// Firecracker supports.
+const userHZ = 100
+
+// cpuSample is the CPU time a VM's Firecracker process had used at one
+// point, and the utilisation computed from the sample before it.
+type cpuSample struct {
+	pid     int
+	ticks   uint64
+	at      time.Time
+	percent int
+	valid   bool
+}
+
+// cpuSampler reports the CPU utilisation of each running VM: the CPU time
+// its Firecracker process used between two samples, in percent of the VM's
+// vCPUs. The process's time covers the vCPU threads and the VMM's own
+// device emulation. Callers hold Agent.statusMu.
+type cpuSampler struct {
+	readTicks func(pid int) (uint64, error)
+	samples   map[string]cpuSample
+}
+
+func newCPUSampler() *cpuSampler {
+	return &cpuSampler{readTicks: readProcessTicks, samples: make(map[string]cpuSample)}
+}
+
+// utilization returns the CPU utilisation of the VM of service name, once
+// two samples of the same process are available.
+func (s *cpuSampler) utilization(name string, pid, vcpus int, now time.Time) (int, bool) {
+	prev, seen := s.samples[name]
+	seen = seen && prev.pid == pid
+	if seen && now.Sub(prev.at) < cpuSampleInterval {
+		return prev.percent, prev.valid
+	}
+	ticks, err := s.readTicks(pid)
+	if err != nil {
+		delete(s.samples, name)
+		return 0, false
+	}
+	cur := cpuSample{pid: pid, ticks: ticks, at: now}
+	if seen && ticks >= prev.ticks {
+		used := float64(ticks-prev.ticks) / userHZ
+		elapsed := now.Sub(prev.at).Seconds()
+		cur.percent = min(int(math.Round(used/elapsed/float64(max(vcpus, 1))*100)), 100)
+		cur.valid = true
+	}
+	s.samples[name] = cur
+	return cur.percent, cur.valid
+}
+
+// retain forgets the samples of the VMs not in running.
+func (s *cpuSampler) retain(running map[string]bool) {
+	for name := range s.samples {
+		if !running[name] {
+			delete(s.samples, name)
+		}
+	}
+}
+
+// readProcessTicks returns the user and system CPU time of a process, in
+// clock ticks, summed over its threads.
+func readProcessTicks(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	return parseProcessTicks(string(data))
+}
+
+// parseProcessTicks reads utime and stime, the 14th and 15th fields of a
+// /proc/<pid>/stat line. The command name in the second field may contain
+// spaces, so the fields are counted from its closing parenthesis.
+func parseProcessTicks(stat string) (uint64, error) {
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed stat line")
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed stat line")
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing utime: %w", err)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing stime: %w", err)
+	}
+	return utime + stime, nil
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseProcessTicks(t *testing.T) {
+	stat := "4242 (fire cracker) S 1 4242 4242 0 -1 4194560 2000 0 0 0 1500 250 0 0 20 0 3 0 100 0 0"
+	got, err := parseProcessTicks(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1750 {
+		t.Fatalf("ticks = %d, want 1750", got)
+	}
+	if _, err := parseProcessTicks("4242 (firecracker) S 1"); err == nil {
+		t.Fatal("expected an error for a truncated stat line")
+	}
+}
+
+func TestCPUSamplerUtilization(t *testing.T) {
+	ticks := map[int]uint64{100: 1000}
+	s := newCPUSampler()
+	s.readTicks = func(pid int) (uint64, error) {
+		v, ok := ticks[pid]
+		if !ok {
+			return 0, errors.New("no such process")
+		}
+		return v, nil
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, ok := s.utilization("web", 100, 2, start); ok {
+		t.Fatal("first sample reported a utilisation")
+	}
+	// 10 CPU seconds over 10 seconds on 2 vCPUs.
+	ticks[100] = 2000
+	if got, ok := s.utilization("web", 100, 2, start.Add(10*time.Second)); !ok || got != 50 {
+		t.Fatalf("utilisation = %d, %v; want 50", got, ok)
+	}
+	// Samples taken sooner than the interval report the last utilisation.
+	ticks[100] = 4000
+	if got, ok := s.utilization("web", 100, 2, start.Add(12*time.Second)); !ok || got != 50 {
+		t.Fatalf("utilisation = %d, %v; want the last one, 50", got, ok)
+	}
+
+	// A restarted VM starts over.
+	ticks[200] = 10
+	if _, ok := s.utilization("web", 200, 2, start.Add(30*time.Second)); ok {
+		t.Fatal("new process reported the old one's utilisation")
+	}
+
+	s.retain(map[string]bool{})
+	if len(s.samples) != 0 {
+		t.Fatalf("samples of stopped VMs kept: %v", s.samples)
+	}
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ipamStateFile holds the agent's guest address leases under state_dir.
+const ipamStateFile = "ipam.json"
+
+// ipam remembers which guest IP and MAC each service holds, so an address
+// does not depend on the service's position in the desired list. Leases are
+// persisted under state_dir and survive agent restarts. The lease of a
+// service missing from the desired state is kept for the grace period, so a
+// service that briefly drops out comes back at its address. A released
+// address returns to the pool only after the release cooldown, so peers that
+// still cache it cannot reach the next VM to take it. Only the reconcile loop
+// uses it.
+type ipam struct {
+	path     string
+	grace    time.Duration
+	cooldown time.Duration
+	logger   *slog.Logger
+	now      func() time.Time
+
+	leases   map[string]lease // service → lease
+	released []releasedLease
+	dirty    bool
+}
+
+// lease is the address a service holds, and the tenant it was handed out to.
+// MissingSince is set while the service is missing from the desired state.
+type lease struct {
+	IP           string    `json:"ip"`
+	MAC          string    `json:"mac"`
+	Tenant       string    `json:"tenant,omitempty"`
+	MissingSince time.Time `json:"missing_since,omitzero"`
+}
+
+// releasedLease is an address in its release cooldown.
+type releasedLease struct {
+	IP         string    `json:"ip"`
+	MAC        string    `json:"mac"`
+	ReleasedAt time.Time `json:"released_at"`
+}
+
+type ipamState struct {
+	Leases   map[string]lease `json:"leases"`
+	Released []releasedLease  `json:"released,omitempty"`
+}
+
+// loadIPAM reads the leases persisted at path. A missing file starts an
+// empty pool; so does an unreadable one, after a warning, since the addresses
+// of running VMs are recovered from the VMs themselves.
+func loadIPAM(path string, grace, cooldown time.Duration, logger *slog.Logger) *ipam {
+	p := &ipam{
+		path:     path,
+		grace:    grace,
+		cooldown: cooldown,
+		logger:   logger,
+		now:      time.Now,
+		leases:   make(map[string]lease),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p
+	}
+	var state ipamState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		logger.Warn("ignoring unreadable guest address leases", "path", path, "error", err)
+		return p
+	}
+	if state.Leases != nil {
+		p.leases = state.Leases
+	}
+	p.released = state.Released
+	return p
+}
+
+// lookup returns the lease of a service.
+func (p *ipam) lookup(service string) (lease, bool) {
+	l, ok := p.leases[service]
+	return l, ok
+}
+
+// bind records l as the service's lease. An address the service held before
+// is released.
+func (p *ipam) bind(service string, l lease) {
+	old, ok := p.leases[service]
+	if ok && old == l {
+		return
+	}
+	if ok && (old.IP != l.IP || old.MAC != l.MAC) {
+		p.releaseLease(old)
+	}
+	p.leases[service] = l
+	p.dirty = true
+}
+
+// release returns the service's address to the pool after the cooldown.
+func (p *ipam) release(service string) {
+	l, ok := p.leases[service]
+	if !ok {
+		return
+	}
+	delete(p.leases, service)
+	p.releaseLease(l)
+	p.dirty = true
+}
+
+func (p *ipam) releaseLease(l lease) {
+	p.logger.Info("released guest address", "ip", l.IP, "mac", l.MAC, "cooldown", p.cooldown)
+	p.released = append(p.released, releasedLease{IP: l.IP, MAC: l.MAC, ReleasedAt: p.now()})
+}
+
+// retain releases the leases of services not in keep once they have been
+// missing for the grace period. Leases of services in keep are no longer
+// missing.
+func (p *ipam) retain(keep map[string]bool) {
+	now := p.now()
+	for service, l := range p.leases {
+		switch {
+		case keep[service]:
+			if !l.MissingSince.IsZero() {
+				l.MissingSince = time.Time{}
+				p.leases[service] = l
+				p.dirty = true
+			}
+		case l.MissingSince.IsZero() && p.grace > 0:
+			l.MissingSince = now
+			p.leases[service] = l
+			p.dirty = true
+		case now.Sub(l.MissingSince) >= p.grace:
+			p.release(service)
+		}
+	}
+}
+
+// reserved reports whether an IP or MAC is leased to any service or still
+// in its release cooldown.
+func (p *ipam) reserved(ip, mac string) bool {
+	for _, l := range p.leases {
+		if l.IP == ip || l.MAC == mac {
+			return true
+		}
+	}
+	for _, r := range p.released {
+		if r.IP == ip || r.MAC == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// expire returns addresses whose cooldown has passed to the pool.
+func (p *ipam) expire() {
+	now := p.now()
+	kept := p.released[:0]
+	for _, r := range p.released {
+		if now.Sub(r.ReleasedAt) < p.cooldown {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) != len(p.released) {
+		p.dirty = true
+	}
+	p.released = kept
+}
+
+// save persists the leases when they changed since the last save.
+func (p *ipam) save() error {
+	if !p.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(ipamState{Leases: p.leases, Released: p.released}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
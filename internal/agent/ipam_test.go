@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func networkedServices(names ...string) []config.ServiceConfig {
+	services := make([]config.ServiceConfig, len(names))
+	for i, name := range names {
+		services[i] = config.ServiceConfig{Name: name, Network: &config.NetworkConfig{Interface: "tap-" + name}}
+	}
+	return services
+}
+
+func TestAssignNetworking_LeasesSurviveRestartAndNewServices(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+
+	first := &Agent{cfg: cfg, logger: testLogger()}
+	first.assignNetworking(networkedServices("b", "c"))
+
+	// A restarted agent reads the leases back, so a service sorting before
+	// the existing ones does not shift their addresses.
+	restarted := &Agent{cfg: cfg, logger: testLogger()}
+	services := networkedServices("a", "b", "c")
+	restarted.assignNetworking(services)
+
+	want := map[string]string{"a": "172.16.0.4", "b": "172.16.0.2", "c": "172.16.0.3"}
+	for _, svc := range services {
+		if svc.Network.GuestIP != want[svc.Name] {
+			t.Fatalf("%s got %s, want %s", svc.Name, svc.Network.GuestIP, want[svc.Name])
+		}
+	}
+	if got := services[0].Network.GuestMAC; got != "AA:FC:00:00:00:03" {
+		t.Fatalf("expected the MAC to follow the allocated slot, got %s", got)
+	}
+}
+
+func TestAssignNetworking_ReleasedAddressCoolsDown(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	cfg.IPReleaseCooldown = 10 * time.Minute
+
+	now := time.Now()
+	a := &Agent{cfg: cfg, logger: testLogger()}
+	a.addressLeases().now = func() time.Time { return now }
+
+	a.assignNetworking(networkedServices("a", "b"))
+	a.assignNetworking(networkedServices("b"))
+
+	services := networkedServices("b", "c")
+	a.assignNetworking(services)
+	if got := services[1].Network.GuestIP; got != "172.16.0.4" {
+		t.Fatalf("released address reused within the cooldown: c got %s", got)
+	}
+
+	now = now.Add(11 * time.Minute)
+	services = networkedServices("b", "c", "d")
+	a.assignNetworking(services)
+	if got := services[1].Network.GuestIP; got != "172.16.0.4" {
+		t.Fatalf("c lost its lease: got %s", got)
+	}
+	if got := services[2].Network.GuestIP; got != "172.16.0.2" {
+		t.Fatalf("expected the cooled down address to return to the pool, d got %s", got)
+	}
+}
+
+func TestAssignNetworking_MissingServiceKeepsLeaseWithinGrace(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	cfg.IPLeaseGrace = 5 * time.Minute
+
+	now := time.Now()
+	a := &Agent{cfg: cfg, logger: testLogger()}
+	a.addressLeases().now = func() time.Time { return now }
+
+	a.assignNetworking(networkedServices("a", "b"))
+
+	// a drops out of one desired state, say during a config publish, and
+	// comes back before the grace period ends.
+	services := networkedServices("b", "c")
+	a.assignNetworking(services)
+	if got := services[1].Network.GuestIP; got != "172.16.0.4" {
+		t.Fatalf("c took the address a still holds: got %s", got)
+	}
+	now = now.Add(4 * time.Minute)
+	services = networkedServices("a", "b", "c")
+	a.assignNetworking(services)
+	if got := services[0].Network.GuestIP; got != "172.16.0.2" {
+		t.Fatalf("a came back within the grace period but got %s", got)
+	}
+
+	// Missing for longer than the grace period releases the lease.
+	a.assignNetworking(networkedServices("b", "c"))
+	now = now.Add(6 * time.Minute)
+	a.assignNetworking(networkedServices("b", "c"))
+	if _, ok := a.addressLeases().lookup("a"); ok {
+		t.Fatal("lease of a must be released after the grace period")
+	}
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// stampJobRevisions ties every job in node to the desired revision it was
+// rendered from, so the reconciler runs a job again when a new revision is
+// published and leaves a finished one alone until then. Without a desired
+// revision a job only runs again when its own config changes. Runs of cron
+// jobs come with their own revision and keep it.
+func stampJobRevisions(node *config.NodeConfig) {
+	for i := range node.Services {
+		if node.Services[i].Kind == config.KindJob && node.Services[i].JobRevision == "" {
+			node.Services[i].JobRevision = node.DesiredRevision
+		}
+	}
+}
+
+// dropScheduledJobs removes the cron jobs from node and returns their names.
+// The controller leader triggers them and assigns each run to a node; an
+// agent that is handed the schedule itself has nothing to run.
+func dropScheduledJobs(node *config.NodeConfig) []string {
+	var dropped []string
+	kept := node.Services[:0]
+	for _, svc := range node.Services {
+		if svc.Schedule != "" {
+			dropped = append(dropped, svc.Name)
+			continue
+		}
+		kept = append(kept, svc)
+	}
+	node.Services = kept
+	return dropped
+}
+
+// jobSucceeded reports whether inst is a job that ran to completion and
+// exited 0.
+func jobSucceeded(inst *vm.Instance) bool {
+	return inst.State == vm.StateStopped && inst.ExitCode != nil && *inst.ExitCode == 0
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func TestStampJobRevisions(t *testing.T) {
+	node := &config.NodeConfig{
+		DesiredRevision: "rev-7",
+		Services: []config.ServiceConfig{
+			{Name: "api"},
+			{Name: "migrate", Kind: config.KindJob},
+			{Name: "report-202601020300", Kind: config.KindJob, JobRevision: "report-202601020300"},
+		},
+	}
+	stampJobRevisions(node)
+	if node.Services[0].JobRevision != "" || node.Services[1].JobRevision != "rev-7" || node.Services[2].JobRevision != "report-202601020300" {
+		t.Fatalf("unexpected job revisions: %+v", node.Services)
+	}
+}
+
+func TestDropScheduledJobs(t *testing.T) {
+	node := &config.NodeConfig{Services: []config.ServiceConfig{
+		{Name: "api"},
+		{Name: "report", Kind: config.KindJob, Schedule: "0 3 * * *"},
+		{Name: "migrate", Kind: config.KindJob},
+	}}
+	dropped := dropScheduledJobs(node)
+	if len(dropped) != 1 || dropped[0] != "report" {
+		t.Fatalf("dropped = %v, want [report]", dropped)
+	}
+	if len(node.Services) != 2 || node.Services[0].Name != "api" || node.Services[1].Name != "migrate" {
+		t.Fatalf("unexpected services: %+v", node.Services)
+	}
+}
+
+func TestTick_JobRunsOncePerDesiredRevision(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "fake-firecracker")
+	runs := filepath.Join(dir, "runs")
+	script := "#!/bin/sh\necho run >> " + runs + "\nprintf 'firework.exit_status=0\\n'\nexit 0\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	nodeYAML := func(rev string) []byte {
+		return []byte("node: web\ndesired_revision: " + rev + "\nservices:\n- name: migrate\n  kind: job\n  image: /img/migrate\n  kernel: /kern\n  vcpus: 1\n  memory_mb: 128\n")
+	}
+	store := &fakeStore{data: map[string][]byte{"web": nodeYAML("rev-1")}, revision: "rev-1"}
+	cfg := testAgentConfig(t)
+	cfg.FirecrackerBin = binary
+	a := New(cfg, store, testLogger())
+
+	waitFinished := func(runsWant int) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			data, _ := os.ReadFile(runs)
+			if inst := a.vmManager.List()["migrate"]; inst != nil && !inst.FinishedAt.IsZero() && len(data) == runsWant*len("run\n") {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		data, _ := os.ReadFile(runs)
+		t.Fatalf("job did not finish run %d: %q, %#v", runsWant, data, a.vmManager.List()["migrate"])
+	}
+
+	a.tick(context.Background())
+	waitFinished(1)
+	a.tick(context.Background())
+
+	status := a.agentStatusSnapshot()
+	if len(status.Services) != 1 {
+		t.Fatalf("unexpected services: %#v", status.Services)
+	}
+	service := status.Services[0]
+	if service.Kind != config.KindJob || service.ReasonCode != "job_succeeded" || service.ExitCode == nil || *service.ExitCode != 0 || service.FinishedAt.IsZero() {
+		t.Fatalf("expected a succeeded job, got %#v", service)
+	}
+	if status.ReadyServices != 1 {
+		t.Fatalf("expected the succeeded job to count as ready, got %d", status.ReadyServices)
+	}
+	if data, _ := os.ReadFile(runs); string(data) != "run\n" {
+		t.Fatalf("expected the job to run once for rev-1, got %q", data)
+	}
+
+	store.data["web"] = nodeYAML("rev-2")
+	store.revision = "rev-2"
+	a.tick(context.Background())
+	waitFinished(2)
+}
@@ -61,6 +61,7 @@ type runtimeMetrics struct {
 	volumeOperations           map[volumeOperationKey]uint64
 	volumeOperationDuration    map[volumeOperationKey]float64
 	volumePools                map[string]volumePoolSnapshot
+	vmCounters                 map[serviceKey]vm.Metrics
 
 	nodeCapacityVCPUs    int
 	nodeCapacityMemoryMB int
@@ -79,6 +80,7 @@ func newRuntimeMetrics(node string) *runtimeMetrics {
 		volumeOperations:           make(map[volumeOperationKey]uint64),
 		volumeOperationDuration:    make(map[volumeOperationKey]float64),
 		volumePools:                make(map[string]volumePoolSnapshot),
+		vmCounters:                 make(map[serviceKey]vm.Metrics),
 	}
 }
 
@@ -212,6 +214,22 @@ func (m *runtimeMetrics) setServiceSnapshot(instances map[string]*vm.Instance, h
 	}
 }
 
+// setVMMetrics replaces the Firecracker counters of the running VMs. A
+// restarted VM starts counting from zero again, which Prometheus treats as a
+// counter reset.
+func (m *runtimeMetrics) setVMMetrics(instances map[string]*vm.Instance, counters map[string]vm.Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vmCounters = make(map[serviceKey]vm.Metrics, len(counters))
+	for name, c := range counters {
+		inst, ok := instances[name]
+		if !ok {
+			continue
+		}
+		m.vmCounters[serviceKey{service: name, tenant: tenantForService(inst.Config)}] = c
+	}
+}
+
 func (m *runtimeMetrics) setCapacity(cap, used capacity.NodeCapacity) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -347,6 +365,42 @@ func (m *runtimeMetrics) render() string {
 		fmt.Fprintf(&b, "firework_agent_volume_available_bytes{node=%q,type=%q} %d\n", m.node, volumeType, pool.availableBytes)
 	}
 
+	vmKeys := make([]serviceKey, 0, len(m.vmCounters))
+	for k := range m.vmCounters {
+		vmKeys = append(vmKeys, k)
+	}
+	sort.Slice(vmKeys, func(i, j int) bool { return vmKeys[i].service < vmKeys[j].service })
+	vmCounter := func(metric, help string, value func(vm.Metrics) uint64) {
+		writeHelpType(&b, metric, help, "counter")
+		for _, k := range vmKeys {
+			fmt.Fprintf(&b, "%s{node=%q,service=%q,tenant=%q} %d\n", metric, m.node, k.service, k.tenant, value(m.vmCounters[k]))
+		}
+	}
+	vmCounter("firework_vm_vcpu_exits_total", "vCPU exits to the VMM for port and MMIO accesses.",
+		func(c vm.Metrics) uint64 { return c.VCPUExits })
+	vmCounter("firework_vm_vcpu_failures_total", "vCPU errors reported by Firecracker.",
+		func(c vm.Metrics) uint64 { return c.VCPUFailures })
+	writeHelpType(&b, "firework_vm_block_bytes_total", "Bytes read and written by the VM's block devices.", "counter")
+	for _, k := range vmKeys {
+		c := m.vmCounters[k]
+		fmt.Fprintf(&b, "firework_vm_block_bytes_total{node=%q,service=%q,tenant=%q,direction=\"read\"} %d\n", m.node, k.service, k.tenant, c.BlockReadBytes)
+		fmt.Fprintf(&b, "firework_vm_block_bytes_total{node=%q,service=%q,tenant=%q,direction=\"write\"} %d\n", m.node, k.service, k.tenant, c.BlockWriteBytes)
+	}
+	vmCounter("firework_vm_block_errors_total", "Block requests that were invalid or failed.",
+		func(c vm.Metrics) uint64 { return c.BlockErrors })
+	vmCounter("firework_vm_block_throttled_total", "Block requests held back by a rate limiter.",
+		func(c vm.Metrics) uint64 { return c.BlockThrottled })
+	writeHelpType(&b, "firework_vm_net_bytes_total", "Bytes received and transmitted by the VM's network interfaces.", "counter")
+	for _, k := range vmKeys {
+		c := m.vmCounters[k]
+		fmt.Fprintf(&b, "firework_vm_net_bytes_total{node=%q,service=%q,tenant=%q,direction=\"rx\"} %d\n", m.node, k.service, k.tenant, c.NetRxBytes)
+		fmt.Fprintf(&b, "firework_vm_net_bytes_total{node=%q,service=%q,tenant=%q,direction=\"tx\"} %d\n", m.node, k.service, k.tenant, c.NetTxBytes)
+	}
+	vmCounter("firework_vm_net_errors_total", "Failed network receives and transmits, TAP errors included.",
+		func(c vm.Metrics) uint64 { return c.NetErrors })
+	vmCounter("firework_vm_net_throttled_total", "Network events held back by a rate limiter.",
+		func(c vm.Metrics) uint64 { return c.NetThrottled })
+
 	if m.nodeCapacityVCPUs > 0 {
 		writeHelpType(&b, "firework_node_capacity_vcpus", "Total vCPU capacity of the node.", "gauge")
 		fmt.Fprintf(&b, "firework_node_capacity_vcpus{node=%q} %d\n", m.node, m.nodeCapacityVCPUs)
@@ -95,3 +95,24 @@ func assertContains(t *testing.T, haystack, needle string) {
 		t.Fatalf("expected output to contain %q, got:\n%s", needle, haystack)
 	}
 }
+
+func TestRender_VMMetricsLabelledByServiceAndTenant(t *testing.T) {
+	m := newRuntimeMetrics("web")
+	instances := map[string]*vm.Instance{
+		"db": {Name: "db", State: vm.StateRunning, Config: config.ServiceConfig{Name: "db", Metadata: map[string]string{"tenant": "acme"}}},
+	}
+	m.setVMMetrics(instances, map[string]vm.Metrics{
+		"db":   {VCPUExits: 42, BlockReadBytes: 4096, BlockWriteBytes: 512, NetThrottled: 3},
+		"gone": {VCPUExits: 1},
+	})
+
+	out := m.render()
+	assertContains(t, out, "# TYPE firework_vm_vcpu_exits_total counter")
+	assertContains(t, out, `firework_vm_vcpu_exits_total{node="web",service="db",tenant="acme"} 42`)
+	assertContains(t, out, `firework_vm_block_bytes_total{node="web",service="db",tenant="acme",direction="read"} 4096`)
+	assertContains(t, out, `firework_vm_block_bytes_total{node="web",service="db",tenant="acme",direction="write"} 512`)
+	assertContains(t, out, `firework_vm_net_throttled_total{node="web",service="db",tenant="acme"} 3`)
+	if strings.Contains(out, `service="gone"`) {
+		t.Error("counters of VMs the manager no longer lists must not be exported")
+	}
+}
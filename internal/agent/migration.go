@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/store"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// migrationReadyFile is written into an exported snapshot directory after
+// everything else, so the receiving agent never imports a partial export.
+const migrationReadyFile = "ready"
+
+// migrationAbandonedSuffix marks a handoff the receiving node stopped waiting
+// for. An export that finishes afterwards removes its own snapshot instead
+// of leaving it on shared storage.
+const migrationAbandonedSuffix = ".abandoned"
+
+// migrationRoot is the shared-storage directory snapshot handoffs go through.
+// Both nodes must mount the shared storage pool at the configured path.
+func (a *Agent) migrationRoot() string {
+	if a.cfg.Storage.Shared == nil || a.cfg.Storage.Shared.Path == "" {
+		return ""
+	}
+	return filepath.Join(a.cfg.Storage.Shared.Path, "snapshots")
+}
+
+// exportMigrations hands snapshot-mode services over to the nodes the
+// controller moved them to while this node drains. A draining node has no
+// config of its own, so the handoffs are found in the peer node configs.
+// Each exported VM is stopped here and resumed on the new node; if the export
+// fails the VM keeps running and the new node cold boots it after
+// snapshot_migration_timeout.
+func (a *Agent) exportMigrations(ctx context.Context) {
+	root := a.migrationRoot()
+	if root == "" || a.vmManager == nil {
+		return
+	}
+	running := make(map[string]config.ServiceConfig)
+	for name, inst := range a.vmManager.List() {
+		if inst.State == vm.StateRunning && inst.Config.Snapshot {
+			running[name] = inst.Config
+		}
+	}
+	if len(running) == 0 {
+		return
+	}
+	lister, ok := a.store.(store.NodeConfigLister)
+	if !ok {
+		return
+	}
+	nodes, err := lister.ListAllNodeConfigs(ctx)
+	if err != nil {
+		a.logger.Warn("failed to list peer node configs for snapshot migrations", "error", err)
+		return
+	}
+
+	for _, nc := range nodes {
+		for _, mig := range nc.Migrations {
+			svc, ok := running[mig.Service]
+			if mig.From != a.cfg.NodeID || !ok {
+				continue
+			}
+			delete(running, mig.Service)
+
+			dir := filepath.Join(root, mig.ID)
+			if _, err := os.Stat(dir + migrationAbandonedSuffix); err == nil {
+				continue
+			}
+			a.logger.Info("exporting snapshot for migration", "service", mig.Service, "to", nc.Node, "migration", mig.ID)
+			// The VM stops as part of the export; a health check must not
+			// restart it in the meantime.
+			if a.healthMon != nil {
+				a.healthMon.Deregister(mig.Service)
+			}
+			if err := a.vmManager.ExportSnapshot(ctx, mig.Service, dir); err != nil {
+				a.logger.Error("snapshot migration export failed; service keeps running here",
+					"service", mig.Service, "migration", mig.ID, "error", err)
+				if a.healthMon != nil && a.vmManager.IsRunning(mig.Service) {
+					a.healthMon.Register(ctx, svc)
+				}
+				removeMigrationDir(dir)
+				continue
+			}
+			if err := os.WriteFile(filepath.Join(dir, migrationReadyFile), nil, 0o644); err != nil {
+				a.logger.Error("failed to mark exported snapshot ready", "service", mig.Service, "migration", mig.ID, "error", err)
+				continue
+			}
+			if _, err := os.Stat(dir + migrationAbandonedSuffix); err == nil {
+				a.logger.Warn("receiving node stopped waiting for the snapshot; discarding it",
+					"service", mig.Service, "to", nc.Node, "migration", mig.ID)
+				removeMigrationDir(dir)
+				continue
+			}
+			a.logger.Info("exported snapshot for migration", "service", mig.Service, "to", nc.Node, "migration", mig.ID)
+		}
+	}
+}
+
+// receiveMigrations imports the snapshots of services migrating onto this
+// node before they are started, so the first start resumes them. A migrating
+// service is held out of node.Services until its snapshot is ready or
+// snapshot_migration_timeout passes, after which it cold boots. It reports
+// whether any service was held, in which case the revision must not be
+// recorded as applied. Snapshots of handoffs that time out or stop being
+// announced are removed from shared storage.
+func (a *Agent) receiveMigrations(node *config.NodeConfig) bool {
+	doneDir := filepath.Join(a.cfg.StateDir, "migrations")
+	announced := make(map[string]bool, len(node.Migrations))
+	hold := make(map[string]bool)
+	root := a.migrationRoot()
+
+	for _, mig := range node.Migrations {
+		announced[mig.ID] = true
+		donePath := filepath.Join(doneDir, mig.ID+".done")
+		if _, err := os.Stat(donePath); err == nil {
+			continue
+		}
+		if !desiresService(node.Services, mig.Service) {
+			continue
+		}
+		if root == "" || a.vmManager.IsRunning(mig.Service) {
+			a.finishMigration(donePath, mig)
+			continue
+		}
+
+		dir := filepath.Join(root, mig.ID)
+		if _, err := os.Stat(filepath.Join(dir, migrationReadyFile)); err == nil {
+			if err := a.vmManager.ImportSnapshot(mig.Service, dir); err != nil {
+				a.logger.Error("snapshot migration import failed; cold booting",
+					"service", mig.Service, "migration", mig.ID, "error", err)
+			}
+			a.finishMigration(donePath, mig)
+			if err := os.RemoveAll(dir); err != nil {
+				a.logger.Warn("failed to remove imported snapshot from shared storage", "migration", mig.ID, "error", err)
+			}
+			continue
+		}
+
+		first, seen := a.migrationSeen[mig.ID]
+		if !seen {
+			first = time.Now()
+			a.migrationSeen[mig.ID] = first
+		}
+		if time.Since(first) < a.cfg.SnapshotMigrationTimeout {
+			a.logger.Info("waiting for migrating service's snapshot", "service", mig.Service, "from", mig.From, "migration", mig.ID)
+			hold[mig.Service] = true
+			continue
+		}
+		a.logger.Warn("snapshot migration timed out; cold booting", "service", mig.Service, "from", mig.From, "migration", mig.ID)
+		if err := os.WriteFile(dir+migrationAbandonedSuffix, nil, 0o644); err != nil {
+			a.logger.Warn("failed to mark snapshot migration abandoned", "migration", mig.ID, "error", err)
+		}
+		removeMigrationDir(dir)
+		a.finishMigration(donePath, mig)
+	}
+
+	// Forget handoffs the controller no longer announces, together with
+	// anything they left on shared storage.
+	for id := range a.migrationSeen {
+		if !announced[id] {
+			delete(a.migrationSeen, id)
+			if root != "" {
+				removeMigrationDir(filepath.Join(root, id))
+			}
+		}
+	}
+	if entries, err := os.ReadDir(doneDir); err == nil {
+		for _, entry := range entries {
+			if id, ok := strings.CutSuffix(entry.Name(), ".done"); ok && !announced[id] {
+				_ = os.Remove(filepath.Join(doneDir, entry.Name()))
+				if root != "" {
+					removeMigrationDir(filepath.Join(root, id))
+					_ = os.Remove(filepath.Join(root, id) + migrationAbandonedSuffix)
+				}
+			}
+		}
+	}
+
+	if len(hold) == 0 {
+		return false
+	}
+	services := node.Services[:0:0]
+	for _, svc := range node.Services {
+		if !hold[svc.Name] {
+			services = append(services, svc)
+		}
+	}
+	node.Services = services
+	return true
+}
+
+// finishMigration records that a handoff needs no further work on this node.
+// The marker outlives agent restarts so an imported snapshot is not waited
+// for again once it has been consumed.
+func (a *Agent) finishMigration(donePath string, mig config.SnapshotMigration) {
+	delete(a.migrationSeen, mig.ID)
+	err := os.MkdirAll(filepath.Dir(donePath), 0o755)
+	if err == nil {
+		err = os.WriteFile(donePath, nil, 0o644)
+	}
+	if err != nil {
+		a.logger.Warn("failed to record finished snapshot migration", "migration", mig.ID, "error", err)
+	}
+}
+
+// removeMigrationDir deletes an exported snapshot and any partial export
+// left next to it.
+func removeMigrationDir(dir string) {
+	_ = os.RemoveAll(dir)
+	_ = os.RemoveAll(dir + ".partial")
+}
+
+func desiresService(services []config.ServiceConfig, name string) bool {
+	for _, svc := range services {
+		if svc.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+func TestReceiveMigrations_HoldsServiceUntilTimeout(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.Storage.Shared = &config.SharedStorageConfig{Path: t.TempDir()}
+	cfg.SnapshotMigrationTimeout = time.Minute
+	a := &Agent{cfg: cfg, logger: testLogger(), vmManager: vm.NewManager("/bin/false", cfg.StateDir, testLogger()),
+		migrationSeen: make(map[string]time.Time)}
+
+	node := func() *config.NodeConfig {
+		return &config.NodeConfig{
+			Services:   []config.ServiceConfig{{Name: "jvm", Snapshot: true}, {Name: "web"}},
+			Migrations: []config.SnapshotMigration{{Service: "jvm", ID: "jvm-1", From: "node-a"}},
+		}
+	}
+
+	held := node()
+	if !a.receiveMigrations(held) {
+		t.Fatal("expected the migrating service to be held while its snapshot is pending")
+	}
+	if len(held.Services) != 1 || held.Services[0].Name != "web" {
+		t.Fatalf("services = %+v, want only web", held.Services)
+	}
+
+	a.migrationSeen["jvm-1"] = time.Now().Add(-2 * time.Minute)
+	released := node()
+	if a.receiveMigrations(released) {
+		t.Fatal("service must be released for a cold boot after the timeout")
+	}
+	if len(released.Services) != 2 {
+		t.Fatalf("services = %+v, want both", released.Services)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, "migrations", "jvm-1.done")); err != nil {
+		t.Fatalf("finished migration not recorded: %v", err)
+	}
+
+	// A finished migration is not waited for again, and its marker is removed
+	// once the controller stops announcing it.
+	if a.receiveMigrations(node()) {
+		t.Fatal("finished migration must not hold the service")
+	}
+	a.receiveMigrations(&config.NodeConfig{Services: node().Services})
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, "migrations", "jvm-1.done")); !os.IsNotExist(err) {
+		t.Fatalf("marker for unannounced migration should be removed, stat err = %v", err)
+	}
+}
+
+func TestReceiveMigrations_RemovesAbandonedSnapshots(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.Storage.Shared = &config.SharedStorageConfig{Path: t.TempDir()}
+	cfg.SnapshotMigrationTimeout = time.Minute
+	a := &Agent{cfg: cfg, logger: testLogger(), vmManager: vm.NewManager("/bin/false", cfg.StateDir, testLogger()),
+		migrationSeen: make(map[string]time.Time)}
+	root := a.migrationRoot()
+	services := []config.ServiceConfig{{Name: "jvm", Snapshot: true}}
+	migrating := func(id string) *config.NodeConfig {
+		return &config.NodeConfig{Services: services,
+			Migrations: []config.SnapshotMigration{{Service: "jvm", ID: id, From: "node-a"}}}
+	}
+
+	// The first move times out while the source is still exporting.
+	if err := os.MkdirAll(filepath.Join(root, "jvm-1.partial"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a.migrationSeen["jvm-1"] = time.Now().Add(-2 * time.Minute)
+	if a.receiveMigrations(migrating("jvm-1")) {
+		t.Fatal("service must be released for a cold boot after the timeout")
+	}
+	if _, err := os.Stat(filepath.Join(root, "jvm-1.partial")); !os.IsNotExist(err) {
+		t.Fatalf("partial export should be removed on timeout, stat err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "jvm-1"+migrationAbandonedSuffix)); err != nil {
+		t.Fatalf("timed out migration not marked abandoned: %v", err)
+	}
+
+	// The export lands anyway; once the handoff is no longer announced,
+	// nothing of it stays on shared storage.
+	if err := os.MkdirAll(filepath.Join(root, "jvm-1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "jvm-1", migrationReadyFile), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a.receiveMigrations(&config.NodeConfig{Services: services})
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("shared storage still holds %d entries after the migration ended", len(entries))
+	}
+
+	// Moving the same service between the same nodes again waits for the
+	// new export instead of finding an earlier one.
+	if !a.receiveMigrations(migrating("jvm-2")) {
+		t.Fatal("second migration must wait for its own snapshot")
+	}
+}
@@ -38,6 +38,9 @@ type registryClient struct {
 	registered bool
 	certExpiry time.Time
 	mtlsClient *http.Client
+	// unserving holds the peer services the last heartbeat response
+	// reported as not serving.
+	unserving map[string]bool
 }
 
 func newRegistryClient(cfg config.AgentConfig, logger *slog.Logger, generation int64) *registryClient {
@@ -68,13 +71,14 @@ func (c *registryClient) sync(ctx context.Context, nodeID string, labels []strin
 }
 
 type registerRequest struct {
-	NodeID     string         `json:"node_id"`
-	Generation int64          `json:"generation"`
-	Labels     []string       `json:"labels,omitempty"`
-	Capacity   capPayload     `json:"capacity"`
-	State      string         `json:"state"`
-	HostIP     string         `json:"host_ip,omitempty"`
-	Storage    storagePayload `json:"storage,omitempty"`
+	NodeID     string            `json:"node_id"`
+	Generation int64             `json:"generation"`
+	Labels     []string          `json:"labels,omitempty"`
+	Topology   map[string]string `json:"topology,omitempty"`
+	Capacity   capPayload        `json:"capacity"`
+	State      string            `json:"state"`
+	HostIP     string            `json:"host_ip,omitempty"`
+	Storage    storagePayload    `json:"storage,omitempty"`
 }
 
 type heartbeatRequest struct {
@@ -87,9 +91,14 @@ type heartbeatRequest struct {
 	Storage     storagePayload           `json:"storage,omitempty"`
 }
 
+type heartbeatResponse struct {
+	UnservingServices []string `json:"unserving_services,omitempty"`
+}
+
 type capPayload struct {
-	VCPUs    int `json:"vcpus"`
-	MemoryMB int `json:"memory_mb"`
+	VCPUs                 int     `json:"vcpus"`
+	MemoryMB              int     `json:"memory_mb"`
+	MemoryOvercommitRatio float64 `json:"memory_overcommit_ratio,omitempty"`
 }
 
 type storagePayload struct {
@@ -122,9 +131,11 @@ func (c *registryClient) register(ctx context.Context, nodeID string, labels []s
 		NodeID:     nodeID,
 		Generation: c.generation,
 		Labels:     labels,
+		Topology:   c.cfg.Topology,
 		Capacity: capPayload{
-			VCPUs:    cap.VCPUs,
-			MemoryMB: cap.MemoryMB,
+			VCPUs:                 cap.VCPUs,
+			MemoryMB:              cap.MemoryMB,
+			MemoryOvercommitRatio: c.cfg.MemoryOvercommitRatio,
 		},
 		State:   "ready",
 		HostIP:  c.hostIP,
@@ -138,8 +149,9 @@ func (c *registryClient) heartbeat(ctx context.Context, nodeID string, cap, used
 		NodeID:     nodeID,
 		Generation: c.generation,
 		Capacity: capPayload{
-			VCPUs:    cap.VCPUs,
-			MemoryMB: cap.MemoryMB,
+			VCPUs:                 cap.VCPUs,
+			MemoryMB:              cap.MemoryMB,
+			MemoryOvercommitRatio: c.cfg.MemoryOvercommitRatio,
 		},
 		Used: capPayload{
 			VCPUs:    used.VCPUs,
@@ -149,7 +161,26 @@ func (c *registryClient) heartbeat(ctx context.Context, nodeID string, cap, used
 		AgentStatus: status,
 		Storage:     c.storagePayload(),
 	}
-	return c.postMTLS(ctx, "/v1/nodes/heartbeat", req, nil)
+	var resp heartbeatResponse
+	if err := c.postMTLS(ctx, "/v1/nodes/heartbeat", req, &resp); err != nil {
+		return err
+	}
+	unserving := make(map[string]bool, len(resp.UnservingServices))
+	for _, name := range resp.UnservingServices {
+		unserving[name] = true
+	}
+	c.mu.Lock()
+	c.unserving = unserving
+	c.mu.Unlock()
+	return nil
+}
+
+// peerServing reports whether the last heartbeat response left a peer
+// service out of the ones not serving.
+func (c *registryClient) peerServing(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.unserving[name]
 }
 
 func (c *registryClient) storagePayload() storagePayload {
@@ -479,6 +510,9 @@ func buildCSR(nodeID string, key ed25519.PrivateKey) (string, error) {
 	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})), nil
 }
 
+// detectHostIP returns the address peers reach this node on: the source
+// address towards the registry, else the first IPv4 address of a host
+// interface, else the first global IPv6 address for IPv6-only nodes.
 func detectHostIP(registryURL, vmBridge string) string {
 	if ip := detectHostIPByRoute(registryURL); ip != "" {
 		return ip
@@ -511,8 +545,8 @@ func detectHostIPByRoute(registryURL string) string {
 	if !ok {
 		return ""
 	}
-	ip := udpAddr.IP.To4()
-	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+	ip := udpAddr.IP
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
 		return ""
 	}
 	return ip.String()
@@ -550,10 +584,21 @@ func detectHostIPFromInterfaces(vmBridge string) string {
 			Addrs: addrs,
 		})
 	}
-	return selectHostIPv4(candidates, vmBridge)
+	if ip := selectHostIPv4(candidates, vmBridge); ip != "" {
+		return ip
+	}
+	return selectHostIPv6(candidates, vmBridge)
 }
 
 func selectHostIPv4(candidates []hostIPInterface, vmBridge string) string {
+	return selectHostAddr(candidates, vmBridge, false)
+}
+
+func selectHostIPv6(candidates []hostIPInterface, vmBridge string) string {
+	return selectHostAddr(candidates, vmBridge, true)
+}
+
+func selectHostAddr(candidates []hostIPInterface, vmBridge string, ipv6 bool) string {
 	for _, iface := range candidates {
 		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
 			continue
@@ -569,11 +614,10 @@ func selectHostIPv4(candidates []hostIPInterface, vmBridge string) string {
 			case *net.IPAddr:
 				ip = v.IP
 			}
-			if ip == nil {
+			if ip == nil || (ip.To4() == nil) != ipv6 {
 				continue
 			}
-			ip = ip.To4()
-			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
 				continue
 			}
 			return ip.String()
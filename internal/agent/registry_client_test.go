@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/statusmodel"
 )
 
 func TestSelectHostIPv4_SkipsBridgeAndVirtualInterfaces(t *testing.T) {
@@ -61,6 +62,23 @@ func TestSelectHostIPv4_UsesConfiguredVMBridgeFilter(t *testing.T) {
 	}
 }
 
+func TestSelectHostIPv6_SkipsLinkLocal(t *testing.T) {
+	candidates := []hostIPInterface{
+		{
+			Name:  "ens3",
+			Flags: net.FlagUp,
+			Addrs: []net.Addr{ipAddr("fe80::1"), ipAddr("2001:db8:10::8")},
+		},
+	}
+
+	if got := selectHostIPv4(candidates, "br-firework"); got != "" {
+		t.Fatalf("expected no IPv4 address on an IPv6-only node, got %q", got)
+	}
+	if got := selectHostIPv6(candidates, "br-firework"); got != "2001:db8:10::8" {
+		t.Fatalf("expected the global IPv6 address, got %q", got)
+	}
+}
+
 func TestMTLSHTTPClient_ReusesClientInstance(t *testing.T) {
 	caFile := writeTestCA(t)
 	c := &registryClient{
@@ -121,3 +139,16 @@ func writeTestCA(t *testing.T) string {
 	}
 	return path
 }
+
+func TestReplicaServing_UsesLocalStatusAndPeerReports(t *testing.T) {
+	a := &Agent{registryClient: &registryClient{unserving: map[string]bool{"web-2": true}}}
+	a.currentStatus.Services = []statusmodel.ServiceStatus{
+		{Name: "web-0", VMState: "running", Health: "healthy"},
+		{Name: "web-1", VMState: "running", Health: "unhealthy", HealthCheckType: "tcp"},
+	}
+	for name, want := range map[string]bool{"web-0": true, "web-1": false, "web-2": false, "web-3": true} {
+		if got := a.replicaServing(name); got != want {
+			t.Errorf("replicaServing(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
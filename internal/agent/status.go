@@ -1,11 +1,13 @@
 package agent
 
 import (
+	"fmt"
 	"sort"
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
 	"github.com/artemnikitin/firework/internal/healthcheck"
+	"github.com/artemnikitin/firework/internal/reconciler"
 	"github.com/artemnikitin/firework/internal/statusmodel"
 	"github.com/artemnikitin/firework/internal/version"
 	"github.com/artemnikitin/firework/internal/vm"
@@ -24,6 +26,15 @@ func (a *Agent) setStatusServices(node config.NodeConfig, fallbackRevision strin
 			healthCheck := *node.Services[i].HealthCheck
 			services[i].HealthCheck = &healthCheck
 		}
+		if node.Services[i].Balloon != nil {
+			balloon := *node.Services[i].Balloon
+			services[i].Balloon = &balloon
+		}
+		if node.Services[i].RestartPolicy != nil {
+			restartPolicy := *node.Services[i].RestartPolicy
+			services[i].RestartPolicy = &restartPolicy
+		}
+		services[i].Networks = append([]config.NetworkConfig(nil), node.Services[i].Networks...)
 		services[i].Volumes = append([]config.VolumeConfig(nil), node.Services[i].Volumes...)
 	}
 	a.statusMu.Lock()
@@ -94,6 +105,10 @@ func (a *Agent) refreshAgentStatus(phase statusmodel.Phase, code, message string
 
 	a.statusMu.Lock()
 	defer a.statusMu.Unlock()
+	var backoffs map[string]reconciler.RestartBackoff
+	if a.reconciler != nil {
+		backoffs = a.reconciler.RestartBackoffs(a.statusServices)
+	}
 	now := time.Now().UTC()
 	previous := make(map[string]statusmodel.ServiceStatus, len(a.currentStatus.Services))
 	for _, service := range a.currentStatus.Services {
@@ -101,11 +116,12 @@ func (a *Agent) refreshAgentStatus(phase statusmodel.Phase, code, message string
 	}
 
 	services := make([]statusmodel.ServiceStatus, 0, len(a.statusServices))
+	running := make(map[string]bool, len(a.statusServices))
 	ready := 0
 	for _, desired := range a.statusServices {
-		service := statusmodel.ServiceStatus{Name: desired.Name, VMState: "unknown", Health: "unknown"}
+		service := statusmodel.ServiceStatus{Name: desired.Name, Kind: desired.Kind, VMState: "unknown", Health: "unknown"}
 		if desired.Network != nil {
-			service.NetworkAddress = desired.Network.GuestIP
+			service.NetworkAddress = networkAddress(*desired.Network)
 		}
 		if desired.HealthCheck == nil {
 			service.Health = "not_configured"
@@ -116,11 +132,29 @@ func (a *Agent) refreshAgentStatus(phase statusmodel.Phase, code, message string
 			service.VMState = string(instance.State)
 			if instance.State == vm.StateRunning {
 				service.PID = instance.PID
+				running[desired.Name] = true
+				if a.cpu != nil {
+					if percent, ok := a.cpu.utilization(desired.Name, instance.PID, desired.VCPUs, now); ok {
+						service.CPUPercent = &percent
+					}
+				}
+				if stats, ok := a.balloonStats[desired.Name]; ok {
+					service.MemoryUsedMB = stats.UsedMiB()
+					service.BalloonMB = stats.ActualMiB
+				}
 			}
 			if instance.State == vm.StateFailed {
 				service.ReasonCode = "vm_failed"
 				service.Message = statusmodel.BoundedMessage(instance.LastError)
 			}
+			if desired.Kind == config.KindJob && !instance.FinishedAt.IsZero() {
+				service.ExitCode = instance.ExitCode
+				service.FinishedAt = instance.FinishedAt.UTC()
+				service.ReasonCode = "job_failed"
+				if jobSucceeded(instance) {
+					service.ReasonCode = "job_succeeded"
+				}
+			}
 			preparedByID := make(map[string]volume.PreparedVolume, len(instance.Volumes))
 			for _, prepared := range instance.Volumes {
 				preparedByID[prepared.LogicalID] = prepared
@@ -142,6 +176,21 @@ func (a *Agent) refreshAgentStatus(phase statusmodel.Phase, code, message string
 				service.Volumes[i].ResizeGeneration = desiredGeneration[service.Volumes[i].LogicalID]
 			}
 		}
+		if backoff, ok := backoffs[desired.Name]; ok {
+			message := fmt.Sprintf("%d consecutive failures; restarting in %s",
+				backoff.Failures, backoff.Until.Sub(now).Round(time.Second))
+			reason := "crash_loop_backoff"
+			if backoff.LimitReached {
+				message = fmt.Sprintf("%d consecutive failures; restart limit of %d attempts reached, waiting for a config change",
+					backoff.Failures, backoff.MaxAttempts)
+				reason = "restart_limit_reached"
+			}
+			if service.Message != "" {
+				message += ": " + service.Message
+			}
+			service.ReasonCode = reason
+			service.Message = statusmodel.BoundedMessage(message)
+		}
 		if result, ok := results[desired.Name]; ok && service.VMState == string(vm.StateRunning) {
 			service.Health = string(result.Status)
 			service.HealthLastCheckedAt = result.LastChecked.UTC()
@@ -151,7 +200,7 @@ func (a *Agent) refreshAgentStatus(phase statusmodel.Phase, code, message string
 				service.ReasonCode = "health_check_failed"
 			}
 		}
-		if service.VMState == "running" && service.Health != "unhealthy" {
+		if (service.VMState == "running" && service.Health != "unhealthy") || service.ReasonCode == "job_succeeded" {
 			ready++
 		}
 		service.RestartCount = a.restartCounts[desired.Name]
@@ -164,6 +213,9 @@ func (a *Agent) refreshAgentStatus(phase statusmodel.Phase, code, message string
 		services = append(services, service)
 	}
 	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	if a.cpu != nil {
+		a.cpu.retain(running)
+	}
 
 	a.currentStatus.SchemaVersion = statusmodel.SchemaVersion
 	a.currentStatus.AgentVersion = version.Version
@@ -216,3 +268,15 @@ func (a *Agent) refreshAgentStatusFromRuntime() {
 	a.statusMu.RUnlock()
 	a.refreshAgentStatus(phase, code, message)
 }
+
+// networkAddress reports the guest's addresses, IPv4 first, separated by a
+// comma when the guest is dual-stack.
+func networkAddress(n config.NetworkConfig) string {
+	if n.GuestIPv6 == "" {
+		return n.GuestIP
+	}
+	if n.GuestIP == "" {
+		return n.GuestIPv6
+	}
+	return n.GuestIP + "," + n.GuestIPv6
+}
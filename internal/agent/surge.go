@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/network"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// surgeNameSuffix marks the temporary name of a surge replacement VM.
+const surgeNameSuffix = "-surge"
+
+// surgeHooks implements reconciler.SurgeHooks on top of the agent's address
+// assignment and Traefik route management.
+type surgeHooks struct {
+	a *Agent
+}
+
+// SurgeConfig returns svc under a temporary name with a free guest IP, MAC,
+// and TAP device. The kernel ip= and firework.ipv6= arguments are rewritten in
+// place, so once the replacement is promoted its config is exactly what
+// assignNetworking derives for the service on later ticks.
+func (h surgeHooks) SurgeConfig(svc config.ServiceConfig) (config.ServiceConfig, error) {
+	a := h.a
+	if svc.Network == nil {
+		return svc, fmt.Errorf("service %s has no guest network", svc.Name)
+	}
+	_, ipNet, err := net.ParseCIDR(a.cfg.VMSubnet)
+	if err != nil {
+		return svc, fmt.Errorf("invalid vm_subnet %q: %w", a.cfg.VMSubnet, err)
+	}
+	if ipNet.IP.To4() == nil {
+		return svc, fmt.Errorf("vm_subnet %q is not IPv4", a.cfg.VMSubnet)
+	}
+	gateway := stripCIDR(a.cfg.VMGateway)
+	netmask := network.SubnetMaskBits(a.cfg.VMSubnet)
+
+	currentArg := guestIPKernelArg(svc.Network.GuestIP, gateway, netmask)
+	if !hasKernelArgToken(svc.KernelArgs, currentArg) {
+		return svc, fmt.Errorf("service %s does not use the agent-assigned kernel ip= argument", svc.Name)
+	}
+
+	name := svc.Name + surgeNameSuffix
+	usedIPs := make(map[string]bool)
+	usedMACs := make(map[string]bool)
+	usedTAPs := make(map[string]bool)
+	markUsed := func(serviceName string, n *config.NetworkConfig) error {
+		if serviceName == name {
+			return fmt.Errorf("temporary name %s is already in use", name)
+		}
+		if n != nil {
+			usedIPs[n.GuestIP] = true
+			usedMACs[n.GuestMAC] = true
+			usedTAPs[n.Interface] = true
+		}
+		return nil
+	}
+	for instName, inst := range a.vmManager.List() {
+		if err := markUsed(instName, inst.Config.Network); err != nil {
+			return svc, err
+		}
+	}
+	a.statusMu.RLock()
+	for _, desired := range a.statusServices {
+		if err := markUsed(desired.Name, desired.Network); err != nil {
+			a.statusMu.RUnlock()
+			return svc, err
+		}
+	}
+	a.statusMu.RUnlock()
+
+	for idx := 0; ; idx++ {
+		guestIP, ok := guestIPv4ForIndex(ipNet, idx)
+		if !ok {
+			return svc, fmt.Errorf("vm_subnet %s has no free guest IP for a surge replacement", a.cfg.VMSubnet)
+		}
+		mac := guestMACForIndex(idx)
+		tap := surgeTAPName(name, guestIP)
+		if usedIPs[guestIP] || usedMACs[mac] || usedTAPs[tap] || a.addressLeases().reserved(guestIP, mac) {
+			continue
+		}
+
+		out := svc
+		out.Name = name
+		out.Network = &config.NetworkConfig{
+			Interface:   tap,
+			HostDevName: svc.Network.HostDevName,
+			GuestMAC:    mac,
+			GuestIP:     guestIP,
+		}
+		out.KernelArgs = replaceKernelArgToken(svc.KernelArgs, currentArg, guestIPKernelArg(guestIP, gateway, netmask))
+		if out.Network.GuestIPv6 = a.guestIPv6(guestIP); out.Network.GuestIPv6 != "" && svc.Network.GuestIPv6 != "" {
+			out.KernelArgs = replaceKernelArgToken(out.KernelArgs,
+				a.guestIPv6KernelArg(svc.Network.GuestIPv6), a.guestIPv6KernelArg(out.Network.GuestIPv6))
+		}
+		return out, nil
+	}
+}
+
+// SwapRoute records svc's network as the service's current address and
+// re-applies local Traefik routes so ingress follows the replacement before
+// the previous VM is stopped.
+func (h surgeHooks) SwapRoute(svc config.ServiceConfig) error {
+	a := h.a
+	a.statusMu.Lock()
+	services := make([]config.ServiceConfig, len(a.statusServices))
+	for i, desired := range a.statusServices {
+		if desired.Name == svc.Name && svc.Network != nil {
+			network := *svc.Network
+			desired.Network = &network
+			a.statusServices[i] = desired
+		}
+		services[i] = desired
+	}
+	a.statusMu.Unlock()
+
+	if a.traefikMgr == nil {
+		return nil
+	}
+	return a.traefikMgr.SyncLocal(services)
+}
+
+// syncRunningNetworks copies the network of each service's running VM onto the
+// desired config when they differ, which happens only after a surge update
+// promoted a replacement during this tick. It reports whether anything moved.
+func (a *Agent) syncRunningNetworks(services []config.ServiceConfig) bool {
+	instances := a.vmManager.List()
+	moved := false
+	for i := range services {
+		svc := &services[i]
+		inst := instances[svc.Name]
+		if svc.Network == nil || inst == nil || inst.State != vm.StateRunning || inst.Config.Network == nil {
+			continue
+		}
+		if *inst.Config.Network == *svc.Network {
+			continue
+		}
+		network := *inst.Config.Network
+		svc.Network = &network
+		svc.KernelArgs = inst.Config.KernelArgs
+		moved = true
+	}
+	return moved
+}
+
+// surgeTAPName derives a TAP device name for a surge replacement. It stays
+// within IFNAMSIZ-1 and differs from the enricher's "tap-" names.
+func surgeTAPName(name, guestIP string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + guestIP))
+	return fmt.Sprintf("fws-%08x", h.Sum32())
+}
+
+func hasKernelArgToken(kernelArgs, token string) bool {
+	for _, tok := range strings.Fields(kernelArgs) {
+		if tok == "--" {
+			break
+		}
+		if tok == token {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceKernelArgToken replaces the first whole-token occurrence of token
+// before the optional "--" separator, preserving the surrounding whitespace.
+func replaceKernelArgToken(kernelArgs, token, replacement string) string {
+	offset := 0
+	for _, part := range strings.Fields(kernelArgs) {
+		at := offset + strings.Index(kernelArgs[offset:], part)
+		if part == "--" {
+			break
+		}
+		if part == token {
+			return kernelArgs[:at] + replacement + kernelArgs[at+len(part):]
+		}
+		offset = at + len(part)
+	}
+	return kernelArgs
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// startFakeVM runs a long-lived fake firecracker for svc so the VM manager has
+// a running instance with the given network.
+func startFakeVM(t *testing.T, mgr *vm.Manager, svc config.ServiceConfig) {
+	t.Helper()
+	if err := mgr.Start(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mgr.Stop(svc.Name) })
+}
+
+func fakeFirecracker(t *testing.T) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "fake-firecracker")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
+
+func TestAssignNetworking_KeepsRunningVMAddress(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	mgr := vm.NewManager(fakeFirecracker(t), cfg.StateDir, testLogger())
+	startFakeVM(t, mgr, config.ServiceConfig{
+		Name: "b", Image: "/img", Kernel: "/kern", VCPUs: 1, MemoryMB: 128,
+		Network: &config.NetworkConfig{Interface: "fws-0000000b", GuestIP: "172.16.0.2", GuestMAC: "AA:FC:00:00:00:01"},
+	})
+	a := &Agent{cfg: cfg, logger: testLogger(), vmManager: mgr, adoptionDone: true}
+
+	services := []config.ServiceConfig{
+		{Name: "a", Network: &config.NetworkConfig{Interface: "tap-a"}},
+		{Name: "b", Network: &config.NetworkConfig{Interface: "tap-b"}},
+	}
+	a.assignNetworking(services)
+
+	if got := services[1].Network; got.GuestIP != "172.16.0.2" || got.GuestMAC != "AA:FC:00:00:00:01" || got.Interface != "fws-0000000b" {
+		t.Fatalf("running VM address not kept: %+v", got)
+	}
+	if got := services[0].Network; got.GuestIP != "172.16.0.3" || got.GuestMAC != "AA:FC:00:00:00:02" {
+		t.Fatalf("expected a to take the next free slot, got %+v", got)
+	}
+}
+
+func TestSurgeConfig_AllocatesFreeAddressAndRewritesIPArg(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	a := &Agent{cfg: cfg, logger: testLogger(), vmManager: vm.NewManager("/bin/false", cfg.StateDir, testLogger())}
+
+	services := []config.ServiceConfig{
+		{Name: "api", KernelArgs: "console=ttyS0  init=/sbin/fc-init -- /bin/api", Network: &config.NetworkConfig{Interface: "tap-api"}},
+		{Name: "web", KernelArgs: "console=ttyS0", Network: &config.NetworkConfig{Interface: "tap-web"}},
+	}
+	a.assignNetworking(services)
+	a.statusServices = services
+
+	got, err := surgeHooks{a: a}.SurgeConfig(services[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "api-surge" || got.Network.GuestIP != "172.16.0.4" || got.Network.GuestMAC != "AA:FC:00:00:00:03" {
+		t.Fatalf("unexpected surge identity: %s %+v", got.Name, got.Network)
+	}
+	if got.Network.Interface == "tap-api" || len(got.Network.Interface) > 15 {
+		t.Fatalf("unexpected surge TAP %q", got.Network.Interface)
+	}
+	want := "console=ttyS0 init=/sbin/fc-init ip=172.16.0.4::172.16.0.1:255.255.255.0::eth0:off -- /bin/api"
+	if got.KernelArgs != want {
+		t.Fatalf("kernel args = %q, want %q", got.KernelArgs, want)
+	}
+	if services[0].Network.GuestIP != "172.16.0.2" {
+		t.Fatal("SurgeConfig mutated the desired service network")
+	}
+}
+
+func TestSurgeConfig_RewritesIPv6Arg(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	cfg.VMSubnetV6 = "fd00:f1::/64"
+	cfg.VMGatewayV6 = "fd00:f1::1"
+	a := &Agent{cfg: cfg, logger: testLogger(), vmManager: vm.NewManager("/bin/false", cfg.StateDir, testLogger())}
+
+	services := []config.ServiceConfig{{Name: "api", KernelArgs: "console=ttyS0", Network: &config.NetworkConfig{Interface: "tap-api"}}}
+	a.assignNetworking(services)
+	a.statusServices = services
+
+	got, err := surgeHooks{a: a}.SurgeConfig(services[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Network.GuestIPv6 != "fd00:f1::3" {
+		t.Fatalf("expected the surge replacement at fd00:f1::3, got %q", got.Network.GuestIPv6)
+	}
+	want := "console=ttyS0 ip=172.16.0.3::172.16.0.1:255.255.255.0::eth0:off firework.ipv6=fd00:f1::3/64,fd00:f1::1"
+	if got.KernelArgs != want {
+		t.Fatalf("kernel args = %q, want %q", got.KernelArgs, want)
+	}
+}
+
+func TestAssignNetworking_ServiceMovedToAnotherTenantGetsFreshAddress(t *testing.T) {
+	cfg := testAgentConfig(t)
+	cfg.VMSubnet = "172.16.0.0/24"
+	cfg.VMGateway = "172.16.0.1"
+	mgr := vm.NewManager(fakeFirecracker(t), cfg.StateDir, testLogger())
+	startFakeVM(t, mgr, config.ServiceConfig{
+		Name: "app", Image: "/img", Kernel: "/kern", VCPUs: 1, MemoryMB: 128,
+		Metadata: map[string]string{"tenant": "acme"},
+		Network:  &config.NetworkConfig{Interface: "tap-app", GuestIP: "172.16.0.2", GuestMAC: "AA:FC:00:00:00:01"},
+	})
+	a := &Agent{cfg: cfg, logger: testLogger(), vmManager: mgr, adoptionDone: true}
+
+	services := []config.ServiceConfig{{
+		Name: "app", Metadata: map[string]string{"tenant": "globex"},
+		Network: &config.NetworkConfig{Interface: "tap-app"},
+	}}
+	a.assignNetworking(services)
+
+	if got := services[0].Network; got.GuestIP == "172.16.0.2" || got.GuestMAC == "AA:FC:00:00:00:01" {
+		t.Fatalf("address of another tenant's VM was kept: %+v", got)
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// logFollowInterval is how often a followed log file is checked for new
+// lines.
+const logFollowInterval = 500 * time.Millisecond
+
+// logLineTimeLayout is the timestamp Firecracker starts its log lines with.
+// Guest console lines carry no timestamp of their own.
+const logLineTimeLayout = "2006-01-02T15:04:05.999999999"
+
+// LogsProvider locates the log files of a service.
+type LogsProvider interface {
+	// LogFiles returns the files of a service's log stream ("console" or
+	// "vmm"), oldest first, ending with the file currently written to. It
+	// reports false for unknown services.
+	LogFiles(service, stream string) ([]string, bool)
+}
+
+// handleLogs serves a service's log stream as plain text: the rotated files
+// of previous boots first, then the current one. since skips files last
+// written before it, and leading lines stamped before it; follow keeps the
+// response open and streams new lines until the client goes away.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	query := r.URL.Query()
+
+	stream := query.Get("stream")
+	if stream == "" {
+		stream = "console"
+	}
+	if stream != "console" && stream != "vmm" {
+		s.writeJSON(w, http.StatusBadRequest, map[string]any{"error": "stream must be console or vmm"})
+		return
+	}
+	follow := false
+	if value := query.Get("follow"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			s.writeJSON(w, http.StatusBadRequest, map[string]any{"error": "follow must be a boolean"})
+			return
+		}
+		follow = parsed
+	}
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		parsed, err := parseSince(value, time.Now())
+		if err != nil {
+			s.writeJSON(w, http.StatusBadRequest, map[string]any{"error": "since must be an RFC 3339 time or a duration such as 10m"})
+			return
+		}
+		since = parsed
+	}
+
+	files, ok := s.logs.LogFiles(name, stream)
+	if !ok || len(files) == 0 {
+		s.writeJSON(w, http.StatusNotFound, map[string]any{"error": "unknown service " + name})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	rc := http.NewResponseController(w)
+	if follow {
+		// The server's write timeout would cut a followed stream short.
+		_ = rc.SetWriteDeadline(time.Time{})
+	}
+
+	for _, path := range files[:len(files)-1] {
+		if err := writeLogFile(w, path, since); err != nil {
+			s.logger.Debug("log stream ended", "service", name, "error", err)
+			return
+		}
+	}
+	current := files[len(files)-1]
+	f, err := os.Open(current)
+	if err != nil {
+		f = nil
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	if f != nil {
+		if info, err := f.Stat(); err == nil && !since.IsZero() && info.ModTime().Before(since) {
+			_, _ = f.Seek(0, io.SeekEnd)
+		} else if err := copyLogSince(w, f, since); err != nil {
+			s.logger.Debug("log stream ended", "service", name, "error", err)
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+	_ = rc.Flush()
+	if err := followLog(r.Context(), w, rc, current, &f); err != nil {
+		s.logger.Debug("log stream ended", "service", name, "error", err)
+	}
+}
+
+// writeLogFile copies a rotated log file to w unless it was last written
+// before since.
+func writeLogFile(w io.Writer, path string, since time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		// Rotated away while we were reading the previous files.
+		return nil
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && !since.IsZero() && info.ModTime().Before(since) {
+		return nil
+	}
+	return copyLogSince(w, f, since)
+}
+
+// copyLogSince copies r to w, dropping leading lines stamped before since.
+// Copying starts at the first line without a timestamp or with a later one,
+// so an unstamped console log is copied whole.
+func copyLogSince(w io.Writer, r io.Reader, since time.Time) error {
+	if since.IsZero() {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if stamp, ok := logLineTime(line); !ok || !stamp.Before(since) {
+				if _, err := w.Write(line); err != nil {
+					return err
+				}
+				_, err := io.Copy(w, reader)
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// followLog streams what is appended to path until ctx ends. *f is the open
+// current file, or nil if it did not exist yet. A file replaced by rotation
+// is drained and the new one followed from its start; a file truncated in
+// place is followed from its start again.
+func followLog(ctx context.Context, w io.Writer, rc *http.ResponseController, path string, f **os.File) error {
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if *f != nil {
+			current, err := (*f).Stat()
+			if err == nil && !os.SameFile(info, current) {
+				if _, err := io.Copy(w, *f); err != nil {
+					return err
+				}
+				(*f).Close()
+				*f = nil
+			} else if offset, err := (*f).Seek(0, io.SeekCurrent); err == nil && info.Size() < offset {
+				_, _ = (*f).Seek(0, io.SeekStart)
+			}
+		}
+		if *f == nil {
+			if *f, err = os.Open(path); err != nil {
+				*f = nil
+				continue
+			}
+		}
+		if _, err := io.Copy(w, *f); err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// parseSince accepts an RFC 3339 time or a duration counted back from now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// logLineTime parses the timestamp a Firecracker log line starts with, in
+// the host's local time.
+func logLineTime(line []byte) (time.Time, bool) {
+	field, _, _ := bytes.Cut(line, []byte(" "))
+	stamp, err := time.ParseInLocation(logLineTimeLayout, string(field), time.Local)
+	return stamp, err == nil
+}
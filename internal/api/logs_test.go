@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockLogs struct {
+	dir string
+}
+
+func (m *mockLogs) LogFiles(service, stream string) ([]string, bool) {
+	if service != "web" {
+		return nil, false
+	}
+	path := filepath.Join(m.dir, stream+".log")
+	return []string{path + ".1", path}, true
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func logsRequest(srv *Server, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.SetPathValue("name", strings.Split(target, "/")[2])
+	w := httptest.NewRecorder()
+	srv.handleLogs(w, req)
+	return w
+}
+
+func TestHandleLogs_ServesPreviousBootsThenCurrent(t *testing.T) {
+	logs := &mockLogs{dir: t.TempDir()}
+	writeFile(t, filepath.Join(logs.dir, "console.log.1"), "boot 1\n")
+	writeFile(t, filepath.Join(logs.dir, "console.log"), "boot 2\n")
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, nil, nil, logs)
+
+	w := logsRequest(srv, "/services/web/logs")
+	if w.Code != http.StatusOK || w.Body.String() != "boot 1\nboot 2\n" {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if w := logsRequest(srv, "/services/db/logs"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown service, got %d", w.Code)
+	}
+	for _, query := range []string{"stream=kernel", "follow=maybe", "since=yesterday"} {
+		if w := logsRequest(srv, "/services/web/logs?"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestHandleLogs_SinceSkipsOlderFilesAndLines(t *testing.T) {
+	logs := &mockLogs{dir: t.TempDir()}
+	old := filepath.Join(logs.dir, "vmm.log.1")
+	writeFile(t, old, "2024-05-01T09:00:00.000000000 [fc:main] previous boot\n")
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+	stamp := func(ts time.Time) string { return ts.Format(logLineTimeLayout) }
+	writeFile(t, filepath.Join(logs.dir, "vmm.log"),
+		stamp(time.Now().Add(-20*time.Minute))+" [fc:main] early\n"+
+			stamp(time.Now().Add(-time.Minute))+" [fc:main] recent\n"+
+			"continuation\n")
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, nil, nil, logs)
+
+	w := logsRequest(srv, "/services/web/logs?stream=vmm&since=10m")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || strings.Contains(body, "previous boot") || strings.Contains(body, "early") ||
+		!strings.Contains(body, "recent\ncontinuation\n") {
+		t.Fatalf("unexpected response %d %q", w.Code, body)
+	}
+}
+
+func TestHandleLogs_FollowStreamsAcrossRotation(t *testing.T) {
+	logs := &mockLogs{dir: t.TempDir()}
+	current := filepath.Join(logs.dir, "console.log")
+	writeFile(t, current, "first\n")
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, nil, nil, logs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/services/web/logs?follow=true", nil).WithContext(ctx)
+	req.SetPathValue("name", "web")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.handleLogs(w, req)
+	}()
+
+	f, err := os.OpenFile(current, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("second\n")
+	f.Close()
+	time.Sleep(2 * logFollowInterval)
+	// A new boot rotates the file away and starts a fresh one.
+	if err := os.Rename(current, current+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, current, "rebooted\n")
+	time.Sleep(3 * logFollowInterval)
+	cancel()
+	<-done
+
+	if got := w.Body.String(); got != "first\nsecond\nrebooted\n" {
+		t.Fatalf("unexpected followed stream %q", got)
+	}
+}
@@ -23,25 +23,27 @@ type MetricsProvider interface {
 	MetricsText() string
 }
 
-// Server is a lightweight HTTP API that exposes agent status and health
-// check results.
+// Server is a lightweight HTTP API that exposes agent status, health check
+// results and service logs.
 type Server struct {
 	addr    string
 	logger  *slog.Logger
 	status  StatusProvider
 	health  HealthResultsProvider
 	metrics MetricsProvider
+	logs    LogsProvider
 	httpSrv *http.Server
 }
 
 // NewServer creates a new API server.
-func NewServer(addr string, logger *slog.Logger, status StatusProvider, health HealthResultsProvider, metrics MetricsProvider) *Server {
+func NewServer(addr string, logger *slog.Logger, status StatusProvider, health HealthResultsProvider, metrics MetricsProvider, logs LogsProvider) *Server {
 	return &Server{
 		addr:    addr,
 		logger:  logger,
 		status:  status,
 		health:  health,
 		metrics: metrics,
+		logs:    logs,
 	}
 }
 
@@ -54,6 +56,9 @@ func (s *Server) Start() error {
 	if s.metrics != nil {
 		mux.HandleFunc("GET /metrics", s.handleMetrics)
 	}
+	if s.logs != nil {
+		mux.HandleFunc("GET /services/{name}/logs", s.handleLogs)
+	}
 
 	s.httpSrv = &http.Server{
 		Addr:              s.addr,
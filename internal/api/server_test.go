@@ -48,7 +48,7 @@ func noopLogger() *slog.Logger {
 }
 
 func TestHandleHealthz(t *testing.T) {
-	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{})
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{}, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
 	w := httptest.NewRecorder()
@@ -73,7 +73,7 @@ func TestHandleHealthz(t *testing.T) {
 }
 
 func TestHandleStatus(t *testing.T) {
-	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{})
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{}, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/status", nil)
 	w := httptest.NewRecorder()
@@ -103,7 +103,7 @@ func TestHandleStatus(t *testing.T) {
 }
 
 func TestHandleHealth(t *testing.T) {
-	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{})
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{}, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	w := httptest.NewRecorder()
@@ -134,7 +134,7 @@ func TestHandleHealth(t *testing.T) {
 }
 
 func TestHandleHealth_NilProvider(t *testing.T) {
-	srv := NewServer(":0", noopLogger(), &mockStatus{}, nil, &mockMetrics{})
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, nil, &mockMetrics{}, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	w := httptest.NewRecorder()
@@ -147,7 +147,7 @@ func TestHandleHealth_NilProvider(t *testing.T) {
 }
 
 func TestHandleStatus_ContentType(t *testing.T) {
-	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{})
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{}, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/status", nil)
 	w := httptest.NewRecorder()
@@ -161,7 +161,7 @@ func TestHandleStatus_ContentType(t *testing.T) {
 }
 
 func TestHandleMetrics(t *testing.T) {
-	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{})
+	srv := NewServer(":0", noopLogger(), &mockStatus{}, &mockHealth{}, &mockMetrics{}, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
 	w := httptest.NewRecorder()
@@ -7,6 +7,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+
+	"github.com/artemnikitin/firework/internal/config"
 )
 
 // NodeCapacity holds the resource capacity of the node.
@@ -15,6 +17,58 @@ type NodeCapacity struct {
 	MemoryMB int
 }
 
+// MemoryDemand is the memory a set of services asks of a node.
+type MemoryDemand struct {
+	// CommittedMB is the memory_mb of every service at face value.
+	CommittedMB int
+	// ResidentMB is what the services are expected to keep resident: the
+	// face value of services without a balloon, and for ballooned services
+	// their observed usage, but never less than the floor the balloon
+	// leaves them.
+	ResidentMB int
+}
+
+// Add returns d with svc added. observedMB is the memory svc currently uses
+// according to its balloon statistics, or 0 when unknown.
+func (d MemoryDemand) Add(svc config.ServiceConfig, observedMB int) MemoryDemand {
+	d.CommittedMB += svc.MemoryMB
+	if svc.Balloon == nil {
+		d.ResidentMB += svc.MemoryMB
+		return d
+	}
+	d.ResidentMB += min(max(observedMB, svc.Balloon.MinMemoryMB), svc.MemoryMB)
+	return d
+}
+
+// Sub returns d with svc removed; it undoes Add with the same arguments.
+func (d MemoryDemand) Sub(svc config.ServiceConfig, observedMB int) MemoryDemand {
+	added := MemoryDemand{}.Add(svc, observedMB)
+	d.CommittedMB -= added.CommittedMB
+	d.ResidentMB -= added.ResidentMB
+	return d
+}
+
+// Fits reports whether d fits in capacityMB of memory. With a ratio above 1
+// the committed memory may reach ratio times capacityMB as long as the
+// resident memory still fits; otherwise memory counts at face value.
+func (d MemoryDemand) Fits(capacityMB int, ratio float64) bool {
+	if ratio > 1 && d.ResidentMB > capacityMB {
+		return false
+	}
+	return d.FitsCommitted(capacityMB, ratio)
+}
+
+// FitsCommitted is Fits without the resident memory: the committed memory
+// may reach ratio times capacityMB, or capacityMB without overcommit. It
+// ignores observed usage, so a usage spike never un-fits services that
+// already run.
+func (d MemoryDemand) FitsCommitted(capacityMB int, ratio float64) bool {
+	if ratio <= 1 {
+		return d.CommittedMB <= capacityMB
+	}
+	return float64(d.CommittedMB) <= float64(capacityMB)*ratio
+}
+
 // Reader reads node capacity.
 type Reader interface {
 	Read() (NodeCapacity, error)
@@ -45,8 +99,19 @@ func (r *osReader) Read() (NodeCapacity, error) {
 	return NodeCapacity{VCPUs: vcpus, MemoryMB: memMB}, nil
 }
 
+// ReadMemAvailableMB returns the host's MemAvailable in MB, the memory that
+// can be handed out without swapping.
+func ReadMemAvailableMB() (int, error) {
+	return readMeminfoMB("MemAvailable")
+}
+
 // readMemTotalMB parses /proc/meminfo and returns MemTotal in MB.
 func readMemTotalMB() (int, error) {
+	return readMeminfoMB("MemTotal")
+}
+
+// readMeminfoMB returns the /proc/meminfo field with the given key in MB.
+func readMeminfoMB(key string) (int, error) {
 	f, err := os.Open("/proc/meminfo")
 	if err != nil {
 		return 0, err
@@ -56,17 +121,17 @@ func readMemTotalMB() (int, error) {
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if !strings.HasPrefix(line, "MemTotal:") {
+		if !strings.HasPrefix(line, key+":") {
 			continue
 		}
 		// Format: "MemTotal:       16384000 kB"
 		fields := strings.Fields(line)
 		if len(fields) < 2 {
-			return 0, fmt.Errorf("unexpected MemTotal line: %q", line)
+			return 0, fmt.Errorf("unexpected %s line: %q", key, line)
 		}
 		kb, err := strconv.Atoi(fields[1])
 		if err != nil {
-			return 0, fmt.Errorf("parsing MemTotal value %q: %w", fields[1], err)
+			return 0, fmt.Errorf("parsing %s value %q: %w", key, fields[1], err)
 		}
 		return kb / 1024, nil
 	}
@@ -74,5 +139,5 @@ func readMemTotalMB() (int, error) {
 	if err := scanner.Err(); err != nil {
 		return 0, err
 	}
-	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+	return 0, fmt.Errorf("%s not found in /proc/meminfo", key)
 }
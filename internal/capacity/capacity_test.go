@@ -3,6 +3,8 @@ package capacity
 import (
 	"runtime"
 	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
 )
 
 func TestOSReader_ReturnsNonZeroOnLinux(t *testing.T) {
@@ -22,3 +24,32 @@ func TestOSReader_ReturnsNonZeroOnLinux(t *testing.T) {
 		t.Errorf("expected MemoryMB > 0, got %d", cap.MemoryMB)
 	}
 }
+
+func TestMemoryDemand_BalloonedServicesCountObservedUsage(t *testing.T) {
+	plain := config.ServiceConfig{Name: "plain", MemoryMB: 1024}
+	ballooned := config.ServiceConfig{Name: "cache", MemoryMB: 2048, Balloon: &config.BalloonConfig{MinMemoryMB: 512}}
+
+	d := MemoryDemand{}.Add(plain, 0).Add(ballooned, 300)
+	if d.CommittedMB != 3072 || d.ResidentMB != 1536 {
+		t.Fatalf("unexpected demand with usage below the floor: %+v", d)
+	}
+	if d := (MemoryDemand{}).Add(ballooned, 4096); d.ResidentMB != 2048 {
+		t.Fatalf("resident memory must not exceed memory_mb, got %d", d.ResidentMB)
+	}
+	if removed := d.Sub(ballooned, 300); removed != (MemoryDemand{}).Add(plain, 0) {
+		t.Fatalf("Sub did not undo Add: %+v", removed)
+	}
+
+	if d.Fits(2048, 1) {
+		t.Fatal("committed memory beyond capacity must not fit without overcommit")
+	}
+	if !d.Fits(2048, 1.5) {
+		t.Fatal("demand should fit with a 1.5 overcommit ratio")
+	}
+	if d.Fits(2048, 1.4) {
+		t.Fatal("committed memory beyond the overcommit ratio must not fit")
+	}
+	if d.Fits(1024, 4) {
+		t.Fatal("resident memory beyond capacity must not fit")
+	}
+}
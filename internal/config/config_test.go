@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -221,6 +222,83 @@ store_url: "https://github.com/example/configs.git"
 	if cfg.LogLevel != "info" {
 		t.Errorf("expected default log level info, got %s", cfg.LogLevel)
 	}
+	if cfg.MemoryOvercommitRatio != 1 {
+		t.Errorf("expected default memory overcommit ratio 1, got %v", cfg.MemoryOvercommitRatio)
+	}
+	if cfg.ServiceLogs.MaxSizeBytes != 10*MiB || cfg.ServiceLogs.MaxFiles != 5 {
+		t.Errorf("unexpected default service log limits %+v", cfg.ServiceLogs)
+	}
+}
+
+func TestLoadAgentConfig_RejectsInvalidServiceLogSize(t *testing.T) {
+	yaml := `
+store_url: "https://github.com/example/configs.git"
+service_logs:
+  max_size: "10MB"
+`
+
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "agent.yaml")
+	if err := os.WriteFile(cfgPath, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("writing test config: %v", err)
+	}
+
+	_, err := LoadAgentConfig(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), "service_logs.max_size") {
+		t.Errorf("expected service_logs.max_size error, got %v", err)
+	}
+}
+
+func TestLoadAgentConfig_RejectsMemoryOvercommitBelowOne(t *testing.T) {
+	yaml := `
+store_url: "https://github.com/example/configs.git"
+memory_overcommit_ratio: 0.5
+`
+
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "agent.yaml")
+	if err := os.WriteFile(cfgPath, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("writing test config: %v", err)
+	}
+
+	_, err := LoadAgentConfig(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), "memory_overcommit_ratio") {
+		t.Errorf("expected memory_overcommit_ratio error, got %v", err)
+	}
+}
+
+func TestLoadAgentConfig_Topology(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "agent.yaml")
+	write := func(yaml string) {
+		t.Helper()
+		if err := os.WriteFile(cfgPath, []byte(yaml), 0o644); err != nil {
+			t.Fatalf("writing test config: %v", err)
+		}
+	}
+
+	write(`
+store_url: "https://github.com/example/configs.git"
+topology:
+  zone: eu-west-1a
+  rack: r12
+`)
+	cfg, err := LoadAgentConfig(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Topology["zone"] != "eu-west-1a" || cfg.Topology["rack"] != "r12" {
+		t.Errorf("unexpected topology: %v", cfg.Topology)
+	}
+
+	write(`
+store_url: "https://github.com/example/configs.git"
+topology:
+  zone: ""
+`)
+	if _, err := LoadAgentConfig(cfgPath); err == nil || !strings.Contains(err.Error(), "topology") {
+		t.Errorf("expected topology error, got %v", err)
+	}
 }
 
 func TestLoadAgentConfig_MissingStoreURL(t *testing.T) {
@@ -404,6 +482,100 @@ gcs_images_bucket: "my-gcs-images"
 	}
 }
 
+func TestLoadAgentConfig_VMSubnetV6(t *testing.T) {
+	load := func(extra string) (AgentConfig, error) {
+		dir := t.TempDir()
+		cfgPath := filepath.Join(dir, "agent.yaml")
+		if err := os.WriteFile(cfgPath, []byte("node_name: n\nstore_url: u\n"+extra), 0o644); err != nil {
+			t.Fatalf("writing test config: %v", err)
+		}
+		return LoadAgentConfig(cfgPath)
+	}
+
+	cfg, err := load("vm_subnet_v6: fd00:f1::/64\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.VMGatewayV6 != "fd00:f1::1" {
+		t.Errorf("expected default IPv6 gateway fd00:f1::1, got %s", cfg.VMGatewayV6)
+	}
+
+	for _, extra := range []string{
+		"vm_subnet_v6: 10.0.0.0/24\n",
+		"vm_subnet_v6: fd00:f1::/124\n",
+		"vm_subnet_v6: fd00:f1::/64\nvm_gateway_v6: fd00:f2::1\n",
+	} {
+		if _, err := load(extra); err == nil {
+			t.Errorf("expected error for %q", extra)
+		}
+	}
+}
+
+func TestLoadAgentConfig_Networks(t *testing.T) {
+	load := func(extra string) (AgentConfig, error) {
+		dir := t.TempDir()
+		cfgPath := filepath.Join(dir, "agent.yaml")
+		if err := os.WriteFile(cfgPath, []byte("node_name: n\nstore_url: u\n"+extra), 0o644); err != nil {
+			t.Fatalf("writing test config: %v", err)
+		}
+		return LoadAgentConfig(cfgPath)
+	}
+
+	cfg, err := load("networks:\n  - name: data\n    subnet: 10.50.0.0/24\n    bridge: br-data\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Networks) != 1 || cfg.Networks[0].Gateway != "10.50.0.1" {
+		t.Fatalf("expected the data network with gateway 10.50.0.1, got %+v", cfg.Networks)
+	}
+
+	for _, extra := range []string{
+		"networks:\n  - name: default\n    subnet: 10.50.0.0/24\n    bridge: br-data\n",
+		"networks:\n  - name: data\n    subnet: 172.16.0.0/16\n    bridge: br-data\n",
+		"networks:\n  - name: data\n    subnet: 10.50.0.0/24\n    bridge: br-firework\n",
+		"networks:\n  - name: data\n    subnet: 10.50.0.0/24\n    bridge: br-a\n  - name: data\n    subnet: 10.51.0.0/24\n    bridge: br-b\n",
+	} {
+		if _, err := load(extra); err == nil {
+			t.Errorf("expected error for %q", extra)
+		}
+	}
+}
+
+func TestLoadAgentConfig_Jailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		jailer  string
+		wantErr string
+	}{
+		{name: "defaults parent cgroup", jailer: "  binary: /usr/bin/jailer\n  uid: 10000\n  gid: 10000\n"},
+		{name: "missing binary", jailer: "  uid: 10000\n  gid: 10000\n", wantErr: "jailer.binary"},
+		{name: "root uid", jailer: "  binary: /usr/bin/jailer\n  uid: 0\n  gid: 10000\n", wantErr: "not root"},
+		{name: "escaping cgroup", jailer: "  binary: /usr/bin/jailer\n  uid: 1\n  gid: 1\n  parent_cgroup: ../system\n", wantErr: "parent_cgroup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := filepath.Join(t.TempDir(), "agent.yaml")
+			yaml := "node_name: my-node\nstore_type: git\nstore_url: https://example.com/repo.git\njailer:\n" + tt.jailer
+			if err := os.WriteFile(cfgPath, []byte(yaml), 0o644); err != nil {
+				t.Fatalf("writing test config: %v", err)
+			}
+			cfg, err := LoadAgentConfig(cfgPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Jailer.ParentCgroup != "firework" {
+				t.Fatalf("parent_cgroup = %q, want firework", cfg.Jailer.ParentCgroup)
+			}
+		})
+	}
+}
+
 func TestLoadAgentConfig_UnsupportedStoreType(t *testing.T) {
 	yaml := `
 node_name: "my-node"
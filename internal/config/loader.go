@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,6 +25,12 @@ func DefaultAgentConfig() AgentConfig {
 		VMSubnet:                  "172.16.0.0/24",
 		VMGateway:                 "172.16.0.1",
 		VMBridge:                  "br-firework",
+		IPReleaseCooldown:         10 * time.Minute,
+		IPLeaseGrace:              5 * time.Minute,
+		MemoryOvercommitRatio:     1,
+		UpdateHealthTimeout:       2 * time.Minute,
+		SnapshotMigrationTimeout:  5 * time.Minute,
+		ServiceLogs:               ServiceLogsConfig{MaxSize: "10Mi", MaxFiles: 5},
 		RegistryCertRenewBefore:   6 * time.Hour,
 		RegistryHeartbeatInterval: 15 * time.Second,
 	}
@@ -80,6 +87,41 @@ func LoadAgentConfig(path string) (AgentConfig, error) {
 		return cfg, fmt.Errorf("unsupported store_type: %q (expected \"git\", \"s3\", or \"gcs\")", cfg.StoreType)
 	}
 
+	switch cfg.UpdateStrategy {
+	case "", "all-at-once", "rolling", "surge":
+	default:
+		return cfg, fmt.Errorf("unsupported update_strategy: %q (expected \"all-at-once\", \"rolling\", or \"surge\")", cfg.UpdateStrategy)
+	}
+	if cfg.UpdateHealthTimeout <= 0 {
+		return cfg, fmt.Errorf("update_health_timeout must be positive")
+	}
+	if cfg.SnapshotMigrationTimeout <= 0 {
+		return cfg, fmt.Errorf("snapshot_migration_timeout must be positive")
+	}
+	if cfg.IPReleaseCooldown < 0 {
+		return cfg, fmt.Errorf("ip_release_cooldown must not be negative")
+	}
+	if cfg.IPLeaseGrace < 0 {
+		return cfg, fmt.Errorf("ip_lease_grace must not be negative")
+	}
+	if cfg.MemoryOvercommitRatio < 1 {
+		return cfg, fmt.Errorf("memory_overcommit_ratio must be at least 1")
+	}
+	if cfg.VMSubnetV6 != "" {
+		if err := validateVMSubnetV6(&cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if err := validateVMNetworks(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Jailer != nil {
+		if err := validateJailer(cfg.Jailer); err != nil {
+			return cfg, err
+		}
+	}
+
 	// Image sync supports a single provider. The agent picks S3 before GCS, so
 	// reject an ambiguous configuration rather than silently ignoring one.
 	if cfg.S3ImagesBucket != "" && cfg.GCSImagesBucket != "" {
@@ -112,6 +154,26 @@ func LoadAgentConfig(path string) (AgentConfig, error) {
 		}
 	}
 
+	switch cfg.FirewallBackend {
+	case "", "iptables", "nftables":
+	default:
+		return cfg, fmt.Errorf("unsupported firewall_backend: %q (expected \"iptables\" or \"nftables\")", cfg.FirewallBackend)
+	}
+	if cfg.TenantNetworkIsolation && cfg.EnableNetworkSetup != nil && !*cfg.EnableNetworkSetup {
+		return cfg, fmt.Errorf("tenant_network_isolation requires enable_network_setup")
+	}
+
+	for _, label := range cfg.Labels {
+		if strings.TrimSpace(label) == "" || strings.ContainsAny(label, " \t") {
+			return cfg, fmt.Errorf("labels: %q is not a valid label", label)
+		}
+	}
+	for key, value := range cfg.Topology {
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, " \t=") || strings.TrimSpace(value) == "" {
+			return cfg, fmt.Errorf("topology: %q: %q is not a valid topology label", key, value)
+		}
+	}
+
 	if cfg.Storage.Local != nil {
 		if err := validateStoragePath("storage.local.path", cfg.Storage.Local.Path); err != nil {
 			return cfg, err
@@ -138,6 +200,15 @@ func LoadAgentConfig(path string) (AgentConfig, error) {
 		}
 	}
 
+	logSize, err := ParseVolumeSize(cfg.ServiceLogs.MaxSize)
+	if err != nil {
+		return cfg, fmt.Errorf("service_logs.max_size: %w", err)
+	}
+	cfg.ServiceLogs.MaxSizeBytes = logSize
+	if cfg.ServiceLogs.MaxFiles < 0 {
+		return cfg, fmt.Errorf("service_logs.max_files must not be negative")
+	}
+
 	return cfg, nil
 }
 
@@ -152,6 +223,24 @@ func validateStoragePath(field, value string) error {
 	return nil
 }
 
+// validateJailer checks the jailer settings and fills in the default parent
+// cgroup. Running the jailed VMs as root would defeat its purpose.
+func validateJailer(j *JailerConfig) error {
+	if strings.TrimSpace(j.Binary) == "" {
+		return fmt.Errorf("jailer.binary is required")
+	}
+	if j.UID <= 0 || j.GID <= 0 {
+		return fmt.Errorf("jailer.uid and jailer.gid must be positive (not root)")
+	}
+	if j.ParentCgroup == "" {
+		j.ParentCgroup = "firework"
+	}
+	if filepath.IsAbs(j.ParentCgroup) || filepath.Clean(j.ParentCgroup) != j.ParentCgroup || strings.HasPrefix(j.ParentCgroup, "..") {
+		return fmt.Errorf("jailer.parent_cgroup must be a clean relative path")
+	}
+	return nil
+}
+
 // ParseNodeConfig parses a node configuration from raw YAML bytes.
 func ParseNodeConfig(data []byte) (NodeConfig, error) {
 	var nc NodeConfig
@@ -180,3 +269,85 @@ func resolveRegistryBootstrapToken(token, tokenFile string) (string, error) {
 	}
 	return t, nil
 }
+
+// validateVMSubnetV6 checks the IPv6 guest subnet and defaults its gateway.
+// Guests take the IPv6 address at the offset of their IPv4 address, so the
+// IPv6 subnet must be at least as large as vm_subnet.
+func validateVMSubnetV6(cfg *AgentConfig) error {
+	_, subnet6, err := net.ParseCIDR(cfg.VMSubnetV6)
+	if err != nil || subnet6.IP.To4() != nil {
+		return fmt.Errorf("vm_subnet_v6 must be an IPv6 CIDR, got %q", cfg.VMSubnetV6)
+	}
+	_, subnet4, err := net.ParseCIDR(cfg.VMSubnet)
+	if err != nil || subnet4.IP.To4() == nil {
+		return fmt.Errorf("vm_subnet_v6 requires an IPv4 vm_subnet")
+	}
+	ones4, bits4 := subnet4.Mask.Size()
+	ones6, bits6 := subnet6.Mask.Size()
+	if bits6-ones6 < bits4-ones4 {
+		return fmt.Errorf("vm_subnet_v6 %s is smaller than vm_subnet %s", cfg.VMSubnetV6, cfg.VMSubnet)
+	}
+
+	if cfg.VMGatewayV6 == "" {
+		gw := make(net.IP, net.IPv6len)
+		copy(gw, subnet6.IP)
+		gw[net.IPv6len-1]++
+		cfg.VMGatewayV6 = gw.String()
+	}
+	gw := net.ParseIP(cfg.VMGatewayV6)
+	if gw == nil || gw.To4() != nil || !subnet6.Contains(gw) {
+		return fmt.Errorf("vm_gateway_v6 %q is not an IPv6 address in vm_subnet_v6", cfg.VMGatewayV6)
+	}
+	return nil
+}
+
+// validateVMNetworks checks the additional guest networks and defaults their
+// gateways. Their subnets and bridges must not overlap with each other or
+// with the default network.
+func validateVMNetworks(cfg *AgentConfig) error {
+	var subnets []*net.IPNet
+	if _, subnet, err := net.ParseCIDR(cfg.VMSubnet); err == nil {
+		subnets = append(subnets, subnet)
+	}
+	names := make(map[string]bool)
+	bridges := map[string]bool{cfg.VMBridge: true}
+	for i := range cfg.Networks {
+		n := &cfg.Networks[i]
+		switch {
+		case n.Name == "":
+			return fmt.Errorf("networks[%d]: name is required", i)
+		case n.Name == DefaultNetwork:
+			return fmt.Errorf("networks[%d]: name %q is reserved for vm_subnet", i, DefaultNetwork)
+		case names[n.Name]:
+			return fmt.Errorf("networks: duplicate name %q", n.Name)
+		case n.Bridge == "":
+			return fmt.Errorf("network %s: bridge is required", n.Name)
+		case bridges[n.Bridge]:
+			return fmt.Errorf("network %s: bridge %s is already in use", n.Name, n.Bridge)
+		}
+		names[n.Name] = true
+		bridges[n.Bridge] = true
+
+		_, subnet, err := net.ParseCIDR(n.Subnet)
+		if err != nil || subnet.IP.To4() == nil {
+			return fmt.Errorf("network %s: subnet must be an IPv4 CIDR, got %q", n.Name, n.Subnet)
+		}
+		for _, other := range subnets {
+			if other.Contains(subnet.IP) || subnet.Contains(other.IP) {
+				return fmt.Errorf("network %s: subnet %s overlaps %s", n.Name, n.Subnet, other)
+			}
+		}
+		subnets = append(subnets, subnet)
+
+		if n.Gateway == "" {
+			gw := make(net.IP, net.IPv4len)
+			copy(gw, subnet.IP.To4())
+			gw[net.IPv4len-1]++
+			n.Gateway = gw.String()
+		}
+		if gw := net.ParseIP(n.Gateway); gw == nil || !subnet.Contains(gw) {
+			return fmt.Errorf("network %s: gateway %q is not in subnet %s", n.Name, n.Gateway, n.Subnet)
+		}
+	}
+	return nil
+}
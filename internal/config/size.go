@@ -24,6 +24,12 @@ func ParseStorageCapacity(value string) (int64, error) {
 	return parseSize(value, true)
 }
 
+// ParseBandwidth accepts a rate limit in bytes per second with the volume
+// size grammar: a positive integer followed by Mi or Gi.
+func ParseBandwidth(value string) (int64, error) {
+	return parseSize(value, false)
+}
+
 func parseSize(value string, allowTi bool) (int64, error) {
 	value = strings.TrimSpace(value)
 	units := "Mi or Gi"
@@ -16,12 +16,42 @@ type NodeConfig struct {
 	DesiredRevision   string `yaml:"desired_revision,omitempty"`
 	PlacementRevision string `yaml:"placement_revision,omitempty"`
 	RenderedRevision  string `yaml:"rendered_revision,omitempty"`
+	// Migrations lists snapshot-mode services the controller is moving onto
+	// this node from a draining node. They live beside Services so that the
+	// handoff never changes a service's config.
+	Migrations []SnapshotMigration `yaml:"migrations,omitempty"`
+}
+
+// SnapshotMigration asks the agent on node From to snapshot Service and hand
+// it to the node whose config lists the migration, through shared storage.
+type SnapshotMigration struct {
+	// Service is the name of the migrating service.
+	Service string `yaml:"service"`
+	// ID identifies the handoff and names its shared-storage directory.
+	ID string `yaml:"id"`
+	// From is the draining node the service is moving off.
+	From string `yaml:"from"`
 }
 
 // ServiceConfig defines a single service (Firecracker microVM) to run.
 type ServiceConfig struct {
 	// Name is a unique identifier for this service on the node.
 	Name string `yaml:"name"`
+	// Kind is KindService (the default when empty) or KindJob.
+	Kind string `yaml:"kind,omitempty"`
+	// JobRevision is the desired revision a job runs for, set by the agent.
+	// A job runs once per revision: a new one starts it again.
+	JobRevision string `yaml:"job_revision,omitempty"`
+	// Schedule is a cron expression, in UTC, for a job the control-plane
+	// controller starts on a schedule instead of once per revision. Each
+	// trigger becomes a run: a copy of the job under its own name.
+	Schedule string `yaml:"schedule,omitempty"`
+	// ConcurrencyPolicy decides what a trigger does while an earlier run
+	// of the scheduled job is still active. Empty means ConcurrencyForbid.
+	ConcurrencyPolicy string `yaml:"concurrency_policy,omitempty"`
+	// StartingDeadline is how late after its trigger a missed run may
+	// still start. Zero never gives up on the most recent trigger.
+	StartingDeadline time.Duration `yaml:"starting_deadline,omitempty"`
 	// Image is the path or URL to the root filesystem image.
 	Image string `yaml:"image"`
 	// Kernel is the path or URL to the kernel binary.
@@ -34,6 +64,10 @@ type ServiceConfig struct {
 	KernelArgs string `yaml:"kernel_args,omitempty"`
 	// Network holds optional network configuration.
 	Network *NetworkConfig `yaml:"network,omitempty"`
+	// Networks attaches the VM to additional agent networks, each with its
+	// own TAP and address. The guest sees them as eth1, eth2, ... in order.
+	// They require Network.
+	Networks []NetworkConfig `yaml:"networks,omitempty"`
 	// HealthCheck holds optional health check configuration.
 	HealthCheck *HealthCheckConfig `yaml:"health_check,omitempty"`
 	// PortForwards defines host-to-VM port mappings for external access.
@@ -53,6 +87,26 @@ type ServiceConfig struct {
 	// AntiAffinityGroup is an optional group label. The scheduler prefers
 	// placing services with the same group on different nodes.
 	AntiAffinityGroup string `yaml:"anti_affinity_group,omitempty"`
+	// ReplicaOf names the service this one is a replica of, set by the
+	// enricher when it expands replicas. Replicas of the same service are
+	// never placed on the same node and share one Traefik route.
+	ReplicaOf string `yaml:"replica_of,omitempty"`
+	// Autoscaling lets the control-plane controller change how many
+	// replicas of ReplicaOf run. The enricher emits the initial replicas;
+	// the controller adds replicas cloned from the first one and removes
+	// the highest-numbered ones.
+	Autoscaling *Autoscaling `yaml:"autoscaling,omitempty"`
+	// NodeSelector restricts the control-plane scheduler to nodes carrying
+	// the given registry labels.
+	NodeSelector *NodeSelector `yaml:"node_selector,omitempty"`
+	// TopologySpread spreads the service's replicas, or its anti-affinity
+	// group, across the failure domains nodes report under Key before
+	// spreading them across nodes.
+	TopologySpread *TopologySpread `yaml:"topology_spread,omitempty"`
+	// Priority ranks the service for the control-plane scheduler: when
+	// capacity runs out, a service may preempt services with a lower
+	// priority. Zero is the lowest.
+	Priority int `yaml:"priority,omitempty"`
 	// CrossNodeLinks declares env vars to inject from peer services on other nodes.
 	CrossNodeLinks []CrossNodeLink `yaml:"cross_node_links,omitempty"`
 	// NodeHostIPEnv, when non-empty, causes the enricher to inject this node's
@@ -64,6 +118,147 @@ type ServiceConfig struct {
 	// SizeBytes is always resolved by the enricher/controller; agents never
 	// apply application defaults independently.
 	Volumes []VolumeConfig `yaml:"volumes,omitempty"`
+	// Snapshot enables snapshot mode: when the agent suspends the VM it writes
+	// a memory and VM-state snapshot, and the next start restores from it
+	// instead of booting. Snapshots are used once and only for an unchanged
+	// config. Not supported together with Volumes.
+	Snapshot bool `yaml:"snapshot,omitempty"`
+	// RateLimits caps the VM's disk and network I/O. The agent applies
+	// changes to a running VM without restarting it.
+	RateLimits RateLimits `yaml:"rate_limits,omitempty"`
+	// Balloon adds a memory balloon the agent inflates to reclaim unused
+	// guest memory under host memory pressure. Ballooned services can be
+	// overcommitted; see AgentConfig.MemoryOvercommitRatio.
+	Balloon *BalloonConfig `yaml:"balloon,omitempty"`
+	// RestartPolicy decides whether the agent restarts the VM after it
+	// exits, fails to start or fails its health check, and how long it
+	// backs off between consecutive restarts. Nil restarts always with the
+	// default backoff.
+	RestartPolicy *RestartPolicy `yaml:"restart_policy,omitempty"`
+}
+
+// Workload kinds.
+const (
+	// KindService is a long-running service, restarted whenever it stops.
+	KindService = "service"
+	// KindJob runs to completion once per desired revision. Its guest
+	// reports the exit status and a job that succeeded is not restarted.
+	KindJob = "job"
+)
+
+// Concurrency policies of scheduled jobs.
+const (
+	// ConcurrencyForbid skips a trigger while a run is still active.
+	ConcurrencyForbid = "forbid"
+	// ConcurrencyReplace cancels the active runs and starts a new one.
+	ConcurrencyReplace = "replace"
+	// ConcurrencyAllow starts a new run alongside the active ones.
+	ConcurrencyAllow = "allow"
+)
+
+// Restart policies.
+const (
+	// RestartAlways restarts the VM whenever it is not running, also after
+	// it exited cleanly.
+	RestartAlways = "always"
+	// RestartOnFailure restarts the VM after it crashed, failed to start or
+	// failed its health check, but leaves it stopped after a clean exit.
+	RestartOnFailure = "on-failure"
+	// RestartNever leaves the VM as it is once it stopped running.
+	RestartNever = "never"
+)
+
+// Restart backoff used for services without a restart policy and for the
+// fields a policy leaves unset.
+const (
+	DefaultRestartBackoff    = 10 * time.Second
+	DefaultRestartMaxBackoff = 5 * time.Minute
+)
+
+// RestartPolicy configures how the agent restarts a service's VM.
+type RestartPolicy struct {
+	// Policy is RestartAlways, RestartOnFailure or RestartNever. Empty
+	// means RestartAlways.
+	Policy string `yaml:"policy,omitempty"`
+	// MaxAttempts bounds the consecutive restarts; once reached the VM is
+	// left stopped until its config changes. 0 is unlimited.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Backoff is the wait before the second consecutive restart; the first
+	// is immediate. It doubles with each further failure up to MaxBackoff.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+	// MaxBackoff caps the wait between restarts. A VM that stays up this
+	// long is considered recovered and its failures are forgotten.
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// Autoscaling bounds the replicas of a service and sets the CPU
+// utilisation the controller scales them towards.
+type Autoscaling struct {
+	MinReplicas int `yaml:"min_replicas"`
+	MaxReplicas int `yaml:"max_replicas"`
+	// TargetCPUUtilization is the average CPU use of the replicas, in
+	// percent of their vCPUs, the controller keeps them near.
+	TargetCPUUtilization int `yaml:"target_cpu_utilization"`
+	// Cooldown is the least time between two changes of the replica count.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// BalloonConfig configures a service's memory balloon.
+type BalloonConfig struct {
+	// MinMemoryMB is the guest memory the balloon never reclaims, resolved
+	// by the enricher. The scheduler counts at least this much as resident.
+	MinMemoryMB int `yaml:"min_memory_mb"`
+}
+
+// RateLimits holds the Firecracker rate limiters of a service's devices.
+// A zero RateLimit leaves the device unlimited.
+type RateLimits struct {
+	// Rootfs limits the root filesystem drive.
+	Rootfs RateLimit `yaml:"rootfs,omitempty"`
+	// Volumes limits each volume drive separately.
+	Volumes RateLimit `yaml:"volumes,omitempty"`
+	// Network limits each guest network interface, separately in each
+	// direction.
+	Network RateLimit `yaml:"network,omitempty"`
+}
+
+// RateLimit is a pair of token buckets refilled every second. A zero field
+// leaves that dimension unlimited.
+type RateLimit struct {
+	// BandwidthBytes is the sustained throughput in bytes per second.
+	BandwidthBytes int64 `yaml:"bandwidth_bytes,omitempty"`
+	// Ops is the sustained rate in I/O operations (drives) or packets
+	// (network interfaces) per second.
+	Ops int64 `yaml:"ops,omitempty"`
+}
+
+// VMNetwork is an additional guest network of the agent.
+type VMNetwork struct {
+	// Name is what services list in networks. "default" is reserved.
+	Name string `yaml:"name"`
+	// Subnet is the IPv4 CIDR guest addresses are allocated from.
+	Subnet string `yaml:"subnet"`
+	// Gateway is the host's address on the bridge. Defaults to the first
+	// address of Subnet.
+	Gateway string `yaml:"gateway,omitempty"`
+	// Bridge is the name of the network's bridge device.
+	Bridge string `yaml:"bridge"`
+}
+
+// NodeSelector matches node labels, which are the node_names an agent
+// registers with. A node must carry every Required label to host the
+// service; among those, nodes carrying more Preferred labels are tried first.
+type NodeSelector struct {
+	Required  []string `yaml:"required,omitempty"`
+	Preferred []string `yaml:"preferred,omitempty"`
+}
+
+// TopologySpread bounds how unevenly a group of services may be spread
+// across the values of a node topology key. A new member may not make the
+// most loaded domain exceed the least loaded one by more than MaxSkew.
+type TopologySpread struct {
+	Key     string `yaml:"key"`
+	MaxSkew int    `yaml:"max_skew"`
 }
 
 // VolumeType identifies the persistence and placement semantics of a volume.
@@ -90,6 +285,23 @@ type VolumeConfig struct {
 	ResizeGeneration int64      `yaml:"resize_generation,omitempty" json:"resize_generation,omitempty"`
 }
 
+// JailerConfig configures Firecracker's jailer. Each VM runs chrooted under
+// state_dir/jail as UID:GID, in its own cgroup v2 group below ParentCgroup
+// whose CPU and memory limits follow the service's VCPUs and MemoryMB.
+type JailerConfig struct {
+	// Binary is the path to the jailer executable.
+	Binary string `yaml:"binary"`
+	// UID and GID are the unprivileged user and group Firecracker runs as.
+	UID int `yaml:"uid"`
+	GID int `yaml:"gid"`
+	// ParentCgroup is the cgroup v2 group, relative to /sys/fs/cgroup, that
+	// holds the per-VM groups. Default: "firework".
+	ParentCgroup string `yaml:"parent_cgroup,omitempty"`
+	// SeccompFilter optionally replaces Firecracker's built-in seccomp filter
+	// with a compiled BPF filter file. The built-in filter applies otherwise.
+	SeccompFilter string `yaml:"seccomp_filter,omitempty"`
+}
+
 // StorageConfig describes host storage pools supplied and mounted by the
 // deployment operator. Firework never provisions cloud storage resources.
 type StorageConfig struct {
@@ -97,6 +309,16 @@ type StorageConfig struct {
 	Shared *SharedStorageConfig `yaml:"shared,omitempty"`
 }
 
+// ServiceLogsConfig configures the rotation of per-service log files.
+type ServiceLogsConfig struct {
+	// MaxSize is the size at which a log file is rotated (Mi or Gi).
+	MaxSize      string `yaml:"max_size,omitempty"`
+	MaxSizeBytes int64  `yaml:"-"`
+	// MaxFiles is how many rotated files of each log stream are kept. Every
+	// boot starts new files, so these include the logs of previous boots.
+	MaxFiles int `yaml:"max_files,omitempty"`
+}
+
 // LocalStorageConfig configures a node-affine storage pool.
 type LocalStorageConfig struct {
 	Path          string `yaml:"path"`
@@ -143,10 +365,20 @@ type ServiceLink struct {
 	Port int `yaml:"port"`
 	// Protocol is the URL scheme. Defaults to "http" if empty.
 	Protocol string `yaml:"protocol,omitempty"`
+	// Network resolves the target's address on one of its additional
+	// networks instead of the default one.
+	Network string `yaml:"network,omitempty"`
 }
 
+// DefaultNetwork names the agent's primary guest network (vm_subnet on
+// vm_bridge), which ServiceConfig.Network attaches to.
+const DefaultNetwork = "default"
+
 // NetworkConfig defines network settings for a microVM.
 type NetworkConfig struct {
+	// Name is the agent network an additional attachment joins. It is empty
+	// for the default network.
+	Name string `yaml:"name,omitempty"`
 	// Interface is the name of the tap device on the host.
 	Interface string `yaml:"interface"`
 	// HostDevName is the host network device to bridge to.
@@ -155,6 +387,9 @@ type NetworkConfig struct {
 	GuestMAC string `yaml:"guest_mac,omitempty"`
 	// GuestIP is the static IP to assign inside the guest (CIDR notation).
 	GuestIP string `yaml:"guest_ip,omitempty"`
+	// GuestIPv6 is the static IPv6 address to assign inside the guest when
+	// the agent sets vm_subnet_v6. fc-init configures it.
+	GuestIPv6 string `yaml:"guest_ipv6,omitempty"`
 }
 
 // PortForward maps a host port to a VM port via iptables DNAT.
@@ -172,9 +407,12 @@ type HealthCheckConfig struct {
 	// Target is the address or command depending on the type.
 	// For HTTP: "http://guest-ip:port/path"
 	// For TCP:  "guest-ip:port"
-	// For Exec: not yet implemented
+	// For Exec: unused; see Command
 	// When empty, the agent composes it from Port, Path, and the allocated guest IP.
 	Target string `yaml:"target,omitempty"`
+	// Command is the argv run inside the guest when Type is "exec". The
+	// guest's fc-init runs it over vsock; exit code 0 means healthy.
+	Command []string `yaml:"command,omitempty"`
 	// Port is the service port for health checks. The agent uses this
 	// together with the allocated guest IP to compose the Target when
 	// Target is not set directly.
@@ -228,6 +466,9 @@ type AgentConfig struct {
 	FirecrackerBin string `yaml:"firecracker_bin"`
 	// StateDir is where the agent stores runtime state.
 	StateDir string `yaml:"state_dir"`
+	// Jailer, when set, starts every VM through Firecracker's jailer instead
+	// of running firecracker directly as the agent's user.
+	Jailer *JailerConfig `yaml:"jailer,omitempty"`
 	// LogLevel controls verbosity: "debug", "info", "warn", "error".
 	LogLevel string `yaml:"log_level"`
 	// APIListenAddr is the address for the status/health HTTP API (e.g. ":8080").
@@ -240,6 +481,14 @@ type AgentConfig struct {
 	// NodeNames lists all labels for this node. The agent fetches and merges
 	// configs for each name. Overrides NodeName when non-empty.
 	NodeNames []string `yaml:"node_names,omitempty"`
+	// Labels are extra registry labels for control-plane placement, such as
+	// a tenant pool (<label>=<tenant>). Unlike NodeNames they are not fetched
+	// as configs.
+	Labels []string `yaml:"labels,omitempty"`
+	// Topology places the node in failure domains, such as zone and rack,
+	// keyed by domain. The control-plane scheduler spreads services that set
+	// a topology_spread across them.
+	Topology map[string]string `yaml:"topology,omitempty"`
 	// S3ImagesBucket is the S3 bucket containing VM images (rootfs, kernels).
 	// If empty, image sync is disabled (images must be pre-placed on disk).
 	S3ImagesBucket string `yaml:"s3_images_bucket,omitempty"`
@@ -253,18 +502,61 @@ type AgentConfig struct {
 	VMGateway string `yaml:"vm_gateway,omitempty"`
 	// VMBridge is the name of the shared bridge device.
 	VMBridge string `yaml:"vm_bridge,omitempty"`
+	// VMSubnetV6 is the CIDR subnet for VM guest IPv6 addresses. When set,
+	// guests are dual-stack: each gets the IPv6 address at the same offset
+	// as its IPv4 address in vm_subnet.
+	VMSubnetV6 string `yaml:"vm_subnet_v6,omitempty"`
+	// VMGatewayV6 is the IPv6 gateway assigned to the shared bridge.
+	// Defaults to the first address of vm_subnet_v6.
+	VMGatewayV6 string `yaml:"vm_gateway_v6,omitempty"`
+	// Networks are additional guest networks services can attach to by
+	// name, each on its own bridge and subnet. They are node-local and not
+	// masqueraded.
+	Networks []VMNetwork `yaml:"networks,omitempty"`
+	// IPReleaseCooldown is how long a released guest IP and MAC stay out of
+	// the pool before another service may lease them.
+	IPReleaseCooldown time.Duration `yaml:"ip_release_cooldown,omitempty"`
+	// IPLeaseGrace is how long a service may be missing from the desired
+	// state before its lease is released, so a service that briefly drops
+	// out keeps its address.
+	IPLeaseGrace time.Duration `yaml:"ip_lease_grace,omitempty"`
 	// OutInterface is the host's external network interface for masquerade.
 	OutInterface string `yaml:"out_interface,omitempty"`
+	// TenantNetworkIsolation separates tenants (metadata.tenant) on the
+	// shared bridge: guests only reach guests of their own tenant, plus the
+	// ports their links name.
+	TenantNetworkIsolation bool `yaml:"tenant_network_isolation,omitempty"`
+	// FirewallBackend programs port forwards, masquerade, and tenant
+	// isolation: "iptables" (default) or "nftables", which replaces the
+	// firework-owned nftables tables atomically over netlink.
+	FirewallBackend string `yaml:"firewall_backend,omitempty"`
 	// EnableCapacityCheck enables node resource capacity checking. Default: true.
 	// When enabled, the agent reads vCPU and memory from the OS and skips
 	// reconciliation if desired services exceed available resources.
 	EnableCapacityCheck *bool `yaml:"enable_capacity_check,omitempty"`
+	// MemoryOvercommitRatio lets the memory_mb of ballooned services add up
+	// to this multiple of host memory, as long as what they are expected to
+	// keep resident still fits. 1 (default) disables overcommit. The agent
+	// reports it with its capacity so the scheduler admits the same.
+	MemoryOvercommitRatio float64 `yaml:"memory_overcommit_ratio,omitempty"`
 	// UpdateStrategy controls how service updates are applied.
 	// "" or "all-at-once" (default): all updates applied simultaneously.
 	// "rolling": updates applied one at a time with UpdateDelay between each.
+	// "surge": like rolling, but each replacement VM starts next to the old
+	// one and traffic moves only after it reports healthy.
 	UpdateStrategy string `yaml:"update_strategy,omitempty"`
 	// UpdateDelay is the pause between individual service updates in rolling mode.
 	UpdateDelay time.Duration `yaml:"update_delay,omitempty"`
+	// UpdateHealthTimeout bounds how long a surge replacement may take to
+	// report healthy before the update is abandoned and the old VM kept.
+	UpdateHealthTimeout time.Duration `yaml:"update_health_timeout,omitempty"`
+	// SuspendOnShutdown makes the agent snapshot and stop snapshot-mode VMs
+	// when it shuts down, instead of leaving them running for adoption. Use it
+	// where agent shutdown usually means a host reboot.
+	SuspendOnShutdown bool `yaml:"suspend_on_shutdown,omitempty"`
+	// SnapshotMigrationTimeout bounds how long the agent waits for a draining
+	// node to export a migrating service's snapshot before cold booting it.
+	SnapshotMigrationTimeout time.Duration `yaml:"snapshot_migration_timeout,omitempty"`
 	// TraefikConfigDir is the directory where the agent writes per-service
 	// Traefik dynamic config files. Traefik's file provider watches this
 	// directory and picks up changes without a reload.
@@ -278,6 +570,8 @@ type AgentConfig struct {
 	IngressDomain string `yaml:"ingress_domain,omitempty"`
 	// Storage contains optional operator-provided persistent storage pools.
 	Storage StorageConfig `yaml:"storage,omitempty"`
+	// ServiceLogs bounds the console and VMM log files kept per service.
+	ServiceLogs ServiceLogsConfig `yaml:"service_logs,omitempty"`
 
 	// RegistryURL enables control-plane registry integration when set.
 	// The agent will enroll/renew mTLS certificates and send register/heartbeat
@@ -0,0 +1,368 @@
+package controlplane
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/enricher"
+	"github.com/artemnikitin/firework/internal/statusmodel"
+)
+
+// autoscalingHistoryLimit is how many decisions are kept per autoscaled
+// service.
+const autoscalingHistoryLimit = 20
+
+// autoscalingDecisionIDLayout formats the decision time in a decision's key,
+// so keys sort in the order the decisions were made.
+const autoscalingDecisionIDLayout = "20060102T150405.000000000Z"
+
+// autoscalingTolerance is how far, as a fraction of the target, the average
+// CPU utilisation may drift before the replica count changes. It keeps a
+// service near its target from flapping between two counts.
+const autoscalingTolerance = 0.1
+
+// Reason codes of autoscaling decisions.
+const (
+	autoscalingCPUAbove    = "cpu_above_target"
+	autoscalingCPUBelow    = "cpu_below_target"
+	autoscalingBelowMin    = "below_min_replicas"
+	autoscalingAboveMax    = "above_max_replicas"
+	autoscalingNodeLimited = "node_capacity_limited"
+)
+
+// replicaObservation is what the agents report about one replica.
+type replicaObservation struct {
+	node   string
+	status statusmodel.ServiceStatus
+}
+
+// autoscaleServices decides the replica count of every autoscaled service
+// in services and returns the services to place with each autoscaled one
+// cut or grown to its count. Each change is written as its own decision
+// record.
+//
+// The state of every service is written conditionally on the record read
+// before, and a service whose state was written by a leader with a newer
+// epoch is left alone, so a deposed leader cannot scale after a failover.
+// Records of services that are no longer autoscaled are only pruned after
+// leadership is confirmed, and never when a newer epoch wrote them.
+func (c *Controller) autoscaleServices(ctx context.Context, services []config.ServiceConfig, now time.Time) ([]config.ServiceConfig, error) {
+	groups := autoscaledGroups(services)
+	keys, err := c.store.ListKeys(ctx, autoscalingPrefix(c.cfg.State.Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("listing autoscaling state: %w", err)
+	}
+	if len(groups) == 0 && len(keys) == 0 {
+		return services, nil
+	}
+	if !c.stillLeader(ctx) {
+		return nil, fmt.Errorf("lost leadership before autoscaling")
+	}
+	c.pruneAutoscalingState(ctx, keys, groups)
+	if len(groups) == 0 {
+		return services, nil
+	}
+	nodes, observed, err := c.autoscalingObservations(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(groups))
+	for _, name := range sortedKeys(groups) {
+		count, err := c.scaleService(ctx, name, groups[name], nodes, observed, now)
+		if err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+	return scaleReplicas(services, counts), nil
+}
+
+// autoscaledGroups returns the replicas of each autoscaled service in
+// services, keyed by the service they are replicas of.
+func autoscaledGroups(services []config.ServiceConfig) map[string][]config.ServiceConfig {
+	groups := make(map[string][]config.ServiceConfig)
+	for _, svc := range services {
+		if svc.Autoscaling != nil && svc.ReplicaOf != "" {
+			groups[svc.ReplicaOf] = append(groups[svc.ReplicaOf], svc)
+		}
+	}
+	return groups
+}
+
+// scaleReplicas returns services with the replicas of each service in
+// counts cut or grown to its count, in place of the replicas services has.
+// Missing replicas are cloned from the first one.
+func scaleReplicas(services []config.ServiceConfig, counts map[string]int) []config.ServiceConfig {
+	groups := autoscaledGroups(services)
+	out := make([]config.ServiceConfig, 0, len(services))
+	for _, svc := range services {
+		group, ok := groups[svc.ReplicaOf]
+		count, scaled := counts[svc.ReplicaOf]
+		if svc.Autoscaling == nil || !ok || !scaled {
+			out = append(out, svc)
+			continue
+		}
+		if group[0].Name != svc.Name {
+			// Emitted with the first replica.
+			continue
+		}
+		byName := make(map[string]config.ServiceConfig, len(group))
+		for _, replica := range group {
+			byName[replica.Name] = replica
+		}
+		for i := range count {
+			name := enricher.ReplicaName(svc.ReplicaOf, i)
+			replica, exists := byName[name]
+			if !exists {
+				replica = enricher.RenameService(group[0], name)
+			}
+			out = append(out, replica)
+		}
+	}
+	return out
+}
+
+// autoscalingObservations returns the ready nodes that heartbeat and what
+// they report about each service, keyed by service name.
+func (c *Controller) autoscalingObservations(ctx context.Context, now time.Time) ([]NodeRecord, map[string]replicaObservation, error) {
+	keys, err := c.store.ListKeys(ctx, registryNodesPrefix(c.cfg.State.Prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	var nodes []NodeRecord
+	observed := make(map[string]replicaObservation)
+	for _, key := range keys {
+		var rec NodeRecord
+		_, exists, err := c.store.GetJSON(ctx, key, &rec)
+		if err != nil {
+			c.logger.Warn("failed reading node record", "key", key, "error", err)
+			continue
+		}
+		if !exists || rec.State != NodeStateReady || rec.LastSeenAt.IsZero() || now.Sub(rec.LastSeenAt) > c.cfg.NodeStaleTTL {
+			continue
+		}
+		nodes = append(nodes, rec)
+		if rec.AgentStatus == nil {
+			continue
+		}
+		for _, svc := range rec.AgentStatus.Services {
+			observed[svc.Name] = replicaObservation{node: rec.NodeID, status: svc}
+		}
+	}
+	return nodes, observed, nil
+}
+
+// scaleService decides the replica count of the service whose replicas are
+// group and records a change. It returns the count to place.
+func (c *Controller) scaleService(ctx context.Context, name string, group []config.ServiceConfig, nodes []NodeRecord, observed map[string]replicaObservation, now time.Time) (int, error) {
+	policy := *group[0].Autoscaling
+	key := autoscalingStateKey(c.cfg.State.Prefix, name)
+	var state AutoscalingState
+	token, exists, err := c.store.GetJSON(ctx, key, &state)
+	if err != nil {
+		return 0, fmt.Errorf("reading autoscaling state of %s: %w", name, err)
+	}
+	if exists && state.LeaderEpoch > c.epoch {
+		return 0, fmt.Errorf("autoscaled service %s is owned by leader epoch %d, this controller has %d", name, state.LeaderEpoch, c.epoch)
+	}
+	if !exists {
+		// A new service starts with the replicas the enricher emitted and
+		// is given a cooldown to warm up before it is scaled.
+		state = AutoscalingState{Service: name, Replicas: len(group), LastScaledAt: now, LeaderEpoch: c.epoch, UpdatedAt: now}
+		if _, _, err := c.store.PutJSONIfAbsent(ctx, key, state); err != nil {
+			return 0, fmt.Errorf("creating autoscaling state of %s: %w", name, err)
+		}
+		return state.Replicas, nil
+	}
+
+	var cpu []int
+	for i := range state.Replicas {
+		obs, ok := observed[enricher.ReplicaName(name, i)]
+		if ok && obs.status.VMState == "running" && obs.status.Health != "unhealthy" && obs.status.CPUPercent != nil {
+			cpu = append(cpu, *obs.status.CPUPercent)
+		}
+	}
+	decision, changed := decideReplicas(policy, state.Replicas, cpu, replicaRoom(group[0], state.Replicas, nodes, observed))
+	if !changed {
+		return state.Replicas, nil
+	}
+	if bounded := decision.ReasonCode == autoscalingBelowMin || decision.ReasonCode == autoscalingAboveMax; !bounded && now.Sub(state.LastScaledAt) < policy.Cooldown {
+		c.logger.Debug("autoscaling in cooldown", "service", name, "replicas", state.Replicas, "wanted", decision.To,
+			"until", state.LastScaledAt.Add(policy.Cooldown))
+		return state.Replicas, nil
+	}
+	decision.Service = name
+	decision.DecidedAt = now
+	decision.LeaderEpoch = c.epoch
+
+	state.Replicas = decision.To
+	state.LastScaledAt = now
+	state.LeaderEpoch = c.epoch
+	state.UpdatedAt = now
+	ok, _, err := c.store.PutJSONIfMatch(ctx, key, token, state)
+	if err != nil {
+		return 0, fmt.Errorf("updating autoscaling state of %s: %w", name, err)
+	}
+	if !ok {
+		return 0, fmt.Errorf("autoscaling state of %s changed concurrently", name)
+	}
+	if _, err := c.store.PutJSON(ctx, autoscalingDecisionKey(c.cfg.State.Prefix, name, now), decision); err != nil {
+		c.logger.Warn("recording autoscaling decision failed", "service", name, "error", err)
+	}
+	c.pruneAutoscalingDecisions(ctx, name)
+	c.logger.Info("scaled service", "service", name, "from", decision.From, "to", decision.To,
+		"reason", decision.ReasonCode, "average_cpu_percent", decision.AverageCPU)
+	return state.Replicas, nil
+}
+
+// decideReplicas returns the replica count a service with current replicas
+// should have, given the CPU utilisation its replicas report and the most
+// replicas the nodes have room for. The count moves in proportion to how far
+// the average utilisation is from the target, within the policy's bounds.
+// changed is false when the count stays as it is.
+func decideReplicas(policy config.Autoscaling, current int, cpu []int, room int) (decision AutoscalingDecision, changed bool) {
+	decision = AutoscalingDecision{From: current, To: current, TargetCPU: policy.TargetCPUUtilization, Samples: len(cpu)}
+	if len(cpu) > 0 {
+		sum := 0
+		for _, percent := range cpu {
+			sum += percent
+		}
+		decision.AverageCPU = int(math.Round(float64(sum) / float64(len(cpu))))
+	}
+	switch {
+	case current < policy.MinReplicas:
+		decision.To = policy.MinReplicas
+		decision.ReasonCode = autoscalingBelowMin
+		decision.Message = fmt.Sprintf("raised to min_replicas %d", policy.MinReplicas)
+		return decision, true
+	case current > policy.MaxReplicas:
+		decision.To = policy.MaxReplicas
+		decision.ReasonCode = autoscalingAboveMax
+		decision.Message = fmt.Sprintf("lowered to max_replicas %d", policy.MaxReplicas)
+		return decision, true
+	case len(cpu) == 0 || policy.TargetCPUUtilization <= 0:
+		return decision, false
+	}
+
+	ratio := float64(decision.AverageCPU) / float64(policy.TargetCPUUtilization)
+	if math.Abs(ratio-1) <= autoscalingTolerance {
+		return decision, false
+	}
+	want := min(max(int(math.Ceil(float64(current)*ratio)), policy.MinReplicas), policy.MaxReplicas)
+	switch {
+	case want < current:
+		decision.To = want
+		decision.ReasonCode = autoscalingCPUBelow
+		decision.Message = fmt.Sprintf("average CPU %d%% over %d replicas is below the %d%% target", decision.AverageCPU, len(cpu), policy.TargetCPUUtilization)
+	case want > current && room > current:
+		decision.To = min(want, room)
+		decision.ReasonCode = autoscalingCPUAbove
+		decision.Message = fmt.Sprintf("average CPU %d%% over %d replicas is above the %d%% target", decision.AverageCPU, len(cpu), policy.TargetCPUUtilization)
+		if want > room {
+			decision.ReasonCode = autoscalingNodeLimited
+			decision.Message += fmt.Sprintf("; %d replicas wanted, nodes have room for %d", want, room)
+		}
+	default:
+		return decision, false
+	}
+	return decision, true
+}
+
+// replicaRoom returns how many replicas of svc the ready nodes can run:
+// one on each node running one of its current replicas, and one on each
+// other node that carries its required labels and reports enough free
+// vCPUs and memory for another.
+func replicaRoom(svc config.ServiceConfig, current int, nodes []NodeRecord, observed map[string]replicaObservation) int {
+	hosting := make(map[string]bool, current)
+	for i := range current {
+		if obs, ok := observed[enricher.ReplicaName(svc.ReplicaOf, i)]; ok {
+			hosting[obs.node] = true
+		}
+	}
+	room := 0
+	for _, node := range nodes {
+		switch {
+		case hosting[node.NodeID]:
+			room++
+		case svc.NodeSelector != nil && !containsAll(node.Labels, svc.NodeSelector.Required):
+		case node.Capacity.VCPUs-node.Used.VCPUs >= svc.VCPUs && node.Capacity.MemoryMB-node.Used.MemoryMB >= svc.MemoryMB:
+			room++
+		}
+	}
+	return room
+}
+
+func containsAll(labels, required []string) bool {
+	for _, label := range required {
+		if !slices.Contains(labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// pruneAutoscalingDecisions deletes the oldest decisions of service beyond
+// the history limit.
+func (c *Controller) pruneAutoscalingDecisions(ctx context.Context, service string) {
+	keys, err := c.store.ListKeys(ctx, autoscalingDecisionsPrefix(c.cfg.State.Prefix, service))
+	if err != nil {
+		c.logger.Warn("listing autoscaling decisions failed", "service", service, "error", err)
+		return
+	}
+	sort.Strings(keys)
+	for _, key := range keys[:max(len(keys)-autoscalingHistoryLimit, 0)] {
+		c.deleteAutoscalingRecord(ctx, key)
+	}
+}
+
+// pruneAutoscalingState deletes the state and decisions of the services in
+// keys that are no longer autoscaled.
+func (c *Controller) pruneAutoscalingState(ctx context.Context, keys []string, groups map[string][]config.ServiceConfig) {
+	for _, key := range keys {
+		service, _, ok := strings.Cut(strings.TrimPrefix(key, autoscalingPrefix(c.cfg.State.Prefix)), "/")
+		if !ok || groups[service] != nil {
+			continue
+		}
+		c.deleteAutoscalingRecord(ctx, key)
+	}
+}
+
+// deleteAutoscalingRecord deletes an autoscaling state or decision record
+// unless a leader with a newer epoch wrote it, so a deposed leader cannot
+// discard what its successor recorded.
+func (c *Controller) deleteAutoscalingRecord(ctx context.Context, key string) {
+	var record struct {
+		LeaderEpoch int64 `json:"leader_epoch"`
+	}
+	_, exists, err := c.store.GetJSON(ctx, key, &record)
+	if err != nil {
+		c.logger.Warn("reading autoscaling record failed", "key", key, "error", err)
+		return
+	}
+	if !exists {
+		return
+	}
+	if record.LeaderEpoch > c.epoch {
+		c.logger.Warn("leaving autoscaling record of a newer leader", "key", key, "leader_epoch", record.LeaderEpoch, "epoch", c.epoch)
+		return
+	}
+	if err := c.store.Delete(ctx, key); err != nil {
+		c.logger.Warn("deleting autoscaling record failed", "key", key, "error", err)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
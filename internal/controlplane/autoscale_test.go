@@ -0,0 +1,224 @@
+package controlplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/enricher"
+	"github.com/artemnikitin/firework/internal/statusmodel"
+)
+
+func autoscaledReplicas(count int) []config.ServiceConfig {
+	svc := config.ServiceConfig{
+		Name: "web", Image: "/images/web.ext4", Kernel: "/images/vmlinux", VCPUs: 1, MemoryMB: 256,
+		Network:     &config.NetworkConfig{Interface: "tap-web"},
+		Autoscaling: &config.Autoscaling{MinReplicas: 1, MaxReplicas: 4, TargetCPUUtilization: 50, Cooldown: time.Minute},
+	}
+	return enricher.ExpandReplicas(svc, count)
+}
+
+// putReplicaNode records a ready node running the given replicas at the
+// given CPU utilisation.
+func putReplicaNode(t *testing.T, ctx context.Context, c *Controller, node string, seenAt time.Time, cpu map[string]int) {
+	t.Helper()
+	status := &statusmodel.AgentStatus{SchemaVersion: 1, NodeID: node, ObservedAt: seenAt}
+	used := Resources{}
+	for name, percent := range cpu {
+		percent := percent
+		status.Services = append(status.Services, statusmodel.ServiceStatus{Name: name, VMState: "running", Health: "healthy", CPUPercent: &percent})
+		used.VCPUs++
+		used.MemoryMB += 256
+	}
+	putNode(t, ctx, c.store, c.cfg, NodeRecord{
+		NodeID: node, State: NodeStateReady, LastSeenAt: seenAt, AgentStatus: status,
+		Capacity: Resources{VCPUs: 2, MemoryMB: 512}, Used: used,
+	})
+}
+
+func loadDecisions(t *testing.T, ctx context.Context, c *Controller, service string) []AutoscalingDecision {
+	t.Helper()
+	keys, err := c.store.ListKeys(ctx, autoscalingDecisionsPrefix(c.cfg.State.Prefix, service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decisions []AutoscalingDecision
+	for _, key := range keys {
+		var decision AutoscalingDecision
+		if _, _, err := c.store.GetJSON(ctx, key, &decision); err != nil {
+			t.Fatal(err)
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
+
+func TestDecideReplicas(t *testing.T) {
+	policy := config.Autoscaling{MinReplicas: 2, MaxReplicas: 6, TargetCPUUtilization: 50}
+	tests := []struct {
+		name    string
+		current int
+		cpu     []int
+		room    int
+		want    int
+		reason  string
+	}{
+		{name: "no samples", current: 3, room: 6, want: 3},
+		{name: "within tolerance", current: 3, cpu: []int{52, 54, 50}, room: 6, want: 3},
+		{name: "above target", current: 3, cpu: []int{80, 90, 70}, room: 6, want: 5, reason: autoscalingCPUAbove},
+		{name: "below target", current: 4, cpu: []int{10, 20, 10, 20}, room: 6, want: 2, reason: autoscalingCPUBelow},
+		{name: "capped by max", current: 4, cpu: []int{100, 100, 100, 100}, room: 8, want: 6, reason: autoscalingCPUAbove},
+		{name: "limited by nodes", current: 3, cpu: []int{100, 100, 100}, room: 4, want: 4, reason: autoscalingNodeLimited},
+		{name: "no room", current: 3, cpu: []int{100, 100, 100}, room: 3, want: 3},
+		{name: "below min", current: 1, room: 6, want: 2, reason: autoscalingBelowMin},
+		{name: "above max", current: 8, cpu: []int{100}, room: 8, want: 6, reason: autoscalingAboveMax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, changed := decideReplicas(policy, tt.current, tt.cpu, tt.room)
+			if changed != (tt.reason != "") || decision.To != tt.want || decision.ReasonCode != tt.reason {
+				t.Fatalf("decision = %+v, changed %v; want %d replicas, reason %q", decision, changed, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAutoscaleServicesScalesWithCooldown(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	desired := append(autoscaledReplicas(2), config.ServiceConfig{Name: "db"})
+
+	services, err := c.autoscaleServices(ctx, desired, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serviceNames(services); len(got) != 3 || got[0] != "web-0" || got[1] != "web-1" || got[2] != "db" {
+		t.Fatalf("services = %v, want the enricher's replicas", got)
+	}
+
+	// Both replicas run hot within the cooldown of the first pass.
+	hot := start.Add(30 * time.Second)
+	putReplicaNode(t, ctx, c, "node-1", hot, map[string]int{"web-0": 90})
+	putReplicaNode(t, ctx, c, "node-2", hot, map[string]int{"web-1": 90})
+	putReplicaNode(t, ctx, c, "node-3", hot, nil)
+	if services, err = c.autoscaleServices(ctx, desired, hot); err != nil || len(services) != 3 {
+		t.Fatalf("services in cooldown = %v, %v", serviceNames(services), err)
+	}
+
+	// Four replicas are wanted, the three nodes have room for three.
+	later := start.Add(2 * time.Minute)
+	putReplicaNode(t, ctx, c, "node-1", later, map[string]int{"web-0": 90})
+	putReplicaNode(t, ctx, c, "node-2", later, map[string]int{"web-1": 90})
+	putReplicaNode(t, ctx, c, "node-3", later, nil)
+	services, err = c.autoscaleServices(ctx, desired, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serviceNames(services); len(got) != 4 || got[2] != "web-2" || got[3] != "db" {
+		t.Fatalf("services = %v, want three replicas", got)
+	}
+	clone := services[2]
+	if clone.ReplicaOf != "web" || clone.Network == nil || clone.Network.Interface == "tap-web" {
+		t.Fatalf("cloned replica = %+v, network %+v", clone, clone.Network)
+	}
+	decisions := loadDecisions(t, ctx, c, "web")
+	if len(decisions) != 1 || decisions[0].From != 2 || decisions[0].To != 3 || decisions[0].ReasonCode != autoscalingNodeLimited ||
+		decisions[0].AverageCPU != 90 || decisions[0].Samples != 2 {
+		t.Fatalf("decisions = %+v", decisions)
+	}
+
+	// Idle replicas scale down to the minimum once the cooldown is over.
+	idle := later.Add(2 * time.Minute)
+	putReplicaNode(t, ctx, c, "node-1", idle, map[string]int{"web-0": 5})
+	putReplicaNode(t, ctx, c, "node-2", idle, map[string]int{"web-1": 5})
+	putReplicaNode(t, ctx, c, "node-3", idle, map[string]int{"web-2": 5})
+	services, err = c.autoscaleServices(ctx, desired, idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serviceNames(services); len(got) != 2 || got[0] != "web-0" {
+		t.Fatalf("services = %v, want one replica", got)
+	}
+	if decisions := loadDecisions(t, ctx, c, "web"); len(decisions) != 2 || decisions[1].ReasonCode != autoscalingCPUBelow || decisions[1].To != 1 {
+		t.Fatalf("decisions = %+v", decisions)
+	}
+
+	// A service that is no longer autoscaled leaves no state behind.
+	if _, err := c.autoscaleServices(ctx, []config.ServiceConfig{{Name: "db"}}, idle); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := store.ListKeys(ctx, autoscalingPrefix(c.cfg.State.Prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("autoscaling records left behind: %v", keys)
+	}
+}
+
+func TestAutoscaleServicesRejectsStaleLeader(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	now := time.Now().UTC()
+	if _, err := store.PutJSON(ctx, autoscalingStateKey(c.cfg.State.Prefix, "web"), AutoscalingState{Service: "web", Replicas: 2, LeaderEpoch: c.epoch + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.autoscaleServices(ctx, autoscaledReplicas(2), now); err == nil {
+		t.Fatal("stale leader epoch scaled a service")
+	}
+}
+
+func TestAutoscaleServicesPrunesOnlyAsCurrentLeader(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	now := time.Now().UTC()
+	own := autoscalingStateKey(c.cfg.State.Prefix, "web")
+	newer := autoscalingDecisionKey(c.cfg.State.Prefix, "web", now)
+	if _, err := store.PutJSON(ctx, own, AutoscalingState{Service: "web", Replicas: 2, LeaderEpoch: c.epoch}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutJSON(ctx, newer, AutoscalingDecision{Service: "web", From: 2, To: 3, LeaderEpoch: c.epoch + 1}); err != nil {
+		t.Fatal(err)
+	}
+	remaining := func() []string {
+		t.Helper()
+		keys, err := store.ListKeys(ctx, autoscalingPrefix(c.cfg.State.Prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	// A controller that lost the lock prunes nothing.
+	var lock LeaderLock
+	if _, _, err := store.GetJSON(ctx, controllerLockKey(c.cfg.State.Prefix), &lock); err != nil {
+		t.Fatal(err)
+	}
+	held := lock
+	lock.HolderID = "other"
+	if _, err := store.PutJSON(ctx, controllerLockKey(c.cfg.State.Prefix), lock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.autoscaleServices(ctx, []config.ServiceConfig{{Name: "db"}}, now); err == nil {
+		t.Fatal("deposed leader autoscaled")
+	}
+	if keys := remaining(); len(keys) != 2 {
+		t.Fatalf("deposed leader pruned autoscaling records, left %v", keys)
+	}
+
+	// The leader prunes its own records but not those of a newer epoch.
+	if _, err := store.PutJSON(ctx, controllerLockKey(c.cfg.State.Prefix), held); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.autoscaleServices(ctx, []config.ServiceConfig{{Name: "db"}}, now); err != nil {
+		t.Fatal(err)
+	}
+	if keys := remaining(); len(keys) != 1 || keys[0] != newer {
+		t.Fatalf("autoscaling records left = %v, want only %s", keys, newer)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/artemnikitin/firework/internal/ingress"
+	"github.com/artemnikitin/firework/internal/scheduler"
 	"gopkg.in/yaml.v3"
 )
 
@@ -36,6 +37,15 @@ type Config struct {
 	NodeStaleTTL        time.Duration `yaml:"node_stale_ttl"`
 	ControllerTick      time.Duration `yaml:"controller_tick"`
 
+	// Tenancy controls which tenants' services may share a node: "shared"
+	// (default), "dedicated", or "pool:<label>".
+	Tenancy string `yaml:"tenancy"`
+	// PreemptionBudget caps how many services one reconcile may evict to
+	// place higher-priority services. Zero disables preemption.
+	PreemptionBudget int `yaml:"preemption_budget"`
+	// Rebalance configures the opt-in rebalancer.
+	Rebalance RebalanceConfig `yaml:"rebalance"`
+
 	TargetBranch string `yaml:"target_branch"`
 	ConfigDir    string `yaml:"config_dir"`
 	GitRepoURL   string `yaml:"git_repo_url"`
@@ -48,6 +58,14 @@ type Config struct {
 	Enrollment EnrollmentConfig `yaml:"enrollment"`
 }
 
+// RebalanceConfig configures the rebalancer, which moves placed services
+// toward a better placement a few at a time.
+type RebalanceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxMoves caps how many services one reconcile moves.
+	MaxMoves int `yaml:"max_moves"`
+}
+
 // StateConfig configures durable control-plane state storage.
 type StateConfig struct {
 	Backend string `yaml:"backend"` // s3 or gcs
@@ -109,6 +127,8 @@ func DefaultConfig() Config {
 		LeaderRenewInterval: 10 * time.Second,
 		NodeStaleTTL:        45 * time.Second,
 		ControllerTick:      10 * time.Second,
+		PreemptionBudget:    2,
+		Rebalance:           RebalanceConfig{MaxMoves: 1},
 		TargetBranch:        "main",
 		Enrollment: EnrollmentConfig{
 			NodeCertTTL: 24 * time.Hour,
@@ -229,6 +249,15 @@ func (c Config) Validate() error {
 	if c.NodeStaleTTL <= 0 {
 		return fmt.Errorf("node_stale_ttl must be > 0")
 	}
+	if _, err := scheduler.ParseTenancy(c.Tenancy); err != nil {
+		return err
+	}
+	if c.PreemptionBudget < 0 {
+		return fmt.Errorf("preemption_budget must be >= 0")
+	}
+	if c.Rebalance.Enabled && c.Rebalance.MaxMoves <= 0 {
+		return fmt.Errorf("rebalance.max_moves must be > 0")
+	}
 
 	// Controller-only role does not expose HTTPS endpoints, so server TLS
 	// cert/key are only required when registry and/or events APIs are enabled.
@@ -77,6 +77,45 @@ func TestConfigValidate_NodeStaleTTL(t *testing.T) {
 	}
 }
 
+func TestConfigValidate_Tenancy(t *testing.T) {
+	cfg := validConfigForRole(RoleController)
+	for _, policy := range []string{"", "shared", "dedicated", "pool:tenant-pool"} {
+		cfg.Tenancy = policy
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("tenancy %q should be valid: %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"isolated", "pool:", "pool:a=b"} {
+		cfg.Tenancy = policy
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("expected tenancy %q validation error", policy)
+		}
+	}
+}
+
+func TestConfigValidate_PreemptionBudget(t *testing.T) {
+	cfg := validConfigForRole(RoleController)
+	if cfg.PreemptionBudget != 2 {
+		t.Fatalf("default preemption_budget = %d, want 2", cfg.PreemptionBudget)
+	}
+	cfg.PreemptionBudget = -1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative preemption_budget validation error")
+	}
+}
+
+func TestConfigValidate_Rebalance(t *testing.T) {
+	cfg := validConfigForRole(RoleController)
+	cfg.Rebalance.MaxMoves = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("max_moves is only checked with the rebalancer enabled: %v", err)
+	}
+	cfg.Rebalance.Enabled = true
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected rebalance.max_moves validation error")
+	}
+}
+
 func TestConfigValidate_IngressDomain(t *testing.T) {
 	cfg := validConfigForRole(RoleAPI)
 	cfg.IngressDomain = "https://example.com"
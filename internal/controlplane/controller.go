@@ -7,13 +7,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
 	"github.com/artemnikitin/firework/internal/scheduler"
+	"github.com/artemnikitin/firework/internal/statusmodel"
 	"gopkg.in/yaml.v3"
 )
 
@@ -174,21 +178,37 @@ func (c *Controller) runReconcile(ctx context.Context) {
 		c.logger.Error("loading retained volume records failed", "error", err)
 		return
 	}
-	services := append([]config.ServiceConfig(nil), desired.Services...)
-	for i := range services {
-		services[i].Volumes = append([]config.VolumeConfig(nil), desired.Services[i].Volumes...)
+	// Scheduled jobs are not placed themselves; their runs are.
+	services := make([]config.ServiceConfig, 0, len(desired.Services))
+	for _, svc := range desired.Services {
+		if svc.Schedule != "" {
+			continue
+		}
+		svc.Volumes = append([]config.VolumeConfig(nil), svc.Volumes...)
+		services = append(services, svc)
 	}
 	if err := c.applyExistingVolumeRecords(ctx, services, volumeRecords); err != nil {
 		c.logger.Error("reconciling volume records failed", "error", err)
 		return
 	}
+	cronRuns, err := c.syncCronJobs(ctx, desired.Services, time.Now().UTC())
+	if err != nil {
+		c.logger.Error("processing cron jobs failed", "error", err)
+		return
+	}
+	services = append(services, cronRuns...)
+	services, err = c.autoscaleServices(ctx, services, time.Now().UTC())
+	if err != nil {
+		c.logger.Error("autoscaling services failed", "error", err)
+		return
+	}
 
-	activeNodes, hostIPByNode, err := c.discoverActiveNodes(ctx)
+	activeNodes, hostIPByNode, draining, err := c.discoverActiveNodes(ctx)
 	if err != nil {
 		c.logger.Error("discovering active nodes failed", "error", err)
 		return
 	}
-	inputSig, err := schedulingInputSignature(desired.Revision, activeNodes, hostIPByNode, volumeRecordsDigest(volumeRecords))
+	inputSig, err := schedulingInputSignature(desired.Revision, activeNodes, hostIPByNode, draining, volumeRecordsDigest(volumeRecords), serviceNames(services))
 	if err != nil {
 		c.logger.Error("failed to compute scheduling input signature; skipping signature cache optimization", "error", err)
 	}
@@ -200,13 +220,31 @@ func (c *Controller) runReconcile(ctx context.Context) {
 		return
 	}
 
-	existingAssignment, err := c.readExistingAssignment(ctx)
+	existingAssignment, inFlight, err := c.readExistingAssignment(ctx)
 	if err != nil {
 		c.logger.Warn("reading existing placement failed; will re-place all", "error", err)
-		existingAssignment = nil
+		existingAssignment, inFlight = nil, nil
 	}
 
-	assignments, pending := scheduler.ScheduleWithStorage(services, activeNodes, existingAssignment, storageReservations(volumeRecords))
+	// Validate rejects unparsable policies, so this only falls back to shared
+	// for configs built without LoadConfig.
+	tenancy, _ := scheduler.ParseTenancy(c.cfg.Tenancy)
+	reservations := storageReservations(volumeRecords)
+	assignments, pending := scheduler.ScheduleWithStorage(services, activeNodes, existingAssignment, reservations, scheduler.ScheduleOptions{
+		Tenancy:          tenancy,
+		PreemptionBudget: c.cfg.PreemptionBudget,
+	})
+	var moves []scheduler.Move
+	rebalanceDeferred := false
+	if c.cfg.Rebalance.Enabled {
+		plan, err := c.rebalance(ctx, services, activeNodes, assignments, pending, reservations, tenancy)
+		if err != nil {
+			c.logger.Warn("rebalancing failed; publishing the placement without moves", "error", err)
+		} else {
+			assignments, pending, moves = plan.Assignment, plan.Pending, plan.Moves
+			rebalanceDeferred = plan.Deferred > 0
+		}
+	}
 
 	nodeConfigs := scheduler.BuildNodeConfigs(assignments)
 	if err := c.createAssignedVolumeRecords(ctx, nodeConfigs, volumeRecords); err != nil {
@@ -217,6 +255,7 @@ func (c *Controller) runReconcile(ctx context.Context) {
 
 	renderRev := newRevision("rendered")
 	placementID := newRevision("placement")
+	annotateSnapshotMigrations(nodeConfigs, existingAssignment, inFlight, draining, placementID)
 	for i := range nodeConfigs {
 		nodeConfigs[i].DesiredRevision = desired.Revision
 		nodeConfigs[i].PlacementRevision = placementID
@@ -234,6 +273,11 @@ func (c *Controller) runReconcile(ctx context.Context) {
 			Service: item.Service, ReasonCode: item.ReasonCode, Message: item.Message,
 		})
 	}
+	for _, move := range moves {
+		placementRev.Moves = append(placementRev.Moves, PlacementMove{
+			Service: move.Service, From: move.From, To: move.To, ReasonCode: move.ReasonCode, Message: move.Message,
+		})
+	}
 	if err := c.publishPlacement(ctx, placementRev); err != nil {
 		c.logger.Error("publishing placement failed", "error", err)
 		return
@@ -243,27 +287,40 @@ func (c *Controller) runReconcile(ctx context.Context) {
 		c.logger.Error("publishing rendered configs failed", "error", err)
 		return
 	}
-	c.lastInputSignature = inputSig
+	// Preemptions and rebalancing moves left over for lack of budget, or
+	// for services that were not healthy yet, happen on a later tick, even
+	// if nothing else changes by then.
+	deferred := rebalanceDeferred || len(moves) > 0 ||
+		slices.ContainsFunc(pending, func(p scheduler.Pending) bool { return p.ReasonCode == scheduler.ReasonPreemptionDeferred })
+	if !deferred {
+		c.lastInputSignature = inputSig
+	}
 
 	c.logger.Info("reconcile complete",
 		"desired_revision", desired.Revision,
 		"placement_revision", placementRev.Revision,
 		"rendered_revision", renderRev,
 		"services", len(desired.Services),
+		"cron_runs", len(cronRuns),
 		"nodes", len(nodeConfigs),
 		"pending_services", len(pending),
+		"rebalanced_services", len(moves),
 	)
 }
 
-func (c *Controller) discoverActiveNodes(ctx context.Context) ([]scheduler.Node, map[string]string, error) {
+// discoverActiveNodes returns the schedulable nodes and their host IPs, plus
+// the draining nodes that still heartbeat. Draining nodes take no placements
+// but can still hand snapshot-mode services over to their new nodes.
+func (c *Controller) discoverActiveNodes(ctx context.Context) ([]scheduler.Node, map[string]string, map[string]bool, error) {
 	keys, err := c.store.ListKeys(ctx, registryNodesPrefix(c.cfg.State.Prefix))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	now := time.Now().UTC()
 	var nodes []scheduler.Node
 	hostIPByNode := make(map[string]string)
+	draining := make(map[string]bool)
 	for _, key := range keys {
 		var rec NodeRecord
 		_, exists, err := c.store.GetJSON(ctx, key, &rec)
@@ -274,22 +331,30 @@ func (c *Controller) discoverActiveNodes(ctx context.Context) ([]scheduler.Node,
 		if !exists {
 			continue
 		}
-		if rec.State != NodeStateReady {
+		if rec.LastSeenAt.IsZero() || now.Sub(rec.LastSeenAt) > c.cfg.NodeStaleTTL {
 			continue
 		}
-		if rec.LastSeenAt.IsZero() || now.Sub(rec.LastSeenAt) > c.cfg.NodeStaleTTL {
+		if rec.State == NodeStateDraining {
+			draining[rec.NodeID] = true
+			continue
+		}
+		if rec.State != NodeStateReady {
 			continue
 		}
 		if rec.Capacity.VCPUs <= 0 || rec.Capacity.MemoryMB <= 0 {
 			continue
 		}
 		nodes = append(nodes, scheduler.Node{
-			InstanceID:          rec.NodeID,
-			CapacityVCPUs:       rec.Capacity.VCPUs,
-			CapacityMemMB:       rec.Capacity.MemoryMB,
-			LocalCapacityBytes:  rec.Storage.LocalCapacityBytes,
-			SharedBackendID:     rec.Storage.SharedBackendID,
-			SharedCapacityBytes: rec.Storage.SharedCapacityBytes,
+			InstanceID:            rec.NodeID,
+			Labels:                append([]string(nil), rec.Labels...),
+			Topology:              rec.Topology,
+			CapacityVCPUs:         rec.Capacity.VCPUs,
+			CapacityMemMB:         rec.Capacity.MemoryMB,
+			MemoryOvercommitRatio: rec.Capacity.MemoryOvercommitRatio,
+			ObservedMemMB:         observedMemory(rec.AgentStatus),
+			LocalCapacityBytes:    rec.Storage.LocalCapacityBytes,
+			SharedBackendID:       rec.Storage.SharedBackendID,
+			SharedCapacityBytes:   rec.Storage.SharedCapacityBytes,
 		})
 		if rec.HostIP != "" {
 			hostIPByNode[rec.NodeID] = rec.HostIP
@@ -298,29 +363,89 @@ func (c *Controller) discoverActiveNodes(ctx context.Context) ([]scheduler.Node,
 	sort.Slice(nodes, func(i, j int) bool {
 		return nodes[i].InstanceID < nodes[j].InstanceID
 	})
-	return nodes, hostIPByNode, nil
+	return nodes, hostIPByNode, draining, nil
 }
 
-func (c *Controller) readExistingAssignment(ctx context.Context) (map[string]string, error) {
+// observedMemory returns the memory usage the agent reported for its
+// ballooned services, keyed by service name.
+func observedMemory(status *statusmodel.AgentStatus) map[string]int {
+	if status == nil {
+		return nil
+	}
+	observed := make(map[string]int)
+	for _, svc := range status.Services {
+		if svc.MemoryUsedMB > 0 {
+			observed[svc.Name] = svc.MemoryUsedMB
+		}
+	}
+	return observed
+}
+
+// readExistingAssignment returns the current placement as service → node,
+// along with the snapshot migrations it announced, keyed by service.
+func (c *Controller) readExistingAssignment(ctx context.Context) (map[string]string, map[string]config.SnapshotMigration, error) {
 	var ptr RevisionPointer
 	_, exists, err := c.store.GetJSON(ctx, placementCurrentKey(c.cfg.State.Prefix), &ptr)
 	if err != nil || !exists || ptr.Revision == "" {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var rev PlacementRevision
 	_, exists, err = c.store.GetJSON(ctx, placementRevisionKey(c.cfg.State.Prefix, ptr.Revision), &rev)
 	if err != nil || !exists {
-		return nil, err
+		return nil, nil, err
 	}
 
 	assignment := make(map[string]string)
+	migrations := make(map[string]config.SnapshotMigration)
 	for _, nc := range rev.NodeConfigs {
 		for _, svc := range nc.Services {
 			assignment[svc.Name] = nc.Node
 		}
+		for _, mig := range nc.Migrations {
+			migrations[mig.Service] = mig
+		}
 	}
-	return assignment, nil
+	return assignment, migrations, nil
+}
+
+// annotateSnapshotMigrations lists on each node config the snapshot-mode
+// services that moved onto it from a node that is still draining, so the
+// draining node's agent exports their snapshot for the new node to resume.
+// A migration stays announced while its source keeps draining; once the
+// source is gone the new node simply cold boots the service. New migrations
+// are named after placementID, the placement that first announces them.
+func annotateSnapshotMigrations(nodeConfigs []config.NodeConfig, previous map[string]string, inFlight map[string]config.SnapshotMigration, draining map[string]bool, placementID string) {
+	for i := range nodeConfigs {
+		nc := &nodeConfigs[i]
+		nc.Migrations = nil
+		for _, svc := range nc.Services {
+			if !svc.Snapshot {
+				continue
+			}
+			if mig, ok := inFlight[svc.Name]; ok && previous[svc.Name] == nc.Node && draining[mig.From] {
+				nc.Migrations = append(nc.Migrations, mig)
+				continue
+			}
+			from := previous[svc.Name]
+			if from == "" || from == nc.Node || !draining[from] {
+				continue
+			}
+			nc.Migrations = append(nc.Migrations, config.SnapshotMigration{
+				Service: svc.Name, ID: snapshotMigrationID(svc.Name, from, nc.Node, placementID), From: from,
+			})
+		}
+	}
+}
+
+// snapshotMigrationID names a handoff after the placement revision that
+// first announced it. Later placements carry the ID along, so a controller
+// that takes over leadership announces the same migration again, while a
+// later move along the same path gets a directory of its own and never sees
+// an earlier attempt's snapshot.
+func snapshotMigrationID(service, from, to, placementID string) string {
+	sum := sha256.Sum256([]byte(service + "\x00" + from + "\x00" + to + "\x00" + placementID))
+	return service + "-" + hex.EncodeToString(sum[:6])
 }
 
 func (c *Controller) stillLeader(ctx context.Context) bool {
@@ -396,11 +521,18 @@ func applyHostIPAndCrossNodeLinks(nodeConfigs []config.NodeConfig, hostIPByNode
 	}
 
 	serviceNode := make(map[string]config.NodeConfig)
+	replicas := make(map[string][]string)
 	for _, nc := range nodeConfigs {
 		for _, svc := range nc.Services {
 			serviceNode[svc.Name] = nc
+			if svc.ReplicaOf != "" {
+				replicas[svc.ReplicaOf] = append(replicas[svc.ReplicaOf], svc.Name)
+			}
 		}
 	}
+	for _, names := range replicas {
+		sort.Strings(names)
+	}
 
 	for i := range nodeConfigs {
 		for j := range nodeConfigs[i].Services {
@@ -418,19 +550,26 @@ func applyHostIPAndCrossNodeLinks(nodeConfigs []config.NodeConfig, hostIPByNode
 			// link still replaces any same-named static env value.
 			linkSet := make(map[string]bool)
 			for _, link := range svc.CrossNodeLinks {
-				peerNC, ok := serviceNode[link.Service]
-				if !ok || peerNC.HostIP == "" {
-					continue
-				}
-				address := fmt.Sprintf("%s:%d", peerNC.HostIP, link.HostPort)
-				if link.Protocol != "" {
-					address = fmt.Sprintf("%s://%s", link.Protocol, address)
+				// A link to a replicated service joins every placed replica.
+				targets := replicas[link.Service]
+				if len(targets) == 0 {
+					targets = []string{link.Service}
 				}
-				if linkSet[link.Env] {
-					svc.Env[link.Env] += "," + address
-				} else {
-					svc.Env[link.Env] = address
-					linkSet[link.Env] = true
+				for _, target := range targets {
+					peerNC, ok := serviceNode[target]
+					if !ok || peerNC.HostIP == "" {
+						continue
+					}
+					address := net.JoinHostPort(peerNC.HostIP, strconv.Itoa(link.HostPort))
+					if link.Protocol != "" {
+						address = fmt.Sprintf("%s://%s", link.Protocol, address)
+					}
+					if linkSet[link.Env] {
+						svc.Env[link.Env] += "," + address
+					} else {
+						svc.Env[link.Env] = address
+						linkSet[link.Env] = true
+					}
 				}
 			}
 			if svc.NodeHostIPEnv != "" && nodeConfigs[i].HostIP != "" {
@@ -440,39 +579,78 @@ func applyHostIPAndCrossNodeLinks(nodeConfigs []config.NodeConfig, hostIPByNode
 	}
 }
 
-func schedulingInputSignature(desiredRevision string, nodes []scheduler.Node, hostIPByNode map[string]string, volumeDigest string) (string, error) {
+// schedulingInputSignature digests what a placement depends on. services
+// names the services to place, which cron runs and autoscaling change
+// between desired revisions.
+// observedMemBucketMB is the granularity at which the memory ballooned
+// services are observed to use enters the scheduling signature.
+const observedMemBucketMB = 256
+
+// observedMemBuckets returns the observed memory of a node's ballooned
+// services in buckets of observedMemBucketMB. It is 0 without overcommit,
+// where the scheduler ignores observed usage.
+func observedMemBuckets(n scheduler.Node) int {
+	if n.MemoryOvercommitRatio <= 1 {
+		return 0
+	}
+	total := 0
+	for _, mb := range n.ObservedMemMB {
+		total += mb
+	}
+	return total / observedMemBucketMB
+}
+
+func schedulingInputSignature(desiredRevision string, nodes []scheduler.Node, hostIPByNode map[string]string, draining map[string]bool, volumeDigest string, services []string) (string, error) {
 	// Intentionally excludes runtime "used" resources from node heartbeats.
 	// Current scheduler decisions are based on node total capacity plus desired
-	// assignment bookkeeping, not host-reported instantaneous utilization.
+	// assignment bookkeeping, not host-reported instantaneous utilization. The
+	// one exception is the memory ballooned services are observed to use,
+	// which new placements fit against under overcommit; it is bucketed so
+	// small fluctuations do not reschedule.
 	type nodeInput struct {
-		ID                  string `json:"id"`
-		CapacityV           int    `json:"capacity_v"`
-		CapacityMB          int    `json:"capacity_mb"`
-		HostIP              string `json:"host_ip,omitempty"`
-		LocalCapacityBytes  int64  `json:"local_capacity_bytes,omitempty"`
-		SharedBackendID     string `json:"shared_backend_id,omitempty"`
-		SharedCapacityBytes int64  `json:"shared_capacity_bytes,omitempty"`
+		ID                  string            `json:"id"`
+		Labels              []string          `json:"labels,omitempty"`
+		Topology            map[string]string `json:"topology,omitempty"`
+		CapacityV           int               `json:"capacity_v"`
+		CapacityMB          int               `json:"capacity_mb"`
+		HostIP              string            `json:"host_ip,omitempty"`
+		LocalCapacityBytes  int64             `json:"local_capacity_bytes,omitempty"`
+		SharedBackendID     string            `json:"shared_backend_id,omitempty"`
+		SharedCapacityBytes int64             `json:"shared_capacity_bytes,omitempty"`
+		OvercommitRatio     float64           `json:"memory_overcommit_ratio,omitempty"`
+		ObservedMemBuckets  int               `json:"observed_mem_buckets,omitempty"`
 	}
 	payload := struct {
 		DesiredRevision     string      `json:"desired_revision"`
 		Nodes               []nodeInput `json:"nodes"`
+		DrainingNodes       []string    `json:"draining_nodes,omitempty"`
 		VolumeRecordsDigest string      `json:"volume_records_digest,omitempty"`
+		Services            []string    `json:"services"`
 	}{
 		DesiredRevision:     desiredRevision,
 		Nodes:               make([]nodeInput, 0, len(nodes)),
 		VolumeRecordsDigest: volumeDigest,
+		Services:            services,
 	}
 	for _, n := range nodes {
 		payload.Nodes = append(payload.Nodes, nodeInput{
 			ID:                  n.InstanceID,
+			Labels:              n.Labels,
+			Topology:            n.Topology,
 			CapacityV:           n.CapacityVCPUs,
 			CapacityMB:          n.CapacityMemMB,
 			HostIP:              hostIPByNode[n.InstanceID],
 			LocalCapacityBytes:  n.LocalCapacityBytes,
 			SharedBackendID:     n.SharedBackendID,
 			SharedCapacityBytes: n.SharedCapacityBytes,
+			OvercommitRatio:     n.MemoryOvercommitRatio,
+			ObservedMemBuckets:  observedMemBuckets(n),
 		})
 	}
+	for id := range draining {
+		payload.DrainingNodes = append(payload.DrainingNodes, id)
+	}
+	sort.Strings(payload.DrainingNodes)
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/scheduler"
 )
 
 func TestApplyHostIPAndCrossNodeLinks(t *testing.T) {
@@ -100,6 +101,13 @@ func TestApplyCrossNodeLinksSharedEnvKey(t *testing.T) {
 			staticEnv: map[string]string{"SEED_HOSTS": "static-host:9300"},
 			want:      "10.0.1.5:9300,10.0.1.6:9301",
 		},
+		{
+			name: "link to a replicated service joins every replica",
+			links: []config.CrossNodeLink{
+				{Service: "backend", Env: "SEED_HOSTS", HostPort: 9300, Protocol: "http"},
+			},
+			want: "http://10.0.1.5:9300,http://10.0.1.6:9300",
+		},
 	}
 
 	for _, tt := range tests {
@@ -118,13 +126,13 @@ func TestApplyCrossNodeLinksSharedEnvKey(t *testing.T) {
 				{
 					Node: "node-backend-1",
 					Services: []config.ServiceConfig{
-						{Name: "backend-1"},
+						{Name: "backend-1", ReplicaOf: "backend"},
 					},
 				},
 				{
 					Node: "node-backend-2",
 					Services: []config.ServiceConfig{
-						{Name: "backend-2"},
+						{Name: "backend-2", ReplicaOf: "backend"},
 					},
 				},
 			}
@@ -142,3 +150,93 @@ func TestApplyCrossNodeLinksSharedEnvKey(t *testing.T) {
 		})
 	}
 }
+
+func TestAnnotateSnapshotMigrations(t *testing.T) {
+	nodeConfigs := []config.NodeConfig{
+		{Node: "node-b", Services: []config.ServiceConfig{
+			{Name: "jvm", Snapshot: true},
+			{Name: "plain"},
+			{Name: "carried", Snapshot: true},
+		}},
+	}
+	previous := map[string]string{"jvm": "node-a", "plain": "node-a", "carried": "node-b"}
+	inFlight := map[string]config.SnapshotMigration{
+		"carried": {Service: "carried", ID: "carried-1", From: "node-c"},
+	}
+	draining := map[string]bool{"node-a": true, "node-c": true}
+
+	annotateSnapshotMigrations(nodeConfigs, previous, inFlight, draining, "placement-1")
+
+	got := nodeConfigs[0].Migrations
+	want := []config.SnapshotMigration{
+		{Service: "jvm", ID: snapshotMigrationID("jvm", "node-a", "node-b", "placement-1"), From: "node-a"},
+		{Service: "carried", ID: "carried-1", From: "node-c"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("migrations = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("migration %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Once the source stops draining (it went down or came back), the
+	// handoff is no longer announced.
+	annotateSnapshotMigrations(nodeConfigs, map[string]string{"jvm": "node-b", "carried": "node-b"}, inFlight, map[string]bool{}, "placement-2")
+	if len(nodeConfigs[0].Migrations) != 0 {
+		t.Fatalf("migrations = %+v, want none", nodeConfigs[0].Migrations)
+	}
+}
+
+func TestAnnotateSnapshotMigrationsSamePathTwice(t *testing.T) {
+	annotate := func(previous map[string]string, inFlight map[string]config.SnapshotMigration, placementID string) config.SnapshotMigration {
+		t.Helper()
+		nodeConfigs := []config.NodeConfig{{Node: "node-b", Services: []config.ServiceConfig{{Name: "jvm", Snapshot: true}}}}
+		annotateSnapshotMigrations(nodeConfigs, previous, inFlight, map[string]bool{"node-a": true}, placementID)
+		if len(nodeConfigs[0].Migrations) != 1 {
+			t.Fatalf("migrations = %+v, want one", nodeConfigs[0].Migrations)
+		}
+		return nodeConfigs[0].Migrations[0]
+	}
+
+	first := annotate(map[string]string{"jvm": "node-a"}, nil, "placement-1")
+	carried := annotate(map[string]string{"jvm": "node-b"}, map[string]config.SnapshotMigration{"jvm": first}, "placement-2")
+	if carried.ID != first.ID {
+		t.Fatalf("in-flight migration renamed from %q to %q", first.ID, carried.ID)
+	}
+
+	// The service went back to node-a and now moves to node-b again.
+	second := annotate(map[string]string{"jvm": "node-a"}, nil, "placement-3")
+	if second.ID == first.ID {
+		t.Fatalf("second migration along the same path reuses id %q", first.ID)
+	}
+}
+
+func TestSchedulingInputSignatureFollowsOvercommitInputs(t *testing.T) {
+	signature := func(node scheduler.Node) string {
+		t.Helper()
+		sig, err := schedulingInputSignature("rev", []scheduler.Node{node}, nil, nil, "", []string{"cache"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	node := scheduler.Node{InstanceID: "n1", CapacityVCPUs: 4, CapacityMemMB: 4096, MemoryOvercommitRatio: 1.5,
+		ObservedMemMB: map[string]int{"cache": 600}}
+	base := signature(node)
+
+	node.ObservedMemMB = map[string]int{"cache": 700}
+	if signature(node) != base {
+		t.Fatal("a change within one bucket must not reschedule")
+	}
+	node.ObservedMemMB = map[string]int{"cache": 300}
+	if signature(node) == base {
+		t.Fatal("balloons freeing memory must reschedule")
+	}
+	node.ObservedMemMB = map[string]int{"cache": 600}
+	node.MemoryOvercommitRatio = 2
+	if signature(node) == base {
+		t.Fatal("a new memory_overcommit_ratio must reschedule")
+	}
+}
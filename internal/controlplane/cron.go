@@ -0,0 +1,371 @@
+package controlplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/cron"
+	"github.com/artemnikitin/firework/internal/enricher"
+	"github.com/artemnikitin/firework/internal/objectstorage"
+	"github.com/artemnikitin/firework/internal/statusmodel"
+)
+
+// cronHistoryLimit is how many finished runs are kept per scheduled job.
+const cronHistoryLimit = 10
+
+// cronRunIDLayout formats the trigger time in a run's name.
+const cronRunIDLayout = "200601021504"
+
+// cronRunID names the run of job triggered at scheduledAt.
+func cronRunID(job string, scheduledAt time.Time) string {
+	return job + "-" + scheduledAt.UTC().Format(cronRunIDLayout)
+}
+
+// storedCronRun is a run record with the write token it was read with.
+type storedCronRun struct {
+	run   CronRun
+	token objectstorage.WriteToken
+}
+
+// syncCronJobs processes the triggers of the scheduled jobs in desired and
+// returns the service configs of the runs that are still active, for the
+// scheduler to place. Runs finish when an agent reports their job exited.
+//
+// Every write is conditional on the record read before, and a job whose
+// state was written by a leader with a newer epoch is left alone, so a
+// deposed leader cannot start runs after a failover.
+func (c *Controller) syncCronJobs(ctx context.Context, desired []config.ServiceConfig, now time.Time) ([]config.ServiceConfig, error) {
+	scheduled := make(map[string]config.ServiceConfig)
+	for _, svc := range desired {
+		if svc.Schedule != "" {
+			scheduled[svc.Name] = svc
+		}
+	}
+	keys, err := c.store.ListKeys(ctx, cronPrefix(c.cfg.State.Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("listing cron state: %w", err)
+	}
+	jobs := make(map[string]bool, len(scheduled))
+	for name := range scheduled {
+		jobs[name] = true
+	}
+	for _, key := range keys {
+		if job, _, ok := strings.Cut(strings.TrimPrefix(key, cronPrefix(c.cfg.State.Prefix)), "/"); ok {
+			jobs[job] = true
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	if !c.stillLeader(ctx) {
+		return nil, fmt.Errorf("lost leadership before processing cron jobs")
+	}
+	finished, err := c.finishedRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var active []config.ServiceConfig
+	for _, name := range names {
+		runs, err := c.loadCronRuns(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		runs = c.completeCronRuns(ctx, runs, finished, now)
+
+		svc, ok := scheduled[name]
+		if ok {
+			runs, err = c.triggerCronJob(ctx, svc, runs, now)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			runs = c.cancelCronRuns(ctx, runs, CronRunCancelled, "job is no longer scheduled", now)
+		}
+		runs = c.pruneCronRuns(ctx, name, runs, ok)
+
+		for _, stored := range runs {
+			if stored.run.State == CronRunActive && stored.run.Service != nil {
+				active = append(active, *stored.run.Service)
+			}
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Name < active[j].Name })
+	return active, nil
+}
+
+// finishedRuns returns the job exits that agents report, keyed by service
+// name, along with the node each was reported by.
+func (c *Controller) finishedRuns(ctx context.Context) (map[string]finishedRun, error) {
+	keys, err := c.store.ListKeys(ctx, registryNodesPrefix(c.cfg.State.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]finishedRun)
+	for _, key := range keys {
+		var rec NodeRecord
+		_, exists, err := c.store.GetJSON(ctx, key, &rec)
+		if err != nil {
+			c.logger.Warn("failed reading node record", "key", key, "error", err)
+			continue
+		}
+		if !exists || rec.AgentStatus == nil {
+			continue
+		}
+		for _, svc := range rec.AgentStatus.Services {
+			if svc.Kind == config.KindJob && !svc.FinishedAt.IsZero() {
+				out[svc.Name] = finishedRun{node: rec.NodeID, status: svc}
+			}
+		}
+	}
+	return out, nil
+}
+
+type finishedRun struct {
+	node   string
+	status statusmodel.ServiceStatus
+}
+
+func (c *Controller) loadCronRuns(ctx context.Context, job string) ([]storedCronRun, error) {
+	keys, err := c.store.ListKeys(ctx, cronRunsPrefix(c.cfg.State.Prefix, job))
+	if err != nil {
+		return nil, fmt.Errorf("listing runs of %s: %w", job, err)
+	}
+	runs := make([]storedCronRun, 0, len(keys))
+	for _, key := range keys {
+		var run CronRun
+		token, exists, err := c.store.GetJSON(ctx, key, &run)
+		if err != nil {
+			return nil, fmt.Errorf("reading cron run %s: %w", key, err)
+		}
+		if exists {
+			runs = append(runs, storedCronRun{run: run, token: token})
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].run.ScheduledAt.Before(runs[j].run.ScheduledAt) })
+	return runs, nil
+}
+
+// completeCronRuns records the exit of every active run an agent reported
+// as finished.
+func (c *Controller) completeCronRuns(ctx context.Context, runs []storedCronRun, finished map[string]finishedRun, now time.Time) []storedCronRun {
+	for i := range runs {
+		run := runs[i].run
+		done, ok := finished[run.ID]
+		if run.State != CronRunActive || !ok {
+			continue
+		}
+		run.State = CronRunFailed
+		if done.status.VMState == "stopped" && done.status.ExitCode != nil && *done.status.ExitCode == 0 {
+			run.State = CronRunSucceeded
+		}
+		run.Node = done.node
+		run.ExitCode = done.status.ExitCode
+		run.Message = done.status.Message
+		run.FinishedAt = done.status.FinishedAt
+		if run.FinishedAt.IsZero() {
+			run.FinishedAt = now
+		}
+		if c.updateCronRun(ctx, &runs[i], run) {
+			c.logger.Info("cron run finished", "job", run.Job, "run", run.ID, "state", run.State, "node", run.Node)
+		}
+	}
+	return runs
+}
+
+// cancelCronRuns ends every active run with state.
+func (c *Controller) cancelCronRuns(ctx context.Context, runs []storedCronRun, state CronRunState, message string, now time.Time) []storedCronRun {
+	for i := range runs {
+		run := runs[i].run
+		if run.State != CronRunActive {
+			continue
+		}
+		run.State = state
+		run.Message = message
+		run.FinishedAt = now
+		if c.updateCronRun(ctx, &runs[i], run) {
+			c.logger.Info("cron run cancelled", "job", run.Job, "run", run.ID, "state", state)
+		}
+	}
+	return runs
+}
+
+// updateCronRun writes run over the stored record. A conflicting write
+// leaves the record as it was; the next pass retries.
+func (c *Controller) updateCronRun(ctx context.Context, stored *storedCronRun, run CronRun) bool {
+	ok, token, err := c.store.PutJSONIfMatch(ctx, cronRunKey(c.cfg.State.Prefix, run.Job, run.ID), stored.token, run)
+	if err != nil || !ok {
+		c.logger.Warn("updating cron run conflicted; retrying on next tick", "run", run.ID, "error", err)
+		return false
+	}
+	stored.run, stored.token = run, token
+	return true
+}
+
+// triggerCronJob handles the triggers of svc that passed since the last
+// pass. Only the most recent one can start a run; earlier ones are counted
+// as missed. The run is created before the job state moves past its
+// trigger, so a leader that fails in between leaves the next one to find
+// the run and carry on.
+func (c *Controller) triggerCronJob(ctx context.Context, svc config.ServiceConfig, runs []storedCronRun, now time.Time) ([]storedCronRun, error) {
+	key := cronJobStateKey(c.cfg.State.Prefix, svc.Name)
+	var state CronJobState
+	token, exists, err := c.store.GetJSON(ctx, key, &state)
+	if err != nil {
+		return runs, fmt.Errorf("reading cron state of %s: %w", svc.Name, err)
+	}
+	if exists && state.LeaderEpoch > c.epoch {
+		return runs, fmt.Errorf("cron job %s is owned by leader epoch %d, this controller has %d", svc.Name, state.LeaderEpoch, c.epoch)
+	}
+	if !exists {
+		// A new job starts with the first trigger after it was published.
+		state = CronJobState{Job: svc.Name, LastScheduledAt: now, LeaderEpoch: c.epoch, UpdatedAt: now}
+		if _, _, err := c.store.PutJSONIfAbsent(ctx, key, state); err != nil {
+			return runs, fmt.Errorf("creating cron state of %s: %w", svc.Name, err)
+		}
+		return runs, nil
+	}
+
+	schedule, err := cron.Parse(svc.Schedule)
+	if err != nil {
+		c.logger.Error("invalid cron schedule", "job", svc.Name, "schedule", svc.Schedule, "error", err)
+		return runs, nil
+	}
+	var latest time.Time
+	triggers := int64(0)
+	for t := schedule.Next(state.LastScheduledAt); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		latest = t
+		triggers++
+	}
+	if latest.IsZero() {
+		return runs, nil
+	}
+	if triggers > 1 {
+		c.logger.Warn("cron job missed triggers", "job", svc.Name, "missed", triggers-1, "since", state.LastScheduledAt)
+	}
+
+	runs, err = c.startCronRun(ctx, svc, runs, latest, now)
+	if err != nil {
+		return runs, err
+	}
+
+	state.LastScheduledAt = latest
+	state.MissedRuns += triggers - 1
+	state.LeaderEpoch = c.epoch
+	state.UpdatedAt = now
+	ok, _, err := c.store.PutJSONIfMatch(ctx, key, token, state)
+	if err != nil {
+		return runs, fmt.Errorf("updating cron state of %s: %w", svc.Name, err)
+	}
+	if !ok {
+		return runs, fmt.Errorf("cron state of %s changed concurrently", svc.Name)
+	}
+	return runs, nil
+}
+
+// startCronRun records the trigger of svc at scheduledAt: a new active run,
+// or a skipped or missed one when the concurrency policy or the starting
+// deadline rule it out.
+func (c *Controller) startCronRun(ctx context.Context, svc config.ServiceConfig, runs []storedCronRun, scheduledAt, now time.Time) ([]storedCronRun, error) {
+	id := cronRunID(svc.Name, scheduledAt)
+	for _, stored := range runs {
+		if stored.run.ID == id {
+			// Recorded by a leader that failed before updating the job state.
+			return runs, nil
+		}
+	}
+	run := CronRun{ID: id, Job: svc.Name, ScheduledAt: scheduledAt, LeaderEpoch: c.epoch, State: CronRunActive, CreatedAt: now}
+
+	activeRuns := 0
+	for _, stored := range runs {
+		if stored.run.State == CronRunActive {
+			activeRuns++
+		}
+	}
+	switch {
+	case svc.StartingDeadline > 0 && now.Sub(scheduledAt) > svc.StartingDeadline:
+		run.State, run.FinishedAt = CronRunMissed, now
+		run.Message = fmt.Sprintf("noticed %s after the trigger, past the starting deadline of %s", now.Sub(scheduledAt).Round(time.Second), svc.StartingDeadline)
+	case activeRuns > 0 && (svc.ConcurrencyPolicy == "" || svc.ConcurrencyPolicy == config.ConcurrencyForbid):
+		run.State, run.FinishedAt = CronRunSkipped, now
+		run.Message = "previous run is still active"
+	case activeRuns > 0 && svc.ConcurrencyPolicy == config.ConcurrencyReplace:
+		runs = c.cancelCronRuns(ctx, runs, CronRunReplaced, "replaced by "+id, now)
+	}
+	if run.State == CronRunActive {
+		runService := cronRunService(svc, id)
+		run.Service = &runService
+	}
+
+	ok, token, err := c.store.PutJSONIfAbsent(ctx, cronRunKey(c.cfg.State.Prefix, svc.Name, id), run)
+	if err != nil {
+		return runs, fmt.Errorf("recording cron run %s: %w", id, err)
+	}
+	if !ok {
+		return runs, fmt.Errorf("cron run %s was recorded concurrently", id)
+	}
+	c.logger.Info("cron job triggered", "job", svc.Name, "run", id, "state", run.State)
+	return append(runs, storedCronRun{run: run, token: token}), nil
+}
+
+// cronRunService derives the service config of a run from its job: a plain
+// job under the run's name that is never restarted, since the next trigger
+// takes the place of a retry.
+func cronRunService(job config.ServiceConfig, id string) config.ServiceConfig {
+	svc := enricher.RenameService(job, id)
+	svc.Schedule = ""
+	svc.ConcurrencyPolicy = ""
+	svc.StartingDeadline = 0
+	svc.JobRevision = id
+	svc.RestartPolicy = &config.RestartPolicy{Policy: config.RestartNever}
+	return svc
+}
+
+// pruneCronRuns deletes the oldest finished runs beyond the history limit.
+// The records of a job that is no longer scheduled go once none of its runs
+// is active.
+func (c *Controller) pruneCronRuns(ctx context.Context, job string, runs []storedCronRun, scheduled bool) []storedCronRun {
+	finished := 0
+	for _, stored := range runs {
+		if stored.run.State != CronRunActive {
+			finished++
+		}
+	}
+	keep := runs[:0]
+	for _, stored := range runs {
+		if stored.run.State != CronRunActive && (finished > cronHistoryLimit || !scheduled) {
+			if err := c.store.Delete(ctx, cronRunKey(c.cfg.State.Prefix, job, stored.run.ID)); err != nil {
+				c.logger.Warn("deleting cron run failed", "run", stored.run.ID, "error", err)
+				keep = append(keep, stored)
+				continue
+			}
+			finished--
+			continue
+		}
+		keep = append(keep, stored)
+	}
+	if !scheduled && len(keep) == 0 {
+		if err := c.store.Delete(ctx, cronJobStateKey(c.cfg.State.Prefix, job)); err != nil {
+			c.logger.Warn("deleting cron state failed", "job", job, "error", err)
+		}
+	}
+	return keep
+}
+
+// serviceNames returns the names of services, in order.
+func serviceNames(services []config.ServiceConfig) []string {
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Name)
+	}
+	return names
+}
@@ -0,0 +1,267 @@
+package controlplane
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/statusmodel"
+)
+
+func newLeaderController(t *testing.T, ctx context.Context, store StateStore) *Controller {
+	t.Helper()
+	c := NewController(validConfigForRole(RoleController), store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := c.renewLeadership(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !c.leader {
+		t.Fatal("controller did not acquire leadership")
+	}
+	return c
+}
+
+func cronJob(schedule, policy string) config.ServiceConfig {
+	return config.ServiceConfig{
+		Name: "report", Kind: config.KindJob, Image: "/images/report.ext4", Kernel: "/images/vmlinux",
+		VCPUs: 1, MemoryMB: 128, Schedule: schedule, ConcurrencyPolicy: policy,
+		Network: &config.NetworkConfig{Interface: "fw-report"},
+	}
+}
+
+func loadRuns(t *testing.T, ctx context.Context, c *Controller, job string) map[string]CronRun {
+	t.Helper()
+	stored, err := c.loadCronRuns(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := make(map[string]CronRun, len(stored))
+	for _, s := range stored {
+		runs[s.run.ID] = s.run
+	}
+	return runs
+}
+
+func TestSyncCronJobsStartsRunAtTrigger(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	desired := []config.ServiceConfig{cronJob("0 3 * * *", config.ConcurrencyForbid)}
+	start := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+
+	active, err := c.syncCronJobs(ctx, desired, start)
+	if err != nil || len(active) != 0 {
+		t.Fatalf("first pass = %v, %v; want no runs", active, err)
+	}
+	for _, now := range []time.Time{start.Add(time.Hour + 30*time.Second), start.Add(time.Hour + 45*time.Second)} {
+		active, err = c.syncCronJobs(ctx, desired, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(active) != 1 {
+			t.Fatalf("active runs = %+v, want one", active)
+		}
+	}
+	run := active[0]
+	if run.Name != "report-202601010300" || run.JobRevision != run.Name || run.Schedule != "" {
+		t.Fatalf("unexpected run service: %+v", run)
+	}
+	if run.RestartPolicy == nil || run.RestartPolicy.Policy != config.RestartNever {
+		t.Fatalf("run restart policy = %+v, want never", run.RestartPolicy)
+	}
+	if run.Network == nil || run.Network.Interface == "fw-report" {
+		t.Fatalf("run kept the job's TAP device: %+v", run.Network)
+	}
+}
+
+func TestSyncCronJobsRecordsCompletion(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	desired := []config.ServiceConfig{cronJob("0 3 * * *", "")}
+	start := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+	if _, err := c.syncCronJobs(ctx, desired, start); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.syncCronJobs(ctx, desired, start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode := 0
+	finishedAt := start.Add(time.Hour + time.Minute)
+	putNode(t, ctx, store, c.cfg, NodeRecord{NodeID: "node-1", State: NodeStateReady, LastSeenAt: finishedAt, AgentStatus: &statusmodel.AgentStatus{
+		SchemaVersion: 1, ObservedAt: finishedAt,
+		Services: []statusmodel.ServiceStatus{{Name: "report-202601010300", Kind: config.KindJob, VMState: "stopped", ExitCode: &exitCode, FinishedAt: finishedAt}},
+	}})
+	active, err := c.syncCronJobs(ctx, desired, finishedAt.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 0 {
+		t.Fatalf("active runs = %+v, want none", active)
+	}
+	run := loadRuns(t, ctx, c, "report")["report-202601010300"]
+	if run.State != CronRunSucceeded || run.Node != "node-1" || !run.FinishedAt.Equal(finishedAt) {
+		t.Fatalf("unexpected run record: %+v", run)
+	}
+}
+
+func TestSyncCronJobsConcurrencyPolicy(t *testing.T) {
+	tests := []struct {
+		policy     string
+		wantActive []string
+		wantFirst  CronRunState
+		wantSecond CronRunState
+	}{
+		{policy: config.ConcurrencyForbid, wantActive: []string{"report-202601010305"}, wantFirst: CronRunActive, wantSecond: CronRunSkipped},
+		{policy: config.ConcurrencyReplace, wantActive: []string{"report-202601010310"}, wantFirst: CronRunReplaced, wantSecond: CronRunActive},
+		{policy: config.ConcurrencyAllow, wantActive: []string{"report-202601010305", "report-202601010310"}, wantFirst: CronRunActive, wantSecond: CronRunActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			ctx := context.Background()
+			store := newBlobStateStore(newMemBlob())
+			c := newLeaderController(t, ctx, store)
+			desired := []config.ServiceConfig{cronJob("*/5 * * * *", tt.policy)}
+			start := time.Date(2026, 1, 1, 3, 1, 0, 0, time.UTC)
+
+			var active []config.ServiceConfig
+			for _, now := range []time.Time{start, start.Add(4 * time.Minute), start.Add(9 * time.Minute)} {
+				var err error
+				active, err = c.syncCronJobs(ctx, desired, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := serviceNames(active); len(got) != len(tt.wantActive) || got[0] != tt.wantActive[0] || got[len(got)-1] != tt.wantActive[len(tt.wantActive)-1] {
+				t.Fatalf("active runs = %v, want %v", got, tt.wantActive)
+			}
+			runs := loadRuns(t, ctx, c, "report")
+			if runs["report-202601010305"].State != tt.wantFirst || runs["report-202601010310"].State != tt.wantSecond {
+				t.Fatalf("run states = %s, %s; want %s, %s", runs["report-202601010305"].State, runs["report-202601010310"].State, tt.wantFirst, tt.wantSecond)
+			}
+		})
+	}
+}
+
+func TestSyncCronJobsCountsMissedTriggers(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	job := cronJob("0 * * * *", "")
+	job.StartingDeadline = 10 * time.Minute
+	desired := []config.ServiceConfig{job}
+	start := time.Date(2026, 1, 1, 1, 30, 0, 0, time.UTC)
+	if _, err := c.syncCronJobs(ctx, desired, start); err != nil {
+		t.Fatal(err)
+	}
+
+	// Controller down from 01:30 to 05:05: the 05:00 trigger is still within
+	// its deadline, the three before it are missed.
+	active, err := c.syncCronJobs(ctx, desired, start.Add(3*time.Hour+35*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Name != "report-202601010500" {
+		t.Fatalf("active runs = %v, want the 05:00 run", serviceNames(active))
+	}
+	var state CronJobState
+	if _, _, err := store.GetJSON(ctx, cronJobStateKey(c.cfg.State.Prefix, "report"), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.MissedRuns != 3 {
+		t.Fatalf("missed runs = %d, want 3", state.MissedRuns)
+	}
+
+	// The 06:00 trigger is noticed 20 minutes late, past the deadline.
+	if _, err := c.syncCronJobs(ctx, desired, start.Add(4*time.Hour+50*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if run := loadRuns(t, ctx, c, "report")["report-202601010600"]; run.State != CronRunMissed {
+		t.Fatalf("06:00 run = %+v, want missed", run)
+	}
+}
+
+func TestSyncCronJobsAcrossFailover(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	old := newLeaderController(t, ctx, store)
+	desired := []config.ServiceConfig{cronJob("0 3 * * *", config.ConcurrencyForbid)}
+	start := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+	if _, err := old.syncCronJobs(ctx, desired, start); err != nil {
+		t.Fatal(err)
+	}
+	// The old leader records the 03:00 run and fails before moving the job
+	// state past it.
+	trigger := start.Add(time.Hour)
+	if _, err := old.startCronRun(ctx, desired[0], nil, trigger, trigger); err != nil {
+		t.Fatal(err)
+	}
+
+	// Its lease expires and another controller takes over with a new epoch.
+	var lock LeaderLock
+	if _, _, err := store.GetJSON(ctx, controllerLockKey(old.cfg.State.Prefix), &lock); err != nil {
+		t.Fatal(err)
+	}
+	lock.LeaseExpiresAt = trigger.Add(-time.Minute)
+	if _, err := store.PutJSON(ctx, controllerLockKey(old.cfg.State.Prefix), lock); err != nil {
+		t.Fatal(err)
+	}
+	current := newLeaderController(t, ctx, store)
+	if current.epoch != old.epoch+1 {
+		t.Fatalf("new leader epoch = %d, want %d", current.epoch, old.epoch+1)
+	}
+
+	active, err := current.syncCronJobs(ctx, desired, trigger.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Name != "report-202601010300" {
+		t.Fatalf("active runs = %v, want the run the old leader recorded", serviceNames(active))
+	}
+	if runs := loadRuns(t, ctx, current, "report"); len(runs) != 1 {
+		t.Fatalf("runs = %+v, want one", runs)
+	}
+
+	// The deposed leader can no longer trigger runs, not even one that
+	// passed its leadership check before the failover.
+	if _, err := old.syncCronJobs(ctx, desired, trigger.Add(24*time.Hour)); err == nil {
+		t.Fatal("deposed leader processed cron jobs")
+	}
+	if _, err := old.triggerCronJob(ctx, desired[0], nil, trigger.Add(24*time.Hour)); err == nil {
+		t.Fatal("stale leader epoch triggered a run")
+	}
+	if runs := loadRuns(t, ctx, current, "report"); len(runs) != 1 {
+		t.Fatalf("runs = %+v, want one", runs)
+	}
+}
+
+func TestSyncCronJobsCancelsRunsOfRemovedJob(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	desired := []config.ServiceConfig{cronJob("0 3 * * *", "")}
+	start := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+	for _, now := range []time.Time{start, start.Add(time.Hour)} {
+		if _, err := c.syncCronJobs(ctx, desired, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	active, err := c.syncCronJobs(ctx, nil, start.Add(time.Hour+time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 0 {
+		t.Fatalf("active runs = %v, want none", serviceNames(active))
+	}
+	keys, err := store.ListKeys(ctx, cronPrefix(c.cfg.State.Prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("cron records left behind: %v", keys)
+	}
+}
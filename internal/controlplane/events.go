@@ -11,6 +11,7 @@ import (
 	"log/slog"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -217,10 +218,14 @@ func (s *EventsServer) publishDesiredRevision(ctx context.Context, desired *Desi
 	return upsertPointer(ctx, s.store, desiredCurrentKey(s.cfg.State.Prefix), desired.Revision)
 }
 
+// flattenNodeConfigs turns the enricher's per-node_type groups into one
+// service list. The group key becomes a required node label, so a service
+// only lands on nodes that registered its node_type among their node_names.
 func flattenNodeConfigs(configs []config.NodeConfig) []config.ServiceConfig {
 	var out []config.ServiceConfig
 	for _, nc := range configs {
 		for _, service := range nc.Services {
+			service.NodeSelector = withRequiredLabel(service.NodeSelector, nc.Node)
 			service.Volumes = append([]config.VolumeConfig(nil), service.Volumes...)
 			for i := range service.Volumes {
 				// Enricher node groups are deployment classes, not stable node IDs.
@@ -233,6 +238,18 @@ func flattenNodeConfigs(configs []config.NodeConfig) []config.ServiceConfig {
 	return out
 }
 
+func withRequiredLabel(selector *config.NodeSelector, label string) *config.NodeSelector {
+	out := &config.NodeSelector{}
+	if selector != nil {
+		out.Required = append(out.Required, selector.Required...)
+		out.Preferred = append(out.Preferred, selector.Preferred...)
+	}
+	if label != "" && !slices.Contains(out.Required, label) {
+		out.Required = append([]string{label}, out.Required...)
+	}
+	return out
+}
+
 func verifyGitHubSignature(secret string, body []byte, headers http.Header) error {
 	signature := strings.TrimSpace(headerValue(headers, "X-Hub-Signature-256"))
 	if signature == "" {
@@ -5,7 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"net/http"
+	"reflect"
 	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
 )
 
 func TestVerifyGitHubSignature(t *testing.T) {
@@ -27,3 +30,22 @@ func TestVerifyGitHubSignature(t *testing.T) {
 		t.Fatal("expected invalid signature error")
 	}
 }
+
+func TestFlattenNodeConfigsRequiresNodeTypeLabel(t *testing.T) {
+	services := flattenNodeConfigs([]config.NodeConfig{
+		{Node: "gpu", Services: []config.ServiceConfig{
+			{Name: "trainer", NodeSelector: &config.NodeSelector{Required: []string{"nvme"}, Preferred: []string{"a100"}}},
+		}},
+		{Node: "general", Services: []config.ServiceConfig{{Name: "web"}}},
+	})
+
+	want := map[string]config.NodeSelector{
+		"trainer": {Required: []string{"gpu", "nvme"}, Preferred: []string{"a100"}},
+		"web":     {Required: []string{"general"}},
+	}
+	for _, service := range services {
+		if service.NodeSelector == nil || !reflect.DeepEqual(*service.NodeSelector, want[service.Name]) {
+			t.Errorf("%s node selector = %+v, want %+v", service.Name, service.NodeSelector, want[service.Name])
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package controlplane
+
+import (
+	"context"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/scheduler"
+)
+
+// rebalance moves services of a fresh placement toward a better one, at most
+// rebalance.max_moves of them. A service only moves while it runs healthy on
+// its node, and so does every placed member of its replica and anti-affinity
+// groups, so a group never has two members down for a move at once.
+func (c *Controller) rebalance(ctx context.Context, services []config.ServiceConfig, nodes []scheduler.Node, assignments map[string][]config.ServiceConfig, pending []scheduler.Pending, reservations scheduler.StorageReservations, tenancy scheduler.Tenancy) (scheduler.RebalancePlan, error) {
+	_, observed, err := c.autoscalingObservations(ctx, time.Now().UTC())
+	if err != nil {
+		return scheduler.RebalancePlan{}, err
+	}
+	placedOn := make(map[string]string)
+	for node, placed := range assignments {
+		for _, svc := range placed {
+			placedOn[svc.Name] = node
+		}
+	}
+	ready := func(name string) bool {
+		obs, ok := observed[name]
+		return ok && obs.node == placedOn[name] && obs.status.VMState == "running" &&
+			(obs.status.Health == "healthy" || obs.status.Health == "not_configured")
+	}
+	members := make(map[string][]string)
+	for _, svc := range services {
+		for _, group := range scheduler.ServiceGroups(svc) {
+			members[group] = append(members[group], svc.Name)
+		}
+	}
+	movable := func(svc config.ServiceConfig) bool {
+		if !ready(svc.Name) {
+			return false
+		}
+		for _, group := range scheduler.ServiceGroups(svc) {
+			for _, member := range members[group] {
+				if placedOn[member] != "" && !ready(member) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	plan := scheduler.Rebalance(services, nodes, assignments, pending, reservations, tenancy, movable, c.cfg.Rebalance.MaxMoves)
+	for _, move := range plan.Moves {
+		c.logger.Info("rebalancing service",
+			"service", move.Service,
+			"from", move.From,
+			"to", move.To,
+			"reason", move.ReasonCode,
+			"message", move.Message,
+		)
+	}
+	return plan, nil
+}
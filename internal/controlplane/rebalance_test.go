@@ -0,0 +1,54 @@
+package controlplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/scheduler"
+	"github.com/artemnikitin/firework/internal/statusmodel"
+)
+
+func TestRebalanceWaitsForHealthyServices(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	c := newLeaderController(t, ctx, store)
+	c.cfg.Rebalance = RebalanceConfig{Enabled: true, MaxMoves: 2}
+
+	services := []config.ServiceConfig{{Name: "api", VCPUs: 1, MemoryMB: 256}, {Name: "web", VCPUs: 1, MemoryMB: 256}}
+	nodes := []scheduler.Node{
+		{InstanceID: "node-1", CapacityVCPUs: 4, CapacityMemMB: 4096},
+		{InstanceID: "node-2", CapacityVCPUs: 4, CapacityMemMB: 4096},
+	}
+	assignments, pending := scheduler.ScheduleWithStorage(services, nodes, map[string]string{"api": "node-1", "web": "node-1"}, scheduler.StorageReservations{}, scheduler.ScheduleOptions{})
+	putStatus := func(webHealth string) {
+		t.Helper()
+		putNode(t, ctx, store, c.cfg, NodeRecord{
+			NodeID: "node-1", State: NodeStateReady, LastSeenAt: time.Now().UTC(), Capacity: Resources{VCPUs: 4, MemoryMB: 4096},
+			AgentStatus: &statusmodel.AgentStatus{SchemaVersion: 1, NodeID: "node-1", Services: []statusmodel.ServiceStatus{
+				{Name: "api", VMState: "running", Health: "healthy"},
+				{Name: "web", VMState: "running", Health: webHealth},
+			}},
+		})
+	}
+
+	// web would even out the nodes but is unhealthy, so it stays for now.
+	putStatus("unhealthy")
+	plan, err := c.rebalance(ctx, services, nodes, assignments, pending, scheduler.StorageReservations{}, scheduler.Tenancy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Moves) != 0 || plan.Deferred != 1 {
+		t.Fatalf("moved an unhealthy service: %+v", plan)
+	}
+
+	putStatus("healthy")
+	plan, err = c.rebalance(ctx, services, nodes, assignments, pending, scheduler.StorageReservations{}, scheduler.Tenancy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Moves) != 1 || plan.Moves[0].Service != "web" || plan.Moves[0].To != "node-2" || plan.Deferred != 0 {
+		t.Fatalf("expected web to move to node-2, got %+v", plan)
+	}
+}
@@ -9,12 +9,18 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/artemnikitin/firework/internal/statusmodel"
 )
 
+// peerHealthTTL bounds how often heartbeats re-read every node record to
+// tell agents which peer services are not serving.
+const peerHealthTTL = 5 * time.Second
+
 // RegistryServer serves node enrollment and registry APIs.
 type RegistryServer struct {
 	cfg         Config
@@ -22,6 +28,12 @@ type RegistryServer struct {
 	logger      *slog.Logger
 	signer      *NodeCertSigner
 	tokenToNode map[string]string
+
+	// peersMu guards the services each node reports as not serving, read
+	// at peersReadAt.
+	peersMu     sync.Mutex
+	peersReadAt time.Time
+	unserving   map[string][]string
 }
 
 // NewRegistryServer creates a registry API server.
@@ -198,6 +210,7 @@ func (s *RegistryServer) handleRegister(w http.ResponseWriter, r *http.Request)
 		cur.Generation = req.Generation
 		cur.State = req.State
 		cur.Labels = req.Labels
+		cur.Topology = req.Topology
 		cur.Capacity = req.Capacity
 		cur.HostIP = req.HostIP
 		cur.Storage = req.Storage
@@ -261,6 +274,9 @@ func (s *RegistryServer) handleHeartbeat(w http.ResponseWriter, r *http.Request)
 		if req.Capacity.MemoryMB > 0 {
 			cur.Capacity.MemoryMB = req.Capacity.MemoryMB
 		}
+		if req.Capacity.MemoryOvercommitRatio > 0 {
+			cur.Capacity.MemoryOvercommitRatio = req.Capacity.MemoryOvercommitRatio
+		}
 		cur.Used = req.Used
 		cur.Storage = req.Storage
 		if req.HostIP != "" {
@@ -283,13 +299,77 @@ func (s *RegistryServer) handleHeartbeat(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	writeJSON(w, http.StatusOK, NodeResponse{
-		NodeID:     rec.NodeID,
-		Generation: rec.Generation,
-		State:      rec.State,
-		LastSeenAt: rec.LastSeenAt,
+		NodeID:            rec.NodeID,
+		Generation:        rec.Generation,
+		State:             rec.State,
+		LastSeenAt:        rec.LastSeenAt,
+		UnservingServices: s.unservingPeerServices(r.Context(), req.NodeID),
 	})
 }
 
+// unservingPeerServices returns the services nodes other than nodeID report
+// as not serving. The node records are read at most once per peerHealthTTL;
+// when they cannot be listed the last known set is used.
+func (s *RegistryServer) unservingPeerServices(ctx context.Context, nodeID string) []string {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	now := time.Now().UTC()
+	if s.unserving == nil || now.Sub(s.peersReadAt) >= peerHealthTTL {
+		if records, err := s.listNodeRecords(ctx); err != nil {
+			s.logger.Warn("failed listing nodes for peer health", "error", err)
+		} else {
+			s.unserving = unservingServices(records, now, s.cfg.NodeStaleTTL)
+			s.peersReadAt = now
+		}
+	}
+	var out []string
+	for node, services := range s.unserving {
+		if node != nodeID {
+			out = append(out, services...)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+func (s *RegistryServer) listNodeRecords(ctx context.Context) ([]NodeRecord, error) {
+	keys, err := s.store.ListKeys(ctx, registryNodesPrefix(s.cfg.State.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]NodeRecord, 0, len(keys))
+	for _, key := range keys {
+		var rec NodeRecord
+		_, exists, err := s.store.GetJSON(ctx, key, &rec)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// unservingServices returns, by node, the services whose agent reports them
+// as not serving. Every service of a node that is down or has stopped
+// heartbeating counts as not serving.
+func unservingServices(records []NodeRecord, now time.Time, staleTTL time.Duration) map[string][]string {
+	out := make(map[string][]string)
+	for _, rec := range records {
+		if rec.AgentStatus == nil {
+			continue
+		}
+		lost := rec.State == NodeStateDown || now.Sub(rec.LastSeenAt) > staleTTL
+		for _, svc := range rec.AgentStatus.Services {
+			if lost || !svc.Serving() {
+				out[rec.NodeID] = append(out[rec.NodeID], svc.Name)
+			}
+		}
+	}
+	return out
+}
+
 func applyHeartbeatAgentStatus(cur *NodeRecord, nodeID string, incoming *statusmodel.AgentStatus) error {
 	if incoming == nil {
 		// Older agents omit agent_status. Clear any value left by a newer
@@ -1,8 +1,10 @@
 package controlplane
 
 import (
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/artemnikitin/firework/internal/statusmodel"
 )
@@ -36,3 +38,24 @@ func TestApplyHeartbeatAgentStatusValidatesIdentityAndBoundsMessages(t *testing.
 		t.Fatalf("bounded message length = %d, want %d", got, statusmodel.MaxMessageLen)
 	}
 }
+
+func TestUnservingServicesReportsDownAndUnhealthyServices(t *testing.T) {
+	now := time.Now().UTC()
+	status := func(node string, services ...statusmodel.ServiceStatus) *statusmodel.AgentStatus {
+		return &statusmodel.AgentStatus{SchemaVersion: statusmodel.SchemaVersion, NodeID: node, Services: services}
+	}
+	records := []NodeRecord{
+		{NodeID: "node-1", State: NodeStateReady, LastSeenAt: now, AgentStatus: status("node-1",
+			statusmodel.ServiceStatus{Name: "web-0", VMState: "running", Health: "healthy"},
+			statusmodel.ServiceStatus{Name: "web-1", VMState: "running", Health: "unhealthy", HealthCheckType: "tcp"},
+			statusmodel.ServiceStatus{Name: "web-2", VMState: "failed", Health: "unknown"},
+		)},
+		{NodeID: "node-2", State: NodeStateReady, LastSeenAt: now.Add(-time.Hour), AgentStatus: status("node-2",
+			statusmodel.ServiceStatus{Name: "web-3", VMState: "running", Health: "healthy"},
+		)},
+	}
+	got := unservingServices(records, now, time.Minute)
+	if !slices.Equal(got["node-1"], []string{"web-1", "web-2"}) || !slices.Equal(got["node-2"], []string{"web-3"}) {
+		t.Fatalf("unexpected unserving services: %v", got)
+	}
+}
@@ -19,10 +19,12 @@ const (
 	NodeStateDown     NodeState = "down"
 )
 
-// Resources represents node resource quantities.
+// Resources represents node resource quantities. MemoryOvercommitRatio is
+// only reported with capacity.
 type Resources struct {
-	VCPUs    int `json:"vcpus"`
-	MemoryMB int `json:"memory_mb"`
+	VCPUs                 int     `json:"vcpus"`
+	MemoryMB              int     `json:"memory_mb"`
+	MemoryOvercommitRatio float64 `json:"memory_overcommit_ratio,omitempty"`
 }
 
 // StorageResources is the provider-neutral storage admission information
@@ -39,6 +41,7 @@ type NodeRecord struct {
 	Generation   int64                    `json:"generation"`
 	State        NodeState                `json:"state"`
 	Labels       []string                 `json:"labels,omitempty"`
+	Topology     map[string]string        `json:"topology,omitempty"`
 	Capacity     Resources                `json:"capacity"`
 	Used         Resources                `json:"used"`
 	HostIP       string                   `json:"host_ip,omitempty"`
@@ -51,13 +54,14 @@ type NodeRecord struct {
 
 // NodeRegisterRequest is the request payload for node registration.
 type NodeRegisterRequest struct {
-	NodeID     string           `json:"node_id"`
-	Generation int64            `json:"generation"`
-	Labels     []string         `json:"labels,omitempty"`
-	Capacity   Resources        `json:"capacity"`
-	State      NodeState        `json:"state,omitempty"`
-	HostIP     string           `json:"host_ip,omitempty"`
-	Storage    StorageResources `json:"storage,omitempty"`
+	NodeID     string            `json:"node_id"`
+	Generation int64             `json:"generation"`
+	Labels     []string          `json:"labels,omitempty"`
+	Topology   map[string]string `json:"topology,omitempty"`
+	Capacity   Resources         `json:"capacity"`
+	State      NodeState         `json:"state,omitempty"`
+	HostIP     string            `json:"host_ip,omitempty"`
+	Storage    StorageResources  `json:"storage,omitempty"`
 }
 
 // NodeHeartbeatRequest is the request payload for node heartbeat.
@@ -92,6 +96,17 @@ type PlacementRevision struct {
 	CreatedAt       time.Time           `json:"created_at"`
 	NodeConfigs     []config.NodeConfig `json:"node_configs"`
 	PendingServices []PendingPlacement  `json:"pending_services,omitempty"`
+	// Moves are the services the rebalancer moved in this placement.
+	Moves []PlacementMove `json:"moves,omitempty"`
+}
+
+// PlacementMove is a placed service the rebalancer moved to another node.
+type PlacementMove struct {
+	Service    string `json:"service"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	ReasonCode string `json:"reason_code"`
+	Message    string `json:"message,omitempty"`
 }
 
 type PendingPlacement struct {
@@ -123,6 +138,88 @@ type VolumeRecord struct {
 	UpdatedAt        time.Time         `json:"updated_at"`
 }
 
+// CronRunState is the lifecycle state of a scheduled job's run.
+type CronRunState string
+
+const (
+	// CronRunActive runs are placed onto nodes until they finish.
+	CronRunActive    CronRunState = "active"
+	CronRunSucceeded CronRunState = "succeeded"
+	CronRunFailed    CronRunState = "failed"
+	// CronRunSkipped triggers came while a run was active under the forbid
+	// concurrency policy.
+	CronRunSkipped CronRunState = "skipped"
+	// CronRunMissed triggers were noticed past their starting deadline.
+	CronRunMissed CronRunState = "missed"
+	// CronRunReplaced runs were cancelled by a newer run under the replace
+	// concurrency policy.
+	CronRunReplaced CronRunState = "replaced"
+	// CronRunCancelled runs were cancelled because their job was removed
+	// or is no longer scheduled.
+	CronRunCancelled CronRunState = "cancelled"
+)
+
+// CronJobState records how far the controller has processed a scheduled
+// job's triggers. LeaderEpoch is the epoch of the leader that wrote it last;
+// a controller with an older epoch must not touch the job.
+type CronJobState struct {
+	Job             string    `json:"job"`
+	LastScheduledAt time.Time `json:"last_scheduled_at"`
+	// MissedRuns counts the triggers that passed without a run because the
+	// controller was down or leaderless for several of them in a row.
+	MissedRuns  int64     `json:"missed_runs,omitempty"`
+	LeaderEpoch int64     `json:"leader_epoch"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CronRun is one trigger of a scheduled job. Its key derives from the
+// trigger time, so leaders that process the same trigger agree on it.
+type CronRun struct {
+	ID          string       `json:"id"`
+	Job         string       `json:"job"`
+	ScheduledAt time.Time    `json:"scheduled_at"`
+	LeaderEpoch int64        `json:"leader_epoch"`
+	State       CronRunState `json:"state"`
+	Node        string       `json:"node,omitempty"`
+	ExitCode    *int         `json:"exit_code,omitempty"`
+	Message     string       `json:"message,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	FinishedAt  time.Time    `json:"finished_at,omitempty"`
+	// Service is the run's service config, frozen at the trigger so a
+	// later change of the job does not restart runs already going.
+	Service *config.ServiceConfig `json:"service,omitempty"`
+}
+
+// AutoscalingState records the replica count the controller chose for an
+// autoscaled service. LeaderEpoch is the epoch of the leader that wrote it
+// last; a controller with an older epoch must not scale the service.
+type AutoscalingState struct {
+	Service  string `json:"service"`
+	Replicas int    `json:"replicas"`
+	// LastScaledAt is when the count last changed, or when the controller
+	// first saw the service. The cooldown runs from it.
+	LastScaledAt time.Time `json:"last_scaled_at"`
+	LeaderEpoch  int64     `json:"leader_epoch"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AutoscalingDecision records one change of an autoscaled service's replica
+// count and the signals it was based on.
+type AutoscalingDecision struct {
+	Service    string    `json:"service"`
+	DecidedAt  time.Time `json:"decided_at"`
+	From       int       `json:"from"`
+	To         int       `json:"to"`
+	ReasonCode string    `json:"reason_code"`
+	Message    string    `json:"message"`
+	// AverageCPU is the mean CPU utilisation of the replicas that reported
+	// one, in percent of their vCPUs; Samples is how many did.
+	AverageCPU  int   `json:"average_cpu_percent,omitempty"`
+	TargetCPU   int   `json:"target_cpu_percent"`
+	Samples     int   `json:"samples,omitempty"`
+	LeaderEpoch int64 `json:"leader_epoch"`
+}
+
 // RevisionPointer points to the current immutable revision.
 type RevisionPointer struct {
 	Revision  string    `json:"revision"`
@@ -167,6 +264,10 @@ type NodeResponse struct {
 	Generation int64     `json:"generation"`
 	State      NodeState `json:"state"`
 	LastSeenAt time.Time `json:"last_seen_at"`
+	// UnservingServices lists the services other nodes report as not
+	// serving, so the agent can leave those replicas out of its routes. Only
+	// heartbeat responses carry it.
+	UnservingServices []string `json:"unserving_services,omitempty"`
 }
 
 func stateRoot(prefix string) string { return strings.TrimSuffix(prefix, "/") + "/" }
@@ -232,6 +333,38 @@ func dedupeKey(prefix, eventID string) string {
 	return path.Join(stateRoot(prefix), "events", "dedupe", eventID+".json")
 }
 
+func cronPrefix(prefix string) string {
+	return path.Join(stateRoot(prefix), "cron") + "/"
+}
+
+func cronJobStateKey(prefix, job string) string {
+	return path.Join(stateRoot(prefix), "cron", job, "state.json")
+}
+
+func cronRunsPrefix(prefix, job string) string {
+	return path.Join(stateRoot(prefix), "cron", job, "runs") + "/"
+}
+
+func cronRunKey(prefix, job, runID string) string {
+	return path.Join(stateRoot(prefix), "cron", job, "runs", runID+".json")
+}
+
+func autoscalingPrefix(prefix string) string {
+	return path.Join(stateRoot(prefix), "autoscaling") + "/"
+}
+
+func autoscalingStateKey(prefix, service string) string {
+	return path.Join(stateRoot(prefix), "autoscaling", service, "state.json")
+}
+
+func autoscalingDecisionsPrefix(prefix, service string) string {
+	return path.Join(stateRoot(prefix), "autoscaling", service, "decisions") + "/"
+}
+
+func autoscalingDecisionKey(prefix, service string, decidedAt time.Time) string {
+	return path.Join(stateRoot(prefix), "autoscaling", service, "decisions", decidedAt.UTC().Format(autoscalingDecisionIDLayout)+".json")
+}
+
 func controllerLockKey(prefix string) string {
 	return path.Join(stateRoot(prefix), "locks", "controller.json")
 }
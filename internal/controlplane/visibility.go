@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/cron"
 	"github.com/artemnikitin/firework/internal/ingress"
 	"github.com/artemnikitin/firework/internal/statusmodel"
 )
@@ -25,6 +26,7 @@ type ListEnvelope[T any] struct {
 type NodeSummary struct {
 	NodeID           string             `json:"node_id"`
 	Labels           []string           `json:"labels,omitempty"`
+	Topology         map[string]string  `json:"topology,omitempty"`
 	State            string             `json:"state"`
 	LastSeenAt       time.Time          `json:"last_seen_at,omitempty"`
 	StatusAgeSeconds int64              `json:"status_age_seconds,omitempty"`
@@ -76,6 +78,8 @@ type NodeDetail struct {
 
 type ServiceSummary struct {
 	Name             string                `json:"name"`
+	Kind             string                `json:"kind,omitempty"`
+	ReplicaOf        string                `json:"replica_of,omitempty"`
 	Node             string                `json:"node,omitempty"`
 	State            string                `json:"state"`
 	Health           string                `json:"health"`
@@ -128,11 +132,44 @@ type ServiceDetail struct {
 	RoutingHostname   string                     `json:"routing_hostname,omitempty"`
 	PublicURL         string                     `json:"public_url,omitempty"`
 	RestartCount      int                        `json:"restart_count"`
+	ExitCode          *int                       `json:"exit_code,omitempty"`
+	FinishedAt        time.Time                  `json:"finished_at,omitempty"`
 	DesiredRevision   string                     `json:"desired_revision,omitempty"`
 	PlacementRevision string                     `json:"placement_revision,omitempty"`
 	RenderedRevision  string                     `json:"rendered_revision,omitempty"`
 	AppliedRevision   string                     `json:"applied_revision,omitempty"`
 	Volumes           []statusmodel.VolumeStatus `json:"volumes,omitempty"`
+	Schedule          string                     `json:"schedule,omitempty"`
+	ConcurrencyPolicy string                     `json:"concurrency_policy,omitempty"`
+	MissedRuns        int64                      `json:"missed_runs,omitempty"`
+	Runs              []CronRunSummary           `json:"runs,omitempty"`
+	CPUPercent        *int                       `json:"cpu_percent,omitempty"`
+	Autoscaling       *AutoscalingSummary        `json:"autoscaling,omitempty"`
+}
+
+// AutoscalingSummary is the autoscaling state of the service a replica
+// belongs to, with its decisions most recent first.
+type AutoscalingSummary struct {
+	Service              string                `json:"service"`
+	Replicas             int                   `json:"replicas"`
+	MinReplicas          int                   `json:"min_replicas"`
+	MaxReplicas          int                   `json:"max_replicas"`
+	TargetCPUUtilization int                   `json:"target_cpu_utilization"`
+	Cooldown             string                `json:"cooldown"`
+	LastScaledAt         time.Time             `json:"last_scaled_at,omitempty"`
+	Decisions            []AutoscalingDecision `json:"decisions,omitempty"`
+}
+
+// CronRunSummary is one run of a cron job, most recent first in
+// ServiceDetail.Runs.
+type CronRunSummary struct {
+	ID          string       `json:"id"`
+	State       CronRunState `json:"state"`
+	ScheduledAt time.Time    `json:"scheduled_at"`
+	Node        string       `json:"node,omitempty"`
+	ExitCode    *int         `json:"exit_code,omitempty"`
+	FinishedAt  time.Time    `json:"finished_at,omitempty"`
+	Message     string       `json:"message,omitempty"`
 }
 
 type visibilitySnapshot struct {
@@ -279,6 +316,9 @@ func (s *VisibilityService) Service(ctx context.Context, name string) (ServiceDe
 					detail.ActualNode = record.NodeID
 					detail.PID = actual.PID
 					detail.RestartCount = actual.RestartCount
+					detail.ExitCode = actual.ExitCode
+					detail.FinishedAt = actual.FinishedAt
+					detail.CPUPercent = actual.CPUPercent
 					detail.Volumes = mergeVolumeStatuses(detail.Volumes, actual.Volumes)
 					if actual.NetworkAddress != "" {
 						detail.NetworkAddress = actual.NetworkAddress
@@ -296,9 +336,102 @@ func (s *VisibilityService) Service(ctx context.Context, name string) (ServiceDe
 		detail.HealthCheck.Type = desired.HealthCheck.Type
 	}
 	detail.Storage = summarizeServiceStorage(detail.Volumes)
+	if desired.Schedule != "" {
+		detail.Schedule = desired.Schedule
+		detail.ConcurrencyPolicy = desired.ConcurrencyPolicy
+		if err := s.loadCronRuns(ctx, &detail); err != nil {
+			return ServiceDetail{}, false, err
+		}
+	}
+	if desired.Autoscaling != nil && desired.ReplicaOf != "" {
+		if err := s.loadAutoscaling(ctx, snapshot, *desired, &detail); err != nil {
+			return ServiceDetail{}, false, err
+		}
+	}
 	return detail, true, nil
 }
 
+// loadAutoscaling fills in the autoscaling state and decisions of the
+// service the replica desired belongs to.
+func (s *VisibilityService) loadAutoscaling(ctx context.Context, snapshot visibilitySnapshot, desired config.ServiceConfig, detail *ServiceDetail) error {
+	policy := desired.Autoscaling
+	summary := &AutoscalingSummary{
+		Service: desired.ReplicaOf, Replicas: len(autoscaledGroups(snapshot.desired.Services)[desired.ReplicaOf]),
+		MinReplicas: policy.MinReplicas, MaxReplicas: policy.MaxReplicas,
+		TargetCPUUtilization: policy.TargetCPUUtilization, Cooldown: policy.Cooldown.String(),
+	}
+	var state AutoscalingState
+	_, exists, err := s.store.GetJSON(ctx, autoscalingStateKey(s.cfg.State.Prefix, desired.ReplicaOf), &state)
+	if err != nil {
+		return fmt.Errorf("reading autoscaling state: %w", err)
+	}
+	if exists {
+		summary.Replicas = state.Replicas
+		summary.LastScaledAt = state.LastScaledAt
+	}
+	keys, err := s.store.ListKeys(ctx, autoscalingDecisionsPrefix(s.cfg.State.Prefix, desired.ReplicaOf))
+	if err != nil {
+		return fmt.Errorf("listing autoscaling decisions: %w", err)
+	}
+	for _, key := range keys {
+		var decision AutoscalingDecision
+		_, exists, err := s.store.GetJSON(ctx, key, &decision)
+		if err != nil {
+			return fmt.Errorf("reading autoscaling decision %s: %w", key, err)
+		}
+		if exists {
+			summary.Decisions = append(summary.Decisions, decision)
+		}
+	}
+	sort.Slice(summary.Decisions, func(i, j int) bool { return summary.Decisions[i].DecidedAt.After(summary.Decisions[j].DecidedAt) })
+	detail.Autoscaling = summary
+	return nil
+}
+
+// applyAutoscaling replaces the replicas of each autoscaled service in the
+// desired revision with the replicas its current count places.
+func (s *VisibilityService) applyAutoscaling(ctx context.Context, snapshot *visibilitySnapshot) error {
+	groups := autoscaledGroups(snapshot.desired.Services)
+	counts := make(map[string]int, len(groups))
+	for name := range groups {
+		var state AutoscalingState
+		_, exists, err := s.store.GetJSON(ctx, autoscalingStateKey(s.cfg.State.Prefix, name), &state)
+		if err != nil {
+			return fmt.Errorf("reading autoscaling state of %s: %w", name, err)
+		}
+		if exists {
+			counts[name] = state.Replicas
+		}
+	}
+	snapshot.desired.Services = scaleReplicas(snapshot.desired.Services, counts)
+	return nil
+}
+
+// loadCronRuns fills in the run history of the cron job in detail.
+func (s *VisibilityService) loadCronRuns(ctx context.Context, detail *ServiceDetail) error {
+	var state CronJobState
+	if _, _, err := s.store.GetJSON(ctx, cronJobStateKey(s.cfg.State.Prefix, detail.Name), &state); err != nil {
+		return fmt.Errorf("reading cron state: %w", err)
+	}
+	detail.MissedRuns = state.MissedRuns
+	keys, err := s.store.ListKeys(ctx, cronRunsPrefix(s.cfg.State.Prefix, detail.Name))
+	if err != nil {
+		return fmt.Errorf("listing cron runs: %w", err)
+	}
+	for _, key := range keys {
+		var run CronRun
+		_, exists, err := s.store.GetJSON(ctx, key, &run)
+		if err != nil {
+			return fmt.Errorf("reading cron run %s: %w", key, err)
+		}
+		if exists {
+			detail.Runs = append(detail.Runs, CronRunSummary{ID: run.ID, State: run.State, ScheduledAt: run.ScheduledAt, Node: run.Node, ExitCode: run.ExitCode, FinishedAt: run.FinishedAt, Message: run.Message})
+		}
+	}
+	sort.Slice(detail.Runs, func(i, j int) bool { return detail.Runs[i].ScheduledAt.After(detail.Runs[j].ScheduledAt) })
+	return nil
+}
+
 func desiredVolumeStatuses(service config.ServiceConfig, records map[string]VolumeRecord) []statusmodel.VolumeStatus {
 	volumes := make([]statusmodel.VolumeStatus, 0, len(service.Volumes))
 	for _, volume := range service.Volumes {
@@ -449,6 +582,9 @@ func (s *VisibilityService) load(ctx context.Context) (visibilitySnapshot, error
 			}
 		}
 	}
+	if err := s.applyAutoscaling(ctx, &snapshot); err != nil {
+		return snapshot, err
+	}
 	sort.Slice(snapshot.desired.Services, func(i, j int) bool { return snapshot.desired.Services[i].Name < snapshot.desired.Services[j].Name })
 	return snapshot, nil
 }
@@ -487,7 +623,7 @@ func (s visibilitySnapshot) nodeSummary(record NodeRecord) NodeSummary {
 		}
 	}
 	available := Resources{VCPUs: max(record.Capacity.VCPUs-allocated.VCPUs, 0), MemoryMB: max(record.Capacity.MemoryMB-allocated.MemoryMB, 0)}
-	summary := NodeSummary{NodeID: record.NodeID, Labels: append([]string(nil), record.Labels...), State: state, LastSeenAt: record.LastSeenAt, Capacity: record.Capacity, Allocated: allocated, Available: available, Storage: s.nodeStorageSummary(record), DesiredServices: desiredCount}
+	summary := NodeSummary{NodeID: record.NodeID, Labels: append([]string(nil), record.Labels...), Topology: record.Topology, State: state, LastSeenAt: record.LastSeenAt, Capacity: record.Capacity, Allocated: allocated, Available: available, Storage: s.nodeStorageSummary(record), DesiredServices: desiredCount}
 	if !record.LastSeenAt.IsZero() {
 		summary.StatusAgeSeconds = max(int64(s.now.Sub(record.LastSeenAt).Seconds()), 0)
 	}
@@ -580,11 +716,14 @@ func (s visibilitySnapshot) statusMatchesCurrent(status statusmodel.AgentStatus)
 }
 
 func (s visibilitySnapshot) serviceSummary(desired config.ServiceConfig) ServiceSummary {
+	if desired.Schedule != "" {
+		return s.cronJobSummary(desired)
+	}
 	reason := "unplaced"
 	if !s.placementCurrent {
 		reason = "placement_pending"
 	}
-	summary := ServiceSummary{Name: desired.Name, State: "pending", Health: "unknown", VCPUs: desired.VCPUs, MemoryMB: desired.MemoryMB, Storage: summarizeServiceStorage(desiredVolumeStatuses(desired, s.volumeByID)), ReasonCode: reason}
+	summary := ServiceSummary{Name: desired.Name, Kind: desired.Kind, ReplicaOf: desired.ReplicaOf, State: "pending", Health: "unknown", VCPUs: desired.VCPUs, MemoryMB: desired.MemoryMB, Storage: summarizeServiceStorage(desiredVolumeStatuses(desired, s.volumeByID)), ReasonCode: reason}
 	if pending, ok := s.pendingByService[desired.Name]; ok && s.placementCurrent {
 		summary.ReasonCode = pending.ReasonCode
 		summary.Message = pending.Message
@@ -626,6 +765,10 @@ func (s visibilitySnapshot) serviceSummary(desired config.ServiceConfig) Service
 	default:
 		summary.State = "unknown"
 	}
+	// A job that exited 0 is done rather than stopped.
+	if desired.Kind == config.KindJob && actual.VMState == "stopped" && actual.ExitCode != nil && *actual.ExitCode == 0 {
+		summary.State = "succeeded"
+	}
 	switch actual.Health {
 	case "healthy", "unhealthy", "unknown", "not_configured":
 		summary.Health = actual.Health
@@ -638,6 +781,22 @@ func (s visibilitySnapshot) serviceSummary(desired config.ServiceConfig) Service
 	return summary
 }
 
+// cronJobSummary describes a cron job. The job itself is never placed; its
+// runs are, under their own names, and show up in its detail.
+func (s visibilitySnapshot) cronJobSummary(desired config.ServiceConfig) ServiceSummary {
+	summary := ServiceSummary{Name: desired.Name, Kind: desired.Kind, State: "scheduled", Health: "not_configured", VCPUs: desired.VCPUs, MemoryMB: desired.MemoryMB, ReasonCode: "cron_scheduled"}
+	schedule, err := cron.Parse(desired.Schedule)
+	if err != nil {
+		summary.ReasonCode = "invalid_schedule"
+		summary.Message = err.Error()
+		return summary
+	}
+	if next := schedule.Next(s.now); !next.IsZero() {
+		summary.Message = "next run at " + next.Format(time.RFC3339)
+	}
+	return summary
+}
+
 func findAgentService(status statusmodel.AgentStatus, name string) (statusmodel.ServiceStatus, bool) {
 	for _, service := range status.Services {
 		if service.Name == name {
@@ -260,6 +260,139 @@ func TestServiceStorageSummaryAndDetailPreserveDurableVolumes(t *testing.T) {
 	}
 }
 
+func TestVisibilityReportsJobCompletion(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	cfg := validConfigForRole(RoleAPI)
+	cfg.NodeStaleTTL = time.Minute
+	now := time.Now().UTC()
+	desired := DesiredRevision{Revision: "d", Services: []config.ServiceConfig{
+		{Name: "migrate", Kind: config.KindJob},
+		{Name: "seed", Kind: config.KindJob},
+	}}
+	placement := PlacementRevision{Revision: "p", DesiredRevision: "d", NodeConfigs: []config.NodeConfig{{Node: "node", Services: desired.Services}}}
+	putCurrentState(t, ctx, store, desired, placement, "r")
+	succeeded, failed := 0, 2
+	finishedAt := now.Add(-time.Minute)
+	putNode(t, ctx, store, cfg, NodeRecord{NodeID: "node", State: NodeStateReady, LastSeenAt: now, AgentStatus: &statusmodel.AgentStatus{
+		SchemaVersion: 1, ObservedAt: now, DesiredRevision: "d", PlacementRevision: "p", ObservedRevision: "r", AppliedRevision: "r",
+		Services: []statusmodel.ServiceStatus{
+			{Name: "migrate", Kind: config.KindJob, VMState: "stopped", Health: "not_configured", ExitCode: &succeeded, FinishedAt: finishedAt, ReasonCode: "job_succeeded"},
+			{Name: "seed", Kind: config.KindJob, VMState: "failed", Health: "not_configured", ExitCode: &failed, FinishedAt: finishedAt, ReasonCode: "job_failed"},
+		},
+	}})
+
+	visibility := NewVisibilityService(cfg, store)
+	services, err := visibility.Services(ctx, "succeeded", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Items) != 1 || services.Items[0].Name != "migrate" || services.Items[0].Kind != config.KindJob {
+		t.Fatalf("succeeded services = %#v", services.Items)
+	}
+
+	detail, found, err := visibility.Service(ctx, "seed")
+	if err != nil || !found {
+		t.Fatalf("service detail found=%v err=%v", found, err)
+	}
+	if detail.State != "failed" || detail.ExitCode == nil || *detail.ExitCode != 2 || !detail.FinishedAt.Equal(finishedAt) || detail.ReasonCode != "job_failed" {
+		t.Fatalf("failed job detail = %#v", detail)
+	}
+}
+
+func TestVisibilityReportsCronJobs(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	cfg := validConfigForRole(RoleAPI)
+	desired := DesiredRevision{Revision: "d", Services: []config.ServiceConfig{
+		{Name: "report", Kind: config.KindJob, Schedule: "0 3 * * *", ConcurrencyPolicy: config.ConcurrencyForbid},
+	}}
+	putCurrentState(t, ctx, store, desired, PlacementRevision{Revision: "p", DesiredRevision: "d"}, "r")
+	exitCode := 0
+	older := time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC)
+	newer := older.Add(24 * time.Hour)
+	for _, run := range []CronRun{
+		{ID: "report-202601010300", Job: "report", ScheduledAt: older, State: CronRunSucceeded, Node: "node-1", ExitCode: &exitCode, FinishedAt: older.Add(time.Minute)},
+		{ID: "report-202601020300", Job: "report", ScheduledAt: newer, State: CronRunActive, Service: &config.ServiceConfig{Name: "report-202601020300", Env: map[string]string{"TOKEN": "secret"}}},
+	} {
+		if _, err := store.PutJSON(ctx, cronRunKey(cfg.State.Prefix, run.Job, run.ID), run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.PutJSON(ctx, cronJobStateKey(cfg.State.Prefix, "report"), CronJobState{Job: "report", LastScheduledAt: newer, MissedRuns: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	visibility := NewVisibilityService(cfg, store)
+	services, err := visibility.Services(ctx, "scheduled", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Items) != 1 || services.Items[0].ReasonCode != "cron_scheduled" || !strings.HasPrefix(services.Items[0].Message, "next run at ") {
+		t.Fatalf("scheduled services = %#v", services.Items)
+	}
+
+	detail, found, err := visibility.Service(ctx, "report")
+	if err != nil || !found {
+		t.Fatalf("service detail found=%v err=%v", found, err)
+	}
+	if detail.Schedule != "0 3 * * *" || detail.MissedRuns != 2 || len(detail.Runs) != 2 {
+		t.Fatalf("cron job detail = %#v", detail)
+	}
+	if detail.Runs[0].ID != "report-202601020300" || detail.Runs[0].State != CronRunActive || detail.Runs[1].State != CronRunSucceeded || detail.Runs[1].Node != "node-1" {
+		t.Fatalf("runs = %#v", detail.Runs)
+	}
+	data, err := json.Marshal(detail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("cron job detail exposes run env: %s", data)
+	}
+}
+
+func TestVisibilityReportsAutoscaling(t *testing.T) {
+	ctx := context.Background()
+	store := newBlobStateStore(newMemBlob())
+	cfg := validConfigForRole(RoleAPI)
+	putCurrentState(t, ctx, store, DesiredRevision{Revision: "d", Services: autoscaledReplicas(2)}, PlacementRevision{Revision: "p", DesiredRevision: "d"}, "r")
+	decidedAt := time.Date(2026, 1, 1, 0, 5, 0, 0, time.UTC)
+	for _, decision := range []AutoscalingDecision{
+		{Service: "web", DecidedAt: decidedAt, From: 2, To: 3, ReasonCode: autoscalingCPUAbove, AverageCPU: 90, TargetCPU: 50, Samples: 2},
+		{Service: "web", DecidedAt: decidedAt.Add(10 * time.Minute), From: 3, To: 1, ReasonCode: autoscalingCPUBelow, AverageCPU: 5, TargetCPU: 50, Samples: 3},
+	} {
+		if _, err := store.PutJSON(ctx, autoscalingDecisionKey(cfg.State.Prefix, "web", decision.DecidedAt), decision); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.PutJSON(ctx, autoscalingStateKey(cfg.State.Prefix, "web"), AutoscalingState{Service: "web", Replicas: 1, LastScaledAt: decidedAt.Add(10 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	visibility := NewVisibilityService(cfg, store)
+	services, err := visibility.Services(ctx, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Items) != 1 || services.Items[0].Name != "web-0" {
+		t.Fatalf("services = %#v, want the one replica running", services.Items)
+	}
+	detail, found, err := visibility.Service(ctx, "web-0")
+	if err != nil || !found {
+		t.Fatalf("service detail found=%v err=%v", found, err)
+	}
+	as := detail.Autoscaling
+	if as == nil || as.Service != "web" || as.Replicas != 1 || as.MaxReplicas != 4 || as.Cooldown != "1m0s" || len(as.Decisions) != 2 {
+		t.Fatalf("autoscaling = %#v", as)
+	}
+	if as.Decisions[0].ReasonCode != autoscalingCPUBelow || as.Decisions[1].ReasonCode != autoscalingCPUAbove {
+		t.Fatalf("decisions = %#v, want most recent first", as.Decisions)
+	}
+	if _, found, err := visibility.Service(ctx, "web-1"); err != nil || found {
+		t.Fatalf("scaled-in replica found=%v err=%v", found, err)
+	}
+}
+
 func TestServiceDetailPublicURL(t *testing.T) {
 	ctx := context.Background()
 	store := newBlobStateStore(newMemBlob())
@@ -8,7 +8,7 @@ const navButtons = [...document.querySelectorAll('[data-view]')];
 
 const states = {
   nodes: ['ready', 'draining', 'down', 'stale', 'unknown'],
-  services: ['pending', 'running', 'stopped', 'failed', 'unknown'],
+  services: ['pending', 'running', 'scheduled', 'succeeded', 'stopped', 'failed', 'unknown'],
 };
 
 let view = 'overview';
@@ -279,13 +279,20 @@ function renderServiceDetail(service) {
   const summary = detailTable([
     ['State', badge(service.state)],
     ['Health', badge(service.health)],
+    ['Replica of', display(service.replica_of)],
     ['Desired node', nodeLink(service.desired_node)],
     ['Actual node', nodeLink(service.actual_node)],
     ['vCPU', display(service.vcpus)],
     ['Memory', `${esc(service.memory_mb)} MB`],
     ['Disk', serviceDisk(service.storage)],
     ['PID', display(service.pid)],
+    ['CPU', service.cpu_percent == null ? display(null) : `${esc(service.cpu_percent)}%`],
     ['Restart count', display(service.restart_count)],
+    ['Exit code', display(service.exit_code)],
+    ['Finished', formatDate(service.finished_at)],
+    ['Schedule', display(service.schedule)],
+    ['Concurrency policy', display(service.concurrency_policy)],
+    ['Missed runs', display(service.missed_runs)],
     ['Network address', display(service.network_address)],
     ['Routing hostname', display(service.routing_hostname)],
     ['Public URL', externalLink(service.public_url)],
@@ -330,15 +337,48 @@ function renderServiceDetail(service) {
     <td>${display(volume.last_error)}</td>
   </tr>`);
 
+  const runRows = (service.runs || []).map(run => `<tr>
+    <td>${esc(run.id)}</td>
+    <td>${badge(run.state)}</td>
+    <td>${formatDate(run.scheduled_at)}</td>
+    <td>${nodeLink(run.node)}</td>
+    <td>${display(run.exit_code)}</td>
+    <td>${formatDate(run.finished_at)}</td>
+    <td>${display(run.message)}</td>
+  </tr>`);
+
+  const scaling = service.autoscaling;
+  const autoscaling = scaling ? detailTable([
+    ['Service', display(scaling.service)],
+    ['Replicas', display(scaling.replicas)],
+    ['Min replicas', display(scaling.min_replicas)],
+    ['Max replicas', display(scaling.max_replicas)],
+    ['Target CPU', `${esc(scaling.target_cpu_utilization)}%`],
+    ['Cooldown', display(scaling.cooldown)],
+    ['Last scaled', formatDate(scaling.last_scaled_at)],
+  ]) : '';
+
+  const decisionRows = ((scaling && scaling.decisions) || []).map(decision => `<tr>
+    <td>${formatDate(decision.decided_at)}</td>
+    <td>${esc(decision.from)} → ${esc(decision.to)}</td>
+    <td>${display(decision.reason_code)}</td>
+    <td>${display(decision.average_cpu_percent)}</td>
+    <td>${display(decision.samples)}</td>
+    <td>${display(decision.message)}</td>
+  </tr>`);
+
   content.innerHTML = `<a class="back-link" href="#services">← Back to services</a>
     <div class="detail-heading"><div><p class="eyebrow">Service</p><h1>${esc(service.name)}</h1></div>${badge(service.state)}</div>
     <div class="detail-grid">
       ${section('Overview', summary)}
       ${section('Health check', healthCheck)}
       ${section('Revisions', revisions)}
+      ${scaling ? section('Autoscaling', autoscaling) : ''}
     </div>
     ${(service.port_forwards || []).length ? section('Port forwards', table(['Host port', 'VM port'], portRows, '')) : ''}
-    ${(service.volumes || []).length ? section('Persistent volumes', table(['Volume', 'Type', 'Mount path', 'Bound node', 'Backend', 'Desired', 'Applied', 'State', 'Last error'], volumeRows, '')) : ''}`;
+    ${(service.volumes || []).length ? section('Persistent volumes', table(['Volume', 'Type', 'Mount path', 'Bound node', 'Backend', 'Desired', 'Applied', 'State', 'Last error'], volumeRows, '')) : ''}
+    ${(service.runs || []).length ? section('Runs', table(['Run', 'State', 'Scheduled', 'Node', 'Exit code', 'Finished', 'Message'], runRows, '')) : ''}
+    ${decisionRows.length ? section('Scaling decisions', table(['Decided', 'Replicas', 'Reason', 'Average CPU %', 'Samples', 'Message'], decisionRows, '')) : ''}`;
 }
 
 async function detail(kind, id) {
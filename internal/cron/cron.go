@@ -0,0 +1,166 @@
+// Package cron parses five-field cron schedules and computes their trigger
+// times. Schedules are evaluated in UTC.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field. Standard cron matches a day
+	// when either day field matches, unless one of them is "*".
+	domAny, dowAny bool
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+var aliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a schedule of the form "minute hour day-of-month month
+// day-of-week". Fields accept "*", single values, ranges (1-5), steps (*/15,
+// 0-30/10) and comma-separated lists of those. Day of week runs from 0 to 7,
+// both meaning Sunday. The @hourly, @daily, @midnight, @weekly, @monthly,
+// @yearly and @annually shorthands are also accepted.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := aliases[spec]; ok {
+		spec = expanded
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("expected %d fields, got %d", len(fields), len(parts))
+	}
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return Schedule{}, err
+		}
+		sets[i] = set
+	}
+	s := Schedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: parts[2] == "*", dowAny: parts[4] == "*",
+	}
+	// 7 is Sunday as well.
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+func parseField(expr string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepExpr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepExpr)
+			}
+			step = n
+		}
+		lo, hi := f.min, f.max
+		switch {
+		case rangeExpr == "*":
+		case strings.Contains(rangeExpr, "-"):
+			from, to, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			if lo, err = parseValue(from, f); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(to, f); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s: range %q runs backwards", f.name, rangeExpr)
+			}
+		default:
+			v, err := parseValue(rangeExpr, f)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: %q is not between %d and %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// searchLimit bounds Next for schedules that never match, such as the 30th
+// of February.
+const searchLimit = 5 * 366 * 24 * time.Hour
+
+// Next returns the first trigger time strictly after t, or the zero time
+// when the schedule never matches.
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(searchLimit)
+	for t.Before(limit) {
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !has(s.hour, t.Hour()):
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<v) != 0
+}
@@ -0,0 +1,44 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 1, 30, 10, 17, 42, 0, time.UTC) // a Friday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 1, 30, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 1, 30, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, 1, 31, 3, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 1, 30, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2026, 2, 2, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matches.
+		{"0 0 13 * 1", time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Next(%q) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejectsInvalidSchedules(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "@reboot"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
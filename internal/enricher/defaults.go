@@ -3,6 +3,7 @@ package enricher
 import (
 	"fmt"
 	"hash/fnv"
+	"maps"
 	"sort"
 	"time"
 
@@ -19,6 +20,14 @@ const (
 	fallbackHealthCheckInterval = "10s"
 	fallbackHealthCheckTimeout  = "5s"
 	fallbackHealthCheckRetries  = 3
+
+	fallbackRestartBackoff    = "10s"
+	fallbackRestartMaxBackoff = "5m"
+
+	fallbackAutoscalingTarget   = 70
+	fallbackAutoscalingCooldown = "5m"
+
+	fallbackMaxSkew = 1
 )
 
 // EnrichService takes a lightweight ServiceSpec and fills in all missing
@@ -37,7 +46,10 @@ func EnrichService(spec ServiceSpec, defs Defaults) config.ServiceConfig {
 		Links:             spec.Links,
 		Metadata:          spec.Metadata,
 		AntiAffinityGroup: spec.AntiAffinityGroup,
+		NodeSelector:      spec.NodeSelector,
+		Priority:          spec.Priority,
 		NodeHostIPEnv:     spec.NodeHostIPEnv,
+		Snapshot:          spec.Snapshot,
 	}
 	if len(spec.CrossNodeLinks) > 0 {
 		svc.CrossNodeLinks = make([]config.CrossNodeLink, len(spec.CrossNodeLinks))
@@ -49,21 +61,52 @@ func EnrichService(spec ServiceSpec, defs Defaults) config.ServiceConfig {
 	svc.VCPUs = coalesceInt(spec.VCPUs, defs.VCPUs, fallbackVCPUs)
 	svc.MemoryMB = coalesceInt(spec.MemoryMB, defs.MemoryMB, fallbackMemoryMB)
 	svc.KernelArgs = coalesce(spec.KernelArgs, defs.KernelArgs, fallbackKernelArgs)
+	if spec.Balloon != nil {
+		svc.Balloon = &config.BalloonConfig{MinMemoryMB: coalesceInt(spec.Balloon.MinMemoryMB, svc.MemoryMB/2)}
+	}
 
-	if spec.Network {
+	if spec.attachesDefaultNetwork() {
 		svc.Network = &config.NetworkConfig{
 			Interface: tapIfname(spec.Name),
 		}
+		for _, name := range spec.Networks {
+			if name == config.DefaultNetwork {
+				continue
+			}
+			svc.Networks = append(svc.Networks, config.NetworkConfig{
+				Name:      name,
+				Interface: attachmentTAPName(spec.Name, name),
+			})
+		}
 	}
 	if len(spec.PortForwards) > 0 {
 		svc.PortForwards = make([]config.PortForward, len(spec.PortForwards))
 		copy(svc.PortForwards, spec.PortForwards)
 	}
 
-	hcSpec := mergeHealthCheck(spec.HealthCheck, defs.HealthCheck)
-	if hcSpec != nil {
+	svc.RateLimits = resolveRateLimits(mergeRateLimits(spec.RateLimits, defs.RateLimits))
+
+	// Jobs are not health checked, so the default health check does not
+	// apply to them.
+	if spec.Kind == config.KindJob {
+		svc.Kind = config.KindJob
+		if spec.Schedule != "" {
+			svc.Schedule = spec.Schedule
+			svc.ConcurrencyPolicy = coalesce(spec.ConcurrencyPolicy, config.ConcurrencyForbid)
+			svc.StartingDeadline, _ = parseDurationWithFallback(spec.StartingDeadline, "0s")
+		}
+	} else if hcSpec := mergeHealthCheck(spec.HealthCheck, defs.HealthCheck); hcSpec != nil {
 		svc.HealthCheck = buildHealthCheck(hcSpec)
 	}
+	if spec.RestartPolicy != nil {
+		svc.RestartPolicy = buildRestartPolicy(spec.RestartPolicy)
+	}
+	if spec.Autoscaling != nil {
+		svc.Autoscaling = buildAutoscaling(spec.Autoscaling)
+	}
+	if spec.TopologySpread != "" {
+		svc.TopologySpread = &config.TopologySpread{Key: spec.TopologySpread, MaxSkew: coalesceInt(spec.MaxSkew, fallbackMaxSkew)}
+	}
 
 	return svc
 }
@@ -89,6 +132,49 @@ func resolveVolumes(specs []VolumeSpec, defs VolumeDefaults) []config.VolumeConf
 	return volumes
 }
 
+// mergeRateLimits overlays the devices set in spec on defs.
+func mergeRateLimits(spec, defs *RateLimitsSpec) *RateLimitsSpec {
+	if spec == nil {
+		return defs
+	}
+	if defs == nil {
+		return spec
+	}
+	merged := *defs
+	if spec.Rootfs != nil {
+		merged.Rootfs = spec.Rootfs
+	}
+	if spec.Volumes != nil {
+		merged.Volumes = spec.Volumes
+	}
+	if spec.Network != nil {
+		merged.Network = spec.Network
+	}
+	return &merged
+}
+
+func resolveRateLimits(spec *RateLimitsSpec) config.RateLimits {
+	if spec == nil {
+		return config.RateLimits{}
+	}
+	return config.RateLimits{
+		Rootfs:  resolveRateLimit(spec.Rootfs),
+		Volumes: resolveRateLimit(spec.Volumes),
+		Network: resolveRateLimit(spec.Network),
+	}
+}
+
+func resolveRateLimit(spec *RateLimitSpec) config.RateLimit {
+	if spec == nil {
+		return config.RateLimit{}
+	}
+	limit := config.RateLimit{Ops: spec.Ops}
+	if spec.Bandwidth != "" {
+		limit.BandwidthBytes, _ = config.ParseBandwidth(spec.Bandwidth) // ValidateInput owns errors.
+	}
+	return limit
+}
+
 // mergeHealthCheck merges spec-level health check with defaults.
 func mergeHealthCheck(spec, defs *HealthCheckSpec) *HealthCheckSpec {
 	if spec == nil && defs == nil {
@@ -111,6 +197,9 @@ func mergeHealthCheck(spec, defs *HealthCheckSpec) *HealthCheckSpec {
 	if merged.Path == "" {
 		merged.Path = defs.Path
 	}
+	if len(merged.Command) == 0 {
+		merged.Command = defs.Command
+	}
 	if merged.Interval == "" {
 		merged.Interval = defs.Interval
 	}
@@ -138,12 +227,38 @@ func buildHealthCheck(spec *HealthCheckSpec) *config.HealthCheckConfig {
 		Type:     spec.Type,
 		Port:     spec.Port,
 		Path:     spec.Path,
+		Command:  append([]string(nil), spec.Command...),
 		Interval: interval,
 		Timeout:  timeout,
 		Retries:  retries,
 	}
 }
 
+// buildRestartPolicy converts a RestartPolicySpec into a
+// config.RestartPolicy with every field resolved.
+func buildRestartPolicy(spec *RestartPolicySpec) *config.RestartPolicy {
+	backoff, _ := parseDurationWithFallback(spec.Backoff, fallbackRestartBackoff)
+	maxBackoff, _ := parseDurationWithFallback(spec.MaxBackoff, fallbackRestartMaxBackoff)
+	return &config.RestartPolicy{
+		Policy:      coalesce(spec.Policy, config.RestartAlways),
+		MaxAttempts: spec.MaxAttempts,
+		Backoff:     backoff,
+		MaxBackoff:  max(maxBackoff, backoff),
+	}
+}
+
+// buildAutoscaling converts an AutoscalingSpec into a config.Autoscaling
+// with every field resolved.
+func buildAutoscaling(spec *AutoscalingSpec) *config.Autoscaling {
+	cooldown, _ := parseDurationWithFallback(spec.Cooldown, fallbackAutoscalingCooldown)
+	return &config.Autoscaling{
+		MinReplicas:          spec.MinReplicas,
+		MaxReplicas:          spec.MaxReplicas,
+		TargetCPUUtilization: coalesceInt(spec.TargetCPUUtilization, fallbackAutoscalingTarget),
+		Cooldown:             cooldown,
+	}
+}
+
 func parseDurationWithFallback(value, fallback string) (time.Duration, error) {
 	if value == "" {
 		value = fallback
@@ -182,6 +297,54 @@ func tapIfname(serviceName string) string {
 	return prefix + short
 }
 
+// RenameService returns a copy of svc under another name, with the TAP
+// devices derived from the new name. The controller uses it for the runs
+// of scheduled jobs.
+func RenameService(svc config.ServiceConfig, name string) config.ServiceConfig {
+	svc.Name = name
+	svc.Env = maps.Clone(svc.Env)
+	svc.Volumes = append([]config.VolumeConfig(nil), svc.Volumes...)
+	if svc.Network != nil {
+		network := *svc.Network
+		network.Interface = tapIfname(name)
+		svc.Network = &network
+	}
+	svc.Networks = append([]config.NetworkConfig(nil), svc.Networks...)
+	for i := range svc.Networks {
+		svc.Networks[i].Interface = attachmentTAPName(name, svc.Networks[i].Name)
+	}
+	return svc
+}
+
+// ExpandReplicas returns the replicas of svc, named <name>-0 to
+// <name>-(n-1). A service without replicas is returned as is.
+func ExpandReplicas(svc config.ServiceConfig, replicas int) []config.ServiceConfig {
+	if replicas == 0 {
+		return []config.ServiceConfig{svc}
+	}
+	out := make([]config.ServiceConfig, 0, replicas)
+	for i := range replicas {
+		replica := RenameService(svc, ReplicaName(svc.Name, i))
+		replica.ReplicaOf = svc.Name
+		out = append(out, replica)
+	}
+	return out
+}
+
+// ReplicaName returns the name of the i-th replica of a service.
+func ReplicaName(service string, i int) string {
+	return fmt.Sprintf("%s-%d", service, i)
+}
+
+// attachmentTAPName derives the TAP device of a service's attachment to an
+// additional network. It stays within IFNAMSIZ-1 and differs from the
+// "tap-" names of default attachments.
+func attachmentTAPName(serviceName, network string) string {
+	h := fnv.New32a()
+	h.Write([]byte(serviceName + "/" + network))
+	return fmt.Sprintf("fwn-%08x", h.Sum32())
+}
+
 func coalesceInt(values ...int) int {
 	for _, v := range values {
 		if v != 0 {
@@ -268,6 +268,149 @@ func TestEnrichService_WithNetwork(t *testing.T) {
 	}
 }
 
+func TestEnrichService_AdditionalNetworks(t *testing.T) {
+	spec := ServiceSpec{
+		Name:     "db",
+		Image:    "/images/db.ext4",
+		Networks: []string{"default", "replication", "storage"},
+	}
+
+	svc := EnrichService(spec, Defaults{})
+
+	if svc.Network == nil || svc.Network.Interface != "tap-db" {
+		t.Fatalf("expected the default network on tap-db, got %+v", svc.Network)
+	}
+	if len(svc.Networks) != 2 || svc.Networks[0].Name != "replication" || svc.Networks[1].Name != "storage" {
+		t.Fatalf("expected replication and storage attachments in order, got %+v", svc.Networks)
+	}
+	if tap := svc.Networks[0].Interface; tap == svc.Networks[1].Interface || len(tap) > 15 || strings.HasPrefix(tap, "tap-") {
+		t.Errorf("unexpected attachment TAPs %q and %q", tap, svc.Networks[1].Interface)
+	}
+}
+
+func TestEnrichService_RateLimitsOverrideDefaultsPerDevice(t *testing.T) {
+	spec := ServiceSpec{
+		Name:  "db",
+		Image: "/images/db.ext4",
+		RateLimits: &RateLimitsSpec{
+			Rootfs:  &RateLimitSpec{Bandwidth: "100Mi"},
+			Network: &RateLimitSpec{},
+		},
+	}
+	defs := Defaults{RateLimits: &RateLimitsSpec{
+		Rootfs:  &RateLimitSpec{Bandwidth: "20Mi", Ops: 500},
+		Volumes: &RateLimitSpec{Ops: 200},
+		Network: &RateLimitSpec{Bandwidth: "1Gi"},
+	}}
+
+	svc := EnrichService(spec, defs)
+
+	want := config.RateLimits{
+		Rootfs:  config.RateLimit{BandwidthBytes: 100 * config.MiB},
+		Volumes: config.RateLimit{Ops: 200},
+	}
+	if svc.RateLimits != want {
+		t.Fatalf("rate limits = %+v, want %+v", svc.RateLimits, want)
+	}
+}
+
+func TestEnrichService_BalloonFloorDefaultsToHalfOfMemory(t *testing.T) {
+	spec := ServiceSpec{Name: "cache", Image: "/images/cache.ext4", MemoryMB: 1024, Balloon: &config.BalloonConfig{}}
+	svc := EnrichService(spec, Defaults{})
+	if svc.Balloon == nil || svc.Balloon.MinMemoryMB != 512 {
+		t.Fatalf("balloon = %+v, want min_memory_mb 512", svc.Balloon)
+	}
+
+	spec.Balloon = &config.BalloonConfig{MinMemoryMB: 256}
+	if svc := EnrichService(spec, Defaults{}); svc.Balloon.MinMemoryMB != 256 {
+		t.Fatalf("explicit min_memory_mb not kept: %+v", svc.Balloon)
+	}
+	if svc := EnrichService(ServiceSpec{Name: "api", Image: "/images/api.ext4"}, Defaults{}); svc.Balloon != nil {
+		t.Fatalf("service without a balloon got %+v", svc.Balloon)
+	}
+}
+
+func TestEnrichService_RestartPolicyDefaults(t *testing.T) {
+	spec := ServiceSpec{Name: "worker", Image: "/images/worker.ext4", RestartPolicy: &RestartPolicySpec{MaxAttempts: 5, Backoff: "30s"}}
+	svc := EnrichService(spec, Defaults{})
+	want := config.RestartPolicy{Policy: config.RestartAlways, MaxAttempts: 5, Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+	if svc.RestartPolicy == nil || *svc.RestartPolicy != want {
+		t.Fatalf("restart policy = %+v, want %+v", svc.RestartPolicy, want)
+	}
+	if svc := EnrichService(ServiceSpec{Name: "api", Image: "/images/api.ext4"}, Defaults{}); svc.RestartPolicy != nil {
+		t.Fatalf("service without a restart policy got %+v", svc.RestartPolicy)
+	}
+}
+
+func TestEnrichService_JobSkipsDefaultHealthCheck(t *testing.T) {
+	defs := Defaults{HealthCheck: &HealthCheckSpec{Type: "http", Port: 8080}}
+	svc := EnrichService(ServiceSpec{Name: "migrate", Image: "/images/migrate.ext4", Kind: config.KindJob}, defs)
+	if svc.Kind != config.KindJob {
+		t.Fatalf("kind = %q, want job", svc.Kind)
+	}
+	if svc.HealthCheck != nil {
+		t.Fatalf("job got default health check %+v", svc.HealthCheck)
+	}
+	if svc := EnrichService(ServiceSpec{Name: "api", Image: "/images/api.ext4", Kind: config.KindService}, defs); svc.Kind != "" || svc.HealthCheck == nil {
+		t.Fatalf("service = kind %q, health check %+v; want no kind and the default health check", svc.Kind, svc.HealthCheck)
+	}
+}
+
+func TestEnrichService_ScheduledJob(t *testing.T) {
+	svc := EnrichService(ServiceSpec{Name: "report", Image: "/images/report.ext4", Kind: config.KindJob, Schedule: "0 3 * * *", StartingDeadline: "10m"}, Defaults{})
+	if svc.Schedule != "0 3 * * *" || svc.ConcurrencyPolicy != config.ConcurrencyForbid || svc.StartingDeadline != 10*time.Minute {
+		t.Fatalf("schedule = %q, concurrency policy = %q, starting deadline = %s", svc.Schedule, svc.ConcurrencyPolicy, svc.StartingDeadline)
+	}
+}
+
+func TestRenameService(t *testing.T) {
+	svc := EnrichService(ServiceSpec{Name: "report", Image: "/images/report.ext4", Network: true, Networks: []string{"backend"}}, Defaults{})
+	run := RenameService(svc, "report-202601010300")
+	if run.Name != "report-202601010300" || run.Network.Interface != tapIfname("report-202601010300") || run.Networks[0].Interface != attachmentTAPName("report-202601010300", "backend") {
+		t.Fatalf("renamed service = %+v, networks %+v", run, run.Networks)
+	}
+	if svc.Network.Interface != tapIfname("report") || svc.Networks[0].Interface != attachmentTAPName("report", "backend") {
+		t.Fatalf("renaming changed the original: %+v, networks %+v", svc.Network, svc.Networks)
+	}
+}
+
+func TestExpandReplicas(t *testing.T) {
+	svc := EnrichService(ServiceSpec{Name: "web", Image: "/images/web.ext4", Network: true, Env: map[string]string{"A": "1"}}, Defaults{})
+	if got := ExpandReplicas(svc, 0); len(got) != 1 || got[0].Name != "web" || got[0].ReplicaOf != "" {
+		t.Fatalf("service without replicas = %+v", got)
+	}
+	replicas := ExpandReplicas(svc, 3)
+	if len(replicas) != 3 {
+		t.Fatalf("got %d replicas, want 3", len(replicas))
+	}
+	for i, replica := range replicas {
+		name := ReplicaName("web", i)
+		if replica.Name != name || replica.ReplicaOf != "web" || replica.Network.Interface != tapIfname(name) {
+			t.Fatalf("replica %d = %+v, network %+v", i, replica, replica.Network)
+		}
+	}
+	replicas[0].Env["A"] = "2"
+	if replicas[1].Env["A"] != "1" || svc.Env["A"] != "1" {
+		t.Fatal("replicas share their env map")
+	}
+}
+
+func TestEnrichService_Autoscaling(t *testing.T) {
+	spec := ServiceSpec{Name: "web", Image: "/images/web.ext4", Autoscaling: &AutoscalingSpec{MinReplicas: 2, MaxReplicas: 5}}
+	svc := EnrichService(spec, Defaults{})
+	want := config.Autoscaling{MinReplicas: 2, MaxReplicas: 5, TargetCPUUtilization: 70, Cooldown: 5 * time.Minute}
+	if svc.Autoscaling == nil || *svc.Autoscaling != want {
+		t.Fatalf("autoscaling = %+v, want %+v", svc.Autoscaling, want)
+	}
+	if got := spec.initialReplicas(); got != 2 {
+		t.Fatalf("initial replicas = %d, want min_replicas", got)
+	}
+	spec.Replicas = 3
+	if got := spec.initialReplicas(); got != 3 {
+		t.Fatalf("initial replicas = %d, want replicas", got)
+	}
+}
+
 func TestEnrichService_WithoutNetwork(t *testing.T) {
 	spec := ServiceSpec{
 		Name:    "batch",
@@ -21,7 +21,7 @@ type Result struct {
 //  2. Load and expand tenants (optional — no-op if tenants/ doesn't exist)
 //  3. Validate input
 //  4. Group services by node type
-//  5. Enrich each service with defaults
+//  5. Enrich each service with defaults and expand its replicas
 //  6. Validate output
 func Enrich(inputDir string) (*Result, error) {
 	// 1. Load input.
@@ -67,7 +67,7 @@ func Enrich(inputDir string) (*Result, error) {
 		var enrichedServices []config.ServiceConfig
 		for _, spec := range services {
 			svc := EnrichService(spec, input.Defaults)
-			enrichedServices = append(enrichedServices, svc)
+			enrichedServices = append(enrichedServices, ExpandReplicas(svc, spec.initialReplicas())...)
 		}
 
 		nc := config.NodeConfig{
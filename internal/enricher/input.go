@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/artemnikitin/firework/internal/config"
@@ -26,11 +27,70 @@ type ServiceSpec struct {
 	Links             []config.ServiceLink   `yaml:"links,omitempty"`
 	Metadata          map[string]string      `yaml:"metadata,omitempty"`
 	AntiAffinityGroup string                 `yaml:"anti_affinity_group,omitempty"`
+	NodeSelector      *config.NodeSelector   `yaml:"node_selector,omitempty"`
 	CrossNodeLinks    []config.CrossNodeLink `yaml:"cross_node_links,omitempty"`
+	// TopologySpread names a node topology key, such as zone, to spread the
+	// service's replicas or anti-affinity group across before spreading
+	// them across nodes. MaxSkew bounds the difference between the most and
+	// least loaded domain; it defaults to 1.
+	TopologySpread string `yaml:"topology_spread,omitempty"`
+	MaxSkew        int    `yaml:"max_skew,omitempty"`
+	// Priority lets the service preempt lower-priority services when the
+	// cluster runs out of capacity. Unset is 0, the lowest.
+	Priority int `yaml:"priority,omitempty"`
 	// NodeHostIPEnv, when set, causes the enricher to inject this node's own
 	// host IP into the named env var (e.g. "transport.publish_host" for ES).
 	NodeHostIPEnv string       `yaml:"node_host_ip_env,omitempty"`
 	Volumes       []VolumeSpec `yaml:"volumes,omitempty"`
+	// Snapshot resumes the service from a memory snapshot after the agent
+	// suspends it or a drain migrates it, instead of cold booting.
+	Snapshot bool `yaml:"snapshot,omitempty"`
+	// Networks names the agent networks the service attaches to. "default"
+	// is the same as network: true; the others become eth1, eth2, ... in
+	// order and require the default network.
+	Networks []string `yaml:"networks,omitempty"`
+	// RateLimits caps disk and network I/O; devices not set here inherit
+	// the defaults.yaml limits.
+	RateLimits *RateLimitsSpec `yaml:"rate_limits,omitempty"`
+	// Balloon adds a memory balloon. min_memory_mb defaults to half of
+	// memory_mb.
+	Balloon *config.BalloonConfig `yaml:"balloon,omitempty"`
+	// RestartPolicy controls restarts after the VM exits or fails its
+	// health check. Unset restarts always with the default backoff.
+	RestartPolicy *RestartPolicySpec `yaml:"restart_policy,omitempty"`
+	// Kind is service (the default) or job. A job runs to completion once
+	// per desired revision and is restarted only when it fails.
+	Kind string `yaml:"kind,omitempty"`
+	// Schedule turns a job into a cron job the control-plane controller
+	// runs at each trigger, e.g. "0 3 * * *" (UTC).
+	Schedule string `yaml:"schedule,omitempty"`
+	// ConcurrencyPolicy is forbid (default), replace or allow.
+	ConcurrencyPolicy string `yaml:"concurrency_policy,omitempty"`
+	// StartingDeadline is a Go duration bounding how late a missed run may
+	// still start. Unset never gives up on the most recent trigger.
+	StartingDeadline string `yaml:"starting_deadline,omitempty"`
+	// Replicas runs the service as that many VMs named <name>-0 to
+	// <name>-(n-1), each on a different node, behind one Traefik route.
+	// Unset runs a single VM under the service's own name.
+	Replicas int `yaml:"replicas,omitempty"`
+	// Autoscaling lets the controller scale the replicas between
+	// min_replicas and max_replicas on their CPU utilisation. replicas,
+	// when set, is the initial count; it defaults to min_replicas.
+	Autoscaling *AutoscalingSpec `yaml:"autoscaling,omitempty"`
+}
+
+// initialReplicas returns how many replicas the enricher emits.
+func (s ServiceSpec) initialReplicas() int {
+	if s.Autoscaling != nil && s.Replicas == 0 {
+		return s.Autoscaling.MinReplicas
+	}
+	return s.Replicas
+}
+
+// attachesDefaultNetwork reports whether the service gets the default guest
+// network, through network: true or by listing it in networks.
+func (s ServiceSpec) attachesDefaultNetwork() bool {
+	return s.Network || slices.Contains(s.Networks, config.DefaultNetwork)
 }
 
 // VolumeSpec is the application-facing persistent-volume declaration. Binding
@@ -48,16 +108,53 @@ type VolumeSpec struct {
 	ResizeGeneration int64  `yaml:"resize_generation,omitempty"`
 }
 
+// RateLimitsSpec is the user-facing I/O limit definition. A device that is
+// set replaces the inherited limit for that device as a whole, so an empty
+// entry (rootfs: {}) lifts a default.
+type RateLimitsSpec struct {
+	Rootfs  *RateLimitSpec `yaml:"rootfs,omitempty"`
+	Volumes *RateLimitSpec `yaml:"volumes,omitempty"`
+	Network *RateLimitSpec `yaml:"network,omitempty"`
+}
+
+// RateLimitSpec limits one device. Bandwidth is per second in the volume size
+// grammar (e.g. 50Mi); Ops counts I/O operations or packets per second.
+type RateLimitSpec struct {
+	Bandwidth string `yaml:"bandwidth,omitempty"`
+	Ops       int64  `yaml:"ops,omitempty"`
+}
+
+// RestartPolicySpec is the user-facing restart policy. Backoff and
+// MaxBackoff are Go durations (e.g. 10s, 5m).
+type RestartPolicySpec struct {
+	Policy      string `yaml:"policy,omitempty"`
+	MaxAttempts int    `yaml:"max_attempts,omitempty"`
+	Backoff     string `yaml:"backoff,omitempty"`
+	MaxBackoff  string `yaml:"max_backoff,omitempty"`
+}
+
+// AutoscalingSpec is the user-facing autoscaling policy.
+// TargetCPUUtilization is in percent of the replicas' vCPUs; Cooldown is a
+// Go duration (e.g. 5m).
+type AutoscalingSpec struct {
+	MinReplicas          int    `yaml:"min_replicas"`
+	MaxReplicas          int    `yaml:"max_replicas"`
+	TargetCPUUtilization int    `yaml:"target_cpu_utilization,omitempty"`
+	Cooldown             string `yaml:"cooldown,omitempty"`
+}
+
 // HealthCheckSpec is the user-facing health check definition.
 // It uses port+path so the agent can compose the full target URL
 // from the guest IP allocated at runtime.
 type HealthCheckSpec struct {
-	Type     string `yaml:"type"`
-	Port     int    `yaml:"port"`
-	Path     string `yaml:"path,omitempty"`
-	Interval string `yaml:"interval,omitempty"`
-	Timeout  string `yaml:"timeout,omitempty"`
-	Retries  int    `yaml:"retries,omitempty"`
+	Type string `yaml:"type"`
+	Port int    `yaml:"port"`
+	Path string `yaml:"path,omitempty"`
+	// Command is the argv run inside the guest for type "exec".
+	Command  []string `yaml:"command,omitempty"`
+	Interval string   `yaml:"interval,omitempty"`
+	Timeout  string   `yaml:"timeout,omitempty"`
+	Retries  int      `yaml:"retries,omitempty"`
 }
 
 // Defaults holds global default values applied to every service.
@@ -68,6 +165,7 @@ type Defaults struct {
 	KernelArgs     string           `yaml:"kernel_args,omitempty"`
 	HealthCheck    *HealthCheckSpec `yaml:"health_check,omitempty"`
 	VolumeDefaults VolumeDefaults   `yaml:"volume_defaults,omitempty"`
+	RateLimits     *RateLimitsSpec  `yaml:"rate_limits,omitempty"`
 }
 
 // VolumeDefaults provides type-specific application quota defaults.
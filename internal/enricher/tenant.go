@@ -32,9 +32,24 @@ type TenantOverride struct {
 	Env               map[string]string      `yaml:"env,omitempty"`
 	Metadata          map[string]string      `yaml:"metadata,omitempty"`
 	AntiAffinityGroup string                 `yaml:"anti_affinity_group,omitempty"`
+	NodeSelector      *config.NodeSelector   `yaml:"node_selector,omitempty"`
 	CrossNodeLinks    []config.CrossNodeLink `yaml:"cross_node_links,omitempty"`
 	NodeHostIPEnv     string                 `yaml:"node_host_ip_env,omitempty"`
 	Volumes           []VolumeSpec           `yaml:"volumes,omitempty"`
+	Snapshot          bool                   `yaml:"snapshot,omitempty"`
+	Networks          []string               `yaml:"networks,omitempty"`
+	RateLimits        *RateLimitsSpec        `yaml:"rate_limits,omitempty"`
+	Balloon           *config.BalloonConfig  `yaml:"balloon,omitempty"`
+	RestartPolicy     *RestartPolicySpec     `yaml:"restart_policy,omitempty"`
+	Kind              string                 `yaml:"kind,omitempty"`
+	Schedule          string                 `yaml:"schedule,omitempty"`
+	ConcurrencyPolicy string                 `yaml:"concurrency_policy,omitempty"`
+	StartingDeadline  string                 `yaml:"starting_deadline,omitempty"`
+	Replicas          int                    `yaml:"replicas,omitempty"`
+	Autoscaling       *AutoscalingSpec       `yaml:"autoscaling,omitempty"`
+	Priority          int                    `yaml:"priority,omitempty"`
+	TopologySpread    string                 `yaml:"topology_spread,omitempty"`
+	MaxSkew           int                    `yaml:"max_skew,omitempty"`
 }
 
 // TenantServiceFile is a parsed override file for one base service.
@@ -133,7 +148,9 @@ func ExpandTenants(base []ServiceSpec, tenants []TenantConfig) []ServiceSpec {
 			if !ok {
 				// Standalone mode: use the tenant file as a full spec if node_type is set.
 				if tsf.Override.NodeType != "" {
-					expanded = append(expanded, standaloneSpec(tenant.ID, tsf))
+					spec := standaloneSpec(tenant.ID, tsf)
+					spec.Metadata = withTenantMetadata(spec.Metadata, tenant.ID)
+					expanded = append(expanded, spec)
 				}
 				continue
 			}
@@ -155,6 +172,12 @@ func ExpandTenants(base []ServiceSpec, tenants []TenantConfig) []ServiceSpec {
 			if ov.HealthCheck != nil {
 				spec.HealthCheck = ov.HealthCheck
 			}
+			if ov.Snapshot {
+				spec.Snapshot = true
+			}
+			if ov.NodeSelector != nil {
+				spec.NodeSelector = ov.NodeSelector
+			}
 
 			// Merge Env: base env + override env (override wins).
 			if len(ov.Env) > 0 {
@@ -179,6 +202,7 @@ func ExpandTenants(base []ServiceSpec, tenants []TenantConfig) []ServiceSpec {
 				}
 				spec.Metadata = merged
 			}
+			spec.Metadata = withTenantMetadata(spec.Metadata, tenant.ID)
 
 			// PortForwards: replace if override set, else inherit base.
 			if len(ov.PortForwards) > 0 {
@@ -188,6 +212,43 @@ func ExpandTenants(base []ServiceSpec, tenants []TenantConfig) []ServiceSpec {
 			if len(ov.Volumes) > 0 {
 				spec.Volumes = append([]VolumeSpec(nil), ov.Volumes...)
 			}
+			if len(ov.Networks) > 0 {
+				spec.Networks = append([]string(nil), ov.Networks...)
+			}
+			spec.RateLimits = mergeRateLimits(ov.RateLimits, spec.RateLimits)
+			if ov.Balloon != nil {
+				spec.Balloon = ov.Balloon
+			}
+			if ov.RestartPolicy != nil {
+				spec.RestartPolicy = ov.RestartPolicy
+			}
+			if ov.Kind != "" {
+				spec.Kind = ov.Kind
+			}
+			if ov.Schedule != "" {
+				spec.Schedule = ov.Schedule
+			}
+			if ov.ConcurrencyPolicy != "" {
+				spec.ConcurrencyPolicy = ov.ConcurrencyPolicy
+			}
+			if ov.StartingDeadline != "" {
+				spec.StartingDeadline = ov.StartingDeadline
+			}
+			if ov.Replicas != 0 {
+				spec.Replicas = ov.Replicas
+			}
+			if ov.Autoscaling != nil {
+				spec.Autoscaling = ov.Autoscaling
+			}
+			if ov.Priority != 0 {
+				spec.Priority = ov.Priority
+			}
+			if ov.TopologySpread != "" {
+				spec.TopologySpread = ov.TopologySpread
+			}
+			if ov.MaxSkew != 0 {
+				spec.MaxSkew = ov.MaxSkew
+			}
 
 			// Rewrite Links so each points to the tenant-namespaced service.
 			for i := range spec.Links {
@@ -200,6 +261,18 @@ func ExpandTenants(base []ServiceSpec, tenants []TenantConfig) []ServiceSpec {
 	return expanded
 }
 
+// withTenantMetadata records the owning tenant in metadata.tenant, which
+// metrics, health-check logs and the scheduler's tenancy policy key on. The
+// tenant directory is authoritative, so an explicit value is overwritten.
+func withTenantMetadata(metadata map[string]string, tenantID string) map[string]string {
+	out := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		out[k] = v
+	}
+	out["tenant"] = tenantID
+	return out
+}
+
 // standaloneSpec builds a ServiceSpec directly from a self-contained tenant file.
 // Links are rewritten to reference tenant-namespaced service names.
 func standaloneSpec(tenantID string, tsf TenantServiceFile) ServiceSpec {
@@ -222,8 +295,23 @@ func standaloneSpec(tenantID string, tsf TenantServiceFile) ServiceSpec {
 		Env:               ov.Env,
 		Metadata:          ov.Metadata,
 		AntiAffinityGroup: ov.AntiAffinityGroup,
+		NodeSelector:      ov.NodeSelector,
 		NodeHostIPEnv:     ov.NodeHostIPEnv,
 		Volumes:           append([]VolumeSpec(nil), ov.Volumes...),
+		Snapshot:          ov.Snapshot,
+		Networks:          append([]string(nil), ov.Networks...),
+		RateLimits:        ov.RateLimits,
+		Balloon:           ov.Balloon,
+		RestartPolicy:     ov.RestartPolicy,
+		Kind:              ov.Kind,
+		Schedule:          ov.Schedule,
+		ConcurrencyPolicy: ov.ConcurrencyPolicy,
+		StartingDeadline:  ov.StartingDeadline,
+		Replicas:          ov.Replicas,
+		Autoscaling:       ov.Autoscaling,
+		Priority:          ov.Priority,
+		TopologySpread:    ov.TopologySpread,
+		MaxSkew:           ov.MaxSkew,
 	}
 
 	for _, link := range ov.Links {
@@ -100,6 +100,9 @@ func TestExpandTenants_NameAndImage(t *testing.T) {
 	if spec.NodeType != "web" {
 		t.Errorf("expected NodeType=web inherited from base, got %s", spec.NodeType)
 	}
+	if spec.Metadata["tenant"] != "tenant-1" {
+		t.Errorf("expected metadata.tenant=tenant-1, got %q", spec.Metadata["tenant"])
+	}
 }
 
 func TestExpandTenants_EnvMerge(t *testing.T) {
@@ -295,6 +298,9 @@ func TestExpandTenants_Standalone(t *testing.T) {
 	if spec.Name != "tenant-1-kibana" {
 		t.Errorf("expected name tenant-1-kibana, got %s", spec.Name)
 	}
+	if spec.Metadata["tenant"] != "tenant-1" {
+		t.Errorf("expected metadata.tenant=tenant-1, got %q", spec.Metadata["tenant"])
+	}
 	// Image derived from convention when not explicitly set.
 	if spec.Image != "/var/lib/images/tenant-1-kibana-rootfs.ext4" {
 		t.Errorf("expected derived image path, got %s", spec.Image)
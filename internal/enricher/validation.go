@@ -5,8 +5,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/cron"
 	"github.com/artemnikitin/firework/internal/ingress"
 )
 
@@ -55,6 +57,7 @@ type Warn struct {
 func ValidateInput(input *InputConfig) error {
 	ve := &ValidationError{}
 	validateVolumeDefaults(ve, input.Defaults.VolumeDefaults)
+	validateRateLimits(ve, "defaults", input.Defaults.RateLimits)
 
 	svcNames := make(map[string]bool)
 	subSeen := make(map[string]string)  // subdomain -> first service using it
@@ -78,13 +81,59 @@ func ValidateInput(input *InputConfig) error {
 		}
 
 		if s.HealthCheck != nil {
-			if s.HealthCheck.Type != "http" && s.HealthCheck.Type != "tcp" {
-				ve.addf("service %s: invalid health check type %q (must be http or tcp)", s.Name, s.HealthCheck.Type)
+			switch s.HealthCheck.Type {
+			case "http", "tcp":
+			case "exec":
+				hc := mergeHealthCheck(s.HealthCheck, input.Defaults.HealthCheck)
+				if len(hc.Command) == 0 || hc.Command[0] == "" {
+					ve.addf("service %s: exec health check requires a command", s.Name)
+				}
+			default:
+				ve.addf("service %s: invalid health check type %q (must be http, tcp, or exec)", s.Name, s.HealthCheck.Type)
+			}
+		}
+
+		if s.NodeSelector != nil {
+			for _, label := range append(append([]string(nil), s.NodeSelector.Required...), s.NodeSelector.Preferred...) {
+				if strings.TrimSpace(label) == "" {
+					ve.addf("service %s: node_selector labels must not be empty", s.Name)
+					break
+				}
 			}
 		}
 
 		validateRouting(ve, s, input.Defaults, subSeen, hostSeen)
+		validateNetworks(ve, s)
+		validateRateLimits(ve, "service "+s.Name, s.RateLimits)
+		if s.Balloon != nil {
+			memoryMB := coalesceInt(s.MemoryMB, input.Defaults.MemoryMB, fallbackMemoryMB)
+			if s.Balloon.MinMemoryMB < 0 || s.Balloon.MinMemoryMB >= memoryMB {
+				ve.addf("service %s: balloon.min_memory_mb must be between 0 and memory_mb (%d)", s.Name, memoryMB)
+			}
+		}
+		validateRestartPolicy(ve, s)
+		validateKind(ve, s)
+		validateReplicas(ve, s)
+		validateAutoscaling(ve, s)
+		if s.Priority < 0 {
+			ve.addf("service %s: priority must not be negative", s.Name)
+		}
+		validateTopologySpread(ve, s)
 		validateVolumes(ve, s)
+		if s.Snapshot && len(s.Volumes) > 0 {
+			ve.addf("service %s: snapshot cannot be combined with volumes", s.Name)
+		}
+	}
+	for _, s := range input.Services {
+		replicas := s.Replicas
+		if s.Autoscaling != nil {
+			replicas = max(replicas, s.Autoscaling.MaxReplicas)
+		}
+		for i := range max(replicas, 0) {
+			if name := ReplicaName(s.Name, i); svcNames[name] {
+				ve.addf("service %s: replica name %s is taken by another service", s.Name, name)
+			}
+		}
 	}
 
 	if ve.hasErrors() {
@@ -109,6 +158,193 @@ func validateVolumeDefaults(ve *ValidationError, defs VolumeDefaults) {
 	}
 }
 
+// validateNetworks checks a service's network names. Whether an agent defines
+// a network is only known on the node.
+func validateNetworks(ve *ValidationError, svc ServiceSpec) {
+	seen := make(map[string]bool, len(svc.Networks))
+	for _, name := range svc.Networks {
+		if !volumeNamePattern.MatchString(name) {
+			ve.addf("service %s: network name %q must be a DNS-label-like value of at most 63 characters", svc.Name, name)
+		}
+		if seen[name] {
+			ve.addf("service %s: duplicate network %q", svc.Name, name)
+		}
+		seen[name] = true
+	}
+	if len(svc.Networks) > 0 && !svc.attachesDefaultNetwork() {
+		ve.addf("service %s: additional networks require the default network", svc.Name)
+	}
+	for _, link := range svc.Links {
+		if link.Network != "" && link.Network != config.DefaultNetwork && !volumeNamePattern.MatchString(link.Network) {
+			ve.addf("service %s: link to %s names invalid network %q", svc.Name, link.Service, link.Network)
+		}
+	}
+}
+
+func validateRateLimits(ve *ValidationError, owner string, limits *RateLimitsSpec) {
+	if limits == nil {
+		return
+	}
+	for _, device := range []struct {
+		name  string
+		limit *RateLimitSpec
+	}{{"rootfs", limits.Rootfs}, {"volumes", limits.Volumes}, {"network", limits.Network}} {
+		if device.limit == nil {
+			continue
+		}
+		if device.limit.Bandwidth != "" {
+			if _, err := config.ParseBandwidth(device.limit.Bandwidth); err != nil {
+				ve.addf("%s: rate_limits.%s.bandwidth %v", owner, device.name, err)
+			}
+		}
+		if device.limit.Ops < 0 {
+			ve.addf("%s: rate_limits.%s.ops must not be negative", owner, device.name)
+		}
+	}
+}
+
+func validateRestartPolicy(ve *ValidationError, svc ServiceSpec) {
+	rp := svc.RestartPolicy
+	if rp == nil {
+		return
+	}
+	switch rp.Policy {
+	case "", config.RestartAlways, config.RestartOnFailure, config.RestartNever:
+	default:
+		ve.addf("service %s: invalid restart_policy.policy %q (must be always, on-failure, or never)", svc.Name, rp.Policy)
+	}
+	if rp.MaxAttempts < 0 {
+		ve.addf("service %s: restart_policy.max_attempts must not be negative", svc.Name)
+	}
+	for field, value := range map[string]string{"backoff": rp.Backoff, "max_backoff": rp.MaxBackoff} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			ve.addf("service %s: restart_policy.%s must be a positive duration, got %q", svc.Name, field, value)
+		}
+	}
+}
+
+// validateKind checks the workload kind and rejects the settings that only
+// make sense for a long-running service.
+func validateKind(ve *ValidationError, svc ServiceSpec) {
+	switch svc.Kind {
+	case "", config.KindService:
+		if svc.Schedule != "" {
+			ve.addf("service %s: schedule requires kind job", svc.Name)
+		}
+		return
+	case config.KindJob:
+	default:
+		ve.addf("service %s: invalid kind %q (must be service or job)", svc.Name, svc.Kind)
+		return
+	}
+	if svc.Schedule != "" {
+		validateSchedule(ve, svc)
+	}
+	if svc.HealthCheck != nil {
+		ve.addf("service %s: a job cannot have a health check", svc.Name)
+	}
+	if svc.Snapshot {
+		ve.addf("service %s: a job cannot be snapshotted", svc.Name)
+	}
+	if svc.RestartPolicy != nil && svc.RestartPolicy.Policy == config.RestartAlways {
+		ve.addf("service %s: a job cannot use restart_policy.policy always", svc.Name)
+	}
+}
+
+// validateReplicas checks the replica count. Replicas of a job would each run
+// it once, which is not what replicas are for.
+func validateReplicas(ve *ValidationError, svc ServiceSpec) {
+	if svc.Replicas < 0 {
+		ve.addf("service %s: replicas must not be negative", svc.Name)
+	}
+	if svc.Replicas > 0 && svc.Kind == config.KindJob {
+		ve.addf("service %s: a job cannot have replicas", svc.Name)
+	}
+}
+
+// validateTopologySpread checks the spread key and skew. Only replicas and
+// anti-affinity groups have members to spread.
+func validateTopologySpread(ve *ValidationError, svc ServiceSpec) {
+	if svc.TopologySpread == "" {
+		if svc.MaxSkew != 0 {
+			ve.addf("service %s: max_skew requires topology_spread", svc.Name)
+		}
+		return
+	}
+	if strings.TrimSpace(svc.TopologySpread) != svc.TopologySpread || strings.ContainsAny(svc.TopologySpread, " \t=") {
+		ve.addf("service %s: topology_spread %q is not a valid topology key", svc.Name, svc.TopologySpread)
+	}
+	if svc.MaxSkew < 0 {
+		ve.addf("service %s: max_skew must be at least 1", svc.Name)
+	}
+	if svc.Replicas == 0 && svc.Autoscaling == nil && svc.AntiAffinityGroup == "" {
+		ve.addf("service %s: topology_spread requires replicas, autoscaling or an anti_affinity_group", svc.Name)
+	}
+}
+
+// validateAutoscaling checks the autoscaling bounds. The controller adds and
+// removes replicas as it scales, so they cannot own persistent volumes.
+func validateAutoscaling(ve *ValidationError, svc ServiceSpec) {
+	as := svc.Autoscaling
+	if as == nil {
+		return
+	}
+	if svc.Kind == config.KindJob {
+		ve.addf("service %s: a job cannot be autoscaled", svc.Name)
+	}
+	if as.MinReplicas < 1 {
+		ve.addf("service %s: autoscaling.min_replicas must be at least 1", svc.Name)
+	}
+	if as.MaxReplicas < as.MinReplicas {
+		ve.addf("service %s: autoscaling.max_replicas must not be less than min_replicas", svc.Name)
+	}
+	if svc.Replicas != 0 && (svc.Replicas < as.MinReplicas || svc.Replicas > as.MaxReplicas) {
+		ve.addf("service %s: replicas must be between autoscaling.min_replicas and max_replicas", svc.Name)
+	}
+	if as.TargetCPUUtilization < 0 || as.TargetCPUUtilization > 100 {
+		ve.addf("service %s: autoscaling.target_cpu_utilization must be between 1 and 100", svc.Name)
+	}
+	if as.Cooldown != "" {
+		if d, err := time.ParseDuration(as.Cooldown); err != nil || d <= 0 {
+			ve.addf("service %s: autoscaling.cooldown must be a positive duration, got %q", svc.Name, as.Cooldown)
+		}
+	}
+	if len(svc.Volumes) > 0 {
+		ve.addf("service %s: an autoscaled service cannot have volumes", svc.Name)
+	}
+}
+
+// validateSchedule checks a scheduled job. Its runs are copies of the job
+// under their own names, so it cannot own persistent volumes or host ports,
+// and the next trigger takes the place of restarts.
+func validateSchedule(ve *ValidationError, svc ServiceSpec) {
+	if _, err := cron.Parse(svc.Schedule); err != nil {
+		ve.addf("service %s: invalid schedule %q: %v", svc.Name, svc.Schedule, err)
+	}
+	switch svc.ConcurrencyPolicy {
+	case "", config.ConcurrencyForbid, config.ConcurrencyReplace, config.ConcurrencyAllow:
+	default:
+		ve.addf("service %s: invalid concurrency_policy %q (must be forbid, replace, or allow)", svc.Name, svc.ConcurrencyPolicy)
+	}
+	if svc.StartingDeadline != "" {
+		if d, err := time.ParseDuration(svc.StartingDeadline); err != nil || d <= 0 {
+			ve.addf("service %s: starting_deadline must be a positive duration, got %q", svc.Name, svc.StartingDeadline)
+		}
+	}
+	if len(svc.Volumes) > 0 {
+		ve.addf("service %s: a scheduled job cannot have volumes", svc.Name)
+	}
+	if len(svc.PortForwards) > 0 {
+		ve.addf("service %s: a scheduled job cannot have port_forwards", svc.Name)
+	}
+	if svc.RestartPolicy != nil {
+		ve.addf("service %s: a scheduled job cannot have a restart_policy; a failed run is retried at the next trigger", svc.Name)
+	}
+}
+
 func validateVolumes(ve *ValidationError, svc ServiceSpec) {
 	if len(svc.Volumes) > config.MaxServiceVolumes {
 		ve.addf("service %s: at most %d volumes are supported", svc.Name, config.MaxServiceVolumes)
@@ -201,7 +437,7 @@ func validateRouting(ve *ValidationError, s ServiceSpec, defs Defaults, subSeen,
 	}
 
 	if hasHost || hasSub {
-		if !s.Network {
+		if !s.attachesDefaultNetwork() {
 			ve.addf("service %s: public routing requires network: true", s.Name)
 		}
 		if effectiveBackendPort(s, defs) == 0 {
@@ -260,6 +496,9 @@ func ValidateOutput(nc config.NodeConfig) error {
 				ve.addf("service %s volume %s: size_bytes must be positive", svc.Name, volume.Name)
 			}
 		}
+		if svc.TopologySpread != nil && svc.TopologySpread.MaxSkew < 1 {
+			ve.addf("service %s: topology_spread max_skew must be at least 1", svc.Name)
+		}
 	}
 
 	if ve.hasErrors() {
@@ -273,7 +512,8 @@ func CheckWarnings(input *InputConfig) []Warn {
 	var warns []Warn
 
 	for _, svc := range input.Services {
-		if svc.HealthCheck != nil && !svc.Network {
+		// Exec checks run over vsock and do not need a guest network.
+		if svc.HealthCheck != nil && svc.HealthCheck.Type != "exec" && !svc.attachesDefaultNetwork() {
 			warns = append(warns, Warn{
 				Code:    WarnHealthCheckWithoutNetwork,
 				Message: fmt.Sprintf("service %s has health check but network is disabled", svc.Name),
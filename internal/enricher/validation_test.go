@@ -100,6 +100,27 @@ func TestValidateInput_InvalidHealthCheckType(t *testing.T) {
 	}
 }
 
+func TestValidateInput_ExecHealthCheckRequiresCommand(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{
+			{
+				Name:        "worker",
+				Image:       "/img/worker.ext4",
+				NodeType:    "compute",
+				HealthCheck: &HealthCheckSpec{Type: "exec"},
+			},
+		},
+	}
+	if err := ValidateInput(input); err == nil || !strings.Contains(err.Error(), "requires a command") {
+		t.Fatalf("expected missing command error, got %v", err)
+	}
+
+	input.Defaults.HealthCheck = &HealthCheckSpec{Command: []string{"/bin/check"}}
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("command from defaults should satisfy exec check: %v", err)
+	}
+}
+
 func routedSpec(name string, meta map[string]string) ServiceSpec {
 	return ServiceSpec{
 		Name:         name,
@@ -223,6 +244,22 @@ func TestValidateOutput_MissingKernel(t *testing.T) {
 	}
 }
 
+func TestValidateOutput_TopologySpreadMaxSkew(t *testing.T) {
+	for _, maxSkew := range []int{0, -1} {
+		nc := config.NodeConfig{
+			Node: "compute",
+			Services: []config.ServiceConfig{{
+				Name: "web", Image: "/img/a", Kernel: "/k", VCPUs: 1, MemoryMB: 256,
+				TopologySpread: &config.TopologySpread{Key: "zone", MaxSkew: maxSkew},
+			}},
+		}
+		err := ValidateOutput(nc)
+		if err == nil || !strings.Contains(err.Error(), "service web: topology_spread max_skew must be at least 1") {
+			t.Fatalf("max_skew %d: expected error, got %v", maxSkew, err)
+		}
+	}
+}
+
 func TestValidateOutput_DuplicateService(t *testing.T) {
 	nc := config.NodeConfig{
 		Node: "compute",
@@ -281,3 +318,296 @@ func TestCheckWarnings_NoWarnings(t *testing.T) {
 		t.Errorf("expected no warnings, got: %v", warns)
 	}
 }
+
+func TestValidateInput_SnapshotRejectsVolumes(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{{
+			Name:     "jvm",
+			Image:    "/img/jvm.ext4",
+			NodeType: "compute",
+			Snapshot: true,
+			Volumes:  []VolumeSpec{{Name: "data", Type: config.VolumeTypeLocal, MountPath: "/data"}},
+		}},
+	}
+	if err := ValidateInput(input); err == nil || !strings.Contains(err.Error(), "snapshot cannot be combined with volumes") {
+		t.Fatalf("expected snapshot/volumes error, got %v", err)
+	}
+
+	input.Services[0].Volumes = nil
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("snapshot without volumes should be valid: %v", err)
+	}
+}
+
+func TestValidateInput_NodeSelectorRejectsEmptyLabels(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{{
+			Name:         "trainer",
+			Image:        "/img/trainer.ext4",
+			NodeType:     "compute",
+			NodeSelector: &config.NodeSelector{Required: []string{"gpu", " "}},
+		}},
+	}
+	if err := ValidateInput(input); err == nil || !strings.Contains(err.Error(), "node_selector labels must not be empty") {
+		t.Fatalf("expected node_selector error, got %v", err)
+	}
+}
+
+func TestValidateInput_Networks(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{{
+			Name:     "db",
+			Image:    "/img/db.ext4",
+			NodeType: "storage",
+			Networks: []string{"replication"},
+		}},
+	}
+	if err := ValidateInput(input); err == nil || !strings.Contains(err.Error(), "require the default network") {
+		t.Fatalf("expected default network error, got %v", err)
+	}
+
+	input.Services[0].Networks = []string{"default", "replication", "replication"}
+	if err := ValidateInput(input); err == nil || !strings.Contains(err.Error(), "duplicate network") {
+		t.Fatalf("expected duplicate network error, got %v", err)
+	}
+
+	input.Services[0].Networks = []string{"replication"}
+	input.Services[0].Network = true
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("network: true with an additional network should be valid: %v", err)
+	}
+}
+
+func TestValidateInput_BalloonFloorBelowMemory(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{{
+			Name:     "cache",
+			Image:    "/img/cache.ext4",
+			NodeType: "general",
+			Balloon:  &config.BalloonConfig{MinMemoryMB: 512},
+		}},
+		Defaults: Defaults{MemoryMB: 512},
+	}
+	err := ValidateInput(input)
+	if err == nil || !strings.Contains(err.Error(), "service cache: balloon.min_memory_mb") {
+		t.Fatalf("expected balloon floor error, got %v", err)
+	}
+
+	input.Services[0].Balloon.MinMemoryMB = 256
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid balloon, got %v", err)
+	}
+}
+
+func TestValidateInput_RestartPolicy(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{{
+			Name:          "worker",
+			Image:         "/img/worker.ext4",
+			NodeType:      "general",
+			RestartPolicy: &RestartPolicySpec{Policy: "sometimes", MaxAttempts: -1, Backoff: "soon"},
+		}},
+	}
+	err := ValidateInput(input)
+	if err == nil || !strings.Contains(err.Error(), `invalid restart_policy.policy "sometimes"`) ||
+		!strings.Contains(err.Error(), "restart_policy.max_attempts must not be negative") ||
+		!strings.Contains(err.Error(), "restart_policy.backoff must be a positive duration") {
+		t.Fatalf("expected restart policy errors, got %v", err)
+	}
+
+	input.Services[0].RestartPolicy = &RestartPolicySpec{Policy: config.RestartOnFailure, MaxAttempts: 3, Backoff: "5s", MaxBackoff: "1m"}
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid restart policy, got %v", err)
+	}
+}
+
+func TestValidateInput_Kind(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{
+			{Name: "cron", Image: "/img/cron.ext4", NodeType: "general", Kind: "cronjob"},
+			{
+				Name: "migrate", Image: "/img/migrate.ext4", NodeType: "general", Kind: config.KindJob,
+				HealthCheck:   &HealthCheckSpec{Type: "tcp", Port: 5432},
+				Snapshot:      true,
+				RestartPolicy: &RestartPolicySpec{Policy: config.RestartAlways},
+			},
+		},
+	}
+	err := ValidateInput(input)
+	if err == nil || !strings.Contains(err.Error(), `service cron: invalid kind "cronjob"`) ||
+		!strings.Contains(err.Error(), "service migrate: a job cannot have a health check") ||
+		!strings.Contains(err.Error(), "service migrate: a job cannot be snapshotted") ||
+		!strings.Contains(err.Error(), "service migrate: a job cannot use restart_policy.policy always") {
+		t.Fatalf("expected kind errors, got %v", err)
+	}
+
+	input.Services = []ServiceSpec{{
+		Name: "migrate", Image: "/img/migrate.ext4", NodeType: "general", Kind: config.KindJob,
+		RestartPolicy: &RestartPolicySpec{Policy: config.RestartNever},
+	}}
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid job, got %v", err)
+	}
+}
+
+func TestValidateInput_Schedule(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{
+			{Name: "api", Image: "/img/api.ext4", NodeType: "general", Schedule: "0 3 * * *"},
+			{
+				Name: "report", Image: "/img/report.ext4", NodeType: "general", Kind: config.KindJob,
+				Schedule: "0 25 * * *", ConcurrencyPolicy: "queue", StartingDeadline: "-5m",
+				RestartPolicy: &RestartPolicySpec{Policy: config.RestartNever},
+			},
+		},
+	}
+	err := ValidateInput(input)
+	if err == nil || !strings.Contains(err.Error(), "service api: schedule requires kind job") ||
+		!strings.Contains(err.Error(), `service report: invalid schedule "0 25 * * *"`) ||
+		!strings.Contains(err.Error(), `service report: invalid concurrency_policy "queue"`) ||
+		!strings.Contains(err.Error(), `service report: starting_deadline must be a positive duration, got "-5m"`) ||
+		!strings.Contains(err.Error(), "service report: a scheduled job cannot have a restart_policy") {
+		t.Fatalf("expected schedule errors, got %v", err)
+	}
+
+	input.Services = []ServiceSpec{{
+		Name: "report", Image: "/img/report.ext4", NodeType: "general", Kind: config.KindJob,
+		Schedule: "*/15 * * * 1-5", ConcurrencyPolicy: config.ConcurrencyReplace, StartingDeadline: "5m",
+	}}
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid scheduled job, got %v", err)
+	}
+}
+
+func TestValidateInput_Replicas(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{
+			{Name: "web", Image: "/img/web.ext4", NodeType: "general", Replicas: 2},
+			{Name: "web-1", Image: "/img/web.ext4", NodeType: "general"},
+			{Name: "api", Image: "/img/api.ext4", NodeType: "general", Replicas: -1},
+			{Name: "migrate", Image: "/img/migrate.ext4", NodeType: "general", Kind: config.KindJob, Replicas: 2},
+		},
+	}
+	err := ValidateInput(input)
+	if err == nil || !strings.Contains(err.Error(), "service web: replica name web-1 is taken by another service") ||
+		!strings.Contains(err.Error(), "service api: replicas must not be negative") ||
+		!strings.Contains(err.Error(), "service migrate: a job cannot have replicas") {
+		t.Fatalf("expected replica errors, got %v", err)
+	}
+
+	input.Services = input.Services[:1]
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid replicated service, got %v", err)
+	}
+}
+
+func TestValidateInput_Autoscaling(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{
+			{Name: "web", Image: "/img/web.ext4", NodeType: "general", Autoscaling: &AutoscalingSpec{MinReplicas: 1, MaxReplicas: 4}},
+			{Name: "web-3", Image: "/img/web.ext4", NodeType: "general"},
+			{Name: "api", Image: "/img/api.ext4", NodeType: "general", Replicas: 5,
+				Autoscaling: &AutoscalingSpec{MinReplicas: 0, MaxReplicas: 3, TargetCPUUtilization: 150, Cooldown: "soon"}},
+			{Name: "db", Image: "/img/db.ext4", NodeType: "general", Volumes: []VolumeSpec{{Name: "data", Type: config.VolumeTypeLocal, MountPath: "/data"}},
+				Autoscaling: &AutoscalingSpec{MinReplicas: 3, MaxReplicas: 2}},
+		},
+	}
+	err := ValidateInput(input)
+	for _, want := range []string{
+		"service web: replica name web-3 is taken by another service",
+		"service api: autoscaling.min_replicas must be at least 1",
+		"service api: replicas must be between autoscaling.min_replicas and max_replicas",
+		"service api: autoscaling.target_cpu_utilization must be between 1 and 100",
+		`service api: autoscaling.cooldown must be a positive duration, got "soon"`,
+		"service db: autoscaling.max_replicas must not be less than min_replicas",
+		"service db: an autoscaled service cannot have volumes",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+	}
+
+	input.Services = input.Services[:1]
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid autoscaled service, got %v", err)
+	}
+}
+
+func TestValidateInput_Priority(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{
+			{Name: "web", Image: "/img/web.ext4", NodeType: "general", Priority: 100},
+			{Name: "batch", Image: "/img/batch.ext4", NodeType: "general", Priority: -1},
+		},
+	}
+	err := ValidateInput(input)
+	if err == nil || !strings.Contains(err.Error(), "service batch: priority must not be negative") {
+		t.Fatalf("expected priority error, got %v", err)
+	}
+
+	input.Services = input.Services[:1]
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid prioritized service, got %v", err)
+	}
+	if svc := EnrichService(input.Services[0], Defaults{}); svc.Priority != 100 {
+		t.Fatalf("priority = %d, want 100", svc.Priority)
+	}
+}
+
+func TestValidateInput_TopologySpread(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{
+			{Name: "web", Image: "/img/web.ext4", NodeType: "general", Replicas: 3, TopologySpread: "zone", MaxSkew: 2},
+			{Name: "api", Image: "/img/api.ext4", NodeType: "general", TopologySpread: "zone"},
+			{Name: "db", Image: "/img/db.ext4", NodeType: "general", AntiAffinityGroup: "db", TopologySpread: "zone=a", MaxSkew: -1},
+			{Name: "cache", Image: "/img/cache.ext4", NodeType: "general", MaxSkew: 1},
+		},
+	}
+	err := ValidateInput(input)
+	for _, want := range []string{
+		"service api: topology_spread requires replicas, autoscaling or an anti_affinity_group",
+		`service db: topology_spread "zone=a" is not a valid topology key`,
+		"service db: max_skew must be at least 1",
+		"service cache: max_skew requires topology_spread",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+	}
+
+	input.Services = input.Services[:1]
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid spread service, got %v", err)
+	}
+	want := config.TopologySpread{Key: "zone", MaxSkew: 2}
+	if svc := EnrichService(input.Services[0], Defaults{}); svc.TopologySpread == nil || *svc.TopologySpread != want {
+		t.Fatalf("topology spread = %+v, want %+v", svc.TopologySpread, want)
+	}
+	input.Services[0].MaxSkew = 0
+	if svc := EnrichService(input.Services[0], Defaults{}); svc.TopologySpread.MaxSkew != 1 {
+		t.Fatalf("max skew = %d, want the default of 1", svc.TopologySpread.MaxSkew)
+	}
+}
+
+func TestValidateInput_RateLimits(t *testing.T) {
+	input := &InputConfig{
+		Services: []ServiceSpec{{
+			Name:       "db",
+			Image:      "/img/db.ext4",
+			NodeType:   "storage",
+			RateLimits: &RateLimitsSpec{Volumes: &RateLimitSpec{Bandwidth: "50MB"}},
+		}},
+		Defaults: Defaults{RateLimits: &RateLimitsSpec{Network: &RateLimitSpec{Ops: -1}}},
+	}
+	err := ValidateInput(input)
+	if err == nil || !strings.Contains(err.Error(), "service db: rate_limits.volumes.bandwidth") ||
+		!strings.Contains(err.Error(), "defaults: rate_limits.network.ops must not be negative") {
+		t.Fatalf("expected bandwidth and ops errors, got %v", err)
+	}
+
+	input.Services[0].RateLimits.Volumes.Bandwidth = "50Mi"
+	input.Defaults.RateLimits.Network.Ops = 1000
+	if err := ValidateInput(input); err != nil {
+		t.Fatalf("expected valid rate limits, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package guestexec runs commands inside a microVM over Firecracker vsock.
+//
+// fc-init listens on a vsock port in the guest and the agent reaches it
+// through the host-side Unix socket Firecracker exposes for the vsock device
+// (uds_path). Firecracker's hybrid vsock requires the host to send
+// "CONNECT <port>\n" and read back "OK <host-port>\n" before the stream is
+// forwarded to the guest listener.
+//
+// Each connection carries exactly one exchange: a JSON Request line from the
+// host, followed by a JSON Response line from the guest.
+package guestexec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Port is the guest vsock port fc-init serves commands on.
+const Port = 52
+
+// GuestCID is the context ID the agent assigns to every guest's vsock device.
+// CIDs only need to be unique per VM, and each VM has its own device.
+const GuestCID = 3
+
+// KernelArg enables the command server in fc-init. Its value is the vsock
+// port to listen on.
+const KernelArg = "firework.exec_port"
+
+// MaxOutputBytes bounds the combined stdout/stderr returned for a command.
+const MaxOutputBytes = 4096
+
+// maxLineBytes bounds a single protocol line in either direction.
+const maxLineBytes = 64 << 10
+
+// Request asks the guest to run Argv. Timeout bounds the command's runtime
+// inside the guest; zero means the guest default.
+type Request struct {
+	Argv    []string      `json:"argv"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// Response reports how a command ended. Error is set when the command could
+// not be run or did not finish in time; ExitCode is then meaningless.
+type Response struct {
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RunFunc runs argv inside the guest and returns its exit code together with
+// its combined output, truncated to MaxOutputBytes.
+type RunFunc func(ctx context.Context, argv []string) (exitCode int, output []byte, err error)
+
+// Exec connects to the vsock device behind udsPath, runs req in the guest,
+// and returns its response. ctx bounds the whole exchange.
+func Exec(ctx context.Context, udsPath string, port uint32, req Request) (Response, error) {
+	var resp Response
+	if len(req.Argv) == 0 {
+		return resp, errors.New("empty command")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", udsPath)
+	if err != nil {
+		return resp, fmt.Errorf("connecting to vsock %s: %w", udsPath, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	r := bufio.NewReaderSize(conn, 4096)
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		return resp, fmt.Errorf("vsock handshake: %w", err)
+	}
+	ack, err := readLine(r)
+	if err != nil {
+		return resp, fmt.Errorf("vsock handshake: %w", err)
+	}
+	if !strings.HasPrefix(ack, "OK ") {
+		return resp, fmt.Errorf("vsock handshake rejected: %q", ack)
+	}
+
+	if err := writeJSONLine(conn, req); err != nil {
+		return resp, fmt.Errorf("sending command: %w", err)
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return resp, fmt.Errorf("reading command result: %w", err)
+	}
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		return resp, fmt.Errorf("decoding command result: %w", err)
+	}
+	return resp, nil
+}
+
+// ServeConn handles a single guest-side connection: it reads one Request,
+// runs it with run, writes the Response, and closes conn. defaultTimeout
+// applies when the request carries none.
+func ServeConn(conn io.ReadWriteCloser, run RunFunc, defaultTimeout time.Duration) {
+	defer conn.Close()
+
+	line, err := readLine(bufio.NewReaderSize(conn, 4096))
+	if err != nil {
+		return
+	}
+	var req Request
+	var resp Response
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		resp.Error = fmt.Sprintf("decoding request: %v", err)
+		_ = writeJSONLine(conn, resp)
+		return
+	}
+	if len(req.Argv) == 0 {
+		resp.Error = "empty command"
+		_ = writeJSONLine(conn, resp)
+		return
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	code, output, err := run(ctx, req.Argv)
+	if len(output) > MaxOutputBytes {
+		output = output[:MaxOutputBytes]
+	}
+	resp.ExitCode = code
+	resp.Output = string(output)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	_ = writeJSONLine(conn, resp)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		b.Write(chunk)
+		if b.Len() > maxLineBytes {
+			return "", fmt.Errorf("line exceeds %d bytes", maxLineBytes)
+		}
+		if !isPrefix {
+			return b.String(), nil
+		}
+	}
+}
+
+func writeJSONLine(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
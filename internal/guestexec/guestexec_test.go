@@ -0,0 +1,110 @@
+package guestexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVsock emulates Firecracker's host-side hybrid vsock socket: it answers
+// the CONNECT handshake for port and hands the stream to ServeConn.
+func fakeVsock(t *testing.T, port uint32, run RunFunc) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vsock.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// Read the handshake a byte at a time so nothing past it is consumed.
+				var line []byte
+				buf := make([]byte, 1)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						conn.Close()
+						return
+					}
+					if buf[0] == '\n' {
+						break
+					}
+					line = append(line, buf[0])
+				}
+				if string(line) != fmt.Sprintf("CONNECT %d", port) {
+					fmt.Fprintf(conn, "FAILURE\n")
+					conn.Close()
+					return
+				}
+				fmt.Fprintf(conn, "OK 1073741824\n")
+				ServeConn(conn, run, time.Second)
+			}()
+		}
+	}()
+	return path
+}
+
+func TestExecReturnsExitCodeAndOutput(t *testing.T) {
+	var gotArgv []string
+	path := fakeVsock(t, Port, func(ctx context.Context, argv []string) (int, []byte, error) {
+		gotArgv = argv
+		return 3, []byte("not ready\n"), nil
+	})
+
+	resp, err := Exec(context.Background(), path, Port, Request{Argv: []string{"pg_isready", "-q"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ExitCode != 3 || resp.Output != "not ready\n" || resp.Error != "" {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if strings.Join(gotArgv, " ") != "pg_isready -q" {
+		t.Fatalf("guest ran %q", gotArgv)
+	}
+}
+
+func TestExecReportsGuestRunError(t *testing.T) {
+	path := fakeVsock(t, Port, func(ctx context.Context, argv []string) (int, []byte, error) {
+		<-ctx.Done()
+		return -1, nil, errors.New("command timed out")
+	})
+
+	resp, err := Exec(context.Background(), path, Port, Request{Argv: []string{"sleep", "10"}, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "command timed out" {
+		t.Fatalf("error = %q, want command timed out", resp.Error)
+	}
+}
+
+func TestExecRejectedHandshake(t *testing.T) {
+	path := fakeVsock(t, Port, nil)
+	if _, err := Exec(context.Background(), path, Port+1, Request{Argv: []string{"true"}}); err == nil {
+		t.Fatal("expected handshake error for unserved port")
+	}
+}
+
+func TestServeConnTruncatesOutput(t *testing.T) {
+	path := fakeVsock(t, Port, func(ctx context.Context, argv []string) (int, []byte, error) {
+		return 0, []byte(strings.Repeat("x", MaxOutputBytes+100)), nil
+	})
+	resp, err := Exec(context.Background(), path, Port, Request{Argv: []string{"yes"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Output) != MaxOutputBytes {
+		t.Fatalf("output length = %d, want %d", len(resp.Output), MaxOutputBytes)
+	}
+}
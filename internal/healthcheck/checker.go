@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/guestexec"
 )
 
 // Status represents the health status of a service.
@@ -31,6 +32,10 @@ type Result struct {
 	LastError   string
 }
 
+// ExecSocketFunc returns the host-side vsock socket of a service's VM, used
+// to run "exec" health checks inside the guest.
+type ExecSocketFunc func(name string) string
+
 // RestartFunc is called when a service needs to be restarted due to health
 // check failures. It receives the service name.
 type RestartFunc func(ctx context.Context, name string) error
@@ -38,8 +43,9 @@ type RestartFunc func(ctx context.Context, name string) error
 // Monitor runs periodic health checks for all configured services and
 // triggers restarts when a service becomes unhealthy.
 type Monitor struct {
-	logger    *slog.Logger
-	restartFn RestartFunc
+	logger     *slog.Logger
+	restartFn  RestartFunc
+	execSocket ExecSocketFunc
 
 	mu      sync.Mutex
 	checks  map[string]*serviceCheck
@@ -62,6 +68,13 @@ func NewMonitor(logger *slog.Logger, restartFn RestartFunc) *Monitor {
 	}
 }
 
+// SetExecSocket enables "exec" health checks. Without it they always fail.
+func (m *Monitor) SetExecSocket(fn ExecSocketFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.execSocket = fn
+}
+
 // Register starts health checking for a service. If the service is already
 // registered, it is replaced.
 func (m *Monitor) Register(ctx context.Context, svc config.ServiceConfig) {
@@ -167,19 +180,29 @@ func (m *Monitor) run(ctx context.Context, svc config.ServiceConfig) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			err := m.check(ctx, hc, guestIP)
+			err := m.check(ctx, svc.Name, hc, guestIP)
 			m.recordResult(ctx, svc.Name, hc, err)
 		}
 	}
 }
 
 // check performs a single health check based on the config type.
-func (m *Monitor) check(ctx context.Context, hc *config.HealthCheckConfig, guestIP string) error {
+func (m *Monitor) check(ctx context.Context, name string, hc *config.HealthCheckConfig, guestIP string) error {
 	timeout := hc.Timeout
 	if timeout == 0 {
 		timeout = 5 * time.Second
 	}
 
+	if hc.Type == "exec" {
+		m.mu.Lock()
+		execSocket := m.execSocket
+		m.mu.Unlock()
+		if execSocket == nil {
+			return fmt.Errorf("exec health checks are not available on this agent")
+		}
+		return checkExec(ctx, execSocket(name), hc.Command, timeout)
+	}
+
 	target := resolveTarget(hc, guestIP)
 	if target == "" {
 		return fmt.Errorf("health check has no target (no Target, Port, or guest IP)")
@@ -317,3 +340,30 @@ func checkTCP(ctx context.Context, target string, timeout time.Duration) error {
 	conn.Close()
 	return nil
 }
+
+// checkExec runs command inside the guest over vsock and expects exit code 0.
+// The guest enforces timeout on the command itself; the host allows a little
+// longer so the guest's timeout report arrives instead of a bare deadline.
+func checkExec(ctx context.Context, socketPath string, command []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout+time.Second)
+	defer cancel()
+
+	resp, err := guestexec.Exec(ctx, socketPath, guestexec.Port, guestexec.Request{Argv: command, Timeout: timeout})
+	if err != nil {
+		return fmt.Errorf("exec check failed: %w", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("exec check failed: %s", resp.Error)
+	}
+	if resp.ExitCode != 0 {
+		if out := strings.TrimSpace(resp.Output); out != "" {
+			// Keep LastError short; status pages show it verbatim.
+			if len(out) > 256 {
+				out = out[:256] + "..."
+			}
+			return fmt.Errorf("exec check exited with code %d: %s", resp.ExitCode, out)
+		}
+		return fmt.Errorf("exec check exited with code %d", resp.ExitCode)
+	}
+	return nil
+}
@@ -6,11 +6,14 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/guestexec"
 )
 
 func TestCheckHTTP_Healthy(t *testing.T) {
@@ -271,3 +274,67 @@ func TestMonitor_RestartOnFailure(t *testing.T) {
 
 	mon.Stop()
 }
+
+// fakeGuestExec serves the Firecracker hybrid vsock handshake on a Unix
+// socket and answers every command with exitCode.
+func fakeGuestExec(t *testing.T, exitCode int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vsock.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, len("CONNECT 52\n"))
+			if _, err := conn.Read(buf); err != nil {
+				conn.Close()
+				continue
+			}
+			fmt.Fprint(conn, "OK 1073741824\n")
+			go guestexec.ServeConn(conn, func(ctx context.Context, argv []string) (int, []byte, error) {
+				return exitCode, []byte("exit " + strings.Join(argv, " ")), nil
+			}, time.Second)
+		}
+	}()
+	return path
+}
+
+func TestCheckExec_ExitCodeDecidesHealth(t *testing.T) {
+	if err := checkExec(context.Background(), fakeGuestExec(t, 0), []string{"true"}, time.Second); err != nil {
+		t.Errorf("expected healthy, got error: %v", err)
+	}
+	err := checkExec(context.Background(), fakeGuestExec(t, 1), []string{"false"}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "code 1") {
+		t.Errorf("expected exit code error, got %v", err)
+	}
+}
+
+func TestMonitor_ExecCheckUsesServiceSocket(t *testing.T) {
+	socket := fakeGuestExec(t, 0)
+	mon := NewMonitor(noopLogger(), func(ctx context.Context, name string) error { return nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hc := &config.HealthCheckConfig{Type: "exec", Command: []string{"pg_isready"}}
+	if err := mon.check(ctx, "db", hc, ""); err == nil {
+		t.Fatal("expected exec check to fail without a socket resolver")
+	}
+
+	var asked string
+	mon.SetExecSocket(func(name string) string {
+		asked = name
+		return socket
+	})
+	if err := mon.check(ctx, "db", hc, ""); err != nil {
+		t.Fatalf("expected healthy exec check, got %v", err)
+	}
+	if asked != "db" {
+		t.Errorf("socket resolved for %q, want db", asked)
+	}
+}
@@ -0,0 +1,129 @@
+package network
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Firewall backends.
+const (
+	// FirewallIPTables programs rules through the iptables commands.
+	FirewallIPTables = "iptables"
+	// FirewallNFTables programs rules over netlink into firework-owned
+	// nftables tables.
+	FirewallNFTables = "nftables"
+)
+
+// Firewall programs the packet filtering and NAT rules firework owns.
+type Firewall interface {
+	// Apply makes the installed rules match rs. Rules firework installed
+	// earlier that rs no longer contains are removed.
+	Apply(rs Ruleset) error
+}
+
+// portForwardRemover is implemented by firewalls that cannot tell which
+// rules an earlier agent process installed. The manager asks them to remove
+// a torn down port forward explicitly.
+type portForwardRemover interface {
+	RemovePortForward(pf PortForward) error
+}
+
+// Ruleset is the complete set of rules firework wants installed on the host.
+type Ruleset struct {
+	Masquerades  []Masquerade
+	PortForwards []PortForward
+	// Isolation is nil unless tenant network isolation is enabled.
+	Isolation *Isolation
+	// IPv6 is set when guests are dual-stack, so isolation must cover IPv6
+	// traffic as well.
+	IPv6 bool
+}
+
+// Masquerade source-NATs traffic from Subnet leaving through OutInterface.
+type Masquerade struct {
+	Subnet       string
+	OutInterface string
+}
+
+// PortForward DNATs TCP traffic that arrives on InInterface for
+// HostIP:HostPort to GuestIP:GuestPort. Both addresses are of the same family.
+type PortForward struct {
+	InInterface string
+	HostIP      string
+	HostPort    int
+	GuestIP     string
+	GuestPort   int
+}
+
+// Isolation keeps tenants apart on the shared bridge. Guests reach guests
+// of their own tenant and, beyond that, only what a link allows.
+type Isolation struct {
+	// Tenants maps each TAP to its tenant; "" is the platform segment.
+	Tenants map[string]string
+	Links   []Link
+}
+
+// NewFirewall returns the firewall backend with the given name. An empty name
+// selects iptables.
+func NewFirewall(backend string, logger *slog.Logger) (Firewall, error) {
+	switch backend {
+	case "", FirewallIPTables:
+		return newIPTablesFirewall(logger), nil
+	case FirewallNFTables:
+		return newNFTablesFirewall(logger)
+	default:
+		return nil, fmt.Errorf("unsupported firewall backend %q (expected %s or %s)", backend, FirewallIPTables, FirewallNFTables)
+	}
+}
+
+// SetFirewall replaces the firewall backend. It must be called before the
+// first rule is installed.
+func (m *Manager) SetFirewall(fw Firewall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.firewall = fw
+}
+
+// syncFirewall installs the manager's current ruleset. Callers must hold m.mu.
+func (m *Manager) syncFirewall() error {
+	rs := Ruleset{Masquerades: m.masquerades, IPv6: m.ipv6}
+	for _, pf := range m.portForwards {
+		rs.PortForwards = append(rs.PortForwards, pf)
+	}
+	slices.SortFunc(rs.PortForwards, func(a, b PortForward) int { return cmp.Compare(a.HostPort, b.HostPort) })
+	if m.isolation != nil {
+		rs.Isolation = &Isolation{Tenants: m.isolation.tenants, Links: m.isolation.links}
+	}
+	return m.firewall.Apply(rs)
+}
+
+// segmentPairs returns every ordered pair of distinct TAPs in the same
+// tenant segment, in a stable order.
+func segmentPairs(tenants map[string]string) [][2]string {
+	taps := make([]string, 0, len(tenants))
+	for tap := range tenants {
+		taps = append(taps, tap)
+	}
+	slices.Sort(taps)
+	var pairs [][2]string
+	for _, from := range taps {
+		for _, to := range taps {
+			if from != to && tenants[from] == tenants[to] {
+				pairs = append(pairs, [2]string{from, to})
+			}
+		}
+	}
+	return pairs
+}
+
+// sortedLinks returns links sorted and without duplicates.
+func sortedLinks(links []Link) []Link {
+	links = slices.Clone(links)
+	slices.SortFunc(links, func(a, b Link) int {
+		return cmp.Or(strings.Compare(a.FromTAP, b.FromTAP), strings.Compare(a.ToTAP, b.ToTAP), cmp.Compare(a.Port, b.Port))
+	})
+	return slices.Compact(links)
+}
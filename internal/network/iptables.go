@@ -0,0 +1,309 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// isolationChain holds the tenant isolation rules. FORWARD jumps to it for
+// every frame switched between two ports of the shared bridge.
+const isolationChain = "FIREWORK-ISOLATION"
+
+// The iptables commands of each address family.
+const (
+	iptables4 = "iptables"
+	iptables6 = "ip6tables"
+)
+
+// iptablesFirewall programs rules with the iptables commands, one process per
+// rule change. IPv6 rules go through ip6tables. It remembers what it
+// installed so rules dropped from the ruleset can be deleted again.
+type iptablesFirewall struct {
+	logger      *slog.Logger
+	masquerades map[Masquerade]bool
+	forwards    map[PortForward]bool
+	isolation   map[string]string // command → isolation chain last loaded
+}
+
+func newIPTablesFirewall(logger *slog.Logger) *iptablesFirewall {
+	return &iptablesFirewall{
+		logger:      logger,
+		masquerades: make(map[Masquerade]bool),
+		forwards:    make(map[PortForward]bool),
+		isolation:   make(map[string]string),
+	}
+}
+
+// iptablesFor returns the iptables command for the family of an address or
+// CIDR.
+func iptablesFor(addr string) string {
+	if ip, _, err := net.ParseCIDR(addr); err == nil {
+		addr = ip.String()
+	}
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		return iptables6
+	}
+	return iptables4
+}
+
+// Apply adds the rules of rs that are missing and deletes the ones it added
+// earlier that rs dropped. Every rule is its own iptables call, so a failure
+// leaves the rules before it in place.
+func (f *iptablesFirewall) Apply(rs Ruleset) error {
+	var errs []error
+
+	want := make(map[Masquerade]bool, len(rs.Masquerades))
+	for _, masq := range rs.Masquerades {
+		want[masq] = true
+		if f.masquerades[masq] {
+			continue
+		}
+		if err := ensureMasquerade(masq); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f.masquerades[masq] = true
+	}
+	for masq := range f.masquerades {
+		if !want[masq] {
+			errs = append(errs, removeMasquerade(masq))
+			delete(f.masquerades, masq)
+		}
+	}
+
+	wantForwards := make(map[PortForward]bool, len(rs.PortForwards))
+	for _, pf := range rs.PortForwards {
+		wantForwards[pf] = true
+		if f.forwards[pf] {
+			continue
+		}
+		spec := scopedPortForwardSpec(pf.InInterface, pf.HostIP, pf.HostPort, pf.GuestIP, pf.GuestPort)
+		if err := ensureIPTablesRule(iptablesFor(pf.GuestIP), "nat", "PREROUTING", spec...); err != nil {
+			errs = append(errs, fmt.Errorf("adding scoped port-forward rule: %w", err))
+			continue
+		}
+		f.forwards[pf] = true
+	}
+	for pf := range f.forwards {
+		if !wantForwards[pf] {
+			errs = append(errs, f.RemovePortForward(pf))
+		}
+	}
+
+	errs = append(errs, f.applyIsolation(rs.Isolation, rs.IPv6))
+	return errors.Join(errs...)
+}
+
+// RemovePortForward deletes a port forward's DNAT rule, along with the
+// unscoped rule older agents installed for it.
+func (f *iptablesFirewall) RemovePortForward(pf PortForward) error {
+	delete(f.forwards, pf)
+	bin := iptablesFor(pf.GuestIP)
+	var errs []error
+	if pf.InInterface != "" {
+		spec := scopedPortForwardSpec(pf.InInterface, pf.HostIP, pf.HostPort, pf.GuestIP, pf.GuestPort)
+		if err := removeIPTablesRule(bin, "nat", "PREROUTING", spec...); err != nil {
+			errs = append(errs, fmt.Errorf("removing scoped port-forward rule: %w", err))
+		}
+	}
+	// Backward-compatible cleanup for older unscoped rules.
+	legacySpec := legacyPortForwardSpec(pf.HostPort, pf.GuestIP, pf.GuestPort)
+	if err := removeIPTablesRule(bin, "nat", "PREROUTING", legacySpec...); err != nil {
+		errs = append(errs, fmt.Errorf("removing legacy port-forward rule: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// applyIsolation loads the isolation chain when it changed. The chain is
+// replaced in one iptables-restore transaction, so no frame ever sees a
+// partially rebuilt ruleset. Bridged frames only reach iptables through
+// br_netfilter, which is enabled the first time isolation is. Dual-stack
+// guests get the same chain in ip6tables.
+func (f *iptablesFirewall) applyIsolation(iso *Isolation, ipv6 bool) error {
+	want := map[string]bool{}
+	if iso != nil {
+		want[iptables4] = true
+		want[iptables6] = ipv6
+	}
+
+	var errs []error
+	for _, bin := range []string{iptables4, iptables6} {
+		loaded, ok := f.isolation[bin]
+		if !want[bin] {
+			if ok {
+				delete(f.isolation, bin)
+				errs = append(errs, removeIPTablesRule(bin, "filter", "FORWARD", isolationJumpSpec()...))
+			}
+			continue
+		}
+
+		if !ok {
+			// br_netfilter may be built into the kernel, in which case modprobe
+			// fails but the sysctl below still works.
+			if err := run("modprobe", "br_netfilter"); err != nil {
+				f.logger.Warn("failed to load br_netfilter", "error", err)
+			}
+			if err := run("sysctl", "-w", "net.bridge.bridge-nf-call-"+bin+"=1"); err != nil {
+				errs = append(errs, fmt.Errorf("enabling bridge netfilter for %s: %w", bin, err))
+				continue
+			}
+		}
+
+		ruleset := isolationRuleset(iso.Tenants, iso.Links)
+		if ok && ruleset == loaded {
+			continue
+		}
+		if err := runInput(ruleset, bin+"-restore", "--noflush"); err != nil {
+			errs = append(errs, fmt.Errorf("loading tenant isolation rules: %w", err))
+			continue
+		}
+		if !ok {
+			if err := insertIPTablesRule(bin, "filter", "FORWARD", isolationJumpSpec()...); err != nil {
+				errs = append(errs, fmt.Errorf("adding tenant isolation jump: %w", err))
+				continue
+			}
+		}
+		f.isolation[bin] = ruleset
+	}
+	return errors.Join(errs...)
+}
+
+// ensureMasquerade source-NATs the subnet's egress and lets its traffic
+// through FORWARD.
+func ensureMasquerade(masq Masquerade) error {
+	bin := iptablesFor(masq.Subnet)
+	if err := ensureIPTablesRule(bin, "nat", "POSTROUTING", "-s", masq.Subnet, "-o", masq.OutInterface, "-j", "MASQUERADE"); err != nil {
+		return fmt.Errorf("setting up masquerade: %w", err)
+	}
+	if err := ensureIPTablesRule(bin, "filter", "FORWARD", "-s", masq.Subnet, "-j", "ACCEPT"); err != nil {
+		return fmt.Errorf("allowing forward from subnet: %w", err)
+	}
+	if err := ensureIPTablesRule(bin, "filter", "FORWARD", "-d", masq.Subnet, "-j", "ACCEPT"); err != nil {
+		return fmt.Errorf("allowing forward to subnet: %w", err)
+	}
+	return nil
+}
+
+func removeMasquerade(masq Masquerade) error {
+	bin := iptablesFor(masq.Subnet)
+	return errors.Join(
+		removeIPTablesRule(bin, "nat", "POSTROUTING", "-s", masq.Subnet, "-o", masq.OutInterface, "-j", "MASQUERADE"),
+		removeIPTablesRule(bin, "filter", "FORWARD", "-s", masq.Subnet, "-j", "ACCEPT"),
+		removeIPTablesRule(bin, "filter", "FORWARD", "-d", masq.Subnet, "-j", "ACCEPT"),
+	)
+}
+
+func scopedPortForwardSpec(outInterface, hostIP string, hostPort int, guestIP string, vmPort int) []string {
+	hostPrefix := hostIP + "/32"
+	if iptablesFor(hostIP) == iptables6 {
+		hostPrefix = hostIP + "/128"
+	}
+	return []string{
+		"-i", outInterface,
+		"-d", hostPrefix,
+		"-p", "tcp",
+		"-m", "tcp",
+		"--dport", fmt.Sprintf("%d", hostPort),
+		"-j", "DNAT",
+		"--to-destination", net.JoinHostPort(guestIP, fmt.Sprintf("%d", vmPort)),
+	}
+}
+
+func legacyPortForwardSpec(hostPort int, guestIP string, vmPort int) []string {
+	return []string{
+		"-p", "tcp",
+		"-m", "tcp",
+		"--dport", fmt.Sprintf("%d", hostPort),
+		"-j", "DNAT",
+		"--to-destination", net.JoinHostPort(guestIP, fmt.Sprintf("%d", vmPort)),
+	}
+}
+
+// isolationRuleset renders the isolation chain in iptables-restore format.
+// Declaring the chain flushes it; established flows, links, and same-segment
+// traffic are accepted and everything else between guests is dropped.
+func isolationRuleset(tenants map[string]string, links []Link) string {
+	var b strings.Builder
+	b.WriteString("*filter\n")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", isolationChain)
+	fmt.Fprintf(&b, "-A %s -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT\n", isolationChain)
+	for _, link := range sortedLinks(links) {
+		for _, proto := range []string{"tcp", "udp"} {
+			fmt.Fprintf(&b, "-A %s -m physdev --physdev-in %s --physdev-out %s -p %s -m %s --dport %d -j ACCEPT\n",
+				isolationChain, link.FromTAP, link.ToTAP, proto, proto, link.Port)
+		}
+	}
+	for _, pair := range segmentPairs(tenants) {
+		fmt.Fprintf(&b, "-A %s -m physdev --physdev-in %s --physdev-out %s -j ACCEPT\n", isolationChain, pair[0], pair[1])
+	}
+	fmt.Fprintf(&b, "-A %s -j DROP\n", isolationChain)
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
+
+// isolationJumpSpec sends bridged traffic to the isolation chain. Routed
+// traffic, such as guest egress and port forwards, does not match.
+func isolationJumpSpec() []string {
+	return []string{"-m", "physdev", "--physdev-is-bridged", "-j", isolationChain}
+}
+
+func ensureIPTablesRule(bin, table, chain string, spec ...string) error {
+	checkArgs := append([]string{"-t", table, "-C", chain}, spec...)
+	if err := run(bin, checkArgs...); err == nil {
+		return nil
+	} else if !isRuleMissingError(err) {
+		return fmt.Errorf("checking existing %s rule: %w", bin, err)
+	}
+
+	addArgs := append([]string{"-t", table, "-A", chain}, spec...)
+	if err := run(bin, addArgs...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// insertIPTablesRule is ensureIPTablesRule for rules that must come before
+// the rules already in the chain.
+func insertIPTablesRule(bin, table, chain string, spec ...string) error {
+	checkArgs := append([]string{"-t", table, "-C", chain}, spec...)
+	if err := run(bin, checkArgs...); err == nil {
+		return nil
+	} else if !isRuleMissingError(err) {
+		return fmt.Errorf("checking existing %s rule: %w", bin, err)
+	}
+
+	insertArgs := append([]string{"-t", table, "-I", chain, "1"}, spec...)
+	return run(bin, insertArgs...)
+}
+
+func removeIPTablesRule(bin, table, chain string, spec ...string) error {
+	delArgs := append([]string{"-t", table, "-D", chain}, spec...)
+	if err := run(bin, delArgs...); err != nil && !isRuleMissingError(err) {
+		return err
+	}
+	return nil
+}
+
+func isRuleMissingError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "No chain/target/match by that name") ||
+		strings.Contains(msg, "Bad rule (does a matching rule exist in that chain?)")
+}
+
+// runInput executes a command with input on its stdin.
+func runInput(input, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %s: %w", name, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package network
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// Link lets the guest behind FromTAP reach Port of the guest behind ToTAP
+// across tenant segments. Links are matched on bridge ports rather than guest
+// IPs so a guest cannot claim another guest's address to get through.
+type Link struct {
+	FromTAP string
+	ToTAP   string
+	Port    int
+}
+
+// isolation is the tenant segment state of the shared bridge. Every TAP
+// belongs to the segment of its service's tenant (metadata.tenant); services
+// without a tenant share the platform segment.
+type isolation struct {
+	tenants map[string]string // TAP → tenant
+	links   []Link
+}
+
+// EnableTenantIsolation separates tenants on the shared bridge: bridged
+// traffic between guests of different tenants is dropped unless a link
+// allows it. It must be called after InitBridge and before the first Setup.
+func (m *Manager) EnableTenantIsolation() error {
+	m.logger.Info("enabling tenant network isolation", "bridge", m.bridgeName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.isolation = &isolation{tenants: make(map[string]string)}
+	return m.syncFirewall()
+}
+
+// JoinSegment puts the service's TAPs, including those on additional
+// networks, into its tenant's segment. Setup calls it for new VMs; VMs adopted
+// after an agent restart must rejoin explicitly.
+func (m *Manager) JoinSegment(svc config.ServiceConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isolation == nil || svc.Network == nil {
+		return nil
+	}
+	taps := []string{TAPName(svc)}
+	for _, att := range svc.Networks {
+		if att.Interface != "" {
+			taps = append(taps, att.Interface)
+		}
+	}
+	tenant := strings.TrimSpace(svc.Metadata["tenant"])
+	changed := false
+	for _, tap := range taps {
+		if current, ok := m.isolation.tenants[tap]; ok && current == tenant {
+			continue
+		}
+		m.isolation.tenants[tap] = tenant
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return m.syncFirewall()
+}
+
+// leaveSegment removes a torn down TAP from its segment.
+func (m *Manager) leaveSegment(tapName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isolation == nil {
+		return nil
+	}
+	if _, ok := m.isolation.tenants[tapName]; !ok {
+		return nil
+	}
+	delete(m.isolation.tenants, tapName)
+	return m.syncFirewall()
+}
+
+// SetLinks replaces the explicit links allowed across tenant segments. It is
+// a no-op when tenant isolation is disabled.
+func (m *Manager) SetLinks(links []Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isolation == nil {
+		return nil
+	}
+	links = sortedLinks(links)
+	if slices.Equal(links, m.isolation.links) {
+		return nil
+	}
+	m.isolation.links = links
+	return m.syncFirewall()
+}
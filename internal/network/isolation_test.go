@@ -0,0 +1,99 @@
+package network
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func TestIsolationRulesetSeparatesTenants(t *testing.T) {
+	t.Parallel()
+
+	ruleset := isolationRuleset(
+		map[string]string{"tap-a1": "acme", "tap-a2": "acme", "tap-g1": "globex", "tap-p1": ""},
+		[]Link{{FromTAP: "tap-g1", ToTAP: "tap-p1", Port: 5432}, {FromTAP: "tap-g1", ToTAP: "tap-p1", Port: 5432}},
+	)
+	lines := strings.Split(strings.TrimSpace(ruleset), "\n")
+
+	if lines[0] != "*filter" || lines[1] != ":FIREWORK-ISOLATION - [0:0]" || lines[len(lines)-1] != "COMMIT" {
+		t.Fatalf("ruleset must redeclare the chain in one transaction:\n%s", ruleset)
+	}
+	if got := lines[len(lines)-2]; got != "-A FIREWORK-ISOLATION -j DROP" {
+		t.Fatalf("last rule = %q, want DROP", got)
+	}
+	for _, want := range []string{
+		"--physdev-in tap-a1 --physdev-out tap-a2 -j ACCEPT",
+		"--physdev-in tap-a2 --physdev-out tap-a1 -j ACCEPT",
+		"--physdev-in tap-g1 --physdev-out tap-p1 -p tcp -m tcp --dport 5432 -j ACCEPT",
+		"--physdev-in tap-g1 --physdev-out tap-p1 -p udp -m udp --dport 5432 -j ACCEPT",
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("ruleset missing %q:\n%s", want, ruleset)
+		}
+	}
+	for _, forbidden := range []string{
+		"--physdev-in tap-a1 --physdev-out tap-g1",
+		"--physdev-in tap-g1 --physdev-out tap-a1",
+		"--physdev-in tap-a1 --physdev-out tap-p1",
+		"--physdev-in tap-p1 --physdev-out tap-g1",
+	} {
+		if strings.Contains(ruleset, forbidden) {
+			t.Errorf("ruleset lets tenants reach each other (%q):\n%s", forbidden, ruleset)
+		}
+	}
+	if n := strings.Count(ruleset, "--dport 5432"); n != 2 {
+		t.Errorf("duplicate links must collapse, got %d port rules", n)
+	}
+}
+
+// recordingFirewall keeps the last ruleset it was asked to apply.
+type recordingFirewall struct {
+	applied []Ruleset
+}
+
+func (f *recordingFirewall) Apply(rs Ruleset) error {
+	f.applied = append(f.applied, rs)
+	return nil
+}
+
+func TestManagerAppliesTenantSegmentsThroughFirewall(t *testing.T) {
+	t.Parallel()
+
+	fw := &recordingFirewall{}
+	m := NewManager(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.SetFirewall(fw)
+	if err := m.EnableTenantIsolation(); err != nil {
+		t.Fatal(err)
+	}
+	svc := config.ServiceConfig{Name: "db", Metadata: map[string]string{"tenant": "acme"}, Network: &config.NetworkConfig{}}
+	if err := m.JoinSegment(svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.JoinSegment(svc); err != nil {
+		t.Fatal(err)
+	}
+	links := []Link{{FromTAP: "tap-web", ToTAP: "tap-db", Port: 5432}}
+	if err := m.SetLinks(links); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetLinks(links); err != nil {
+		t.Fatal(err)
+	}
+	if len(fw.applied) != 3 {
+		t.Fatalf("unchanged segments and links must not be reapplied, got %d applies", len(fw.applied))
+	}
+	last := fw.applied[len(fw.applied)-1]
+	if last.Isolation == nil || last.Isolation.Tenants["tap-db"] != "acme" || len(last.Isolation.Links) != 1 {
+		t.Fatalf("unexpected ruleset %+v", last.Isolation)
+	}
+
+	if err := m.leaveSegment("tap-db"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fw.applied[len(fw.applied)-1].Isolation.Tenants["tap-db"]; ok {
+		t.Fatal("torn down TAP is still in its segment")
+	}
+}
@@ -0,0 +1,35 @@
+package network
+
+import (
+	"net"
+)
+
+// linkAPI is the kernel interface the manager uses for links, addresses and
+// routes. It is implemented over rtnetlink on Linux; tests substitute a fake.
+type linkAPI interface {
+	// LinkExists reports whether a network device with the name exists.
+	LinkExists(name string) (bool, error)
+	// AddBridge creates a bridge device.
+	AddBridge(name string) error
+	// AddTAP creates a persistent TAP device.
+	AddTAP(name string) error
+	// DeleteLink removes a network device.
+	DeleteLink(name string) error
+	// SetLinkUp and SetLinkDown change the administrative state of a device.
+	SetLinkUp(name string) error
+	SetLinkDown(name string) error
+	// SetMaster enslaves a device to a bridge.
+	SetMaster(name, master string) error
+	// AddAddress assigns an IPv4 or IPv6 address to a device. An address
+	// that is already assigned is not an error.
+	AddAddress(name string, addr *net.IPNet) error
+	// ReplaceRoute points the route for dst at dev, preferring src as the
+	// source address when src is not nil.
+	ReplaceRoute(dst *net.IPNet, dev string, src net.IP) error
+	// DefaultRouteInterface returns the device of the IPv4 or, with ipv6,
+	// the IPv6 default route with the lowest metric.
+	DefaultRouteInterface(ipv6 bool) (string, error)
+	// InterfaceAddr returns the first global IPv4 or, with ipv6, IPv6
+	// address of a device.
+	InterfaceAddr(name string, ipv6 bool) (net.IP, error)
+}
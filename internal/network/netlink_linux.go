@@ -0,0 +1,352 @@
+//go:build linux
+
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// rtnetlink implements linkAPI over NETLINK_ROUTE. Every call opens its own
+// socket, so the manager holds no connection state. TAP devices are the one
+// exception: the kernel only creates them through /dev/net/tun.
+type rtnetlink struct{}
+
+func newLinkAPI() linkAPI {
+	return rtnetlink{}
+}
+
+func (r rtnetlink) LinkExists(name string) (bool, error) {
+	if _, err := r.linkIndex(name); err != nil {
+		if errors.Is(err, unix.ENODEV) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r rtnetlink) AddBridge(name string) error {
+	_, err := r.request(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL,
+		ifinfomsg(0, 0, 0),
+		strAttr(unix.IFLA_IFNAME, name),
+		nested(unix.IFLA_LINKINFO, strAttr(unix.IFLA_INFO_KIND, "bridge")),
+	)
+	return err
+}
+
+func (r rtnetlink) AddTAP(name string) error {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("opening /dev/net/tun: %w", err)
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return fmt.Errorf("TAP name %q: %w", name, err)
+	}
+	ifr.SetUint16(unix.IFF_TAP | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		return fmt.Errorf("TUNSETIFF: %w", err)
+	}
+	// Without persistence the device disappears when fd is closed.
+	if err := unix.IoctlSetInt(fd, unix.TUNSETPERSIST, 1); err != nil {
+		return fmt.Errorf("TUNSETPERSIST: %w", err)
+	}
+	return nil
+}
+
+func (r rtnetlink) DeleteLink(name string) error {
+	index, err := r.linkIndex(name)
+	if err != nil {
+		return err
+	}
+	_, err = r.request(unix.RTM_DELLINK, 0, ifinfomsg(index, 0, 0))
+	return err
+}
+
+func (r rtnetlink) SetLinkUp(name string) error {
+	return r.setFlags(name, unix.IFF_UP)
+}
+
+func (r rtnetlink) SetLinkDown(name string) error {
+	return r.setFlags(name, 0)
+}
+
+func (r rtnetlink) setFlags(name string, flags uint32) error {
+	index, err := r.linkIndex(name)
+	if err != nil {
+		return err
+	}
+	_, err = r.request(unix.RTM_NEWLINK, 0, ifinfomsg(index, flags, unix.IFF_UP))
+	return err
+}
+
+func (r rtnetlink) SetMaster(name, master string) error {
+	index, err := r.linkIndex(name)
+	if err != nil {
+		return err
+	}
+	masterIndex, err := r.linkIndex(master)
+	if err != nil {
+		return err
+	}
+	_, err = r.request(unix.RTM_NEWLINK, 0, ifinfomsg(index, 0, 0), hostU32Attr(unix.IFLA_MASTER, uint32(masterIndex)))
+	return err
+}
+
+func (r rtnetlink) AddAddress(name string, addr *net.IPNet) error {
+	ip, family := addrFamily(addr.IP)
+	index, err := r.linkIndex(name)
+	if err != nil {
+		return err
+	}
+	// IPv6 addresses skip duplicate address detection: the gateway sits on a
+	// bridge that may have no ports yet, where DAD would never complete.
+	var flags byte
+	if family == unix.AF_INET6 {
+		flags = unix.IFA_F_NODAD
+	}
+	ones, _ := addr.Mask.Size()
+	msg := []byte{family, byte(ones), flags, unix.RT_SCOPE_UNIVERSE}
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(index))
+	attrs := [][]byte{attr(unix.IFA_ADDRESS, ip)}
+	if family == unix.AF_INET {
+		attrs = append(attrs, attr(unix.IFA_LOCAL, ip))
+	}
+	_, err = r.request(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, msg, attrs...)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return err
+	}
+	return nil
+}
+
+func (r rtnetlink) ReplaceRoute(dst *net.IPNet, dev string, src net.IP) error {
+	network, family := addrFamily(dst.IP)
+	index, err := r.linkIndex(dev)
+	if err != nil {
+		return err
+	}
+	ones, _ := dst.Mask.Size()
+	attrs := [][]byte{
+		attr(unix.RTA_DST, network.Mask(dst.Mask)),
+		hostU32Attr(unix.RTA_OIF, uint32(index)),
+	}
+	if src != nil {
+		if src, srcFamily := addrFamily(src); srcFamily == family {
+			attrs = append(attrs, attr(unix.RTA_PREFSRC, src))
+		}
+	}
+	msg := rtmsg(family, byte(ones), unix.RT_TABLE_MAIN, unix.RTPROT_BOOT, unix.RT_SCOPE_LINK, unix.RTN_UNICAST)
+	_, err = r.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, msg, attrs...)
+	return err
+}
+
+func (r rtnetlink) DefaultRouteInterface(ipv6 bool) (string, error) {
+	family := byte(unix.AF_INET)
+	if ipv6 {
+		family = unix.AF_INET6
+	}
+	msgs, err := r.dump(unix.RTM_GETROUTE, rtmsg(family, 0, 0, 0, 0, 0))
+	if err != nil {
+		return "", fmt.Errorf("listing routes: %w", err)
+	}
+	best, bestMetric := -1, uint32(0)
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Header.Type != unix.RTM_NEWROUTE || len(msg.Data) < unix.SizeofRtMsg {
+			continue
+		}
+		dstLen, table, routeType := msg.Data[1], uint32(msg.Data[4]), msg.Data[7]
+		if msg.Data[0] != family || dstLen != 0 || routeType != unix.RTN_UNICAST {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err != nil {
+			continue
+		}
+		oif, metric := -1, uint32(0)
+		for _, a := range attrs {
+			switch {
+			case a.Attr.Type == unix.RTA_TABLE && len(a.Value) == 4:
+				table = binary.NativeEndian.Uint32(a.Value)
+			case a.Attr.Type == unix.RTA_OIF && len(a.Value) == 4:
+				oif = int(binary.NativeEndian.Uint32(a.Value))
+			case a.Attr.Type == unix.RTA_PRIORITY && len(a.Value) == 4:
+				metric = binary.NativeEndian.Uint32(a.Value)
+			}
+		}
+		if table != unix.RT_TABLE_MAIN || oif < 0 {
+			continue
+		}
+		if best < 0 || metric < bestMetric {
+			best, bestMetric = oif, metric
+		}
+	}
+	if best < 0 {
+		return "", errors.New("default route interface not found")
+	}
+	iface, err := net.InterfaceByIndex(best)
+	if err != nil {
+		return "", fmt.Errorf("resolving default route interface %d: %w", best, err)
+	}
+	return iface.Name, nil
+}
+
+func (r rtnetlink) InterfaceAddr(name string, ipv6 bool) (net.IP, error) {
+	family, label := byte(unix.AF_INET), "IPv4"
+	if ipv6 {
+		family, label = unix.AF_INET6, "IPv6"
+	}
+	index, err := r.linkIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := r.dump(unix.RTM_GETADDR, []byte{family, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return nil, fmt.Errorf("listing addresses: %w", err)
+	}
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Header.Type != unix.RTM_NEWADDR || len(msg.Data) < unix.SizeofIfAddrmsg {
+			continue
+		}
+		msgFamily, flags, scope := msg.Data[0], msg.Data[2], msg.Data[3]
+		if msgFamily != family || scope != unix.RT_SCOPE_UNIVERSE || int32(binary.NativeEndian.Uint32(msg.Data[4:8])) != index {
+			continue
+		}
+		// Tentative and deprecated addresses cannot take new connections.
+		if flags&(unix.IFA_F_TENTATIVE|unix.IFA_F_DEPRECATED) != 0 {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err != nil {
+			continue
+		}
+		var address net.IP
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case unix.IFA_LOCAL:
+				return net.IP(a.Value), nil
+			case unix.IFA_ADDRESS:
+				address = net.IP(a.Value)
+			}
+		}
+		if address != nil {
+			return address, nil
+		}
+	}
+	return nil, fmt.Errorf("%s address not found on %s", label, name)
+}
+
+// linkIndex returns the interface index of a device, or an error wrapping
+// ENODEV when there is none.
+func (r rtnetlink) linkIndex(name string) (int32, error) {
+	msgs, err := r.request(unix.RTM_GETLINK, 0, ifinfomsg(0, 0, 0), strAttr(unix.IFLA_IFNAME, name))
+	if err != nil {
+		return 0, fmt.Errorf("looking up %s: %w", name, err)
+	}
+	for _, msg := range msgs {
+		if msg.Header.Type == unix.RTM_NEWLINK && len(msg.Data) >= unix.SizeofIfInfomsg {
+			return int32(binary.NativeEndian.Uint32(msg.Data[4:8])), nil
+		}
+	}
+	return 0, fmt.Errorf("looking up %s: %w", name, unix.ENODEV)
+}
+
+// request sends an rtnetlink request and collects the replies up to its
+// acknowledgement.
+func (r rtnetlink) request(msgType uint16, flags uint16, msg []byte, attrs ...[]byte) ([]syscall.NetlinkMessage, error) {
+	return r.exchange(msgType, flags|unix.NLM_F_ACK, msg, attrs...)
+}
+
+// dump collects every object of a kind, up to NLMSG_DONE.
+func (r rtnetlink) dump(msgType uint16, msg []byte) ([]syscall.NetlinkMessage, error) {
+	return r.exchange(msgType, unix.NLM_F_DUMP, msg)
+}
+
+func (r rtnetlink) exchange(msgType uint16, flags uint16, msg []byte, attrs ...[]byte) ([]syscall.NetlinkMessage, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("opening rtnetlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("binding rtnetlink socket: %w", err)
+	}
+	timeout := unix.NsecToTimeval(nftReplyTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		return nil, fmt.Errorf("setting rtnetlink reply timeout: %w", err)
+	}
+
+	payload := append(msg, bytes.Join(attrs, nil)...)
+	req := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload))
+	binary.NativeEndian.PutUint32(req[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(req[4:6], msgType)
+	binary.NativeEndian.PutUint16(req[6:8], flags|unix.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(req[8:12], 1)
+	if err := unix.Sendto(fd, append(req, payload...), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("sending rtnetlink request: %w", err)
+	}
+
+	var replies []syscall.NetlinkMessage
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("reading rtnetlink reply: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("parsing rtnetlink reply: %w", err)
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return replies, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if code := int32(binary.NativeEndian.Uint32(m.Data[:4])); code != 0 {
+						return nil, syscall.Errno(-code)
+					}
+				}
+				return replies, nil
+			default:
+				// The data aliases buf, which the next read overwrites.
+				m.Data = bytes.Clone(m.Data)
+				replies = append(replies, m)
+			}
+		}
+	}
+}
+
+func ifinfomsg(index int32, flags, change uint32) []byte {
+	msg := []byte{unix.AF_UNSPEC, 0, 0, 0}
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(index))
+	msg = binary.NativeEndian.AppendUint32(msg, flags)
+	return binary.NativeEndian.AppendUint32(msg, change)
+}
+
+func rtmsg(family, dstLen, table, protocol, scope, routeType byte) []byte {
+	return []byte{family, dstLen, 0, 0, table, protocol, scope, routeType, 0, 0, 0, 0}
+}
+
+// addrFamily returns ip in its family form and the family.
+func addrFamily(ip net.IP) (net.IP, byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, unix.AF_INET
+	}
+	return ip.To16(), unix.AF_INET6
+}
+
+// hostU32Attr encodes a 32-bit attribute in host byte order, as rtnetlink
+// expects.
+func hostU32Attr(typ uint16, value uint32) []byte {
+	return attr(typ, binary.NativeEndian.AppendUint32(nil, value))
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"net"
+)
+
+var errLinksUnsupported = errors.New("network device management requires Linux")
+
+// unsupportedLinks lets the manager build on other platforms; every call
+// fails.
+type unsupportedLinks struct{}
+
+func newLinkAPI() linkAPI {
+	return unsupportedLinks{}
+}
+
+func (unsupportedLinks) LinkExists(string) (bool, error)               { return false, errLinksUnsupported }
+func (unsupportedLinks) AddBridge(string) error                        { return errLinksUnsupported }
+func (unsupportedLinks) AddTAP(string) error                           { return errLinksUnsupported }
+func (unsupportedLinks) DeleteLink(string) error                       { return errLinksUnsupported }
+func (unsupportedLinks) SetLinkUp(string) error                        { return errLinksUnsupported }
+func (unsupportedLinks) SetLinkDown(string) error                      { return errLinksUnsupported }
+func (unsupportedLinks) SetMaster(string, string) error                { return errLinksUnsupported }
+func (unsupportedLinks) AddAddress(string, *net.IPNet) error           { return errLinksUnsupported }
+func (unsupportedLinks) ReplaceRoute(*net.IPNet, string, net.IP) error { return errLinksUnsupported }
+func (unsupportedLinks) DefaultRouteInterface(bool) (string, error)    { return "", errLinksUnsupported }
+func (unsupportedLinks) InterfaceAddr(string, bool) (net.IP, error)    { return nil, errLinksUnsupported }
@@ -0,0 +1,454 @@
+//go:build linux
+
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// nftTable names the firework-owned tables: inet firework holds the NAT
+	// chains, bridge firework the tenant isolation of the shared bridge.
+	nftTable = "firework"
+	// nftReplyTimeout bounds how long Apply waits for the kernel to confirm
+	// a transaction.
+	nftReplyTimeout = 5 * time.Second
+
+	// Verdicts and priorities from the netfilter headers.
+	nfDrop                 = 0
+	nfAccept               = 1
+	nfBridgeForward        = 2
+	nfPriorityDstNAT       = -100
+	nfPrioritySrcNAT       = 100
+	nfPriorityBridgeFilter = -200
+)
+
+// nftablesFirewall programs rules over netlink into the firework-owned
+// nftables tables. Every Apply deletes and recreates the tables in a single
+// transaction: the kernel switches to the new ruleset atomically or, when
+// anything in it is rejected, keeps the old one. Rules of earlier agent
+// processes never survive a reconcile.
+type nftablesFirewall struct {
+	// bridgeConntrack is set when the kernel tracks bridged flows, so link
+	// replies can be accepted as established flows.
+	bridgeConntrack bool
+}
+
+func newNFTablesFirewall(logger *slog.Logger) (Firewall, error) {
+	f := &nftablesFirewall{bridgeConntrack: loadBridgeConntrack()}
+	if !f.bridgeConntrack {
+		logger.Warn("nf_conntrack_bridge is not available; tenant isolation accepts link replies by source port, " +
+			"so a link target can also open flows from that port toward the source")
+	}
+	return f, nil
+}
+
+// loadBridgeConntrack reports whether bridge conntrack (nf_conntrack_bridge)
+// is built into the kernel or loaded, loading the module if needed.
+func loadBridgeConntrack() bool {
+	if bridgeConntrackPresent() {
+		return true
+	}
+	_ = run("modprobe", "nf_conntrack_bridge")
+	return bridgeConntrackPresent()
+}
+
+func bridgeConntrackPresent() bool {
+	if _, err := os.Stat("/sys/module/nf_conntrack_bridge"); err == nil {
+		return true
+	}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	builtin, err := os.ReadFile(filepath.Join("/lib/modules", unix.ByteSliceToString(uts.Release[:]), "modules.builtin"))
+	return err == nil && bytes.Contains(builtin, []byte("/nf_conntrack_bridge.ko"))
+}
+
+// Apply replaces the firework tables with rs.
+func (f *nftablesFirewall) Apply(rs Ruleset) error {
+	msgs, err := nftRuleset(rs, f.bridgeConntrack)
+	if err != nil {
+		return err
+	}
+	return nftCommit(msgs)
+}
+
+// nftRuleset encodes rs as an nftables transaction. Each table is created
+// before it is deleted so the delete also succeeds on a host that has none.
+// Without bridgeConntrack, link replies are matched by source port.
+func nftRuleset(rs Ruleset, bridgeConntrack bool) ([][]byte, error) {
+	b := &nftBatch{}
+	b.add(unix.NFNL_MSG_BATCH_BEGIN, unix.AF_UNSPEC, 0, unix.NFNL_SUBSYS_NFTABLES)
+	for _, family := range []uint8{unix.NFPROTO_INET, unix.NFPROTO_BRIDGE} {
+		b.nft(unix.NFT_MSG_NEWTABLE, family, unix.NLM_F_CREATE, strAttr(unix.NFTA_TABLE_NAME, nftTable))
+		b.nft(unix.NFT_MSG_DELTABLE, family, 0, strAttr(unix.NFTA_TABLE_NAME, nftTable))
+	}
+
+	b.nft(unix.NFT_MSG_NEWTABLE, unix.NFPROTO_INET, unix.NLM_F_CREATE, strAttr(unix.NFTA_TABLE_NAME, nftTable))
+	b.chain(unix.NFPROTO_INET, "prerouting", "nat", unix.NF_INET_PRE_ROUTING, nfPriorityDstNAT)
+	b.chain(unix.NFPROTO_INET, "postrouting", "nat", unix.NF_INET_POST_ROUTING, nfPrioritySrcNAT)
+	for _, pf := range rs.PortForwards {
+		hostIP, guestIP := ipFamilyForm(net.ParseIP(pf.HostIP)), ipFamilyForm(net.ParseIP(pf.GuestIP))
+		if hostIP == nil || guestIP == nil || len(hostIP) != len(guestIP) {
+			return nil, fmt.Errorf("port forward %d: host IP %q and guest IP %q must be of the same family", pf.HostPort, pf.HostIP, pf.GuestIP)
+		}
+		family := nfproto(hostIP)
+		bits := 8 * len(hostIP)
+		b.rule(unix.NFPROTO_INET, "prerouting",
+			matchFamily(family),
+			matchIfname(unix.NFT_META_IIFNAME, pf.InInterface),
+			matchAddr(dstAddrOffset(family), &net.IPNet{IP: hostIP, Mask: net.CIDRMask(bits, bits)}),
+			matchPort(unix.IPPROTO_TCP, transportDport, pf.HostPort),
+			[][]byte{
+				exprImmediate(unix.NFT_REG_1, guestIP),
+				exprImmediate(unix.NFT_REG_2, be16(pf.GuestPort)),
+				expr("nat",
+					u32Attr(unix.NFTA_NAT_TYPE, unix.NFT_NAT_DNAT),
+					u32Attr(unix.NFTA_NAT_FAMILY, uint32(family)),
+					u32Attr(unix.NFTA_NAT_REG_ADDR_MIN, unix.NFT_REG_1),
+					u32Attr(unix.NFTA_NAT_REG_PROTO_MIN, unix.NFT_REG_2),
+				),
+			},
+		)
+	}
+	for _, masq := range rs.Masquerades {
+		_, subnet, err := net.ParseCIDR(masq.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("masquerade subnet %q must be a CIDR", masq.Subnet)
+		}
+		subnet.IP = ipFamilyForm(subnet.IP)
+		family := nfproto(subnet.IP)
+		b.rule(unix.NFPROTO_INET, "postrouting",
+			matchFamily(family),
+			matchAddr(srcAddrOffset(family), subnet),
+			matchIfname(unix.NFT_META_OIFNAME, masq.OutInterface),
+			[][]byte{expr("masq")},
+		)
+	}
+
+	if iso := rs.Isolation; iso != nil {
+		b.nft(unix.NFT_MSG_NEWTABLE, unix.NFPROTO_BRIDGE, unix.NLM_F_CREATE, strAttr(unix.NFTA_TABLE_NAME, nftTable))
+		b.chain(unix.NFPROTO_BRIDGE, "forward", "filter", nfBridgeForward, nfPriorityBridgeFilter)
+		// Address resolution must work for links to reach their targets.
+		b.rule(unix.NFPROTO_BRIDGE, "forward", [][]byte{
+			exprMeta(unix.NFT_META_PROTOCOL),
+			exprCmp(unix.NFT_CMP_EQ, be16(unix.ETH_P_ARP)),
+			exprVerdict(nfAccept),
+		})
+		// Replies of flows a link let through are accepted by bridge
+		// conntrack (nf_conntrack_bridge), so a link target can only answer
+		// and never open flows of its own toward the source.
+		if bridgeConntrack {
+			b.rule(unix.NFPROTO_BRIDGE, "forward",
+				matchCtState(ctStateEstablished|ctStateRelated),
+				[][]byte{exprVerdict(nfAccept)},
+			)
+		}
+		for _, link := range sortedLinks(iso.Links) {
+			for _, proto := range []uint8{unix.IPPROTO_TCP, unix.IPPROTO_UDP} {
+				b.rule(unix.NFPROTO_BRIDGE, "forward",
+					matchIfname(unix.NFT_META_IIFNAME, link.FromTAP),
+					matchIfname(unix.NFT_META_OIFNAME, link.ToTAP),
+					matchPort(proto, transportDport, link.Port),
+					[][]byte{exprVerdict(nfAccept)},
+				)
+				if !bridgeConntrack {
+					b.rule(unix.NFPROTO_BRIDGE, "forward",
+						matchIfname(unix.NFT_META_IIFNAME, link.ToTAP),
+						matchIfname(unix.NFT_META_OIFNAME, link.FromTAP),
+						matchPort(proto, transportSport, link.Port),
+						[][]byte{exprVerdict(nfAccept)},
+					)
+				}
+			}
+		}
+		for _, pair := range segmentPairs(iso.Tenants) {
+			b.rule(unix.NFPROTO_BRIDGE, "forward",
+				matchIfname(unix.NFT_META_IIFNAME, pair[0]),
+				matchIfname(unix.NFT_META_OIFNAME, pair[1]),
+				[][]byte{exprVerdict(nfAccept)},
+			)
+		}
+		b.rule(unix.NFPROTO_BRIDGE, "forward", [][]byte{exprVerdict(nfDrop)})
+	}
+
+	b.add(unix.NFNL_MSG_BATCH_END, unix.AF_UNSPEC, 0, unix.NFNL_SUBSYS_NFTABLES)
+	return b.msgs, nil
+}
+
+// Transport header offsets of the source and destination ports.
+const (
+	transportSport = 0
+	transportDport = 2
+)
+
+// Conntrack state bits as the ct expression reports them.
+const (
+	ctStateEstablished = 1 << 1
+	ctStateRelated     = 1 << 2
+)
+
+// nftBatch collects the netlink messages of one nftables transaction.
+type nftBatch struct {
+	msgs [][]byte
+}
+
+// add appends a netfilter netlink message with the next sequence number.
+func (b *nftBatch) add(msgType uint16, family uint8, flags uint16, resID uint16, attrs ...[]byte) {
+	payload := []byte{family, unix.NFNETLINK_V0, byte(resID >> 8), byte(resID)}
+	payload = append(payload, bytes.Join(attrs, nil)...)
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|flags)
+	binary.NativeEndian.PutUint32(msg[8:12], uint32(len(b.msgs)+1))
+	b.msgs = append(b.msgs, append(msg, payload...))
+}
+
+// nft appends an nf_tables message.
+func (b *nftBatch) nft(msgType uint16, family uint8, flags uint16, attrs ...[]byte) {
+	b.add(unix.NFNL_SUBSYS_NFTABLES<<8|msgType, family, flags, 0, attrs...)
+}
+
+// chain appends a base chain with an accept policy.
+func (b *nftBatch) chain(family uint8, name, chainType string, hook uint32, priority int32) {
+	b.nft(unix.NFT_MSG_NEWCHAIN, family, unix.NLM_F_CREATE,
+		strAttr(unix.NFTA_CHAIN_TABLE, nftTable),
+		strAttr(unix.NFTA_CHAIN_NAME, name),
+		nested(unix.NFTA_CHAIN_HOOK,
+			u32Attr(unix.NFTA_HOOK_HOOKNUM, hook),
+			u32Attr(unix.NFTA_HOOK_PRIORITY, uint32(priority)),
+		),
+		u32Attr(unix.NFTA_CHAIN_POLICY, nfAccept),
+		strAttr(unix.NFTA_CHAIN_TYPE, chainType),
+	)
+}
+
+// rule appends a rule made of the given expression groups.
+func (b *nftBatch) rule(family uint8, chain string, exprs ...[][]byte) {
+	var list []byte
+	for _, group := range exprs {
+		list = append(list, bytes.Join(group, nil)...)
+	}
+	b.nft(unix.NFT_MSG_NEWRULE, family, unix.NLM_F_CREATE|unix.NLM_F_APPEND,
+		strAttr(unix.NFTA_RULE_TABLE, nftTable),
+		strAttr(unix.NFTA_RULE_CHAIN, chain),
+		attr(unix.NFTA_RULE_EXPRESSIONS|unix.NLA_F_NESTED, list),
+	)
+}
+
+func matchFamily(family uint8) [][]byte {
+	return [][]byte{exprMeta(unix.NFT_META_NFPROTO), exprCmp(unix.NFT_CMP_EQ, []byte{family})}
+}
+
+// ipFamilyForm returns ip in its 4-byte form when it is IPv4, so its length
+// tells the family.
+func ipFamilyForm(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+func nfproto(ip net.IP) uint8 {
+	if len(ip) == net.IPv4len {
+		return unix.NFPROTO_IPV4
+	}
+	return unix.NFPROTO_IPV6
+}
+
+// srcAddrOffset and dstAddrOffset locate the addresses in the network header.
+func srcAddrOffset(family uint8) uint32 {
+	if family == unix.NFPROTO_IPV6 {
+		return 8
+	}
+	return 12
+}
+
+func dstAddrOffset(family uint8) uint32 {
+	if family == unix.NFPROTO_IPV6 {
+		return 24
+	}
+	return 16
+}
+
+func matchIfname(key uint32, name string) [][]byte {
+	padded := make([]byte, unix.IFNAMSIZ)
+	copy(padded, name)
+	return [][]byte{exprMeta(key), exprCmp(unix.NFT_CMP_EQ, padded)}
+}
+
+// matchAddr matches the address at offset in the network header against
+// prefix, whose IP must be in its family form.
+func matchAddr(offset uint32, prefix *net.IPNet) [][]byte {
+	exprs := [][]byte{exprPayload(unix.NFT_PAYLOAD_NETWORK_HEADER, offset, uint32(len(prefix.IP)))}
+	if ones, bits := prefix.Mask.Size(); ones != bits {
+		exprs = append(exprs, exprBitwise(prefix.Mask))
+	}
+	return append(exprs, exprCmp(unix.NFT_CMP_EQ, prefix.IP.Mask(prefix.Mask)))
+}
+
+func matchPort(proto uint8, offset uint32, port int) [][]byte {
+	return [][]byte{
+		exprMeta(unix.NFT_META_L4PROTO),
+		exprCmp(unix.NFT_CMP_EQ, []byte{proto}),
+		exprPayload(unix.NFT_PAYLOAD_TRANSPORT_HEADER, offset, 2),
+		exprCmp(unix.NFT_CMP_EQ, be16(port)),
+	}
+}
+
+// matchCtState matches packets whose conntrack state is one of states.
+func matchCtState(states uint32) [][]byte {
+	mask := binary.NativeEndian.AppendUint32(nil, states)
+	return [][]byte{
+		expr("ct", u32Attr(unix.NFTA_CT_DREG, unix.NFT_REG_1), u32Attr(unix.NFTA_CT_KEY, unix.NFT_CT_STATE)),
+		exprBitwise(mask),
+		exprCmp(unix.NFT_CMP_NEQ, make([]byte, len(mask))),
+	}
+}
+
+func expr(name string, data ...[]byte) []byte {
+	attrs := [][]byte{strAttr(unix.NFTA_EXPR_NAME, name)}
+	if len(data) > 0 {
+		attrs = append(attrs, nested(unix.NFTA_EXPR_DATA, data...))
+	}
+	return nested(unix.NFTA_LIST_ELEM, attrs...)
+}
+
+func exprMeta(key uint32) []byte {
+	return expr("meta", u32Attr(unix.NFTA_META_DREG, unix.NFT_REG_1), u32Attr(unix.NFTA_META_KEY, key))
+}
+
+func exprPayload(base, offset, length uint32) []byte {
+	return expr("payload",
+		u32Attr(unix.NFTA_PAYLOAD_DREG, unix.NFT_REG_1),
+		u32Attr(unix.NFTA_PAYLOAD_BASE, base),
+		u32Attr(unix.NFTA_PAYLOAD_OFFSET, offset),
+		u32Attr(unix.NFTA_PAYLOAD_LEN, length),
+	)
+}
+
+func exprCmp(op uint32, data []byte) []byte {
+	return expr("cmp",
+		u32Attr(unix.NFTA_CMP_SREG, unix.NFT_REG_1),
+		u32Attr(unix.NFTA_CMP_OP, op),
+		nested(unix.NFTA_CMP_DATA, attr(unix.NFTA_DATA_VALUE, data)),
+	)
+}
+
+func exprBitwise(mask []byte) []byte {
+	return expr("bitwise",
+		u32Attr(unix.NFTA_BITWISE_SREG, unix.NFT_REG_1),
+		u32Attr(unix.NFTA_BITWISE_DREG, unix.NFT_REG_1),
+		u32Attr(unix.NFTA_BITWISE_LEN, uint32(len(mask))),
+		nested(unix.NFTA_BITWISE_MASK, attr(unix.NFTA_DATA_VALUE, mask)),
+		nested(unix.NFTA_BITWISE_XOR, attr(unix.NFTA_DATA_VALUE, make([]byte, len(mask)))),
+	)
+}
+
+func exprImmediate(reg uint32, data []byte) []byte {
+	return expr("immediate",
+		u32Attr(unix.NFTA_IMMEDIATE_DREG, reg),
+		nested(unix.NFTA_IMMEDIATE_DATA, attr(unix.NFTA_DATA_VALUE, data)),
+	)
+}
+
+func exprVerdict(code uint32) []byte {
+	return expr("immediate",
+		u32Attr(unix.NFTA_IMMEDIATE_DREG, unix.NFT_REG_VERDICT),
+		nested(unix.NFTA_IMMEDIATE_DATA, nested(unix.NFTA_DATA_VERDICT, u32Attr(unix.NFTA_VERDICT_CODE, code))),
+	)
+}
+
+// attr encodes a netlink attribute padded to the 4-byte alignment.
+func attr(typ uint16, data []byte) []byte {
+	length := unix.SizeofNlAttr + len(data)
+	out := make([]byte, (length+unix.NLA_ALIGNTO-1) & ^(unix.NLA_ALIGNTO-1))
+	binary.NativeEndian.PutUint16(out[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(out[2:4], typ)
+	copy(out[unix.SizeofNlAttr:], data)
+	return out
+}
+
+func nested(typ uint16, attrs ...[]byte) []byte {
+	return attr(typ|unix.NLA_F_NESTED, bytes.Join(attrs, nil))
+}
+
+func strAttr(typ uint16, value string) []byte {
+	return attr(typ, append([]byte(value), 0))
+}
+
+// u32Attr encodes a 32-bit attribute; nf_tables expects network byte order.
+func u32Attr(typ uint16, value uint32) []byte {
+	return attr(typ, binary.BigEndian.AppendUint32(nil, value))
+}
+
+func be16(value int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(value))
+}
+
+// nftCommit sends a transaction and waits for the kernel's verdict. Only the
+// last message asks for an acknowledgement: the kernel reports every
+// rejected message before it, so the transaction succeeded when that
+// acknowledgement arrives without an error in front of it.
+func nftCommit(msgs [][]byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("opening nftables netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("binding nftables netlink socket: %w", err)
+	}
+	timeout := unix.NsecToTimeval(nftReplyTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		return fmt.Errorf("setting nftables reply timeout: %w", err)
+	}
+
+	last := msgs[len(msgs)-2]
+	flags := binary.NativeEndian.Uint16(last[6:8])
+	binary.NativeEndian.PutUint16(last[6:8], flags|unix.NLM_F_ACK)
+	lastSeq := binary.NativeEndian.Uint32(last[8:12])
+
+	batch := bytes.Join(msgs, nil)
+	// A whole ruleset is one datagram; large ones need a larger buffer.
+	_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUFFORCE, len(batch))
+	if err := unix.Sendto(fd, batch, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("sending nftables transaction: %w", err)
+	}
+
+	var rejected error
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("reading nftables reply: %w", err)
+		}
+		replies, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("parsing nftables reply: %w", err)
+		}
+		for _, reply := range replies {
+			if reply.Header.Type != unix.NLMSG_ERROR || len(reply.Data) < 4 {
+				continue
+			}
+			if code := int32(binary.NativeEndian.Uint32(reply.Data[:4])); code != 0 && rejected == nil {
+				rejected = fmt.Errorf("nftables transaction rejected at message %d: %w", reply.Header.Seq, syscall.Errno(-code))
+			}
+			if reply.Header.Seq == lastSeq {
+				return rejected
+			}
+		}
+	}
+}
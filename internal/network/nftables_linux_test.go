@@ -0,0 +1,214 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// nftMessageTypes decodes a transaction into its nf_tables message types and
+// address families.
+func nftMessageTypes(t *testing.T, msgs [][]byte) ([]uint16, []uint8) {
+	t.Helper()
+	parsed, err := syscall.ParseNetlinkMessage(bytes.Join(msgs, nil))
+	if err != nil {
+		t.Fatalf("transaction is not valid netlink: %v", err)
+	}
+	var types []uint16
+	var families []uint8
+	for i, msg := range parsed {
+		if msg.Header.Seq != uint32(i+1) {
+			t.Fatalf("message %d has sequence %d", i, msg.Header.Seq)
+		}
+		types = append(types, msg.Header.Type)
+		families = append(families, msg.Data[0])
+	}
+	return types, families
+}
+
+func TestNFTRulesetReplacesTablesInOneTransaction(t *testing.T) {
+	t.Parallel()
+
+	msgs, err := nftRuleset(Ruleset{
+		Masquerades:  []Masquerade{{Subnet: "172.16.0.0/24", OutInterface: "eth0"}},
+		PortForwards: []PortForward{{InInterface: "eth0", HostIP: "10.0.0.5", HostPort: 8080, GuestIP: "172.16.0.2", GuestPort: 80}},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, families := nftMessageTypes(t, msgs)
+
+	nft := func(msg uint16) uint16 { return unix.NFNL_SUBSYS_NFTABLES<<8 | msg }
+	want := []uint16{
+		unix.NFNL_MSG_BATCH_BEGIN,
+		nft(unix.NFT_MSG_NEWTABLE), nft(unix.NFT_MSG_DELTABLE),
+		nft(unix.NFT_MSG_NEWTABLE), nft(unix.NFT_MSG_DELTABLE),
+		nft(unix.NFT_MSG_NEWTABLE),
+		nft(unix.NFT_MSG_NEWCHAIN), nft(unix.NFT_MSG_NEWCHAIN),
+		nft(unix.NFT_MSG_NEWRULE), nft(unix.NFT_MSG_NEWRULE),
+		unix.NFNL_MSG_BATCH_END,
+	}
+	if len(types) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(types), len(want))
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("message %d has type %#x, want %#x", i, types[i], want[i])
+		}
+	}
+	if families[3] != unix.NFPROTO_BRIDGE || families[5] != unix.NFPROTO_INET {
+		t.Fatalf("without isolation only the inet table is recreated, got families %v", families)
+	}
+
+	dnat := msgs[8]
+	guest := append([]byte{172, 16, 0, 2}, binary.BigEndian.AppendUint16(nil, 80)...)
+	if !bytes.Contains(dnat, guest[:4]) || !bytes.Contains(dnat, guest[4:]) || !bytes.Contains(dnat, []byte("nat\x00")) {
+		t.Fatal("port forward rule does not DNAT to the guest")
+	}
+}
+
+func TestNFTRulesetIsolatesTenantsOnBridge(t *testing.T) {
+	t.Parallel()
+
+	msgs, err := nftRuleset(Ruleset{Isolation: &Isolation{
+		Tenants: map[string]string{"tap-a1": "acme", "tap-a2": "acme", "tap-g1": "globex"},
+		Links:   []Link{{FromTAP: "tap-g1", ToTAP: "tap-a1", Port: 5432}},
+	}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, families := nftMessageTypes(t, msgs)
+
+	var rules [][]byte
+	for i, msg := range msgs {
+		if families[i] == unix.NFPROTO_BRIDGE && binary.NativeEndian.Uint16(msg[4:6]) == unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_NEWRULE {
+			rules = append(rules, msg)
+		}
+	}
+	// ARP, established flows, two link rules (TCP and UDP), two acme pairs,
+	// and the final drop.
+	if len(rules) != 7 {
+		t.Fatalf("got %d bridge rules, want 7", len(rules))
+	}
+	ifname := func(name string) []byte {
+		padded := make([]byte, unix.IFNAMSIZ)
+		copy(padded, name)
+		return padded
+	}
+	for _, rule := range rules[4:6] {
+		if !bytes.Contains(rule, ifname("tap-a1")) || !bytes.Contains(rule, ifname("tap-a2")) {
+			t.Fatal("tenant pair rule must match both acme TAPs")
+		}
+	}
+	if bytes.Contains(rules[6], []byte("cmp\x00")) {
+		t.Fatal("last bridge rule must drop unconditionally")
+	}
+}
+
+func TestNFTRulesetLinkRepliesNeedEstablishedFlow(t *testing.T) {
+	t.Parallel()
+
+	msgs, err := nftRuleset(Ruleset{Isolation: &Isolation{
+		Tenants: map[string]string{"tap-a1": "acme", "tap-g1": "globex"},
+		Links:   []Link{{FromTAP: "tap-g1", ToTAP: "tap-a1", Port: 5432}},
+	}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifname := func(name string) []byte {
+		padded := make([]byte, unix.IFNAMSIZ)
+		copy(padded, name)
+		return padded
+	}
+	sawCt := false
+	for _, msg := range msgs {
+		if binary.NativeEndian.Uint16(msg[4:6]) != unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_NEWRULE || msg[unix.NLMSG_HDRLEN] != unix.NFPROTO_BRIDGE {
+			continue
+		}
+		// A packet from the link target back to the source, even from port
+		// 5432, must only pass as part of a flow the source opened.
+		from, to := bytes.Index(msg, ifname("tap-a1")), bytes.Index(msg, ifname("tap-g1"))
+		if from >= 0 && to >= 0 && from < to {
+			t.Fatal("a rule accepts new flows from the link target to the source")
+		}
+		if bytes.Contains(msg, []byte("ct\x00")) {
+			sawCt = true
+			state := binary.NativeEndian.AppendUint32(nil, ctStateEstablished|ctStateRelated)
+			if !bytes.Contains(msg, state) || from >= 0 || to >= 0 {
+				t.Fatal("conntrack rule must only accept established and related flows")
+			}
+		}
+	}
+	if !sawCt {
+		t.Fatal("replies of linked flows are not accepted by conntrack")
+	}
+}
+
+func TestNFTRulesetLinkRepliesBySourcePortWithoutBridgeConntrack(t *testing.T) {
+	t.Parallel()
+
+	msgs, err := nftRuleset(Ruleset{Isolation: &Isolation{
+		Tenants: map[string]string{"tap-a1": "acme", "tap-g1": "globex"},
+		Links:   []Link{{FromTAP: "tap-g1", ToTAP: "tap-a1", Port: 5432}},
+	}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifname := func(name string) []byte {
+		padded := make([]byte, unix.IFNAMSIZ)
+		copy(padded, name)
+		return padded
+	}
+	replies := 0
+	for _, msg := range msgs {
+		if binary.NativeEndian.Uint16(msg[4:6]) != unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_NEWRULE || msg[unix.NLMSG_HDRLEN] != unix.NFPROTO_BRIDGE {
+			continue
+		}
+		if bytes.Contains(msg, []byte("ct\x00")) {
+			t.Fatal("conntrack rule installed although bridge conntrack is unavailable")
+		}
+		from, to := bytes.Index(msg, ifname("tap-a1")), bytes.Index(msg, ifname("tap-g1"))
+		if from >= 0 && to >= 0 && from < to {
+			replies++
+		}
+	}
+	// TCP and UDP replies from the link target's port back to the source.
+	if replies != 2 {
+		t.Fatalf("got %d reply rules, want 2", replies)
+	}
+}
+
+func TestNFTRulesetForwardsIPv6(t *testing.T) {
+	t.Parallel()
+
+	msgs, err := nftRuleset(Ruleset{
+		Masquerades:  []Masquerade{{Subnet: "fd00:f1::/64", OutInterface: "eth0"}},
+		PortForwards: []PortForward{{InInterface: "eth0", HostIP: "2001:db8::5", HostPort: 8080, GuestIP: "fd00:f1::2", GuestPort: 80}},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 11 {
+		t.Fatalf("got %d messages, want 11", len(msgs))
+	}
+	dnat, masq := msgs[8], msgs[9]
+	if !bytes.Contains(dnat, net.ParseIP("fd00:f1::2").To16()) || !bytes.Contains(dnat, net.ParseIP("2001:db8::5").To16()) {
+		t.Fatal("IPv6 port forward rule does not match the host and DNAT to the guest")
+	}
+	if !bytes.Contains(masq, net.ParseIP("fd00:f1::").To16()) {
+		t.Fatal("IPv6 masquerade rule does not match the guest subnet")
+	}
+}
+
+func TestNFTRulesetRejectsMixedFamilyPortForward(t *testing.T) {
+	t.Parallel()
+
+	_, err := nftRuleset(Ruleset{PortForwards: []PortForward{{InInterface: "eth0", HostIP: "10.0.0.5", HostPort: 80, GuestIP: "fd00::2", GuestPort: 80}}}, true)
+	if err == nil {
+		t.Fatal("expected an error for an IPv6 guest behind an IPv4 host address")
+	}
+}
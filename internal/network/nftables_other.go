@@ -0,0 +1,12 @@
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"log/slog"
+)
+
+func newNFTablesFirewall(*slog.Logger) (Firewall, error) {
+	return nil, errors.New("the nftables firewall backend requires Linux")
+}
@@ -3,8 +3,11 @@ package network
 import (
 	"fmt"
 	"log/slog"
+	"net"
 	"os/exec"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/artemnikitin/firework/internal/config"
 )
@@ -13,12 +16,37 @@ import (
 // It creates TAP devices and optionally bridges them to a host interface.
 type Manager struct {
 	logger     *slog.Logger
-	bridgeName string // shared bridge name, set by InitBridge
+	links      linkAPI
+	bridgeName string            // shared bridge name, set by InitBridge
+	networks   map[string]string // additional network → bridge, set by AddNetwork
+
+	mu           sync.Mutex
+	firewall     Firewall
+	masquerades  []Masquerade
+	portForwards map[portForwardKey]PortForward
+	isolation    *isolation // tenant segments, set by EnableTenantIsolation
+	ipv6         bool       // guests are dual-stack, set by InitBridgeIPv6
 }
 
-// NewManager creates a new network manager.
+// portForwardKey identifies a port forward independently of the host
+// ingress context it was installed with.
+type portForwardKey struct {
+	hostPort  int
+	guestIP   string
+	guestPort int
+}
+
+// NewManager creates a new network manager. Devices, addresses and routes are
+// managed over rtnetlink; firewall rules go through iptables unless
+// SetFirewall selects another backend.
 func NewManager(logger *slog.Logger) *Manager {
-	return &Manager{logger: logger}
+	return &Manager{
+		logger:       logger,
+		links:        newLinkAPI(),
+		firewall:     newIPTablesFirewall(logger),
+		portForwards: make(map[portForwardKey]PortForward),
+		networks:     make(map[string]string),
+	}
 }
 
 // InitBridge creates a shared bridge for all VMs, assigns the gateway IP,
@@ -32,51 +60,115 @@ func (m *Manager) InitBridge(name, gatewayIP, subnet string) error {
 	// break health checks with "no route to host".
 	m.cleanupLegacyBridge(name, "br-firework")
 
-	if !deviceExists(name) {
-		if err := run("ip", "link", "add", "name", name, "type", "bridge"); err != nil {
+	if err := m.createGatewayBridge(name, gatewayIP, subnet); err != nil {
+		return err
+	}
+
+	// Enable IP forwarding globally.
+	if err := run("sysctl", "-w", "net.ipv4.ip_forward=1"); err != nil {
+		m.logger.Warn("failed to enable ip_forward", "error", err)
+	}
+
+	m.bridgeName = name
+	return nil
+}
+
+// InitBridgeIPv6 makes the shared bridge dual-stack: it assigns the IPv6
+// gateway, pins the IPv6 guest subnet to the bridge, and enables IPv6
+// forwarding. It must be called after InitBridge.
+func (m *Manager) InitBridgeIPv6(gatewayIP, subnet string) error {
+	if m.bridgeName == "" {
+		return fmt.Errorf("shared bridge is not initialized")
+	}
+	m.logger.Info("adding IPv6 to shared bridge", "bridge", m.bridgeName, "gateway", gatewayIP)
+
+	gatewayCIDR := gatewayIP + "/" + subnetMask(subnet)
+	ip, ipNet, err := net.ParseCIDR(gatewayCIDR)
+	if err != nil || ip.To4() != nil {
+		return fmt.Errorf("invalid IPv6 gateway address %s", gatewayCIDR)
+	}
+	if err := m.links.AddAddress(m.bridgeName, &net.IPNet{IP: ip, Mask: ipNet.Mask}); err != nil {
+		return fmt.Errorf("assigning IPv6 gateway IP: %w", err)
+	}
+	if err := m.pinSubnetRoute(m.bridgeName, gatewayIP, subnet); err != nil {
+		m.logger.Warn("failed to pin IPv6 vm subnet route to bridge",
+			"bridge", m.bridgeName, "subnet", subnet, "error", err)
+	}
+
+	// Forwarding hosts ignore router advertisements unless accept_ra is 2,
+	// so keep the uplink's RA-learned default route before enabling it.
+	if out, err := m.links.DefaultRouteInterface(true); err == nil {
+		if err := run("sysctl", "-w", fmt.Sprintf("net.ipv6.conf.%s.accept_ra=2", out)); err != nil {
+			m.logger.Warn("failed to keep accepting router advertisements", "interface", out, "error", err)
+		}
+	}
+	if err := run("sysctl", "-w", "net.ipv6.conf.all.forwarding=1"); err != nil {
+		m.logger.Warn("failed to enable IPv6 forwarding", "error", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ipv6 = true
+	if m.isolation != nil {
+		return m.syncFirewall()
+	}
+	return nil
+}
+
+// AddNetwork creates the bridge of an additional guest network and assigns
+// its gateway. Setup attaches services that list the network to it.
+func (m *Manager) AddNetwork(network, bridgeName, gatewayIP, subnet string) error {
+	m.logger.Info("initializing network bridge", "network", network, "bridge", bridgeName, "gateway", gatewayIP)
+
+	if err := m.createGatewayBridge(bridgeName, gatewayIP, subnet); err != nil {
+		return err
+	}
+	m.networks[network] = bridgeName
+	return nil
+}
+
+// createGatewayBridge creates a bridge holding the gateway of subnet and
+// routes the subnet to it.
+func (m *Manager) createGatewayBridge(name, gatewayIP, subnet string) error {
+	if !m.deviceExists(name) {
+		if err := m.links.AddBridge(name); err != nil {
 			return fmt.Errorf("creating bridge %s: %w", name, err)
 		}
 	}
 
 	// Assign gateway IP (with subnet mask).
 	gatewayCIDR := gatewayIP + "/" + subnetMask(subnet)
-	if err := run("ip", "addr", "add", gatewayCIDR, "dev", name); err != nil {
-		// Ignore "already exists" errors.
-		if !strings.Contains(err.Error(), "RTNETLINK answers: File exists") {
-			return fmt.Errorf("assigning gateway IP: %w", err)
-		}
+	ip, ipNet, err := net.ParseCIDR(gatewayCIDR)
+	if err != nil {
+		return fmt.Errorf("parsing gateway address %s: %w", gatewayCIDR, err)
+	}
+	if err := m.links.AddAddress(name, &net.IPNet{IP: ip, Mask: ipNet.Mask}); err != nil {
+		return fmt.Errorf("assigning gateway IP: %w", err)
 	}
 
-	if err := run("ip", "link", "set", name, "up"); err != nil {
+	if err := m.links.SetLinkUp(name); err != nil {
 		return fmt.Errorf("bringing bridge up: %w", err)
 	}
 
-	// Explicitly pin vm_subnet route to this bridge so stale bridges/routes
+	// Explicitly pin the subnet route to this bridge so stale bridges/routes
 	// cannot steal traffic to guest IPs.
 	if err := m.pinSubnetRoute(name, gatewayIP, subnet); err != nil {
 		m.logger.Warn("failed to pin vm subnet route to bridge",
 			"bridge", name, "subnet", subnet, "error", err)
 	}
-
-	// Enable IP forwarding globally.
-	if err := run("sysctl", "-w", "net.ipv4.ip_forward=1"); err != nil {
-		m.logger.Warn("failed to enable ip_forward", "error", err)
-	}
-
-	m.bridgeName = name
 	return nil
 }
 
 func (m *Manager) cleanupLegacyBridge(desired, legacy string) {
-	if legacy == "" || legacy == desired || !deviceExists(legacy) {
+	if legacy == "" || legacy == desired || !m.deviceExists(legacy) {
 		return
 	}
 
 	m.logger.Warn("removing legacy bridge to avoid route conflicts",
 		"legacy_bridge", legacy, "active_bridge", desired)
 
-	_ = run("ip", "link", "set", legacy, "down")
-	if err := run("ip", "link", "del", legacy); err != nil {
+	_ = m.links.SetLinkDown(legacy)
+	if err := m.links.DeleteLink(legacy); err != nil {
 		m.logger.Warn("failed to delete legacy bridge", "bridge", legacy, "error", err)
 	}
 }
@@ -87,58 +179,78 @@ func (m *Manager) pinSubnetRoute(bridgeName, gatewayIP, subnet string) error {
 		src = src[:idx]
 	}
 
-	args := []string{"route", "replace", subnet, "dev", bridgeName}
-	if src != "" {
-		args = append(args, "src", src)
+	_, dst, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("parsing subnet %s: %w", subnet, err)
 	}
-
-	if err := run("ip", args...); err != nil {
-		return fmt.Errorf("ip %s: %w", strings.Join(args, " "), err)
+	if err := m.links.ReplaceRoute(dst, bridgeName, net.ParseIP(src)); err != nil {
+		return fmt.Errorf("replacing route %s dev %s: %w", subnet, bridgeName, err)
 	}
 	return nil
 }
 
-// SetupPortForward creates an iptables DNAT rule forwarding traffic from
-// a host port to a VM port on the given guest IP.
+// SetupPortForward forwards traffic from a host port to a VM port on the
+// given guest IP. An IPv6 guest IP is reached through the host's IPv6
+// address.
 func (m *Manager) SetupPortForward(hostPort int, guestIP string, vmPort int) error {
 	m.logger.Info("setting up port forward", "host_port", hostPort, "guest_ip", guestIP, "vm_port", vmPort)
 
+	ip := net.ParseIP(guestIP)
+	if ip == nil {
+		return fmt.Errorf("invalid guest IP %q", guestIP)
+	}
+
 	// Scope DNAT to traffic arriving on the external interface and addressed
 	// to the host IP. This avoids hijacking guest-to-peer traffic that also
 	// targets the same host port (e.g. cross-node ES transport on 19300).
-	outInterface, hostIP, err := m.resolveHostIngressContext()
+	outInterface, hostIP, err := m.resolveHostIngressContext(ip.To4() == nil)
 	if err != nil {
 		return fmt.Errorf("resolving host ingress context: %w", err)
 	}
 
-	spec := scopedPortForwardSpec(outInterface, hostIP, hostPort, guestIP, vmPort)
-	if err := ensureIPTablesRule("nat", "PREROUTING", spec...); err != nil {
-		return fmt.Errorf("adding scoped port-forward rule: %w", err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.portForwards[portForwardKey{hostPort, guestIP, vmPort}] = PortForward{
+		InInterface: outInterface,
+		HostIP:      hostIP,
+		HostPort:    hostPort,
+		GuestIP:     guestIP,
+		GuestPort:   vmPort,
+	}
+	if err := m.syncFirewall(); err != nil {
+		return fmt.Errorf("adding port forward: %w", err)
 	}
 	return nil
 }
 
-// TeardownPortForward removes the iptables DNAT rule for a port forward.
+// TeardownPortForward removes the forwarding rule of a port forward.
 func (m *Manager) TeardownPortForward(hostPort int, guestIP string, vmPort int) error {
 	m.logger.Info("tearing down port forward", "host_port", hostPort, "guest_ip", guestIP, "vm_port", vmPort)
 
-	var errs []error
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := portForwardKey{hostPort, guestIP, vmPort}
+	_, known := m.portForwards[key]
+	delete(m.portForwards, key)
 
-	// Remove scoped rule first (new behavior).
-	if outInterface, hostIP, err := m.resolveHostIngressContext(); err == nil {
-		spec := scopedPortForwardSpec(outInterface, hostIP, hostPort, guestIP, vmPort)
-		if err := removeIPTablesRule("nat", "PREROUTING", spec...); err != nil {
-			errs = append(errs, fmt.Errorf("removing scoped port-forward rule: %w", err))
-		}
-	} else {
-		m.logger.Warn("failed to resolve host ingress context for scoped cleanup, trying legacy rule only",
-			"host_port", hostPort, "error", err)
+	var errs []error
+	if err := m.syncFirewall(); err != nil {
+		errs = append(errs, err)
 	}
 
-	// Backward-compatible cleanup for older unscoped rules.
-	legacySpec := legacyPortForwardSpec(hostPort, guestIP, vmPort)
-	if err := removeIPTablesRule("nat", "PREROUTING", legacySpec...); err != nil {
-		errs = append(errs, fmt.Errorf("removing legacy port-forward rule: %w", err))
+	// A forward set up by an earlier agent process is unknown here. Backends
+	// that keep no ruleset of their own still have to find and remove it.
+	if remover, ok := m.firewall.(portForwardRemover); ok && !known {
+		pf := PortForward{HostPort: hostPort, GuestIP: guestIP, GuestPort: vmPort}
+		if outInterface, hostIP, err := m.resolveHostIngressContext(strings.Contains(guestIP, ":")); err == nil {
+			pf.InInterface, pf.HostIP = outInterface, hostIP
+		} else {
+			m.logger.Warn("failed to resolve host ingress context for scoped cleanup, trying legacy rule only",
+				"host_port", hostPort, "error", err)
+		}
+		if err := remover.RemovePortForward(pf); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	if len(errs) > 0 {
@@ -147,114 +259,18 @@ func (m *Manager) TeardownPortForward(hostPort int, guestIP string, vmPort int)
 	return nil
 }
 
-func scopedPortForwardSpec(outInterface, hostIP string, hostPort int, guestIP string, vmPort int) []string {
-	return []string{
-		"-i", outInterface,
-		"-d", hostIP + "/32",
-		"-p", "tcp",
-		"-m", "tcp",
-		"--dport", fmt.Sprintf("%d", hostPort),
-		"-j", "DNAT",
-		"--to-destination", fmt.Sprintf("%s:%d", guestIP, vmPort),
-	}
-}
-
-func legacyPortForwardSpec(hostPort int, guestIP string, vmPort int) []string {
-	return []string{
-		"-p", "tcp",
-		"-m", "tcp",
-		"--dport", fmt.Sprintf("%d", hostPort),
-		"-j", "DNAT",
-		"--to-destination", fmt.Sprintf("%s:%d", guestIP, vmPort),
-	}
-}
-
-func (m *Manager) resolveHostIngressContext() (string, string, error) {
-	routeOut, err := runOutput("ip", "route", "show", "default")
+// resolveHostIngressContext returns the interface of the default route and
+// its global address, of the IPv6 family with ipv6.
+func (m *Manager) resolveHostIngressContext(ipv6 bool) (string, string, error) {
+	outInterface, err := m.links.DefaultRouteInterface(ipv6)
 	if err != nil {
 		return "", "", fmt.Errorf("detecting default route: %w", err)
 	}
-	outInterface, err := parseDefaultRouteInterface(routeOut)
-	if err != nil {
-		return "", "", err
-	}
-
-	addrOut, err := runOutput("ip", "-4", "-o", "addr", "show", "dev", outInterface, "scope", "global")
-	if err != nil {
-		return "", "", fmt.Errorf("detecting host IPv4 on %s: %w", outInterface, err)
-	}
-	hostIP, err := parseInterfaceIPv4(addrOut)
+	hostIP, err := m.links.InterfaceAddr(outInterface, ipv6)
 	if err != nil {
-		return "", "", fmt.Errorf("parsing host IPv4 on %s: %w", outInterface, err)
+		return "", "", fmt.Errorf("detecting host address on %s: %w", outInterface, err)
 	}
-	return outInterface, hostIP, nil
-}
-
-func parseDefaultRouteInterface(output string) (string, error) {
-	for _, line := range strings.Split(output, "\n") {
-		fields := strings.Fields(strings.TrimSpace(line))
-		if len(fields) == 0 || fields[0] != "default" {
-			continue
-		}
-		for i := 0; i < len(fields)-1; i++ {
-			if fields[i] == "dev" && fields[i+1] != "" {
-				return fields[i+1], nil
-			}
-		}
-	}
-	return "", fmt.Errorf("default route interface not found in output: %q", strings.TrimSpace(output))
-}
-
-func parseInterfaceIPv4(output string) (string, error) {
-	for _, line := range strings.Split(output, "\n") {
-		fields := strings.Fields(strings.TrimSpace(line))
-		for i := 0; i < len(fields)-1; i++ {
-			if fields[i] != "inet" {
-				continue
-			}
-			cidr := fields[i+1]
-			ip := cidr
-			if slash := strings.Index(cidr, "/"); slash != -1 {
-				ip = cidr[:slash]
-			}
-			if ip != "" {
-				return ip, nil
-			}
-		}
-	}
-	return "", fmt.Errorf("IPv4 address not found in output: %q", strings.TrimSpace(output))
-}
-
-func ensureIPTablesRule(table, chain string, spec ...string) error {
-	checkArgs := append([]string{"-t", table, "-C", chain}, spec...)
-	if err := run("iptables", checkArgs...); err == nil {
-		return nil
-	} else if !isRuleMissingError(err) {
-		return fmt.Errorf("checking existing iptables rule: %w", err)
-	}
-
-	addArgs := append([]string{"-t", table, "-A", chain}, spec...)
-	if err := run("iptables", addArgs...); err != nil {
-		return err
-	}
-	return nil
-}
-
-func removeIPTablesRule(table, chain string, spec ...string) error {
-	delArgs := append([]string{"-t", table, "-D", chain}, spec...)
-	if err := run("iptables", delArgs...); err != nil && !isRuleMissingError(err) {
-		return err
-	}
-	return nil
-}
-
-func isRuleMissingError(err error) bool {
-	if err == nil {
-		return false
-	}
-	msg := err.Error()
-	return strings.Contains(msg, "No chain/target/match by that name") ||
-		strings.Contains(msg, "Bad rule (does a matching rule exist in that chain?)")
+	return outInterface, hostIP.String(), nil
 }
 
 // Setup creates the network devices needed for a service's microVM.
@@ -264,10 +280,7 @@ func (m *Manager) Setup(svc config.ServiceConfig) error {
 		return nil
 	}
 
-	tapName := svc.Network.Interface
-	if tapName == "" {
-		tapName = fmt.Sprintf("tap-%s", svc.Name)
-	}
+	tapName := TAPName(svc)
 
 	m.logger.Info("setting up network", "service", svc.Name, "tap", tapName)
 
@@ -279,10 +292,14 @@ func (m *Manager) Setup(svc config.ServiceConfig) error {
 	// Attach TAP to shared bridge if initialized, otherwise fall back to
 	// per-service bridge when a host device is specified.
 	if m.bridgeName != "" {
-		if err := run("ip", "link", "set", tapName, "master", m.bridgeName); err != nil {
+		if err := m.links.SetMaster(tapName, m.bridgeName); err != nil {
 			_ = m.deleteTAP(tapName)
 			return fmt.Errorf("attaching TAP to shared bridge: %w", err)
 		}
+		if err := m.JoinSegment(svc); err != nil {
+			_ = m.deleteTAP(tapName)
+			return fmt.Errorf("joining tenant segment: %w", err)
+		}
 	} else if svc.Network.HostDevName != "" {
 		bridgeName := fmt.Sprintf("br-%s", svc.Name)
 		if err := m.setupBridge(bridgeName, tapName, svc.Network.HostDevName); err != nil {
@@ -291,25 +308,57 @@ func (m *Manager) Setup(svc config.ServiceConfig) error {
 		}
 	}
 
+	if err := m.setupAttachments(svc); err != nil {
+		_ = m.Teardown(svc)
+		return err
+	}
+
 	m.logger.Info("network setup complete", "service", svc.Name, "tap", tapName)
 	return nil
 }
 
+// setupAttachments creates a TAP for each additional network of svc on that
+// network's bridge, in the service's tenant segment.
+func (m *Manager) setupAttachments(svc config.ServiceConfig) error {
+	for _, att := range svc.Networks {
+		bridgeName, ok := m.networks[att.Name]
+		if !ok {
+			return fmt.Errorf("network %q is not defined on this node", att.Name)
+		}
+		if att.Interface == "" {
+			return fmt.Errorf("attachment to network %s has no interface", att.Name)
+		}
+		if err := m.createTAP(att.Interface); err != nil {
+			return fmt.Errorf("creating TAP device %s: %w", att.Interface, err)
+		}
+		if err := m.links.SetMaster(att.Interface, bridgeName); err != nil {
+			return fmt.Errorf("attaching TAP to bridge %s: %w", bridgeName, err)
+		}
+	}
+	if len(svc.Networks) > 0 {
+		if err := m.JoinSegment(svc); err != nil {
+			return fmt.Errorf("joining tenant segment: %w", err)
+		}
+	}
+	return nil
+}
+
 // Teardown removes the network devices created for a service.
 func (m *Manager) Teardown(svc config.ServiceConfig) error {
 	if svc.Network == nil {
 		return nil
 	}
 
-	tapName := svc.Network.Interface
-	if tapName == "" {
-		tapName = fmt.Sprintf("tap-%s", svc.Name)
-	}
+	tapName := TAPName(svc)
 
 	m.logger.Info("tearing down network", "service", svc.Name, "tap", tapName)
 
 	var errs []error
 
+	if err := m.leaveSegment(tapName); err != nil {
+		errs = append(errs, err)
+	}
+
 	// Remove bridge if it exists.
 	if svc.Network.HostDevName != "" {
 		bridgeName := fmt.Sprintf("br-%s", svc.Name)
@@ -322,6 +371,17 @@ func (m *Manager) Teardown(svc config.ServiceConfig) error {
 	if err := m.deleteTAP(tapName); err != nil {
 		errs = append(errs, err)
 	}
+	for _, att := range svc.Networks {
+		if att.Interface == "" {
+			continue
+		}
+		if err := m.leaveSegment(att.Interface); err != nil {
+			errs = append(errs, err)
+		}
+		if err := m.deleteTAP(att.Interface); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("network teardown errors: %v", errs)
@@ -329,19 +389,28 @@ func (m *Manager) Teardown(svc config.ServiceConfig) error {
 	return nil
 }
 
+// TAPName returns the TAP device of a service: its configured interface, or
+// tap-<name> by default.
+func TAPName(svc config.ServiceConfig) string {
+	if svc.Network != nil && svc.Network.Interface != "" {
+		return svc.Network.Interface
+	}
+	return fmt.Sprintf("tap-%s", svc.Name)
+}
+
 // createTAP creates a TAP device and brings it up.
 func (m *Manager) createTAP(name string) error {
 	// Check if TAP already exists.
-	if deviceExists(name) {
+	if m.deviceExists(name) {
 		m.logger.Debug("TAP device already exists", "tap", name)
 		return nil
 	}
 
-	if err := run("ip", "tuntap", "add", "dev", name, "mode", "tap"); err != nil {
+	if err := m.links.AddTAP(name); err != nil {
 		return fmt.Errorf("creating TAP: %w", err)
 	}
 
-	if err := run("ip", "link", "set", name, "up"); err != nil {
+	if err := m.links.SetLinkUp(name); err != nil {
 		return fmt.Errorf("bringing TAP up: %w", err)
 	}
 
@@ -350,11 +419,11 @@ func (m *Manager) createTAP(name string) error {
 
 // deleteTAP removes a TAP device.
 func (m *Manager) deleteTAP(name string) error {
-	if !deviceExists(name) {
+	if !m.deviceExists(name) {
 		return nil
 	}
 
-	if err := run("ip", "link", "del", name); err != nil {
+	if err := m.links.DeleteLink(name); err != nil {
 		return fmt.Errorf("deleting TAP %s: %w", name, err)
 	}
 	return nil
@@ -363,24 +432,24 @@ func (m *Manager) deleteTAP(name string) error {
 // setupBridge creates a bridge and attaches the TAP and host device to it.
 func (m *Manager) setupBridge(bridgeName, tapName, hostDev string) error {
 	// Create bridge if it doesn't exist.
-	if !deviceExists(bridgeName) {
-		if err := run("ip", "link", "add", "name", bridgeName, "type", "bridge"); err != nil {
+	if !m.deviceExists(bridgeName) {
+		if err := m.links.AddBridge(bridgeName); err != nil {
 			return fmt.Errorf("creating bridge: %w", err)
 		}
 	}
 
 	// Attach TAP to bridge.
-	if err := run("ip", "link", "set", tapName, "master", bridgeName); err != nil {
+	if err := m.links.SetMaster(tapName, bridgeName); err != nil {
 		return fmt.Errorf("attaching TAP to bridge: %w", err)
 	}
 
 	// Attach host device to bridge.
-	if err := run("ip", "link", "set", hostDev, "master", bridgeName); err != nil {
+	if err := m.links.SetMaster(hostDev, bridgeName); err != nil {
 		return fmt.Errorf("attaching host device to bridge: %w", err)
 	}
 
 	// Bring bridge up.
-	if err := run("ip", "link", "set", bridgeName, "up"); err != nil {
+	if err := m.links.SetLinkUp(bridgeName); err != nil {
 		return fmt.Errorf("bringing bridge up: %w", err)
 	}
 
@@ -394,46 +463,42 @@ func (m *Manager) setupBridge(bridgeName, tapName, hostDev string) error {
 
 // deleteBridge removes a bridge device.
 func (m *Manager) deleteBridge(name string) error {
-	if !deviceExists(name) {
+	if !m.deviceExists(name) {
 		return nil
 	}
 
 	// Bring it down first.
-	_ = run("ip", "link", "set", name, "down")
+	_ = m.links.SetLinkDown(name)
 
-	if err := run("ip", "link", "del", name); err != nil {
+	if err := m.links.DeleteLink(name); err != nil {
 		return fmt.Errorf("deleting bridge %s: %w", name, err)
 	}
 	return nil
 }
 
-// SetupMasquerade configures iptables masquerading so VMs can reach the
-// internet through the host. The subnet should be in CIDR notation (e.g. "172.16.0.0/24").
+// SetupMasquerade configures masquerading so VMs can reach the internet
+// through the host. The subnet should be in CIDR notation (e.g. "172.16.0.0/24").
 func (m *Manager) SetupMasquerade(subnet, outInterface string) error {
 	m.logger.Info("setting up masquerade", "subnet", subnet, "interface", outInterface)
 
-	if err := run("iptables", "-t", "nat", "-A", "POSTROUTING",
-		"-s", subnet, "-o", outInterface, "-j", "MASQUERADE"); err != nil {
-		return fmt.Errorf("setting up masquerade: %w", err)
-	}
-
-	// Allow forwarding for the subnet.
-	if err := run("iptables", "-A", "FORWARD",
-		"-s", subnet, "-j", "ACCEPT"); err != nil {
-		return fmt.Errorf("allowing forward from subnet: %w", err)
-	}
-	if err := run("iptables", "-A", "FORWARD",
-		"-d", subnet, "-j", "ACCEPT"); err != nil {
-		return fmt.Errorf("allowing forward to subnet: %w", err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	masq := Masquerade{Subnet: subnet, OutInterface: outInterface}
+	if slices.Contains(m.masquerades, masq) {
+		return nil
 	}
-
-	return nil
+	m.masquerades = append(m.masquerades, masq)
+	return m.syncFirewall()
 }
 
-// deviceExists checks if a network device exists.
-func deviceExists(name string) bool {
-	err := run("ip", "link", "show", name)
-	return err == nil
+// deviceExists checks if a network device exists. A failed lookup counts
+// as missing, so the following create reports the underlying problem.
+func (m *Manager) deviceExists(name string) bool {
+	exists, err := m.links.LinkExists(name)
+	if err != nil {
+		m.logger.Debug("failed to look up network device", "device", name, "error", err)
+	}
+	return exists
 }
 
 // run executes a command and returns an error if it fails.
@@ -446,15 +511,6 @@ func run(name string, args ...string) error {
 	return nil
 }
 
-func runOutput(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("%s %s: %s: %w", name, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
-	}
-	return strings.TrimSpace(string(out)), nil
-}
-
 // subnetMask extracts the prefix length from a CIDR string.
 // e.g. "172.16.0.0/24" → "24".
 func subnetMask(cidr string) string {
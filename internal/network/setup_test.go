@@ -1,55 +1,290 @@
 package network
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
 	"strings"
 	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
 )
 
-func TestParseDefaultRouteInterface(t *testing.T) {
-	t.Parallel()
+// fakeLinks is an in-memory linkAPI.
+type fakeLinks struct {
+	devices       map[string]*fakeDevice
+	routes        map[string]string // destination → device
+	defaultRoute  string
+	defaultRoute6 string
+}
+
+type fakeDevice struct {
+	kind   string
+	up     bool
+	master string
+	addrs  []string
+}
+
+func newFakeLinks() *fakeLinks {
+	return &fakeLinks{devices: make(map[string]*fakeDevice), routes: make(map[string]string)}
+}
+
+func (f *fakeLinks) device(name string) (*fakeDevice, error) {
+	dev, ok := f.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: no such device", name)
+	}
+	return dev, nil
+}
+
+func (f *fakeLinks) add(name, kind string) error {
+	if _, ok := f.devices[name]; ok {
+		return fmt.Errorf("%s: file exists", name)
+	}
+	f.devices[name] = &fakeDevice{kind: kind}
+	return nil
+}
+
+func (f *fakeLinks) LinkExists(name string) (bool, error) {
+	_, ok := f.devices[name]
+	return ok, nil
+}
+
+func (f *fakeLinks) AddBridge(name string) error { return f.add(name, "bridge") }
+func (f *fakeLinks) AddTAP(name string) error    { return f.add(name, "tap") }
+
+func (f *fakeLinks) DeleteLink(name string) error {
+	if _, err := f.device(name); err != nil {
+		return err
+	}
+	delete(f.devices, name)
+	return nil
+}
+
+func (f *fakeLinks) SetLinkUp(name string) error   { return f.setUp(name, true) }
+func (f *fakeLinks) SetLinkDown(name string) error { return f.setUp(name, false) }
+
+func (f *fakeLinks) setUp(name string, up bool) error {
+	dev, err := f.device(name)
+	if err != nil {
+		return err
+	}
+	dev.up = up
+	return nil
+}
+
+func (f *fakeLinks) SetMaster(name, master string) error {
+	dev, err := f.device(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.device(master); err != nil {
+		return err
+	}
+	dev.master = master
+	return nil
+}
+
+func (f *fakeLinks) AddAddress(name string, addr *net.IPNet) error {
+	dev, err := f.device(name)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(dev.addrs, addr.String()) {
+		dev.addrs = append(dev.addrs, addr.String())
+	}
+	return nil
+}
+
+func (f *fakeLinks) ReplaceRoute(dst *net.IPNet, dev string, _ net.IP) error {
+	if _, err := f.device(dev); err != nil {
+		return err
+	}
+	f.routes[dst.String()] = dev
+	return nil
+}
 
-	out := "default via 10.0.100.1 dev enp3s0 proto dhcp src 10.0.100.91 metric 512"
-	iface, err := parseDefaultRouteInterface(out)
+func (f *fakeLinks) DefaultRouteInterface(ipv6 bool) (string, error) {
+	route := f.defaultRoute
+	if ipv6 {
+		route = f.defaultRoute6
+	}
+	if route == "" {
+		return "", errors.New("default route interface not found")
+	}
+	return route, nil
+}
+
+func (f *fakeLinks) InterfaceAddr(name string, ipv6 bool) (net.IP, error) {
+	dev, err := f.device(name)
 	if err != nil {
-		t.Fatalf("parseDefaultRouteInterface returned error: %v", err)
+		return nil, err
+	}
+	for _, addr := range dev.addrs {
+		if ip, _, err := net.ParseCIDR(addr); err == nil && (ip.To4() == nil) == ipv6 {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("address not found on %s", name)
+}
+
+func newFakeManager(links *fakeLinks) *Manager {
+	m := NewManager(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.links = links
+	m.SetFirewall(&recordingFirewall{})
+	return m
+}
+
+func TestSetupAttachesTAPToSharedBridge(t *testing.T) {
+	t.Parallel()
+
+	links := newFakeLinks()
+	m := newFakeManager(links)
+	if err := m.links.AddBridge("br0"); err != nil {
+		t.Fatal(err)
+	}
+	m.bridgeName = "br0"
+
+	svc := config.ServiceConfig{Name: "web", Network: &config.NetworkConfig{}}
+	if err := m.Setup(svc); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	tap := links.devices["tap-web"]
+	if tap == nil || tap.kind != "tap" || !tap.up || tap.master != "br0" {
+		t.Fatalf("unexpected TAP state %+v", tap)
+	}
+
+	// Setup is idempotent for a TAP left behind by an earlier run.
+	if err := m.Setup(svc); err != nil {
+		t.Fatalf("second Setup: %v", err)
+	}
+
+	if err := m.Teardown(svc); err != nil {
+		t.Fatalf("Teardown: %v", err)
+	}
+	if _, ok := links.devices["tap-web"]; ok {
+		t.Fatal("TAP must be deleted on teardown")
+	}
+}
+
+func TestSetupAttachesAdditionalNetworks(t *testing.T) {
+	t.Parallel()
+
+	links := newFakeLinks()
+	m := newFakeManager(links)
+	if err := m.links.AddBridge("br0"); err != nil {
+		t.Fatal(err)
+	}
+	m.bridgeName = "br0"
+	if err := m.AddNetwork("data", "br-data", "10.50.0.1", "10.50.0.0/24"); err != nil {
+		t.Fatalf("AddNetwork: %v", err)
+	}
+	if bridge := links.devices["br-data"]; bridge == nil || !bridge.up || !slices.Contains(bridge.addrs, "10.50.0.1/24") {
+		t.Fatalf("unexpected data bridge state %+v", bridge)
+	}
+
+	svc := config.ServiceConfig{
+		Name:     "db",
+		Network:  &config.NetworkConfig{},
+		Networks: []config.NetworkConfig{{Name: "data", Interface: "fwn-db"}},
+	}
+	if err := m.Setup(svc); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if links.devices["tap-db"].master != "br0" || links.devices["fwn-db"].master != "br-data" {
+		t.Fatal("each TAP must be attached to its network's bridge")
 	}
-	if iface != "enp3s0" {
-		t.Fatalf("unexpected interface: got %q, want %q", iface, "enp3s0")
+
+	if err := m.Teardown(svc); err != nil {
+		t.Fatalf("Teardown: %v", err)
+	}
+	if _, ok := links.devices["fwn-db"]; ok {
+		t.Fatal("attachment TAP must be deleted on teardown")
+	}
+
+	svc.Networks[0].Name = "unknown"
+	if err := m.Setup(svc); err == nil {
+		t.Fatal("expected an error for a network the node does not define")
+	}
+	if _, ok := links.devices["tap-db"]; ok {
+		t.Fatal("a failed Setup must not leave the default TAP behind")
 	}
 }
 
-func TestParseDefaultRouteInterfaceError(t *testing.T) {
+func TestSetupPerServiceBridge(t *testing.T) {
 	t.Parallel()
 
-	_, err := parseDefaultRouteInterface("10.0.100.0/24 dev enp3s0 proto kernel scope link")
-	if err == nil {
-		t.Fatal("expected error for output without default route, got nil")
+	links := newFakeLinks()
+	m := newFakeManager(links)
+	if err := links.add("eth1", "device"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enabling forwarding on the bridge still runs sysctl, which only warns.
+	svc := config.ServiceConfig{Name: "web", Network: &config.NetworkConfig{HostDevName: "eth1"}}
+	if err := m.Setup(svc); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	bridge := links.devices["br-web"]
+	if bridge == nil || bridge.kind != "bridge" || !bridge.up {
+		t.Fatalf("unexpected bridge state %+v", bridge)
+	}
+	if links.devices["tap-web"].master != "br-web" || links.devices["eth1"].master != "br-web" {
+		t.Fatal("TAP and host device must be attached to the per-service bridge")
+	}
+
+	if err := m.Teardown(svc); err != nil {
+		t.Fatalf("Teardown: %v", err)
+	}
+	if _, ok := links.devices["br-web"]; ok {
+		t.Fatal("bridge must be deleted on teardown")
 	}
 }
 
-func TestParseInterfaceIPv4(t *testing.T) {
+func TestResolveHostIngressContext(t *testing.T) {
 	t.Parallel()
 
-	out := strings.Join([]string{
-		"2: enp3s0    inet 10.0.100.91/24 metric 512 brd 10.0.100.255 scope global dynamic enp3s0",
-		"2: enp3s0    inet6 fe80::1234/64 scope link",
-	}, "\n")
+	links := newFakeLinks()
+	if err := links.add("enp3s0", "device"); err != nil {
+		t.Fatal(err)
+	}
+	_, ipNet, _ := net.ParseCIDR("10.0.100.0/24")
+	if err := links.AddAddress("enp3s0", &net.IPNet{IP: net.ParseIP("10.0.100.91"), Mask: ipNet.Mask}); err != nil {
+		t.Fatal(err)
+	}
+	_, ipNet6, _ := net.ParseCIDR("2001:db8:100::/64")
+	if err := links.AddAddress("enp3s0", &net.IPNet{IP: net.ParseIP("2001:db8:100::91"), Mask: ipNet6.Mask}); err != nil {
+		t.Fatal(err)
+	}
+	links.defaultRoute = "enp3s0"
+	links.defaultRoute6 = "enp3s0"
+
+	m := newFakeManager(links)
+	iface, hostIP, err := m.resolveHostIngressContext(false)
+	if err != nil {
+		t.Fatalf("resolveHostIngressContext returned error: %v", err)
+	}
+	if iface != "enp3s0" || hostIP != "10.0.100.91" {
+		t.Fatalf("unexpected ingress context: got %q %q", iface, hostIP)
+	}
 
-	ip, err := parseInterfaceIPv4(out)
+	iface, hostIP, err = m.resolveHostIngressContext(true)
 	if err != nil {
-		t.Fatalf("parseInterfaceIPv4 returned error: %v", err)
+		t.Fatalf("resolveHostIngressContext(ipv6) returned error: %v", err)
 	}
-	if ip != "10.0.100.91" {
-		t.Fatalf("unexpected ip: got %q, want %q", ip, "10.0.100.91")
+	if iface != "enp3s0" || hostIP != "2001:db8:100::91" {
+		t.Fatalf("unexpected IPv6 ingress context: got %q %q", iface, hostIP)
 	}
 }
 
-func TestParseInterfaceIPv4Error(t *testing.T) {
+func TestResolveHostIngressContextError(t *testing.T) {
 	t.Parallel()
 
-	_, err := parseInterfaceIPv4("2: enp3s0    inet6 fe80::1234/64 scope link")
-	if err == nil {
-		t.Fatal("expected error for output without inet IPv4, got nil")
+	if _, _, err := newFakeManager(newFakeLinks()).resolveHostIngressContext(false); err == nil {
+		t.Fatal("expected error without a default route, got nil")
 	}
 }
 
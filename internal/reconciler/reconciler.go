@@ -2,8 +2,10 @@ package reconciler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sort"
 	"time"
 
@@ -27,6 +29,8 @@ const (
 	ActionCreate ActionType = "create"
 	ActionUpdate ActionType = "update"
 	ActionDelete ActionType = "delete"
+	// ActionRateLimit changes the rate limits of a running VM in place.
+	ActionRateLimit ActionType = "rate_limit"
 )
 
 // VMManager abstracts VM lifecycle operations used by the Reconciler.
@@ -40,6 +44,10 @@ type volumePreflighter interface {
 	Preflight(context.Context, config.ServiceConfig) error
 }
 
+type rateLimitUpdater interface {
+	UpdateRateLimits(context.Context, config.ServiceConfig) error
+}
+
 // Reconciler compares desired state from the config store with the actual
 // state of running VMs and produces a plan to converge them.
 type Reconciler struct {
@@ -50,6 +58,12 @@ type Reconciler struct {
 	updateStrategy string
 	updateDelay    time.Duration
 	sleepFn        func(context.Context, time.Duration) error
+
+	surgeHooks    SurgeHooks
+	surgeTimeout  time.Duration
+	surgeFailures map[string]*surgeFailure
+
+	restarts *restartTracker
 }
 
 // New creates a new Reconciler. The healthMon and networkMgr parameters are
@@ -62,6 +76,8 @@ func New(vmManager VMManager, logger *slog.Logger, healthMon *healthcheck.Monito
 		logger:         logger,
 		updateStrategy: updateStrategy,
 		updateDelay:    updateDelay,
+		restarts:       newRestartTracker(),
+		surgeFailures:  make(map[string]*surgeFailure),
 		sleepFn: func(ctx context.Context, d time.Duration) error {
 			select {
 			case <-time.After(d):
@@ -74,6 +90,8 @@ func New(vmManager VMManager, logger *slog.Logger, healthMon *healthcheck.Monito
 }
 
 // Plan computes the list of actions needed to reach the desired state.
+// Services whose VM is down are only restarted as their restart policy
+// allows.
 func (r *Reconciler) Plan(desired config.NodeConfig) []Action {
 	actual := r.vmManager.List()
 	var actions []Action
@@ -83,21 +101,40 @@ func (r *Reconciler) Plan(desired config.NodeConfig) []Action {
 	for _, svc := range desired.Services {
 		desiredSet[svc.Name] = svc
 	}
+	r.restarts.retain(desiredSet)
+	for name := range r.surgeFailures {
+		if _, ok := desiredSet[name]; !ok {
+			delete(r.surgeFailures, name)
+		}
+	}
 
 	// Check for services that need to be created or updated.
 	for _, svc := range desired.Services {
 		inst, exists := actual[svc.Name]
 		if !exists {
-			actions = append(actions, Action{Type: ActionCreate, Service: svc})
+			if r.restarts.allow(svc) {
+				actions = append(actions, Action{Type: ActionCreate, Service: svc})
+			}
 			continue
 		}
-		if needsUpdate(inst, svc) {
-			prev := inst.Config
-			actions = append(actions, Action{
-				Type:            ActionUpdate,
-				Service:         svc,
-				PreviousService: &prev,
-			})
+		_, inPlace := r.vmManager.(rateLimitUpdater)
+		rateLimitsChanged := inst.Config.RateLimits != svc.RateLimits
+		prev := inst.Config
+		update := Action{Type: ActionUpdate, Service: svc, PreviousService: &prev}
+		switch {
+		case configChanged(inst.Config, svc) || (rateLimitsChanged && !inPlace):
+			// A changed config gets a fresh start.
+			r.restarts.reset(svc.Name)
+			actions = append(actions, update)
+		case needsUpdate(inst, svc):
+			r.restarts.exited(svc, inst.State)
+			if r.restarts.allow(svc) {
+				actions = append(actions, update)
+			}
+		case rateLimitsChanged:
+			actions = append(actions, Action{Type: ActionRateLimit, Service: svc})
+		default:
+			r.restarts.running(svc)
 		}
 	}
 
@@ -114,9 +151,9 @@ func (r *Reconciler) Plan(desired config.NodeConfig) []Action {
 }
 
 // Apply executes the list of reconciliation actions.
-// Uses rolling strategy if configured, otherwise applies all at once.
+// Uses rolling (or surge) strategy if configured, otherwise applies all at once.
 func (r *Reconciler) Apply(ctx context.Context, actions []Action) error {
-	if r.updateStrategy == "rolling" {
+	if r.updateStrategy == "rolling" || r.updateStrategy == StrategySurge {
 		return r.applyRolling(ctx, actions)
 	}
 	return r.applyAllAtOnce(ctx, actions)
@@ -155,11 +192,16 @@ func (r *Reconciler) applyAllAtOnce(ctx context.Context, actions []Action) error
 		case ActionDelete:
 			r.logger.Info("deleting service", "service", action.Service.Name)
 			r.deleteService(action.Service)
+
+		case ActionRateLimit:
+			if err := r.updateRateLimits(ctx, action.Service); err != nil {
+				errs = append(errs, err)
+			}
 		}
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("reconciliation had %d error(s): %v", len(errs), errs)
+		return fmt.Errorf("reconciliation had %d error(s): %w", len(errs), errors.Join(errs...))
 	}
 	return nil
 }
@@ -190,6 +232,16 @@ func (r *Reconciler) applyRolling(ctx context.Context, actions []Action) error {
 		}
 	}
 
+	// Rate limit changes do not disrupt the VM either.
+	for _, action := range actions {
+		if action.Type != ActionRateLimit {
+			continue
+		}
+		if err := r.updateRateLimits(ctx, action.Service); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	// Apply updates one at a time with delay between each.
 	var updates []Action
 	for _, action := range actions {
@@ -199,21 +251,45 @@ func (r *Reconciler) applyRolling(ctx context.Context, actions []Action) error {
 	}
 
 	for i, action := range updates {
-		r.logger.Info("updating service (stop + start)", "service", action.Service.Name)
-		if err := r.preflight(ctx, action.Service); err != nil {
-			r.logger.Error("volume preflight failed; keeping current VM running", "service", action.Service.Name, "error", err)
-			errs = append(errs, fmt.Errorf("preflight update %s: %w", action.Service.Name, err))
-			break
-		}
 		prev := action.Service
 		if action.PreviousService != nil {
 			prev = *action.PreviousService
 		}
-		r.deleteService(prev)
-		if err := r.createService(ctx, action.Service); err != nil {
-			r.logger.Error("failed to start service during update", "service", action.Service.Name, "error", err)
-			errs = append(errs, fmt.Errorf("update %s: %w", action.Service.Name, err))
-			break
+		surge := false
+		if r.updateStrategy == StrategySurge {
+			reason := r.surgeUnsupported(action.Service)
+			surge = reason == ""
+			if !surge {
+				r.logger.Info("surge not possible; falling back to stop + start",
+					"service", action.Service.Name, "reason", reason)
+			}
+		}
+		if surge {
+			if err := r.surgeBackoff(action.Service); err != nil {
+				r.logger.Info("surge update backing off; keeping current VM", "service", action.Service.Name, "reason", err.Reason)
+				errs = append(errs, fmt.Errorf("update %s: %w", action.Service.Name, err))
+				break
+			}
+			r.logger.Info("updating service (surge)", "service", action.Service.Name)
+			if err := r.surgeUpdate(ctx, prev, action.Service); err != nil {
+				r.surgeFailed(action.Service)
+				errs = append(errs, fmt.Errorf("update %s: %w", action.Service.Name, err))
+				break
+			}
+			delete(r.surgeFailures, action.Service.Name)
+		} else {
+			r.logger.Info("updating service (stop + start)", "service", action.Service.Name)
+			if err := r.preflight(ctx, action.Service); err != nil {
+				r.logger.Error("volume preflight failed; keeping current VM running", "service", action.Service.Name, "error", err)
+				errs = append(errs, fmt.Errorf("preflight update %s: %w", action.Service.Name, err))
+				break
+			}
+			r.deleteService(prev)
+			if err := r.createService(ctx, action.Service); err != nil {
+				r.logger.Error("failed to start service during update", "service", action.Service.Name, "error", err)
+				errs = append(errs, fmt.Errorf("update %s: %w", action.Service.Name, err))
+				break
+			}
 		}
 
 		// Sleep between updates, but not after the last one.
@@ -225,7 +301,7 @@ func (r *Reconciler) applyRolling(ctx context.Context, actions []Action) error {
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("reconciliation had %d error(s): %v", len(errs), errs)
+		return fmt.Errorf("reconciliation had %d error(s): %w", len(errs), errors.Join(errs...))
 	}
 	return nil
 }
@@ -237,6 +313,16 @@ func (r *Reconciler) preflight(ctx context.Context, svc config.ServiceConfig) er
 	return nil
 }
 
+// updateRateLimits applies changed rate limits to a running VM.
+func (r *Reconciler) updateRateLimits(ctx context.Context, svc config.ServiceConfig) error {
+	r.logger.Info("updating service rate limits", "service", svc.Name)
+	if err := r.vmManager.(rateLimitUpdater).UpdateRateLimits(ctx, svc); err != nil {
+		r.logger.Error("failed to update rate limits", "service", svc.Name, "error", err)
+		return fmt.Errorf("rate limits %s: %w", svc.Name, err)
+	}
+	return nil
+}
+
 // Reconcile is a convenience method that plans and applies in one step.
 func (r *Reconciler) Reconcile(ctx context.Context, desired config.NodeConfig) error {
 	actions := r.Plan(desired)
@@ -250,6 +336,7 @@ func (r *Reconciler) Reconcile(ctx context.Context, desired config.NodeConfig) e
 		"creates", countActions(actions, ActionCreate),
 		"updates", countActions(actions, ActionUpdate),
 		"deletes", countActions(actions, ActionDelete),
+		"rate_limits", countActions(actions, ActionRateLimit),
 	)
 
 	return r.Apply(ctx, actions)
@@ -270,15 +357,43 @@ func (r *Reconciler) createService(ctx context.Context, svc config.ServiceConfig
 		if r.networkMgr != nil {
 			_ = r.networkMgr.Teardown(svc)
 		}
+		r.restarts.startFailed(svc)
 		return fmt.Errorf("starting VM: %w", err)
 	}
+	r.restarts.started(svc.Name)
 
+	r.attachService(ctx, svc)
+	return nil
+}
+
+// Restore re-attaches port forwards and health checks for services whose VMs
+// were adopted after an agent restart. The VM and its TAP device survived, so
+// only the agent-side state that lived in the previous process is rebuilt.
+// Port forward setup is idempotent, so rules that survived are left as-is.
+// The TAP rejoins its tenant segment, which lived in the previous process.
+func (r *Reconciler) Restore(ctx context.Context, services []config.ServiceConfig) {
+	for _, svc := range services {
+		r.logger.Info("restoring adopted service", "service", svc.Name)
+		if r.networkMgr != nil {
+			if err := r.networkMgr.JoinSegment(svc); err != nil {
+				r.logger.Warn("failed to rejoin tenant segment", "service", svc.Name, "error", err)
+			}
+		}
+		r.attachService(ctx, svc)
+	}
+}
+
+// attachService sets up port forwards and registers the health check for a
+// service whose VM is running.
+func (r *Reconciler) attachService(ctx context.Context, svc config.ServiceConfig) {
 	// Set up port forwards.
 	if r.networkMgr != nil && svc.Network != nil && len(svc.PortForwards) > 0 {
 		for _, pf := range svc.PortForwards {
-			if err := r.networkMgr.SetupPortForward(pf.HostPort, svc.Network.GuestIP, pf.VMPort); err != nil {
-				r.logger.Warn("failed to setup port forward",
-					"service", svc.Name, "host_port", pf.HostPort, "error", err)
+			for _, guestIP := range guestAddresses(svc.Network) {
+				if err := r.networkMgr.SetupPortForward(pf.HostPort, guestIP, pf.VMPort); err != nil {
+					r.logger.Warn("failed to setup port forward",
+						"service", svc.Name, "host_port", pf.HostPort, "guest_ip", guestIP, "error", err)
+				}
 			}
 		}
 	}
@@ -287,8 +402,6 @@ func (r *Reconciler) createService(ctx context.Context, svc config.ServiceConfig
 	if r.healthMon != nil && svc.HealthCheck != nil {
 		r.healthMon.Register(ctx, svc)
 	}
-
-	return nil
 }
 
 // deleteService deregisters health checks, tears down port forwards,
@@ -302,9 +415,11 @@ func (r *Reconciler) deleteService(svc config.ServiceConfig) {
 	// Tear down port forwards before stopping VM.
 	if r.networkMgr != nil && svc.Network != nil && len(svc.PortForwards) > 0 {
 		for _, pf := range svc.PortForwards {
-			if err := r.networkMgr.TeardownPortForward(pf.HostPort, svc.Network.GuestIP, pf.VMPort); err != nil {
-				r.logger.Warn("failed to teardown port forward",
-					"service", svc.Name, "host_port", pf.HostPort, "error", err)
+			for _, guestIP := range guestAddresses(svc.Network) {
+				if err := r.networkMgr.TeardownPortForward(pf.HostPort, guestIP, pf.VMPort); err != nil {
+					r.logger.Warn("failed to teardown port forward",
+						"service", svc.Name, "host_port", pf.HostPort, "guest_ip", guestIP, "error", err)
+				}
 			}
 		}
 	}
@@ -323,10 +438,18 @@ func (r *Reconciler) deleteService(svc config.ServiceConfig) {
 }
 
 // needsUpdate compares a running instance with its desired config to
-// determine if the VM needs to be recreated.
+// determine if the VM needs to be recreated, or started again because its
+// process is no longer running.
 func needsUpdate(inst *vm.Instance, desired config.ServiceConfig) bool {
-	cur := inst.Config
+	return configChanged(inst.Config, desired) || inst.State != vm.StateRunning
+}
 
+// configChanged reports whether a VM booted from cur must be recreated to
+// run desired.
+func configChanged(cur, desired config.ServiceConfig) bool {
+	if cur.Kind != desired.Kind || cur.JobRevision != desired.JobRevision {
+		return true
+	}
 	if cur.Image != desired.Image {
 		return true
 	}
@@ -345,15 +468,18 @@ func needsUpdate(inst *vm.Instance, desired config.ServiceConfig) bool {
 	if !networkEqual(cur.Network, desired.Network) {
 		return true
 	}
+	if !slices.Equal(cur.Networks, desired.Networks) {
+		return true
+	}
 	if !portForwardsEqual(cur.PortForwards, desired.PortForwards) {
 		return true
 	}
 	if !volumesEqual(cur.Volumes, desired.Volumes) {
 		return true
 	}
-
-	// Check if the VM process is actually still running.
-	if inst.State != vm.StateRunning {
+	// The balloon device is attached at boot; its floor only steers the
+	// agent and changes without a restart.
+	if (cur.Balloon == nil) != (desired.Balloon == nil) {
 		return true
 	}
 
@@ -376,6 +502,18 @@ func volumesEqual(a, b []config.VolumeConfig) bool {
 	return true
 }
 
+// guestAddresses returns the guest's IPv4 address and, for dual-stack guests,
+// its IPv6 address. Port forwards are set up for each.
+func guestAddresses(n *config.NetworkConfig) []string {
+	var addrs []string
+	for _, addr := range []string{n.GuestIP, n.GuestIPv6} {
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 // networkEqual compares two NetworkConfig pointers for equality.
 func networkEqual(a, b *config.NetworkConfig) bool {
 	if a == nil && b == nil {
@@ -386,6 +524,7 @@ func networkEqual(a, b *config.NetworkConfig) bool {
 	}
 	return a.Interface == b.Interface &&
 		a.GuestIP == b.GuestIP &&
+		a.GuestIPv6 == b.GuestIPv6 &&
 		a.GuestMAC == b.GuestMAC &&
 		a.HostDevName == b.HostDevName
 }
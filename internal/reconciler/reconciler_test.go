@@ -9,14 +9,17 @@ import (
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/healthcheck"
 	"github.com/artemnikitin/firework/internal/vm"
 )
 
 // fakeVMManager implements VMManager for testing.
 type fakeVMManager struct {
-	instances   map[string]*vm.Instance
-	startCalls  []string
-	removeCalls []string
+	instances      map[string]*vm.Instance
+	startCalls     []string
+	removeCalls    []string
+	rateLimitCalls []string
+	startErr       error
 }
 
 func newFakeVMManager() *fakeVMManager {
@@ -33,6 +36,9 @@ func (f *fakeVMManager) List() map[string]*vm.Instance {
 
 func (f *fakeVMManager) Start(_ context.Context, svc config.ServiceConfig) error {
 	f.startCalls = append(f.startCalls, svc.Name)
+	if f.startErr != nil {
+		return f.startErr
+	}
 	f.instances[svc.Name] = &vm.Instance{Name: svc.Name, State: vm.StateRunning, Config: svc}
 	return nil
 }
@@ -43,6 +49,12 @@ func (f *fakeVMManager) Remove(name string) error {
 	return nil
 }
 
+func (f *fakeVMManager) UpdateRateLimits(_ context.Context, svc config.ServiceConfig) error {
+	f.rateLimitCalls = append(f.rateLimitCalls, svc.Name)
+	f.instances[svc.Name].Config.RateLimits = svc.RateLimits
+	return nil
+}
+
 func newTestReconciler(strategy string, delay time.Duration) *Reconciler {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	r := New(newFakeVMManager(), logger, nil, nil, strategy, delay)
@@ -130,6 +142,31 @@ func TestPlan_UpdateChangedServices(t *testing.T) {
 	}
 }
 
+func TestReconcile_RateLimitChangeAppliesInPlace(t *testing.T) {
+	r := newTestReconciler("rolling", 0)
+	fvm := r.vmManager.(*fakeVMManager)
+	running := config.ServiceConfig{Name: "svc-a", Image: "/img/a", Kernel: "/kern", VCPUs: 1, MemoryMB: 256}
+	fvm.instances["svc-a"] = &vm.Instance{Name: "svc-a", State: vm.StateRunning, Config: running}
+
+	desired := running
+	desired.RateLimits.Rootfs = config.RateLimit{BandwidthBytes: 10 * config.MiB}
+	actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{desired}})
+	if len(actions) != 1 || actions[0].Type != ActionRateLimit {
+		t.Fatalf("expected one rate_limit action, got %+v", actions)
+	}
+
+	if err := r.Apply(context.Background(), actions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fvm.rateLimitCalls) != 1 || len(fvm.startCalls) != 0 || len(fvm.removeCalls) != 0 {
+		t.Fatalf("expected an in-place update only, got rate limits %v, starts %v, removes %v",
+			fvm.rateLimitCalls, fvm.startCalls, fvm.removeCalls)
+	}
+	if actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{desired}}); len(actions) != 0 {
+		t.Fatalf("expected a converged plan, got %+v", actions)
+	}
+}
+
 func TestPlan_NoChanges(t *testing.T) {
 	svc := config.ServiceConfig{
 		Name: "svc-a", Image: "/img/a", Kernel: "/kern", VCPUs: 1, MemoryMB: 256,
@@ -611,3 +648,27 @@ func TestApply_Rolling_StopsOnContextCancel(t *testing.T) {
 		t.Errorf("expected 1 start call before cancellation, got %d", len(fvm.startCalls))
 	}
 }
+
+func TestRestore_RegistersHealthChecksWithoutStartingVMs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mon := healthcheck.NewMonitor(logger, nil)
+	defer mon.Stop()
+	fvm := newFakeVMManager()
+	r := New(fvm, logger, mon, nil, "", 0)
+
+	svc := config.ServiceConfig{Name: "adopted", Image: "/img", Kernel: "/kern", VCPUs: 1, MemoryMB: 128,
+		HealthCheck: &config.HealthCheckConfig{Type: "tcp", Target: "127.0.0.1:1", Interval: time.Hour, Timeout: time.Second}}
+	fvm.instances["adopted"] = &vm.Instance{Name: "adopted", State: vm.StateRunning, Config: svc}
+
+	r.Restore(context.Background(), []config.ServiceConfig{svc})
+
+	if len(fvm.startCalls) != 0 {
+		t.Fatalf("restore started VMs: %v", fvm.startCalls)
+	}
+	if _, ok := mon.GetResult("adopted"); !ok {
+		t.Fatal("expected health check to be registered for adopted service")
+	}
+	if actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{svc}}); len(actions) != 0 {
+		t.Fatalf("expected converged plan after restore, got %+v", actions)
+	}
+}
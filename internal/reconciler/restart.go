@@ -0,0 +1,294 @@
+package reconciler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// RestartBackoff describes a service that is down and waiting out its
+// restart backoff after consecutive failures, or that used up its restart
+// attempts.
+type RestartBackoff struct {
+	// Failures is the number of consecutive exits, failed starts and failed
+	// health checks.
+	Failures int
+	// Until is when the service is restarted next.
+	Until time.Time
+	// LimitReached is set once MaxAttempts restarts have failed. The service
+	// then stays down until its config changes, and Until is zero.
+	LimitReached bool
+	// MaxAttempts is the restart limit of the service's policy.
+	MaxAttempts int
+}
+
+// restartState is what the reconciler remembers about a service's
+// consecutive failures. It is forgotten once the VM stays up for the
+// policy's max backoff, or when the service's config changes.
+type restartState struct {
+	failures int
+	// down is set once the current exit has been counted, so a VM seen
+	// stopped on several passes counts once. failed tells a crash, failed
+	// start or failed health check from a clean exit.
+	down   bool
+	failed bool
+	// notBefore is the earliest time the VM may be started again.
+	notBefore time.Time
+	// startedAt is when the VM was last started.
+	startedAt time.Time
+}
+
+// restartTracker applies restart policies for the reconcile loop and the
+// health monitor, which run on different goroutines.
+type restartTracker struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	states map[string]*restartState
+}
+
+func newRestartTracker() *restartTracker {
+	return &restartTracker{now: time.Now, states: make(map[string]*restartState)}
+}
+
+// resolveRestartPolicy fills in the defaults a service's restart policy
+// leaves unset. Jobs are never restarted after they succeeded.
+func resolveRestartPolicy(svc config.ServiceConfig) config.RestartPolicy {
+	p := config.RestartPolicy{
+		Policy:     config.RestartAlways,
+		Backoff:    config.DefaultRestartBackoff,
+		MaxBackoff: config.DefaultRestartMaxBackoff,
+	}
+	if svc.Kind == config.KindJob {
+		p.Policy = config.RestartOnFailure
+	}
+	if svc.RestartPolicy == nil {
+		return p
+	}
+	// A job that succeeded is done, whatever its policy says.
+	if svc.RestartPolicy.Policy != "" && !(svc.Kind == config.KindJob && svc.RestartPolicy.Policy == config.RestartAlways) {
+		p.Policy = svc.RestartPolicy.Policy
+	}
+	p.MaxAttempts = svc.RestartPolicy.MaxAttempts
+	if svc.RestartPolicy.Backoff > 0 {
+		p.Backoff = svc.RestartPolicy.Backoff
+	}
+	if svc.RestartPolicy.MaxBackoff > 0 {
+		p.MaxBackoff = svc.RestartPolicy.MaxBackoff
+	}
+	p.MaxBackoff = max(p.MaxBackoff, p.Backoff)
+	return p
+}
+
+// restartDelay returns how long to wait before restarting after the given
+// number of consecutive failures. The first restart is immediate so a
+// one-off crash costs no downtime; after that the wait starts at the
+// backoff and doubles up to the max backoff.
+func restartDelay(p config.RestartPolicy, failures int) time.Duration {
+	if failures <= 1 {
+		return 0
+	}
+	delay := p.Backoff
+	for i := 2; i < failures && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxBackoff)
+}
+
+// fail counts a failure or exit of svc and schedules its next start.
+// Callers hold t.mu.
+func (t *restartTracker) fail(svc config.ServiceConfig, failed bool) {
+	st := t.states[svc.Name]
+	if st == nil {
+		st = &restartState{}
+		t.states[svc.Name] = st
+	}
+	st.failures++
+	st.down = true
+	st.failed = failed
+	st.notBefore = t.now().Add(restartDelay(resolveRestartPolicy(svc), st.failures))
+}
+
+// exited counts the exit of a VM that is no longer running, once per exit.
+func (t *restartTracker) exited(svc config.ServiceConfig, state vm.State) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st := t.states[svc.Name]; st != nil && st.down {
+		return
+	}
+	t.fail(svc, state == vm.StateFailed)
+}
+
+// startFailed counts a failed start of svc.
+func (t *restartTracker) startFailed(svc config.ServiceConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fail(svc, true)
+}
+
+// started records that svc was (re)started.
+func (t *restartTracker) started(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st := t.states[name]; st != nil {
+		st.down = false
+		st.startedAt = t.now()
+	}
+}
+
+// running forgets the failures of svc once its VM has stayed up for the
+// policy's max backoff.
+func (t *restartTracker) running(svc config.ServiceConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.states[svc.Name]
+	if st == nil || st.down {
+		return
+	}
+	if t.now().Sub(st.startedAt) >= resolveRestartPolicy(svc).MaxBackoff {
+		delete(t.states, svc.Name)
+	}
+}
+
+// allow reports whether svc may be started now. A service that never failed
+// always may.
+func (t *restartTracker) allow(svc config.ServiceConfig) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.allowLocked(svc)
+}
+
+func (t *restartTracker) allowLocked(svc config.ServiceConfig) bool {
+	st := t.states[svc.Name]
+	if st == nil || !st.down {
+		return true
+	}
+	if !restartable(resolveRestartPolicy(svc), st) {
+		return false
+	}
+	return !t.now().Before(st.notBefore)
+}
+
+// restartable reports whether the policy restarts a service at all after
+// the failures recorded in st.
+func restartable(p config.RestartPolicy, st *restartState) bool {
+	switch {
+	case p.Policy == config.RestartNever:
+		return false
+	case p.Policy == config.RestartOnFailure && !st.failed:
+		return false
+	case limitReached(p, st):
+		return false
+	}
+	return true
+}
+
+// limitReached reports whether the failures recorded in st used up the
+// policy's restart attempts.
+func limitReached(p config.RestartPolicy, st *restartState) bool {
+	return p.MaxAttempts > 0 && st.failures > p.MaxAttempts
+}
+
+// isDown reports whether the last start or run of a service failed.
+func (t *restartTracker) isDown(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.states[name]
+	return st != nil && st.down
+}
+
+// reset forgets the failures of a service, giving a changed config a fresh
+// start.
+func (t *restartTracker) reset(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, name)
+}
+
+// retain forgets the services not in desired.
+func (t *restartTracker) retain(desired map[string]config.ServiceConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name := range t.states {
+		if _, ok := desired[name]; !ok {
+			delete(t.states, name)
+		}
+	}
+}
+
+// backoffs returns the services waiting out a restart backoff and those
+// left down because their restart attempts ran out.
+func (t *restartTracker) backoffs(policies map[string]config.ServiceConfig) map[string]RestartBackoff {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	out := make(map[string]RestartBackoff)
+	for name, st := range t.states {
+		svc, ok := policies[name]
+		if !ok || !st.down {
+			continue
+		}
+		p := resolveRestartPolicy(svc)
+		// A policy that does not restart this exit at all leaves the
+		// service down by design; only a used up limit is reported.
+		unlimited := p
+		unlimited.MaxAttempts = 0
+		switch {
+		case limitReached(p, st) && restartable(unlimited, st):
+			out[name] = RestartBackoff{Failures: st.failures, LimitReached: true, MaxAttempts: p.MaxAttempts}
+		case restartable(p, st) && now.Before(st.notBefore):
+			out[name] = RestartBackoff{Failures: st.failures, Until: st.notBefore, MaxAttempts: p.MaxAttempts}
+		}
+	}
+	return out
+}
+
+// RecordHealthFailure counts a failed health check of svc's running VM and
+// reports whether it may be restarted right away. If not, the caller stops
+// the VM and the reconcile loop starts it once the backoff has passed.
+func (r *Reconciler) RecordHealthFailure(svc config.ServiceConfig) bool {
+	r.restarts.mu.Lock()
+	defer r.restarts.mu.Unlock()
+	r.restarts.fail(svc, true)
+	if !r.restarts.allowLocked(svc) {
+		return false
+	}
+	r.restarts.states[svc.Name].down = false
+	r.restarts.states[svc.Name].startedAt = r.restarts.now()
+	return true
+}
+
+// RestartsDue reports whether any of services is down and due to be
+// started under its restart policy, so a pass with an unchanged config
+// still has work to do.
+func (r *Reconciler) RestartsDue(services []config.ServiceConfig) bool {
+	actual := r.vmManager.List()
+	for _, svc := range services {
+		inst, exists := actual[svc.Name]
+		switch {
+		case exists && inst.State == vm.StateRunning:
+			continue
+		case exists:
+			r.restarts.exited(svc, inst.State)
+		case !r.restarts.isDown(svc.Name):
+			// Missing without a failed start to retry.
+			continue
+		}
+		if r.restarts.allow(svc) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestartBackoffs returns the services among desired that are down and
+// waiting out their restart backoff, keyed by name.
+func (r *Reconciler) RestartBackoffs(desired []config.ServiceConfig) map[string]RestartBackoff {
+	services := make(map[string]config.ServiceConfig, len(desired))
+	for _, svc := range desired {
+		services[svc.Name] = svc
+	}
+	return r.restarts.backoffs(services)
+}
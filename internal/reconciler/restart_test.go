@@ -0,0 +1,217 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// newRestartTestReconciler returns a reconciler on a fake VM manager and a
+// clock the test advances by hand.
+func newRestartTestReconciler() (*Reconciler, *fakeVMManager, *time.Time) {
+	r := newTestReconciler("", 0)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.restarts.now = func() time.Time { return clock }
+	return r, r.vmManager.(*fakeVMManager), &clock
+}
+
+func TestRestartDelayDoublesUpToMaxBackoff(t *testing.T) {
+	p := config.RestartPolicy{Backoff: 10 * time.Second, MaxBackoff: time.Minute}
+	want := []time.Duration{0, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := restartDelay(p, i+1); got != w {
+			t.Errorf("restartDelay after %d failures = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestReconcile_BacksOffCrashLoopingService(t *testing.T) {
+	r, vms, clock := newRestartTestReconciler()
+	svc := config.ServiceConfig{Name: "web", Image: "/img/web"}
+	desired := config.NodeConfig{Services: []config.ServiceConfig{svc}}
+	crash := func() {
+		vms.instances["web"] = &vm.Instance{Name: "web", State: vm.StateFailed, Config: svc}
+	}
+
+	crash()
+	if err := r.Reconcile(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+	if len(vms.startCalls) != 1 {
+		t.Fatalf("expected the first crash to restart immediately, got %d starts", len(vms.startCalls))
+	}
+
+	crash()
+	if err := r.Reconcile(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+	if len(vms.startCalls) != 1 {
+		t.Fatalf("expected the second crash to back off, got %d starts", len(vms.startCalls))
+	}
+	backoffs := r.RestartBackoffs(desired.Services)
+	if b := backoffs["web"]; b.Failures != 2 || !b.Until.Equal(clock.Add(config.DefaultRestartBackoff)) {
+		t.Fatalf("unexpected backoff %+v", backoffs)
+	}
+	if r.RestartsDue(desired.Services) {
+		t.Fatal("expected no restart due during the backoff")
+	}
+
+	*clock = clock.Add(config.DefaultRestartBackoff)
+	if !r.RestartsDue(desired.Services) {
+		t.Fatal("expected a restart due once the backoff passed")
+	}
+	if err := r.Reconcile(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+	if len(vms.startCalls) != 2 {
+		t.Fatalf("expected a restart after the backoff, got %d starts", len(vms.startCalls))
+	}
+	if len(r.RestartBackoffs(desired.Services)) != 0 {
+		t.Fatal("expected no backoff after the restart")
+	}
+
+	// A VM that stays up for the max backoff is considered recovered.
+	*clock = clock.Add(config.DefaultRestartMaxBackoff)
+	r.Plan(desired)
+	crash()
+	r.Plan(desired)
+	if r.restarts.states["web"].failures != 1 {
+		t.Fatalf("expected failures to be forgotten, got %d", r.restarts.states["web"].failures)
+	}
+}
+
+func TestPlan_RestartPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		state   vm.State
+		restart bool
+	}{
+		{"always restarts a clean exit", config.RestartAlways, vm.StateStopped, true},
+		{"on-failure restarts a crash", config.RestartOnFailure, vm.StateFailed, true},
+		{"on-failure leaves a clean exit", config.RestartOnFailure, vm.StateStopped, false},
+		{"never leaves a crash", config.RestartNever, vm.StateFailed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, vms, _ := newRestartTestReconciler()
+			svc := config.ServiceConfig{Name: "job", Image: "/img/job", RestartPolicy: &config.RestartPolicy{Policy: tt.policy}}
+			vms.instances["job"] = &vm.Instance{Name: "job", State: tt.state, Config: svc}
+
+			actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{svc}})
+			if got := len(actions) == 1 && actions[0].Type == ActionUpdate; got != tt.restart {
+				t.Fatalf("restart = %v, want %v (actions %+v)", got, tt.restart, actions)
+			}
+		})
+	}
+}
+
+func TestPlan_MaxAttemptsUntilConfigChanges(t *testing.T) {
+	r, vms, clock := newRestartTestReconciler()
+	svc := config.ServiceConfig{Name: "web", Image: "/img/v1", RestartPolicy: &config.RestartPolicy{MaxAttempts: 1}}
+	desired := config.NodeConfig{Services: []config.ServiceConfig{svc}}
+
+	vms.instances["web"] = &vm.Instance{Name: "web", State: vm.StateFailed, Config: svc}
+	if err := r.Reconcile(context.Background(), desired); err != nil {
+		t.Fatal(err)
+	}
+	vms.instances["web"] = &vm.Instance{Name: "web", State: vm.StateFailed, Config: svc}
+	*clock = clock.Add(time.Hour)
+	if actions := r.Plan(desired); len(actions) != 0 {
+		t.Fatalf("expected no restart past max_attempts, got %+v", actions)
+	}
+	backoff := r.RestartBackoffs(desired.Services)["web"]
+	if !backoff.LimitReached || backoff.Failures != 2 || backoff.MaxAttempts != 1 || !backoff.Until.IsZero() {
+		t.Fatalf("expected the used up restart limit to be reported, got %+v", backoff)
+	}
+
+	svc.Image = "/img/v2"
+	actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{svc}})
+	if len(actions) != 1 || actions[0].Type != ActionUpdate {
+		t.Fatalf("expected a config change to start the service, got %+v", actions)
+	}
+}
+
+func TestPlan_JobRunsOncePerRevision(t *testing.T) {
+	r, vms, _ := newRestartTestReconciler()
+	job := config.ServiceConfig{Name: "migrate", Image: "/img/migrate", Kind: config.KindJob, JobRevision: "rev-1"}
+	exitCode := 0
+	vms.instances["migrate"] = &vm.Instance{Name: "migrate", State: vm.StateStopped, Config: job, ExitCode: &exitCode}
+
+	if actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{job}}); len(actions) != 0 {
+		t.Fatalf("expected a succeeded job to stay finished, got %+v", actions)
+	}
+	// always does not apply to jobs: one that succeeded is done.
+	always := job
+	always.RestartPolicy = &config.RestartPolicy{Policy: config.RestartAlways}
+	vms.instances["migrate"].Config = always
+	if actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{always}}); len(actions) != 0 {
+		t.Fatalf("expected restart policy always to be ignored for a job, got %+v", actions)
+	}
+
+	failed := job
+	failed.Name = "seed"
+	vms.instances["seed"] = &vm.Instance{Name: "seed", State: vm.StateFailed, Config: failed}
+	actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{job, failed}})
+	if len(actions) != 1 || actions[0].Service.Name != "seed" || actions[0].Type != ActionUpdate {
+		t.Fatalf("expected only the failed job to be restarted, got %+v", actions)
+	}
+
+	delete(vms.instances, "seed")
+	next := job
+	next.JobRevision = "rev-2"
+	actions = r.Plan(config.NodeConfig{Services: []config.ServiceConfig{next}})
+	if len(actions) != 1 || actions[0].Type != ActionUpdate {
+		t.Fatalf("expected a new revision to run the job again, got %+v", actions)
+	}
+}
+
+func TestReconcile_FailedStartBacksOff(t *testing.T) {
+	r, vms, _ := newRestartTestReconciler()
+	vms.startErr = errors.New("image missing")
+	desired := config.NodeConfig{Services: []config.ServiceConfig{{Name: "web", Image: "/img/web"}}}
+
+	for range 3 {
+		_ = r.Reconcile(context.Background(), desired)
+	}
+	if len(vms.startCalls) != 2 {
+		t.Fatalf("expected one immediate retry and then a backoff, got %d starts", len(vms.startCalls))
+	}
+	if b := r.RestartBackoffs(desired.Services)["web"]; b.Failures != 2 {
+		t.Fatalf("expected 2 failures in backoff, got %+v", b)
+	}
+}
+
+func TestRecordHealthFailure(t *testing.T) {
+	r, vms, clock := newRestartTestReconciler()
+	svc := config.ServiceConfig{Name: "web", Image: "/img/web"}
+	services := []config.ServiceConfig{svc}
+
+	if !r.RecordHealthFailure(svc) {
+		t.Fatal("expected the first health failure to restart right away")
+	}
+	if r.RecordHealthFailure(svc) {
+		t.Fatal("expected the second health failure to back off")
+	}
+
+	// The agent stopped the VM; the reconciler restarts it after the backoff.
+	vms.instances["web"] = &vm.Instance{Name: "web", State: vm.StateStopped, Config: svc}
+	if r.RestartsDue(services) {
+		t.Fatal("expected no restart due during the backoff")
+	}
+	*clock = clock.Add(config.DefaultRestartBackoff)
+	if !r.RestartsDue(services) {
+		t.Fatal("expected a restart due after the backoff")
+	}
+
+	// The health failure counts as a failure even though the VM stopped
+	// cleanly, so on-failure still restarts it.
+	svc.RestartPolicy = &config.RestartPolicy{Policy: config.RestartOnFailure}
+	if actions := r.Plan(config.NodeConfig{Services: []config.ServiceConfig{svc}}); len(actions) != 1 {
+		t.Fatalf("expected on-failure to restart after a health failure, got %+v", actions)
+	}
+}
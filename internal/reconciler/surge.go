@@ -0,0 +1,214 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/healthcheck"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// StrategySurge is the update strategy that starts each replacement VM next to
+// the one it replaces and only cuts traffic over once the replacement passes
+// its health check. Updates are applied one at a time, as with "rolling".
+const StrategySurge = "surge"
+
+// surgePollInterval is how often the health monitor is consulted while waiting
+// for a replacement VM to become healthy.
+const surgePollInterval = time.Second
+
+// SurgeHooks connects surge updates to agent-owned state the reconciler does
+// not manage: guest address allocation and ingress routes.
+type SurgeHooks interface {
+	// SurgeConfig returns svc under a temporary identity (name, guest IP,
+	// MAC, TAP device, and matching kernel ip= argument) that does not
+	// collide with any running or desired VM.
+	SurgeConfig(svc config.ServiceConfig) (config.ServiceConfig, error)
+	// SwapRoute points the ingress route for svc.Name at svc's network.
+	SwapRoute(svc config.ServiceConfig) error
+}
+
+// surgeFailure is what the reconciler remembers about consecutive failed
+// surge updates of a service toward one config. It is forgotten when the
+// update succeeds or the desired config changes.
+type surgeFailure struct {
+	target    config.ServiceConfig
+	failures  int
+	notBefore time.Time
+}
+
+// vmRenamer is implemented by VM managers that can promote a replacement VM
+// to the name of the service it replaces.
+type vmRenamer interface {
+	Rename(string, config.ServiceConfig) error
+}
+
+// UpdateHealthError reports a surge update whose replacement VM never became
+// healthy, or one held back by the backoff after such failures. The previous
+// VM was left serving.
+type UpdateHealthError struct {
+	Service string
+	Reason  string
+}
+
+func (e *UpdateHealthError) Error() string {
+	return fmt.Sprintf("replacement for %s did not become healthy: %s", e.Service, e.Reason)
+}
+
+// SetSurge enables surge updates. healthTimeout bounds how long a replacement
+// VM may take to report healthy before the update is abandoned.
+func (r *Reconciler) SetSurge(hooks SurgeHooks, healthTimeout time.Duration) {
+	r.surgeHooks = hooks
+	r.surgeTimeout = healthTimeout
+}
+
+// surgeUnsupported returns why svc cannot be updated with a surge, or "" when
+// it can. Such services fall back to the rolling stop-then-start update.
+func (r *Reconciler) surgeUnsupported(svc config.ServiceConfig) string {
+	switch {
+	case r.surgeHooks == nil:
+		return "surge updates are not configured"
+	case r.healthMon == nil:
+		return "health checks are disabled"
+	case svc.HealthCheck == nil:
+		return "service has no health check"
+	case svc.HealthCheck.Target != "":
+		return "health check target is fixed and cannot observe the replacement"
+	case svc.Network == nil:
+		return "service has no guest network"
+	case len(svc.Volumes) > 0:
+		return "persistent volumes cannot be attached to two VMs"
+	case len(svc.Networks) > 0:
+		return "additional networks are not allocated for a replacement"
+	}
+	if _, ok := r.vmManager.(vmRenamer); !ok {
+		return "VM manager cannot promote a replacement VM"
+	}
+	return ""
+}
+
+// surgeUpdate replaces prev with next without a serving gap: the replacement
+// boots under a temporary identity, and only once it is healthy are the port
+// forwards and route moved to it and the previous VM removed. If the
+// replacement fails, it is removed and prev keeps serving untouched.
+//
+// The promoted VM keeps the temporary guest address; the agent treats the
+// addresses of running VMs as sticky, so the next plan sees it as converged.
+func (r *Reconciler) surgeUpdate(ctx context.Context, prev, next config.ServiceConfig) error {
+	tmp, err := r.surgeHooks.SurgeConfig(next)
+	if err != nil {
+		return fmt.Errorf("allocating surge identity: %w", err)
+	}
+	// Host ports stay with the serving VM until cut-over.
+	tmp.PortForwards = nil
+
+	r.logger.Info("starting surge replacement", "service", next.Name, "surge", tmp.Name,
+		"guest_ip", tmp.Network.GuestIP)
+	if err := r.createService(ctx, tmp); err != nil {
+		return fmt.Errorf("starting surge replacement: %w", err)
+	}
+
+	if reason := r.waitHealthy(ctx, tmp.Name); reason != "" {
+		r.logger.Error("surge replacement not healthy; keeping current VM", "service", next.Name,
+			"surge", tmp.Name, "reason", reason)
+		r.deleteService(tmp)
+		return &UpdateHealthError{Service: next.Name, Reason: reason}
+	}
+
+	promoted := tmp
+	promoted.Name = next.Name
+	promoted.PortForwards = next.PortForwards
+
+	// Cut over: new forwards first so the host port never goes unserved, then
+	// the route, and only then remove the previous VM's forwards.
+	if r.networkMgr != nil {
+		for _, pf := range promoted.PortForwards {
+			for _, guestIP := range guestAddresses(promoted.Network) {
+				if err := r.networkMgr.SetupPortForward(pf.HostPort, guestIP, pf.VMPort); err != nil {
+					r.logger.Warn("failed to setup port forward",
+						"service", promoted.Name, "host_port", pf.HostPort, "guest_ip", guestIP, "error", err)
+				}
+			}
+		}
+	}
+	if err := r.surgeHooks.SwapRoute(promoted); err != nil {
+		r.logger.Warn("failed to swap route to surge replacement", "service", promoted.Name, "error", err)
+	}
+	r.healthMon.Deregister(tmp.Name)
+	r.deleteService(prev)
+
+	if err := r.vmManager.(vmRenamer).Rename(tmp.Name, promoted); err != nil {
+		return fmt.Errorf("promoting surge replacement: %w", err)
+	}
+	r.healthMon.Register(ctx, promoted)
+	r.logger.Info("surge update complete", "service", promoted.Name, "guest_ip", promoted.Network.GuestIP)
+	return nil
+}
+
+// surgeFailed records a failed surge update of svc. The next attempt at the
+// same config waits like a restart after one more failure would: the
+// service's restart backoff, doubling up to its max backoff. A failed surge
+// can hold the reconcile pass for update_health_timeout, so it is not retried
+// on every pass.
+func (r *Reconciler) surgeFailed(svc config.ServiceConfig) {
+	st := r.surgeFailures[svc.Name]
+	if st == nil || configChanged(st.target, svc) {
+		st = &surgeFailure{target: svc}
+		r.surgeFailures[svc.Name] = st
+	}
+	st.failures++
+	st.notBefore = r.restarts.now().Add(restartDelay(resolveRestartPolicy(svc), st.failures+1))
+}
+
+// surgeBackoff returns an error while a surge update of svc must wait out
+// the backoff from earlier failures toward the same config.
+func (r *Reconciler) surgeBackoff(svc config.ServiceConfig) *UpdateHealthError {
+	st := r.surgeFailures[svc.Name]
+	if st == nil || configChanged(st.target, svc) {
+		return nil
+	}
+	wait := st.notBefore.Sub(r.restarts.now())
+	if wait <= 0 {
+		return nil
+	}
+	return &UpdateHealthError{
+		Service: svc.Name,
+		Reason:  fmt.Sprintf("%d failed attempt(s); retrying in %s", st.failures, wait.Round(time.Second)),
+	}
+}
+
+// waitHealthy polls the health monitor until name reports healthy. It returns
+// "" on success and otherwise a short reason: the VM exited, the timeout
+// elapsed, or ctx was cancelled.
+func (r *Reconciler) waitHealthy(ctx context.Context, name string) string {
+	timeout := r.surgeTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+	lastError := ""
+	for {
+		if inst := r.vmManager.List()[name]; inst == nil || inst.State != vm.StateRunning {
+			return "replacement VM exited"
+		}
+		if result, ok := r.healthMon.GetResult(name); ok {
+			if result.Status == healthcheck.StatusHealthy {
+				return ""
+			}
+			if result.LastError != "" {
+				lastError = result.LastError
+			}
+		}
+		if !time.Now().Before(deadline) {
+			if lastError != "" {
+				return fmt.Sprintf("timed out after %s: %s", timeout, lastError)
+			}
+			return fmt.Sprintf("timed out after %s", timeout)
+		}
+		if err := r.sleepFn(ctx, surgePollInterval); err != nil {
+			return err.Error()
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/healthcheck"
+	"github.com/artemnikitin/firework/internal/vm"
+)
+
+// renamingVMManager adds Rename to fakeVMManager so surge updates can promote.
+type renamingVMManager struct {
+	*fakeVMManager
+}
+
+func (f *renamingVMManager) Rename(from string, svc config.ServiceConfig) error {
+	inst := f.instances[from]
+	if inst == nil {
+		return errors.New("not running")
+	}
+	delete(f.instances, from)
+	inst.Name = svc.Name
+	inst.Config = svc
+	f.instances[svc.Name] = inst
+	return nil
+}
+
+type fakeSurgeHooks struct {
+	swapped []config.ServiceConfig
+}
+
+func (f *fakeSurgeHooks) SurgeConfig(svc config.ServiceConfig) (config.ServiceConfig, error) {
+	out := svc
+	out.Name = svc.Name + "-surge"
+	network := *svc.Network
+	network.Interface = "tap-surge"
+	network.GuestMAC = "AA:FC:00:00:00:09"
+	out.Network = &network
+	return out, nil
+}
+
+func (f *fakeSurgeHooks) SwapRoute(svc config.ServiceConfig) error {
+	f.swapped = append(f.swapped, svc)
+	return nil
+}
+
+// surgeService returns a service health-checked over TCP on 127.0.0.1:port.
+func surgeService(image string, port int) config.ServiceConfig {
+	return config.ServiceConfig{
+		Name: "web", Image: image, Kernel: "/kern", VCPUs: 1, MemoryMB: 128,
+		Network: &config.NetworkConfig{Interface: "tap-web", GuestIP: "127.0.0.1", GuestMAC: "AA:FC:00:00:00:01"},
+		HealthCheck: &config.HealthCheckConfig{
+			Type: "tcp", Port: port, Interval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond, Retries: 1000,
+		},
+	}
+}
+
+func newSurgeReconciler(t *testing.T, timeout time.Duration) (*Reconciler, *renamingVMManager, *fakeSurgeHooks) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mon := healthcheck.NewMonitor(logger, nil)
+	t.Cleanup(mon.Stop)
+	fvm := &renamingVMManager{fakeVMManager: newFakeVMManager()}
+	hooks := &fakeSurgeHooks{}
+	r := New(fvm, logger, mon, nil, StrategySurge, 0)
+	r.SetSurge(hooks, timeout)
+	r.sleepFn = func(ctx context.Context, _ time.Duration) error {
+		select {
+		case <-time.After(5 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return r, fvm, hooks
+}
+
+func TestApply_Surge_PromotesHealthyReplacement(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	r, fvm, hooks := newSurgeReconciler(t, 5*time.Second)
+	prev := surgeService("/img/v1", port)
+	fvm.instances["web"] = &vm.Instance{Name: "web", State: vm.StateRunning, Config: prev}
+	next := surgeService("/img/v2", port)
+
+	if err := r.Apply(context.Background(), []Action{{Type: ActionUpdate, Service: next, PreviousService: &prev}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fvm.startCalls; len(got) != 1 || got[0] != "web-surge" {
+		t.Fatalf("start calls = %v, want [web-surge]", got)
+	}
+	inst := fvm.instances["web"]
+	if inst == nil || inst.Config.Image != "/img/v2" || inst.Config.Network.Interface != "tap-surge" {
+		t.Fatalf("replacement was not promoted: %#v", inst)
+	}
+	if _, ok := fvm.instances["web-surge"]; ok {
+		t.Fatal("temporary name still present after promotion")
+	}
+	if len(hooks.swapped) != 1 || hooks.swapped[0].Name != "web" || hooks.swapped[0].Network.Interface != "tap-surge" {
+		t.Fatalf("route swap = %+v", hooks.swapped)
+	}
+	if _, ok := r.healthMon.GetResult("web"); !ok {
+		t.Fatal("health check not registered for promoted service")
+	}
+}
+
+func TestApply_Surge_UnhealthyReplacementKeepsPreviousVM(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close() // nothing answers: the replacement never becomes healthy
+
+	r, fvm, hooks := newSurgeReconciler(t, 100*time.Millisecond)
+	prev := surgeService("/img/v1", port)
+	fvm.instances["web"] = &vm.Instance{Name: "web", State: vm.StateRunning, Config: prev}
+	next := surgeService("/img/v2", port)
+
+	err = r.Apply(context.Background(), []Action{{Type: ActionUpdate, Service: next, PreviousService: &prev}})
+	var healthErr *UpdateHealthError
+	if !errors.As(err, &healthErr) || healthErr.Service != "web" {
+		t.Fatalf("expected UpdateHealthError for web, got %v", err)
+	}
+	if inst := fvm.instances["web"]; inst == nil || inst.Config.Image != "/img/v1" {
+		t.Fatalf("previous VM was not kept: %#v", inst)
+	}
+	if _, ok := fvm.instances["web-surge"]; ok {
+		t.Fatal("unhealthy replacement was not removed")
+	}
+	if len(fvm.removeCalls) != 1 || fvm.removeCalls[0] != "web-surge" {
+		t.Fatalf("remove calls = %v, want [web-surge]", fvm.removeCalls)
+	}
+	if len(hooks.swapped) != 0 {
+		t.Fatalf("route swapped despite failure: %+v", hooks.swapped)
+	}
+}
+
+func TestApply_Surge_FallsBackWithoutHealthCheck(t *testing.T) {
+	r, fvm, _ := newSurgeReconciler(t, time.Second)
+	prev := config.ServiceConfig{Name: "worker", Image: "/img/v1", Kernel: "/kern", VCPUs: 1, MemoryMB: 128}
+	fvm.instances["worker"] = &vm.Instance{Name: "worker", State: vm.StateRunning, Config: prev}
+	next := prev
+	next.Image = "/img/v2"
+
+	if err := r.Apply(context.Background(), []Action{{Type: ActionUpdate, Service: next, PreviousService: &prev}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fvm.startCalls; len(got) != 1 || got[0] != "worker" {
+		t.Fatalf("start calls = %v, want stop-then-start of worker", got)
+	}
+	if reason := r.surgeUnsupported(next); reason == "" {
+		t.Fatal("expected surge to be unsupported for a service without health check")
+	}
+}
+
+func TestApply_Surge_BacksOffAfterFailedReplacement(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	r, fvm, _ := newSurgeReconciler(t, 50*time.Millisecond)
+	clock := time.Now()
+	r.restarts.now = func() time.Time { return clock }
+	prev := surgeService("/img/v1", port)
+	fvm.instances["web"] = &vm.Instance{Name: "web", State: vm.StateRunning, Config: prev}
+	next := surgeService("/img/v2", port)
+	update := []Action{{Type: ActionUpdate, Service: next, PreviousService: &prev}}
+
+	var healthErr *UpdateHealthError
+	if err := r.Apply(context.Background(), update); !errors.As(err, &healthErr) {
+		t.Fatalf("expected UpdateHealthError, got %v", err)
+	}
+	if len(fvm.startCalls) != 1 {
+		t.Fatalf("start calls = %v, want one surge attempt", fvm.startCalls)
+	}
+
+	// The same config is not surged again until the backoff passes.
+	if err := r.Apply(context.Background(), update); !errors.As(err, &healthErr) {
+		t.Fatalf("expected UpdateHealthError while backing off, got %v", err)
+	}
+	if len(fvm.startCalls) != 1 {
+		t.Fatalf("start calls = %v, surge retried during backoff", fvm.startCalls)
+	}
+
+	// After the backoff the config is tried once more, and the wait before
+	// the next attempt doubles.
+	clock = clock.Add(config.DefaultRestartBackoff)
+	_ = r.Apply(context.Background(), update)
+	if len(fvm.startCalls) != 2 {
+		t.Fatalf("start calls = %v, want a retry after the backoff", fvm.startCalls)
+	}
+	clock = clock.Add(config.DefaultRestartBackoff)
+	_ = r.Apply(context.Background(), update)
+	if len(fvm.startCalls) != 2 {
+		t.Fatalf("start calls = %v, backoff did not grow", fvm.startCalls)
+	}
+
+	// A new config is tried right away.
+	newer := surgeService("/img/v3", port)
+	_ = r.Apply(context.Background(), []Action{{Type: ActionUpdate, Service: newer, PreviousService: &prev}})
+	if len(fvm.startCalls) != 3 {
+		t.Fatalf("start calls = %v, want a surge of the new config", fvm.startCalls)
+	}
+}
@@ -0,0 +1,230 @@
+package scheduler
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// Move reasons of the rebalancer.
+const (
+	MoveFragmentation = "fragmentation"
+	MoveBalance       = "balance"
+)
+
+// Move is a placed service the rebalancer moves to another node.
+type Move struct {
+	Service    string
+	From       string
+	To         string
+	ReasonCode string
+	Message    string
+}
+
+// RebalancePlan is the placement after the moves of one Rebalance call.
+type RebalancePlan struct {
+	Assignment map[string][]config.ServiceConfig
+	Pending    []Pending
+	Moves      []Move
+	// Deferred counts the moves that were wanted but left for a later call,
+	// because the move budget was spent or a service could not move yet.
+	Deferred int
+}
+
+// Rebalance moves services of a placement computed by ScheduleWithStorage
+// toward a better one, at most maxMoves of them, one service at a time.
+//
+// It first looks for a service to move so that a service pending for lack
+// of compute, which would fit if the nodes were not fragmented, can be
+// placed. It then moves services toward the placement ScheduleWithStorage
+// computes from scratch, which spreads load over every node, as long as each
+// move leaves the target node less loaded than the source was. Every move is
+// checked by scheduling again: it must keep every other service where it
+// was and must not move a service next to a member of its anti-affinity
+// group. Services with a topology spread only move to resolve
+// fragmentation. At most one member of a replica or anti-affinity group
+// moves per call. movable reports whether the caller allows a service to
+// move, for example because it and its group are healthy.
+func Rebalance(services []config.ServiceConfig, nodes []Node, assignment map[string][]config.ServiceConfig, pending []Pending, reservations StorageReservations, tenancy Tenancy, movable func(config.ServiceConfig) bool, maxMoves int) RebalancePlan {
+	plan := RebalancePlan{Assignment: assignment, Pending: pending}
+	schedule := func(existing map[string]string) (map[string][]config.ServiceConfig, []Pending) {
+		return ScheduleWithStorage(services, nodes, existing, reservations, ScheduleOptions{Tenancy: tenancy})
+	}
+	byName := make(map[string]config.ServiceConfig, len(services))
+	for _, service := range services {
+		byName[service.Name] = service
+	}
+	capacityOf := make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		capacityOf[node.InstanceID] = node.CapacityVCPUs
+	}
+
+	current := nodeOf(assignment)
+	movedGroups := make(map[string]bool)
+	canMove := func(service config.ServiceConfig) bool {
+		for _, group := range ServiceGroups(service) {
+			if movedGroups[group] {
+				return false
+			}
+		}
+		return service.Kind != config.KindJob && len(service.Volumes) == 0 && movable(service)
+	}
+	// try reschedules with existing and commits the result if only mover
+	// changed nodes and, when resolves is set, that service got placed.
+	try := func(existing map[string]string, mover, resolves, reason, message string) bool {
+		result, trialPending := schedule(existing)
+		placed := nodeOf(result)
+		target := placed[mover]
+		if target == "" || target == current[mover] || (resolves != "" && placed[resolves] == "") {
+			return false
+		}
+		for name, node := range current {
+			if name != mover && placed[name] != node {
+				return false
+			}
+		}
+		// The scheduler only prefers to keep anti-affinity groups apart,
+		// and a dedicated node hosting a service it was told to keep
+		// counts as shared, so both are checked here.
+		tenant := ServiceTenant(byName[mover])
+		for _, neighbour := range result[target] {
+			if neighbour.Name == mover {
+				continue
+			}
+			if group := byName[mover].AntiAffinityGroup; group != "" && neighbour.AntiAffinityGroup == group {
+				return false
+			}
+			if other := ServiceTenant(neighbour); tenancy.Mode == TenancyDedicated && tenant != "" && other != "" && other != tenant {
+				return false
+			}
+		}
+		plan.Moves = append(plan.Moves, Move{Service: mover, From: current[mover], To: target, ReasonCode: reason, Message: message})
+		for _, group := range ServiceGroups(byName[mover]) {
+			movedGroups[group] = true
+		}
+		plan.Assignment, plan.Pending = result, keepReasons(trialPending, plan.Pending)
+		current = placed
+		return true
+	}
+
+	// Smaller services are tried first: they are the cheapest to restart.
+	placedServices := func() []config.ServiceConfig {
+		var placed []config.ServiceConfig
+		for name := range current {
+			placed = append(placed, byName[name])
+		}
+		sort.Slice(placed, func(i, j int) bool {
+			if placed[i].VCPUs != placed[j].VCPUs {
+				return placed[i].VCPUs < placed[j].VCPUs
+			}
+			return placed[i].Name < placed[j].Name
+		})
+		return placed
+	}
+
+	// Resolve fragmentation first: a pending service that fits once one
+	// placed service moves elsewhere.
+	var starved []config.ServiceConfig
+	for _, p := range pending {
+		switch p.ReasonCode {
+		case "insufficient_compute_capacity", ReasonPreemptionDeferred, ReasonPreempted:
+			starved = append(starved, byName[p.Service])
+		}
+	}
+	sort.SliceStable(starved, func(i, j int) bool {
+		if starved[i].Priority != starved[j].Priority {
+			return starved[i].Priority > starved[j].Priority
+		}
+		return starved[i].Name < starved[j].Name
+	})
+	for _, service := range starved {
+		if len(plan.Moves) >= maxMoves {
+			plan.Deferred++
+			continue
+		}
+		blocked := false
+		for _, candidate := range placedServices() {
+			if !canMove(candidate) {
+				blocked = true
+				continue
+			}
+			existing := maps.Clone(current)
+			delete(existing, candidate.Name)
+			if try(existing, candidate.Name, service.Name, MoveFragmentation, fmt.Sprintf("makes room for %s", service.Name)) {
+				blocked = false
+				break
+			}
+		}
+		if blocked {
+			plan.Deferred++
+		}
+	}
+
+	// Then move services toward a placement made from scratch, taking them
+	// from the most loaded nodes first.
+	ideal, _ := schedule(nil)
+	target := nodeOf(ideal)
+	used := func() map[string]int {
+		used := make(map[string]int, len(nodes))
+		for node, services := range plan.Assignment {
+			for _, service := range services {
+				used[node] += service.VCPUs
+			}
+		}
+		return used
+	}
+	candidates := placedServices()
+	load := used()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := current[candidates[i].Name], current[candidates[j].Name]
+		return load[a]*capacityOf[b] > load[b]*capacityOf[a]
+	})
+	for _, service := range candidates {
+		from, to := current[service.Name], target[service.Name]
+		// A service that runs on a node is exempt from its topology
+		// spread there, so the scheduler cannot vouch for such a move.
+		if to == "" || to == from || service.TopologySpread != nil {
+			continue
+		}
+		// The target must end up less loaded than the source was, so a
+		// later call does not move the service back.
+		load := used()
+		if (load[to]+service.VCPUs)*capacityOf[from] >= load[from]*capacityOf[to] {
+			continue
+		}
+		if len(plan.Moves) >= maxMoves || !canMove(service) {
+			plan.Deferred++
+			continue
+		}
+		existing := maps.Clone(current)
+		existing[service.Name] = to
+		try(existing, service.Name, "", MoveBalance, fmt.Sprintf("moves load from %s to %s", from, to))
+	}
+	return plan
+}
+
+// nodeOf maps each service of an assignment to its node.
+func nodeOf(assignment map[string][]config.ServiceConfig) map[string]string {
+	nodes := make(map[string]string)
+	for node, services := range assignment {
+		for _, service := range services {
+			nodes[service.Name] = node
+		}
+	}
+	return nodes
+}
+
+// keepReasons returns pending with the entries of services that were
+// already pending before replaced by their earlier entry, so a rebalance
+// does not hide why a service was left out, such as a preemption.
+func keepReasons(pending, before []Pending) []Pending {
+	for i, p := range pending {
+		if j := slices.IndexFunc(before, func(b Pending) bool { return b.Service == p.Service }); j >= 0 {
+			pending[i] = before[j]
+		}
+	}
+	return pending
+}
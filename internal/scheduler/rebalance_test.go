@@ -0,0 +1,56 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func anyService(config.ServiceConfig) bool { return true }
+
+func TestRebalanceResolvesFragmentationFirst(t *testing.T) {
+	services := []config.ServiceConfig{svc("a", 2, 256), svc("b", 2, 256), svc("big", 4, 256)}
+	nodes := []Node{node("n1", 4, 4096), node("n2", 4, 4096)}
+	assignment, pending := ScheduleWithStorage(services, nodes, map[string]string{"a": "n1", "b": "n2"}, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 1 || pending[0].Service != "big" {
+		t.Fatalf("expected big pending on fragmented nodes, got %#v", pending)
+	}
+
+	plan := Rebalance(services, nodes, assignment, pending, StorageReservations{}, Tenancy{}, anyService, 1)
+	if len(plan.Moves) != 1 || plan.Moves[0] != (Move{Service: "a", From: "n1", To: "n2", ReasonCode: MoveFragmentation, Message: "makes room for big"}) {
+		t.Fatalf("unexpected moves: %#v", plan.Moves)
+	}
+	placed := placedOn(plan.Assignment)
+	if len(plan.Pending) != 0 || placed["big"] != "n1" || placed["b"] != "n2" {
+		t.Fatalf("expected big placed on n1, got %v pending=%#v", placed, plan.Pending)
+	}
+}
+
+func TestRebalanceMovesLoadOntoNewNodesWithinBudget(t *testing.T) {
+	services := []config.ServiceConfig{svc("s1", 1, 256), svc("s2", 1, 256), svc("s3", 1, 256), svc("s4", 1, 256)}
+	// A second node joined after the services were placed on the first;
+	// the scheduler alone leaves them there.
+	nodes := []Node{node("n1", 8, 8192), node("n2", 8, 8192)}
+	existing := map[string]string{"s1": "n1", "s2": "n1", "s3": "n1", "s4": "n1"}
+	assignment, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{})
+	if len(assignment["n1"]) != 4 {
+		t.Fatalf("expected services to stay on n1, got %#v", assignment)
+	}
+
+	if plan := Rebalance(services, nodes, assignment, pending, StorageReservations{}, Tenancy{}, func(config.ServiceConfig) bool { return false }, 1); len(plan.Moves) != 0 || plan.Deferred != 2 {
+		t.Fatalf("unmovable services moved: %#v", plan)
+	}
+	plan := Rebalance(services, nodes, assignment, pending, StorageReservations{}, Tenancy{}, anyService, 1)
+	if len(plan.Moves) != 1 || plan.Moves[0].Service != "s2" || plan.Moves[0].To != "n2" || plan.Moves[0].ReasonCode != MoveBalance || plan.Deferred != 1 {
+		t.Fatalf("expected s2 to move and one move deferred, got %#v", plan)
+	}
+
+	plan = Rebalance(services, nodes, plan.Assignment, plan.Pending, StorageReservations{}, Tenancy{}, anyService, 1)
+	if len(plan.Moves) != 1 || plan.Moves[0].Service != "s4" || plan.Deferred != 0 {
+		t.Fatalf("expected s4 to move next, got %#v", plan)
+	}
+	plan = Rebalance(services, nodes, plan.Assignment, plan.Pending, StorageReservations{}, Tenancy{}, anyService, 1)
+	if len(plan.Moves) != 0 || plan.Deferred != 0 || len(plan.Assignment["n1"]) != 2 || len(plan.Assignment["n2"]) != 2 {
+		t.Fatalf("expected a balanced placement, got %#v", plan)
+	}
+}
@@ -7,12 +7,24 @@
 //     still alive and has capacity.
 //  2. For services that cannot be kept, bin-pack them onto the node with the
 //     most remaining capacity (best-fit descending by vCPU).
+//
+// A service's node selector limits both phases to nodes carrying its
+// required labels.
+//
+// ScheduleWithStorage also spreads the replicas of a service: no node gets
+// two of them, and a replica that finds no other node stays pending. It
+// places services that keep a live node first, then the rest by priority, and
+// may preempt lower-priority services for a higher-priority one that found no
+// capacity, up to a budget of evictions per call.
 package scheduler
 
 import (
 	"fmt"
+	"slices"
 	"sort"
+	"strings"
 
+	"github.com/artemnikitin/firework/internal/capacity"
 	"github.com/artemnikitin/firework/internal/config"
 )
 
@@ -20,10 +32,21 @@ import (
 type Node struct {
 	// InstanceID is the EC2 instance ID (used as the S3 config key).
 	InstanceID string
+	// Labels are the node labels the agent registered with.
+	Labels []string
+	// Topology holds the node's failure domains, such as zone and rack,
+	// keyed by domain.
+	Topology map[string]string
 	// CapacityVCPUs is the total number of vCPUs on the node.
 	CapacityVCPUs int
 	// CapacityMemMB is the total memory on the node in MB.
-	CapacityMemMB       int
+	CapacityMemMB int
+	// MemoryOvercommitRatio is the node's memory_overcommit_ratio; see
+	// capacity.MemoryDemand. Values up to 1 disable overcommit.
+	MemoryOvercommitRatio float64
+	// ObservedMemMB is the memory the node's ballooned services currently
+	// use, keyed by service name.
+	ObservedMemMB       map[string]int
 	LocalCapacityBytes  int64
 	SharedBackendID     string
 	SharedCapacityBytes int64
@@ -45,6 +68,18 @@ type Pending struct {
 	Message    string
 }
 
+// Pending reasons of preemption. A preempted service was evicted to make room
+// for a higher-priority one; a deferred one could preempt but the budget of
+// this call ran out, so the next call should try again.
+const (
+	ReasonPreempted          = "preempted"
+	ReasonPreemptionDeferred = "preemption_deferred"
+)
+
+// reasonInsufficientCompute marks a service that found no node with enough
+// vCPUs and memory; only those may preempt.
+const reasonInsufficientCompute = "insufficient_compute_capacity"
+
 // Schedule distributes services across nodes.
 //
 // existingAssignment maps service name → instance ID from the previous run.
@@ -89,7 +124,7 @@ func Schedule(
 		}
 
 		n, alive := nodeByID[existingNode]
-		if !alive {
+		if !alive || !matchesRequired(n, svc) {
 			unplaced = append(unplaced, svc)
 			continue
 		}
@@ -155,7 +190,7 @@ func bestFit(nodes []Node, svc config.ServiceConfig, usedVCPUs, usedMemMB map[st
 		freeVCPUs := n.CapacityVCPUs - usedVCPUs[n.InstanceID]
 		freeMemMB := n.CapacityMemMB - usedMemMB[n.InstanceID]
 
-		if freeVCPUs < svc.VCPUs || freeMemMB < svc.MemoryMB {
+		if freeVCPUs < svc.VCPUs || freeMemMB < svc.MemoryMB || !matchesRequired(n, svc) {
 			continue
 		}
 
@@ -192,13 +227,24 @@ func BuildNodeConfigs(assignment map[string][]config.ServiceConfig) []config.Nod
 	return result
 }
 
+// ScheduleOptions holds the placement policies of ScheduleWithStorage. The
+// zero value places services without tenant isolation or preemption.
+type ScheduleOptions struct {
+	// Tenancy is the tenancy policy; the zero value is shared.
+	Tenancy Tenancy
+	// PreemptionBudget caps how many services may be evicted for
+	// higher-priority ones; 0 disables preemption.
+	PreemptionBudget int
+}
+
 // ScheduleWithStorage preserves the legacy CPU/memory behavior while adding
-// retained-volume constraints and per-service pending results. It is kept
-// separate from Schedule so existing direct callers retain error semantics.
-func ScheduleWithStorage(services []config.ServiceConfig, nodes []Node, existing map[string]string, reservations StorageReservations) (map[string][]config.ServiceConfig, []Pending) {
+// retained-volume constraints, the policies of opts, memory overcommit for
+// ballooned services, and per-service pending results. It is kept separate from Schedule so existing direct callers
+// retain error semantics.
+func ScheduleWithStorage(services []config.ServiceConfig, nodes []Node, existing map[string]string, reservations StorageReservations, opts ScheduleOptions) (map[string][]config.ServiceConfig, []Pending) {
 	result := make(map[string][]config.ServiceConfig, len(nodes))
 	usedVCPU := make(map[string]int, len(nodes))
-	usedMem := make(map[string]int, len(nodes))
+	usedMem := make(map[string]capacity.MemoryDemand, len(nodes))
 	usedLocal := make(map[string]int64, len(nodes))
 	usedShared := make(map[string]int64)
 	groups := make(map[string]map[string]bool, len(nodes))
@@ -209,47 +255,106 @@ func ScheduleWithStorage(services []config.ServiceConfig, nodes []Node, existing
 		nodeByID[node.InstanceID] = node
 	}
 
+	tenants := newTenantTracker(opts.Tenancy, services, existing)
+	replicas := newReplicaTracker(services, existing)
+	topology := newTopologyTracker(nodes)
+
+	// Services that still have a live node go first, so a newcomer only
+	// displaces one through the budgeted preemption below.
+	kept := func(service config.ServiceConfig) bool {
+		_, ok := nodeByID[existing[service.Name]]
+		return ok
+	}
 	ordered := append([]config.ServiceConfig(nil), services...)
 	sort.SliceStable(ordered, func(i, j int) bool {
+		if iKept, jKept := kept(ordered[i]), kept(ordered[j]); iKept != jKept {
+			return iKept
+		}
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority > ordered[j].Priority
+		}
 		if ordered[i].VCPUs != ordered[j].VCPUs {
 			return ordered[i].VCPUs > ordered[j].VCPUs
 		}
 		return ordered[i].Name < ordered[j].Name
 	})
 
-	var pending []Pending
-	for _, service := range ordered {
+	// place puts service on the best node with room left, or explains why it
+	// found none.
+	place := func(service config.ServiceConfig) (Pending, bool) {
 		boundNode, split := localBinding(service)
 		if split {
-			pending = append(pending, Pending{Service: service.Name, ReasonCode: "local_volume_binding_conflict", Message: "local volumes are retained on different nodes"})
-			continue
+			return Pending{Service: service.Name, ReasonCode: "local_volume_binding_conflict", Message: "local volumes are retained on different nodes"}, false
 		}
 		if hasSharedVolume(service) && !reservations.SharedEnabled {
-			pending = append(pending, Pending{Service: service.Name, ReasonCode: "shared_volume_runtime_unavailable", Message: "shared volumes await durable supervisor and fencing validation"})
-			continue
+			return Pending{Service: service.Name, ReasonCode: "shared_volume_runtime_unavailable", Message: "shared volumes await durable supervisor and fencing validation"}, false
 		}
 
 		preferred := existing[service.Name]
 		if boundNode != "" {
 			preferred = boundNode
 			if _, active := nodeByID[boundNode]; !active {
-				pending = append(pending, Pending{Service: service.Name, ReasonCode: "local_volume_node_unavailable", Message: fmt.Sprintf("bound node %s is unavailable", boundNode)})
-				continue
+				return Pending{Service: service.Name, ReasonCode: "local_volume_node_unavailable", Message: fmt.Sprintf("bound node %s is unavailable", boundNode)}, false
 			}
 		}
 
-		candidates := append([]Node(nil), nodes...)
+		var candidates []Node
+		for _, node := range nodes {
+			if matchesRequired(node, service) {
+				candidates = append(candidates, node)
+			}
+		}
+		if len(candidates) == 0 {
+			return Pending{Service: service.Name, ReasonCode: "node_selector_unsatisfied", Message: fmt.Sprintf("no active node carries labels %s", strings.Join(service.NodeSelector.Required, ", "))}, false
+		}
+		candidates = slices.DeleteFunc(candidates, func(node Node) bool { return !tenants.allows(node, service) })
+		if len(candidates) == 0 {
+			return tenants.pending(service), false
+		}
+		candidates = topology.filter(candidates, service, existing[service.Name])
+		if len(candidates) == 0 {
+			return topology.pending(service), false
+		}
+		candidates = slices.DeleteFunc(candidates, func(node Node) bool { return replicas.hosts(node.InstanceID, service) })
+		if len(candidates) == 0 {
+			return Pending{Service: service.Name, ReasonCode: "replica_spread_unsatisfied", Message: fmt.Sprintf("every eligible node already runs a replica of %s", service.ReplicaOf)}, false
+		}
 		sort.SliceStable(candidates, func(i, j int) bool {
+			iPreferred := candidates[i].InstanceID == preferred
+			jPreferred := candidates[j].InstanceID == preferred
+			// A spread service fills the least loaded domain first, and
+			// only then looks at anti-affinity within it.
+			if service.TopologySpread != nil {
+				if iPreferred != jPreferred {
+					return iPreferred
+				}
+				if iLoad, jLoad := topology.load(candidates[i], service), topology.load(candidates[j], service); iLoad != jLoad {
+					return iLoad < jLoad
+				}
+			}
 			iConflict := service.AntiAffinityGroup != "" && groups[candidates[i].InstanceID][service.AntiAffinityGroup]
 			jConflict := service.AntiAffinityGroup != "" && groups[candidates[j].InstanceID][service.AntiAffinityGroup]
 			if iConflict != jConflict {
 				return !iConflict
 			}
-			iPreferred := candidates[i].InstanceID == preferred
-			jPreferred := candidates[j].InstanceID == preferred
+			iClaimed := replicas.claimedBySibling(candidates[i].InstanceID, service)
+			jClaimed := replicas.claimedBySibling(candidates[j].InstanceID, service)
+			if iClaimed != jClaimed {
+				return !iClaimed
+			}
 			if iPreferred != jPreferred {
 				return iPreferred
 			}
+			iOwned := tenants.owns(candidates[i], service)
+			jOwned := tenants.owns(candidates[j], service)
+			if iOwned != jOwned {
+				return iOwned
+			}
+			iLabels := preferredLabelCount(candidates[i], service)
+			jLabels := preferredLabelCount(candidates[j], service)
+			if iLabels != jLabels {
+				return iLabels > jLabels
+			}
 			freeI := candidates[i].CapacityVCPUs - usedVCPU[candidates[i].InstanceID]
 			freeJ := candidates[j].CapacityVCPUs - usedVCPU[candidates[j].InstanceID]
 			if freeI != freeJ {
@@ -264,7 +369,14 @@ func ScheduleWithStorage(services []config.ServiceConfig, nodes []Node, existing
 			if boundNode != "" && node.InstanceID != boundNode {
 				continue
 			}
-			if usedVCPU[node.InstanceID]+service.VCPUs > node.CapacityVCPUs || usedMem[node.InstanceID]+service.MemoryMB > node.CapacityMemMB {
+			memory := usedMem[node.InstanceID].Add(service, node.ObservedMemMB[service.Name])
+			fits := memory.Fits(node.CapacityMemMB, node.MemoryOvercommitRatio)
+			if node.InstanceID == existing[service.Name] {
+				// Observed usage only gates new placements; a running
+				// service never loses its node to a usage spike.
+				fits = memory.FitsCommitted(node.CapacityMemMB, node.MemoryOvercommitRatio)
+			}
+			if usedVCPU[node.InstanceID]+service.VCPUs > node.CapacityVCPUs || !fits {
 				continue
 			}
 			candidateService, localDelta, sharedDelta, ok := fitStorage(service, node, reservations, usedLocal, usedShared)
@@ -280,26 +392,233 @@ func ScheduleWithStorage(services []config.ServiceConfig, nodes []Node, existing
 			break
 		}
 		if chosen == "" {
-			reason := "insufficient_compute_capacity"
+			reason := reasonInsufficientCompute
 			message := "no active node satisfies compute capacity"
 			if len(service.Volumes) > 0 {
 				reason = "volume_capacity_unavailable"
 				message = "no active node satisfies volume binding and capacity"
 			}
-			pending = append(pending, Pending{Service: service.Name, ReasonCode: reason, Message: message})
-			continue
+			if service.NodeSelector != nil && len(service.NodeSelector.Required) > 0 {
+				message = strings.Replace(message, "no active node", "no active node matching the node selector", 1)
+			}
+			return Pending{Service: service.Name, ReasonCode: reason, Message: message}, false
 		}
 		result[chosen] = append(result[chosen], chosenService)
 		usedVCPU[chosen] += service.VCPUs
-		usedMem[chosen] += service.MemoryMB
+		usedMem[chosen] = usedMem[chosen].Add(service, nodeByID[chosen].ObservedMemMB[service.Name])
 		if service.AntiAffinityGroup != "" {
 			groups[chosen][service.AntiAffinityGroup] = true
 		}
+		tenants.place(chosen, service)
+		replicas.place(chosen, service)
+		topology.place(nodeByID[chosen], service)
+		return Pending{}, true
+	}
+
+	var pending []Pending
+	var starved []config.ServiceConfig
+	for _, service := range ordered {
+		p, ok := place(service)
+		if ok {
+			continue
+		}
+		pending = append(pending, p)
+		if p.ReasonCode == reasonInsufficientCompute {
+			starved = append(starved, service)
+		}
+	}
+
+	// Evict lower-priority services for the highest-priority services that
+	// found no capacity, as long as the budget lasts. Earlier evictions may
+	// have freed room or changed which nodes a service is allowed on, so the
+	// candidates are filtered again.
+	sort.SliceStable(starved, func(i, j int) bool { return starved[i].Priority > starved[j].Priority })
+	budget := opts.PreemptionBudget
+	for _, service := range starved {
+		if budget <= 0 {
+			break
+		}
+		candidates := slices.DeleteFunc(slices.Clone(nodes), func(node Node) bool {
+			return !matchesRequired(node, service) || !tenants.allows(node, service)
+		})
+		candidates = topology.filter(candidates, service, existing[service.Name])
+		candidates = slices.DeleteFunc(candidates, func(node Node) bool { return replicas.hosts(node.InstanceID, service) })
+		node, victims, ok := preemptionTarget(service, candidates, result)
+		if !ok {
+			continue
+		}
+		index := slices.IndexFunc(pending, func(p Pending) bool { return p.Service == service.Name })
+		if len(victims) > budget {
+			pending[index] = Pending{Service: service.Name, ReasonCode: ReasonPreemptionDeferred,
+				Message: fmt.Sprintf("waiting to preempt %d lower-priority service(s); the preemption budget is spent", len(victims))}
+			continue
+		}
+		budget -= len(victims)
+		pending = slices.Delete(pending, index, index+1)
+		remaining := slices.DeleteFunc(result[node], func(placed config.ServiceConfig) bool {
+			return slices.ContainsFunc(victims, func(victim config.ServiceConfig) bool { return victim.Name == placed.Name })
+		})
+		result[node] = append(remaining, service)
+		usedVCPU[node], usedMem[node] = 0, capacity.MemoryDemand{}
+		groups[node] = make(map[string]bool)
+		for _, placed := range result[node] {
+			usedVCPU[node] += placed.VCPUs
+			usedMem[node] = usedMem[node].Add(placed, nodeByID[node].ObservedMemMB[placed.Name])
+			if placed.AntiAffinityGroup != "" {
+				groups[node][placed.AntiAffinityGroup] = true
+			}
+		}
+		for _, victim := range victims {
+			tenants.release(node, victim, result[node])
+			replicas.remove(node, victim)
+			topology.remove(nodeByID[node], victim)
+		}
+		tenants.place(node, service)
+		replicas.place(node, service)
+		topology.place(nodeByID[node], service)
+		// A victim that fits elsewhere moves there instead of waiting.
+		for _, victim := range victims {
+			if _, ok := place(victim); !ok {
+				pending = append(pending, Pending{Service: victim.Name, ReasonCode: ReasonPreempted,
+					Message: fmt.Sprintf("preempted by %s (priority %d)", service.Name, service.Priority)})
+			}
+		}
 	}
 	sort.Slice(pending, func(i, j int) bool { return pending[i].Service < pending[j].Service })
 	return result, pending
 }
 
+// preemptionTarget picks the candidate node where evicting the fewest
+// lower-priority services makes room for service, and returns it with those
+// services. A node an earlier eviction left with enough room needs none.
+// Services with volumes are never evicted, since their data cannot follow
+// them. It reports false when no node qualifies.
+func preemptionTarget(service config.ServiceConfig, candidates []Node, result map[string][]config.ServiceConfig) (string, []config.ServiceConfig, bool) {
+	best, found := "", false
+	var bestVictims []config.ServiceConfig
+	for _, node := range candidates {
+		var lower []config.ServiceConfig
+		vcpus, memory := service.VCPUs, capacity.MemoryDemand{}.Add(service, node.ObservedMemMB[service.Name])
+		for _, placed := range result[node.InstanceID] {
+			vcpus += placed.VCPUs
+			memory = memory.Add(placed, node.ObservedMemMB[placed.Name])
+			if placed.Priority < service.Priority && len(placed.Volumes) == 0 {
+				lower = append(lower, placed)
+			}
+		}
+		// Evict the lowest priorities first and, among equals, the largest.
+		sort.SliceStable(lower, func(i, j int) bool {
+			if lower[i].Priority != lower[j].Priority {
+				return lower[i].Priority < lower[j].Priority
+			}
+			return lower[i].VCPUs > lower[j].VCPUs
+		})
+		var victims []config.ServiceConfig
+		fits := true
+		for vcpus > node.CapacityVCPUs || !memory.Fits(node.CapacityMemMB, node.MemoryOvercommitRatio) {
+			if len(victims) == len(lower) {
+				fits = false
+				break
+			}
+			victim := lower[len(victims)]
+			victims = append(victims, victim)
+			vcpus -= victim.VCPUs
+			memory = memory.Sub(victim, node.ObservedMemMB[victim.Name])
+		}
+		if !fits {
+			continue
+		}
+		if !found || len(victims) < len(bestVictims) ||
+			(len(victims) > 0 && len(victims) == len(bestVictims) && victims[len(victims)-1].Priority < bestVictims[len(bestVictims)-1].Priority) {
+			best, bestVictims, found = node.InstanceID, victims, true
+		}
+	}
+	return best, bestVictims, found
+}
+
+// replicaTracker keeps the replicas of a service on different nodes.
+type replicaTracker struct {
+	// placed holds the services with a replica on each node.
+	placed map[string]map[string]bool
+	// claimed holds, per service, the node each replica ran on before, so
+	// a sibling placed first does not take a node a replica could stay on.
+	claimed map[string]map[string]string
+}
+
+func newReplicaTracker(services []config.ServiceConfig, existing map[string]string) *replicaTracker {
+	t := &replicaTracker{placed: make(map[string]map[string]bool), claimed: make(map[string]map[string]string)}
+	for _, service := range services {
+		node, ok := existing[service.Name]
+		if service.ReplicaOf == "" || !ok {
+			continue
+		}
+		if t.claimed[service.ReplicaOf] == nil {
+			t.claimed[service.ReplicaOf] = make(map[string]string)
+		}
+		t.claimed[service.ReplicaOf][node] = service.Name
+	}
+	return t
+}
+
+// hosts reports whether node already runs a replica of the same service.
+func (t *replicaTracker) hosts(node string, service config.ServiceConfig) bool {
+	return service.ReplicaOf != "" && t.placed[node][service.ReplicaOf]
+}
+
+// claimedBySibling reports whether another replica of the same service ran
+// on node before.
+func (t *replicaTracker) claimedBySibling(node string, service config.ServiceConfig) bool {
+	if service.ReplicaOf == "" {
+		return false
+	}
+	owner, ok := t.claimed[service.ReplicaOf][node]
+	return ok && owner != service.Name
+}
+
+// remove records that service no longer runs on node.
+func (t *replicaTracker) remove(node string, service config.ServiceConfig) {
+	if service.ReplicaOf != "" {
+		delete(t.placed[node], service.ReplicaOf)
+	}
+}
+
+func (t *replicaTracker) place(node string, service config.ServiceConfig) {
+	if service.ReplicaOf == "" {
+		return
+	}
+	if t.placed[node] == nil {
+		t.placed[node] = make(map[string]bool)
+	}
+	t.placed[node][service.ReplicaOf] = true
+}
+
+// matchesRequired reports whether node carries every label the service's
+// node selector requires.
+func matchesRequired(node Node, service config.ServiceConfig) bool {
+	if service.NodeSelector == nil {
+		return true
+	}
+	for _, label := range service.NodeSelector.Required {
+		if !slices.Contains(node.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+func preferredLabelCount(node Node, service config.ServiceConfig) int {
+	if service.NodeSelector == nil {
+		return 0
+	}
+	count := 0
+	for _, label := range service.NodeSelector.Preferred {
+		if slices.Contains(node.Labels, label) {
+			count++
+		}
+	}
+	return count
+}
+
 func localBinding(service config.ServiceConfig) (string, bool) {
 	bound := ""
 	for _, volume := range service.Volumes {
@@ -254,7 +254,7 @@ func TestScheduleWithStorageBindsLocalVolumeAndHonorsRetainedBinding(t *testing.
 		{InstanceID: "small", CapacityVCPUs: 4, CapacityMemMB: 1024, LocalCapacityBytes: 5 * config.GiB},
 		{InstanceID: "large", CapacityVCPUs: 4, CapacityMemMB: 1024, LocalCapacityBytes: 20 * config.GiB},
 	}
-	result, pending := ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{})
+	result, pending := ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{}, ScheduleOptions{})
 	if len(pending) != 0 || len(result["large"]) != 1 {
 		t.Fatalf("unexpected placement result=%#v pending=%#v", result, pending)
 	}
@@ -263,7 +263,7 @@ func TestScheduleWithStorageBindsLocalVolumeAndHonorsRetainedBinding(t *testing.
 	}
 
 	service.Volumes[0].BoundNode = "lost"
-	_, pending = ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{})
+	_, pending = ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{}, ScheduleOptions{})
 	if len(pending) != 1 || pending[0].ReasonCode != "local_volume_node_unavailable" {
 		t.Fatalf("unexpected retained binding result: %#v", pending)
 	}
@@ -273,8 +273,232 @@ func TestScheduleWithStorageKeepsSharedPendingUntilSafetyGate(t *testing.T) {
 	service := svc("db", 1, 256)
 	service.Volumes = []config.VolumeConfig{{Name: "data", Type: config.VolumeTypeShared, MountPath: "/data", SizeBytes: config.GiB}}
 	nodes := []Node{{InstanceID: "node", CapacityVCPUs: 4, CapacityMemMB: 1024, SharedBackendID: "primary"}}
-	_, pending := ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{})
+	_, pending := ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{}, ScheduleOptions{})
 	if len(pending) != 1 || pending[0].ReasonCode != "shared_volume_runtime_unavailable" {
 		t.Fatalf("unexpected pending result: %#v", pending)
 	}
 }
+
+func TestScheduleWithStorageHonorsRequiredNodeLabels(t *testing.T) {
+	service := svc("trainer", 1, 256)
+	service.NodeSelector = &config.NodeSelector{Required: []string{"gpu"}}
+	nodes := []Node{
+		{InstanceID: "cpu-node", Labels: []string{"general"}, CapacityVCPUs: 16, CapacityMemMB: 8192},
+		{InstanceID: "gpu-node", Labels: []string{"general", "gpu"}, CapacityVCPUs: 4, CapacityMemMB: 1024},
+	}
+	result, pending := ScheduleWithStorage([]config.ServiceConfig{service}, nodes, map[string]string{"trainer": "cpu-node"}, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 0 || len(result["gpu-node"]) != 1 {
+		t.Fatalf("expected trainer on gpu-node, got result=%#v pending=%#v", result, pending)
+	}
+
+	_, pending = ScheduleWithStorage([]config.ServiceConfig{service}, nodes[:1], nil, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 1 || pending[0].ReasonCode != "node_selector_unsatisfied" {
+		t.Fatalf("expected node_selector_unsatisfied, got %#v", pending)
+	}
+
+	service.VCPUs = 8
+	_, pending = ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 1 || pending[0].ReasonCode != "insufficient_compute_capacity" {
+		t.Fatalf("expected insufficient_compute_capacity on the only labeled node, got %#v", pending)
+	}
+}
+
+func TestScheduleWithStoragePrefersNodesWithPreferredLabels(t *testing.T) {
+	service := svc("cache", 1, 256)
+	service.NodeSelector = &config.NodeSelector{Preferred: []string{"ssd"}}
+	nodes := []Node{
+		{InstanceID: "big", CapacityVCPUs: 16, CapacityMemMB: 8192},
+		{InstanceID: "fast", Labels: []string{"ssd"}, CapacityVCPUs: 4, CapacityMemMB: 1024},
+	}
+	result, _ := ScheduleWithStorage([]config.ServiceConfig{service}, nodes, nil, StorageReservations{}, ScheduleOptions{})
+	if len(result["fast"]) != 1 {
+		t.Fatalf("expected cache on the preferred node, got %#v", result)
+	}
+
+	// An existing placement is kept even when a preferred node is available.
+	result, _ = ScheduleWithStorage([]config.ServiceConfig{service}, nodes, map[string]string{"cache": "big"}, StorageReservations{}, ScheduleOptions{})
+	if len(result["big"]) != 1 {
+		t.Fatalf("expected cache to stay on big, got %#v", result)
+	}
+
+	// Preferred labels never make a service unschedulable.
+	result, pending := ScheduleWithStorage([]config.ServiceConfig{service}, nodes[:1], nil, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 0 || len(result["big"]) != 1 {
+		t.Fatalf("expected cache on big without preferred nodes, got result=%#v pending=%#v", result, pending)
+	}
+}
+
+func TestSchedule_SkipsNodesMissingRequiredLabels(t *testing.T) {
+	service := svc("trainer", 1, 256)
+	service.NodeSelector = &config.NodeSelector{Required: []string{"gpu"}}
+	nodes := []Node{node("i-001", 16, 8192)}
+
+	if _, err := Schedule([]config.ServiceConfig{service}, nodes, map[string]string{"trainer": "i-001"}); err == nil {
+		t.Fatal("expected error when no node carries the required label")
+	}
+}
+
+func TestScheduleWithStorageOvercommitsBalloonedMemory(t *testing.T) {
+	services := []config.ServiceConfig{svc("a", 1, 1024), svc("b", 1, 1024)}
+	for i := range services {
+		services[i].Balloon = &config.BalloonConfig{MinMemoryMB: 256}
+	}
+	nodes := []Node{{InstanceID: "node", CapacityVCPUs: 4, CapacityMemMB: 1200}}
+
+	_, pending := ScheduleWithStorage(services, nodes, nil, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 1 {
+		t.Fatalf("expected one service pending without overcommit, got %#v", pending)
+	}
+
+	nodes[0].MemoryOvercommitRatio = 2
+	nodes[0].ObservedMemMB = map[string]int{"a": 600}
+	result, pending := ScheduleWithStorage(services, nodes, map[string]string{"a": "node"}, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 0 || len(result["node"]) != 2 {
+		t.Fatalf("unexpected overcommitted placement result=%#v pending=%#v", result, pending)
+	}
+
+	nodes[0].ObservedMemMB = map[string]int{"a": 1024}
+	_, pending = ScheduleWithStorage(services, nodes, map[string]string{"a": "node"}, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 1 || pending[0].Service != "b" {
+		t.Fatalf("observed usage should keep b pending, got %#v", pending)
+	}
+
+	// A spike never pushes running services off their node.
+	nodes[0].ObservedMemMB = map[string]int{"a": 1024, "b": 1024}
+	result, pending = ScheduleWithStorage(services, nodes, map[string]string{"a": "node", "b": "node"}, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 0 || len(result["node"]) != 2 {
+		t.Fatalf("expected both running services kept, got result=%#v pending=%#v", result, pending)
+	}
+}
+
+func TestScheduleWithStorageSpreadsReplicas(t *testing.T) {
+	var services []config.ServiceConfig
+	for _, name := range []string{"web-0", "web-1", "web-2"} {
+		service := svc(name, 1, 256)
+		service.ReplicaOf = "web"
+		services = append(services, service)
+	}
+	nodes := []Node{
+		{InstanceID: "a", CapacityVCPUs: 16, CapacityMemMB: 8192},
+		{InstanceID: "b", CapacityVCPUs: 4, CapacityMemMB: 1024},
+	}
+	result, pending := ScheduleWithStorage(services, nodes, nil, StorageReservations{}, ScheduleOptions{})
+	if len(result["a"]) != 1 || len(result["b"]) != 1 {
+		t.Fatalf("expected one replica per node, got %#v", result)
+	}
+	if len(pending) != 1 || pending[0].Service != "web-2" || pending[0].ReasonCode != "replica_spread_unsatisfied" {
+		t.Fatalf("expected web-2 pending on replica spread, got %#v", pending)
+	}
+
+	// A replica keeps its node even when a sibling placed before it would
+	// fit there best.
+	nodes = append(nodes, Node{InstanceID: "c", CapacityVCPUs: 4, CapacityMemMB: 1024})
+	result, pending = ScheduleWithStorage(services, nodes, map[string]string{"web-1": "a", "web-2": "b"}, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 0 || result["a"][0].Name != "web-1" || result["b"][0].Name != "web-2" || result["c"][0].Name != "web-0" {
+		t.Fatalf("expected replicas to keep their nodes, got result=%#v pending=%#v", result, pending)
+	}
+}
+
+func TestScheduleWithStoragePreemptsLowerPriorityWithinBudget(t *testing.T) {
+	prioritized := func(name string, vcpus, priority int) config.ServiceConfig {
+		service := svc(name, vcpus, 256)
+		service.Priority = priority
+		return service
+	}
+	services := []config.ServiceConfig{
+		prioritized("batch-a", 2, 0), prioritized("batch-b", 2, 0), prioritized("cache", 2, 5),
+		prioritized("api", 4, 10), prioritized("web", 4, 10),
+	}
+	nodes := []Node{
+		{InstanceID: "n1", CapacityVCPUs: 4, CapacityMemMB: 4096},
+		{InstanceID: "n2", CapacityVCPUs: 4, CapacityMemMB: 4096},
+	}
+	existing := map[string]string{"batch-a": "n1", "batch-b": "n1", "cache": "n2"}
+
+	// Without a budget the running services keep their nodes.
+	_, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 2 || pending[0].ReasonCode != "insufficient_compute_capacity" || pending[1].ReasonCode != "insufficient_compute_capacity" {
+		t.Fatalf("expected api and web pending on capacity, got %#v", pending)
+	}
+
+	// api evicts the lowest priority node first: cache alone frees n2.
+	result, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{PreemptionBudget: 2})
+	if len(result["n2"]) != 1 || result["n2"][0].Name != "api" || len(result["n1"]) != 2 {
+		t.Fatalf("expected api to replace cache on n2, got %#v", result)
+	}
+	byService := make(map[string]Pending)
+	for _, p := range pending {
+		byService[p.Service] = p
+	}
+	if p := byService["cache"]; p.ReasonCode != ReasonPreempted || p.Message != "preempted by api (priority 10)" {
+		t.Fatalf("cache pending = %#v", p)
+	}
+	// web needs both batch services evicted, more than the budget left.
+	if p := byService["web"]; p.ReasonCode != ReasonPreemptionDeferred {
+		t.Fatalf("web pending = %#v", p)
+	}
+
+	// On the next call api keeps its node and web spends a fresh budget.
+	existing = map[string]string{"batch-a": "n1", "batch-b": "n1", "api": "n2"}
+	result, pending = ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{PreemptionBudget: 2})
+	if len(result["n1"]) != 1 || result["n1"][0].Name != "web" || result["n2"][0].Name != "api" || len(pending) != 3 {
+		t.Fatalf("expected web to replace the batch services, got result=%#v pending=%#v", result, pending)
+	}
+
+	// Equal priorities never preempt each other.
+	services[4].Priority = 0
+	_, pending = ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{PreemptionBudget: 2})
+	if len(pending) != 2 || pending[1].Service != "web" || pending[1].ReasonCode != "insufficient_compute_capacity" {
+		t.Fatalf("expected web pending without preemption, got %#v", pending)
+	}
+}
+
+func TestScheduleWithStoragePreemptionStopsOnceBudgetIsSpent(t *testing.T) {
+	prioritized := func(name string, vcpus, priority int) config.ServiceConfig {
+		service := svc(name, vcpus, 256)
+		service.Priority = priority
+		return service
+	}
+	services := []config.ServiceConfig{
+		prioritized("batch-a", 4, 0), prioritized("batch-b", 4, 0),
+		prioritized("api", 4, 10), prioritized("web", 4, 9),
+	}
+	nodes := []Node{node("n1", 4, 4096), node("n2", 4, 4096)}
+	existing := map[string]string{"batch-a": "n1", "batch-b": "n2"}
+
+	_, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{PreemptionBudget: 1})
+	byService := make(map[string]Pending)
+	for _, p := range pending {
+		byService[p.Service] = p
+	}
+	if len(pending) != 2 || byService["batch-a"].ReasonCode != ReasonPreempted {
+		t.Fatalf("expected api to preempt batch-a only, got %#v", pending)
+	}
+	if p := byService["web"]; p.ReasonCode != "insufficient_compute_capacity" {
+		t.Fatalf("web pending = %#v, want its plain capacity reason", p)
+	}
+}
+
+func TestScheduleWithStoragePreemptedServiceMovesToFreeNode(t *testing.T) {
+	prioritized := func(name string, vcpus, priority int) config.ServiceConfig {
+		service := svc(name, vcpus, 256)
+		service.Priority = priority
+		return service
+	}
+	services := []config.ServiceConfig{
+		prioritized("batch", 2, 0), prioritized("cron", 2, 0), prioritized("cache", 2, 10),
+		prioritized("api", 4, 10),
+	}
+	nodes := []Node{node("n1", 4, 4096), node("n2", 4, 4096)}
+	existing := map[string]string{"batch": "n1", "cron": "n1", "cache": "n2"}
+
+	// api needs all of n1; batch fits next to cache on n2, cron nowhere.
+	result, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{PreemptionBudget: 2})
+	placed := placedOn(result)
+	if placed["api"] != "n1" || placed["batch"] != "n2" || placed["cache"] != "n2" {
+		t.Fatalf("expected api on n1 and batch moved to n2, got %v", placed)
+	}
+	if len(pending) != 1 || pending[0].Service != "cron" || pending[0].ReasonCode != ReasonPreempted {
+		t.Fatalf("expected only cron preempted, got %#v", pending)
+	}
+}
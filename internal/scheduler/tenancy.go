@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// Tenancy modes. A service's tenant is its metadata.tenant, which the
+// enricher sets on every tenant expansion; services without a tenant are
+// platform services.
+const (
+	// TenancyShared lets services of any tenants share a node.
+	TenancyShared = "shared"
+	// TenancyDedicated gives every node to at most one tenant. Platform
+	// services may still run next to any tenant.
+	TenancyDedicated = "dedicated"
+	// TenancyPool places a tenant only on nodes labeled <label>=<tenant>.
+	// Nodes without such a label form the platform pool and take no
+	// tenant services; pool nodes take no platform services.
+	TenancyPool = "pool"
+)
+
+// Tenancy is the parsed tenancy policy of the controller.
+type Tenancy struct {
+	Mode string
+	// PoolLabel is the node label key of TenancyPool.
+	PoolLabel string
+}
+
+// ParseTenancy parses "shared", "dedicated" or "pool:<label>". An empty
+// policy is shared.
+func ParseTenancy(policy string) (Tenancy, error) {
+	switch {
+	case policy == "" || policy == TenancyShared:
+		return Tenancy{Mode: TenancyShared}, nil
+	case policy == TenancyDedicated:
+		return Tenancy{Mode: TenancyDedicated}, nil
+	case strings.HasPrefix(policy, TenancyPool+":"):
+		label := strings.TrimPrefix(policy, TenancyPool+":")
+		if label == "" || strings.ContainsAny(label, "= \t") {
+			return Tenancy{}, fmt.Errorf("tenancy %q: pool label must be a non-empty label key", policy)
+		}
+		return Tenancy{Mode: TenancyPool, PoolLabel: label}, nil
+	default:
+		return Tenancy{}, fmt.Errorf("unsupported tenancy %q (expected shared, dedicated, or pool:<label>)", policy)
+	}
+}
+
+// String returns the policy in its configuration form.
+func (t Tenancy) String() string {
+	if t.Mode == TenancyPool {
+		return TenancyPool + ":" + t.PoolLabel
+	}
+	if t.Mode == "" {
+		return TenancyShared
+	}
+	return t.Mode
+}
+
+// ServiceTenant returns the tenant a service belongs to, or "" for platform
+// services.
+func ServiceTenant(service config.ServiceConfig) string {
+	return strings.TrimSpace(service.Metadata["tenant"])
+}
+
+// tenantTracker enforces a tenancy policy while services are placed one at a
+// time. Under TenancyDedicated it remembers which tenant owns each node.
+type tenantTracker struct {
+	policy Tenancy
+	owner  map[string]string
+}
+
+// newTenantTracker seeds node ownership from the existing assignment. A node
+// that only hosts one tenant stays reserved for it, so the placement order
+// cannot hand a tenant's node to another tenant and move everything around.
+func newTenantTracker(policy Tenancy, services []config.ServiceConfig, existing map[string]string) *tenantTracker {
+	t := &tenantTracker{policy: policy, owner: make(map[string]string)}
+	if policy.Mode != TenancyDedicated {
+		return t
+	}
+	mixed := make(map[string]bool)
+	for _, service := range services {
+		tenant, node := ServiceTenant(service), existing[service.Name]
+		if tenant == "" || node == "" || mixed[node] {
+			continue
+		}
+		if owner, ok := t.owner[node]; ok && owner != tenant {
+			delete(t.owner, node)
+			mixed[node] = true
+			continue
+		}
+		t.owner[node] = tenant
+	}
+	return t
+}
+
+// allows reports whether the policy lets service run on node.
+func (t *tenantTracker) allows(node Node, service config.ServiceConfig) bool {
+	tenant := ServiceTenant(service)
+	switch t.policy.Mode {
+	case TenancyDedicated:
+		if tenant == "" {
+			return true
+		}
+		owner, ok := t.owner[node.InstanceID]
+		return !ok || owner == tenant
+	case TenancyPool:
+		pool := ""
+		prefix := t.policy.PoolLabel + "="
+		for _, label := range node.Labels {
+			if strings.HasPrefix(label, prefix) {
+				pool = strings.TrimPrefix(label, prefix)
+				break
+			}
+		}
+		return pool == tenant
+	default:
+		return true
+	}
+}
+
+// owns reports whether node already belongs to the service's tenant. Packing
+// a tenant onto its own nodes keeps unowned nodes free for other tenants.
+func (t *tenantTracker) owns(node Node, service config.ServiceConfig) bool {
+	tenant := ServiceTenant(service)
+	return tenant != "" && t.owner[node.InstanceID] == tenant
+}
+
+// place records that service now runs on node.
+func (t *tenantTracker) place(node string, service config.ServiceConfig) {
+	if tenant := ServiceTenant(service); t.policy.Mode == TenancyDedicated && tenant != "" {
+		t.owner[node] = tenant
+	}
+}
+
+// release records that service no longer runs on node, whose remaining
+// services are placed. The node stops belonging to the service's tenant once
+// none of them belongs to it.
+func (t *tenantTracker) release(node string, service config.ServiceConfig, placed []config.ServiceConfig) {
+	tenant := ServiceTenant(service)
+	if t.policy.Mode != TenancyDedicated || tenant == "" || t.owner[node] != tenant {
+		return
+	}
+	if !slices.ContainsFunc(placed, func(other config.ServiceConfig) bool { return ServiceTenant(other) == tenant }) {
+		delete(t.owner, node)
+	}
+}
+
+// pending explains why no node was allowed for service.
+func (t *tenantTracker) pending(service config.ServiceConfig) Pending {
+	tenant := ServiceTenant(service)
+	message := fmt.Sprintf("every eligible node hosts another tenant than %s", tenant)
+	switch {
+	case t.policy.Mode == TenancyPool && tenant == "":
+		message = fmt.Sprintf("no eligible node outside the %s tenant pools", t.policy.PoolLabel)
+	case t.policy.Mode == TenancyPool:
+		message = fmt.Sprintf("no eligible node is labeled %s=%s", t.policy.PoolLabel, tenant)
+	}
+	return Pending{Service: service.Name, ReasonCode: "tenant_isolation_unsatisfied", Message: message}
+}
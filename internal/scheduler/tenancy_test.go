@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func tenantSvc(name, tenant string) config.ServiceConfig {
+	service := svc(name, 1, 256)
+	if tenant != "" {
+		service.Metadata = map[string]string{"tenant": tenant}
+	}
+	return service
+}
+
+func TestParseTenancy(t *testing.T) {
+	cases := map[string]Tenancy{
+		"":                 {Mode: TenancyShared},
+		"shared":           {Mode: TenancyShared},
+		"dedicated":        {Mode: TenancyDedicated},
+		"pool:tenant-pool": {Mode: TenancyPool, PoolLabel: "tenant-pool"},
+	}
+	for policy, want := range cases {
+		got, err := ParseTenancy(policy)
+		if err != nil || got != want {
+			t.Errorf("ParseTenancy(%q) = %+v, %v; want %+v", policy, got, err, want)
+		}
+	}
+	for _, policy := range []string{"isolated", "pool", "pool:", "pool:a=b"} {
+		if _, err := ParseTenancy(policy); err == nil {
+			t.Errorf("ParseTenancy(%q) should fail", policy)
+		}
+	}
+}
+
+func TestScheduleWithStorageDedicatedTenancyKeepsTenantsApart(t *testing.T) {
+	services := []config.ServiceConfig{
+		tenantSvc("acme-web", "acme"),
+		tenantSvc("acme-db", "acme"),
+		tenantSvc("globex-web", "globex"),
+		tenantSvc("monitoring", ""),
+	}
+	nodes := []Node{node("n1", 8, 4096), node("n2", 8, 4096)}
+	dedicated := Tenancy{Mode: TenancyDedicated}
+
+	result, pending := ScheduleWithStorage(services, nodes, nil, StorageReservations{}, ScheduleOptions{Tenancy: dedicated})
+	if len(pending) != 0 {
+		t.Fatalf("unexpected pending: %#v", pending)
+	}
+	for node, placed := range result {
+		tenants := make(map[string]bool)
+		for _, service := range placed {
+			if tenant := ServiceTenant(service); tenant != "" {
+				tenants[tenant] = true
+			}
+		}
+		if len(tenants) > 1 {
+			t.Fatalf("node %s hosts several tenants: %v", node, tenants)
+		}
+	}
+
+	// A third tenant finds no free node.
+	services = append(services, tenantSvc("initech-web", "initech"))
+	_, pending = ScheduleWithStorage(services, nodes, nil, StorageReservations{}, ScheduleOptions{Tenancy: dedicated})
+	if len(pending) != 1 || pending[0].Service != "initech-web" || pending[0].ReasonCode != "tenant_isolation_unsatisfied" {
+		t.Fatalf("expected initech-web pending on tenancy, got %#v", pending)
+	}
+}
+
+func TestScheduleWithStorageDedicatedTenancyKeepsExistingOwner(t *testing.T) {
+	// globex-big sorts first, but n1 already belongs to acme and must not be
+	// taken over.
+	big := tenantSvc("globex-big", "globex")
+	big.VCPUs = 2
+	services := []config.ServiceConfig{tenantSvc("acme-web", "acme"), big}
+	nodes := []Node{node("n1", 8, 4096), node("n2", 4, 4096)}
+	existing := map[string]string{"acme-web": "n1"}
+
+	result, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, ScheduleOptions{Tenancy: Tenancy{Mode: TenancyDedicated}})
+	if len(pending) != 0 || len(result["n1"]) != 1 || result["n1"][0].Name != "acme-web" {
+		t.Fatalf("expected acme-web to stay on n1 alone, got result=%#v pending=%#v", result, pending)
+	}
+}
+
+func TestScheduleWithStoragePoolTenancyUsesTenantPools(t *testing.T) {
+	services := []config.ServiceConfig{tenantSvc("acme-web", "acme"), tenantSvc("monitoring", ""), tenantSvc("globex-web", "globex")}
+	nodes := []Node{
+		{InstanceID: "platform", CapacityVCPUs: 8, CapacityMemMB: 4096},
+		{InstanceID: "acme-1", Labels: []string{"tenant-pool=acme"}, CapacityVCPUs: 2, CapacityMemMB: 1024},
+	}
+	result, pending := ScheduleWithStorage(services, nodes, nil, StorageReservations{}, ScheduleOptions{Tenancy: Tenancy{Mode: TenancyPool, PoolLabel: "tenant-pool"}})
+	if len(result["acme-1"]) != 1 || result["acme-1"][0].Name != "acme-web" {
+		t.Fatalf("expected acme-web in its pool, got %#v", result)
+	}
+	if len(result["platform"]) != 1 || result["platform"][0].Name != "monitoring" {
+		t.Fatalf("expected monitoring on the platform node only, got %#v", result)
+	}
+	if len(pending) != 1 || pending[0].Service != "globex-web" || pending[0].ReasonCode != "tenant_isolation_unsatisfied" {
+		t.Fatalf("expected globex-web pending without a pool, got %#v", pending)
+	}
+}
+
+func TestScheduleWithStoragePreemptionReleasesTenantNode(t *testing.T) {
+	sized := func(name, tenant string, vcpus, priority int) config.ServiceConfig {
+		service := tenantSvc(name, tenant)
+		service.VCPUs, service.Priority = vcpus, priority
+		return service
+	}
+	services := []config.ServiceConfig{
+		sized("globex-batch", "globex", 4, 0), sized("acme-app", "acme", 4, 0),
+		sized("api", "", 2, 10), sized("acme-web", "acme", 2, 5),
+	}
+	nodes := []Node{node("n1", 4, 4096), node("n2", 4, 4096)}
+	existing := map[string]string{"globex-batch": "n1", "acme-app": "n2"}
+	opts := ScheduleOptions{Tenancy: Tenancy{Mode: TenancyDedicated}, PreemptionBudget: 2}
+
+	// api evicts globex's only service from n1, so n1 no longer belongs to
+	// globex and acme-web takes the room left without evicting acme-app.
+	result, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, opts)
+	if len(result["n1"]) != 2 || result["n1"][0].Name != "api" || result["n1"][1].Name != "acme-web" {
+		t.Fatalf("expected api and acme-web on n1, got %#v", result)
+	}
+	if len(result["n2"]) != 1 || result["n2"][0].Name != "acme-app" {
+		t.Fatalf("expected acme-app to keep n2, got %#v", result)
+	}
+	if len(pending) != 1 || pending[0].Service != "globex-batch" || pending[0].ReasonCode != ReasonPreempted {
+		t.Fatalf("expected only globex-batch preempted, got %#v", pending)
+	}
+}
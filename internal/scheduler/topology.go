@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// topologyTracker spreads groups of services across the failure domains nodes
+// report in their topology, such as zones. A group is the replicas of a
+// service or an anti-affinity group.
+type topologyTracker struct {
+	// nodes are the active nodes, whose domains the skew is measured over.
+	nodes []Node
+	// placed counts the members of each group per topology key and value.
+	placed map[string]map[string]map[string]int
+}
+
+func newTopologyTracker(nodes []Node) *topologyTracker {
+	return &topologyTracker{nodes: nodes, placed: make(map[string]map[string]map[string]int)}
+}
+
+// ServiceGroups returns the keys of the groups a service belongs to: the
+// replicas of its service first, then its anti-affinity group.
+func ServiceGroups(service config.ServiceConfig) []string {
+	var groups []string
+	if service.ReplicaOf != "" {
+		groups = append(groups, "replicas:"+service.ReplicaOf)
+	}
+	if service.AntiAffinityGroup != "" {
+		groups = append(groups, "group:"+service.AntiAffinityGroup)
+	}
+	return groups
+}
+
+// spreadGroup returns the group a service is spread with, or "" if it has
+// none. Replicas spread as one group even within an anti-affinity group.
+func spreadGroup(service config.ServiceConfig) string {
+	if groups := ServiceGroups(service); len(groups) > 0 {
+		return groups[0]
+	}
+	return ""
+}
+
+// load returns how many members of the service's group run in the domain of
+// node.
+func (t *topologyTracker) load(node Node, service config.ServiceConfig) int {
+	if service.TopologySpread == nil {
+		return 0
+	}
+	key := service.TopologySpread.Key
+	return t.placed[spreadGroup(service)][key][node.Topology[key]]
+}
+
+// filter drops the candidates a service may not be placed on without
+// exceeding its max skew: those outside every domain of its topology key,
+// and those whose domain already holds max skew more members than the least
+// loaded domain. The least loaded domain is taken over every node matching
+// the service's node selector, not only the candidates, so a domain whose
+// nodes are full or taken by another tenant still bounds the spread. The
+// node the service runs on is exempt from the skew, so spreading never moves
+// a running service.
+func (t *topologyTracker) filter(candidates []Node, service config.ServiceConfig, current string) []Node {
+	if service.TopologySpread == nil || spreadGroup(service) == "" {
+		return candidates
+	}
+	key := service.TopologySpread.Key
+	counts := t.placed[spreadGroup(service)][key]
+	least := -1
+	for _, node := range t.nodes {
+		if value, ok := node.Topology[key]; ok && matchesRequired(node, service) && (least < 0 || counts[value] < least) {
+			least = counts[value]
+		}
+	}
+	var allowed []Node
+	for _, node := range candidates {
+		value, ok := node.Topology[key]
+		if !ok {
+			continue
+		}
+		if node.InstanceID == current || counts[value]+1-least <= service.TopologySpread.MaxSkew {
+			allowed = append(allowed, node)
+		}
+	}
+	return allowed
+}
+
+// place records that service now runs on node.
+func (t *topologyTracker) place(node Node, service config.ServiceConfig) {
+	t.add(node, service, 1)
+}
+
+// remove records that service no longer runs on node.
+func (t *topologyTracker) remove(node Node, service config.ServiceConfig) {
+	t.add(node, service, -1)
+}
+
+func (t *topologyTracker) add(node Node, service config.ServiceConfig, delta int) {
+	group := spreadGroup(service)
+	if group == "" {
+		return
+	}
+	if t.placed[group] == nil {
+		t.placed[group] = make(map[string]map[string]int)
+	}
+	for key, value := range node.Topology {
+		if t.placed[group][key] == nil {
+			t.placed[group][key] = make(map[string]int)
+		}
+		t.placed[group][key][value] += delta
+	}
+}
+
+// pending explains why no node was allowed for service.
+func (t *topologyTracker) pending(service config.ServiceConfig) Pending {
+	spread := service.TopologySpread
+	return Pending{Service: service.Name, ReasonCode: "topology_spread_unsatisfied",
+		Message: fmt.Sprintf("no eligible node with a %s label keeps the spread within max skew %d", spread.Key, spread.MaxSkew)}
+}
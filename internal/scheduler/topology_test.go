@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func zonedNode(id, zone string) Node {
+	n := node(id, 8, 8192)
+	if zone != "" {
+		n.Topology = map[string]string{"zone": zone}
+	}
+	return n
+}
+
+func spreadReplicas(count, maxSkew int) []config.ServiceConfig {
+	var services []config.ServiceConfig
+	for i := range count {
+		service := svc(fmt.Sprintf("web-%d", i), 1, 256)
+		service.ReplicaOf = "web"
+		service.TopologySpread = &config.TopologySpread{Key: "zone", MaxSkew: maxSkew}
+		services = append(services, service)
+	}
+	return services
+}
+
+func placedOn(result map[string][]config.ServiceConfig) map[string]string {
+	nodes := make(map[string]string)
+	for node, services := range result {
+		for _, service := range services {
+			nodes[service.Name] = node
+		}
+	}
+	return nodes
+}
+
+func TestScheduleWithStorageSpreadsAcrossZonesFirst(t *testing.T) {
+	nodes := []Node{zonedNode("a1", "a"), zonedNode("a2", "a"), zonedNode("b1", "b")}
+	result, pending := ScheduleWithStorage(spreadReplicas(4, 1), nodes, nil, StorageReservations{}, ScheduleOptions{})
+	placed := placedOn(result)
+	if placed["web-0"] != "a1" || placed["web-1"] != "b1" || placed["web-2"] != "a2" {
+		t.Fatalf("expected zones to alternate, got %v", placed)
+	}
+	// A fourth replica fits only in zone b, where b1 already runs one.
+	if len(pending) != 1 || pending[0].Service != "web-3" || pending[0].ReasonCode != "replica_spread_unsatisfied" {
+		t.Fatalf("expected web-3 pending, got %#v", pending)
+	}
+
+	// A larger skew lets a zone run ahead.
+	nodes = append(nodes, zonedNode("a3", "a"))
+	result, pending = ScheduleWithStorage(spreadReplicas(4, 2), nodes, placed, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 0 || placedOn(result)["web-3"] != "a3" {
+		t.Fatalf("expected web-3 on a3, got result=%#v pending=%#v", result, pending)
+	}
+}
+
+func TestScheduleWithStorageTopologySpreadKeepsRunningServices(t *testing.T) {
+	nodes := []Node{zonedNode("a1", "a"), zonedNode("a2", "a"), zonedNode("b1", "b"), zonedNode("none", "")}
+	existing := map[string]string{"web-0": "a1", "web-1": "a2"}
+	result, pending := ScheduleWithStorage(spreadReplicas(3, 1), nodes, existing, StorageReservations{}, ScheduleOptions{})
+	placed := placedOn(result)
+	if len(pending) != 0 || placed["web-0"] != "a1" || placed["web-1"] != "a2" || placed["web-2"] != "b1" {
+		t.Fatalf("expected running replicas kept and web-2 in zone b, got %v pending=%#v", placed, pending)
+	}
+
+	// Nodes without the topology key never take a spread service.
+	_, pending = ScheduleWithStorage(spreadReplicas(1, 1), []Node{zonedNode("none", "")}, nil, StorageReservations{}, ScheduleOptions{})
+	if len(pending) != 1 || pending[0].ReasonCode != "topology_spread_unsatisfied" {
+		t.Fatalf("expected topology_spread_unsatisfied, got %#v", pending)
+	}
+}
+
+func TestScheduleWithStorageTopologySpreadCountsDomainsWithoutCandidates(t *testing.T) {
+	// Zone c's only node belongs to another tenant, so web never lands there,
+	// but the empty zone still bounds how far a and b may run ahead.
+	nodes := []Node{zonedNode("a1", "a"), zonedNode("a2", "a"), zonedNode("b1", "b"), zonedNode("c1", "c")}
+	services := []config.ServiceConfig{tenantSvc("globex-db", "globex")}
+	for _, replica := range spreadReplicas(3, 1) {
+		replica.Metadata = map[string]string{"tenant": "acme"}
+		services = append(services, replica)
+	}
+	existing := map[string]string{"globex-db": "c1"}
+	opts := ScheduleOptions{Tenancy: Tenancy{Mode: TenancyDedicated}}
+
+	result, pending := ScheduleWithStorage(services, nodes, existing, StorageReservations{}, opts)
+	placed := placedOn(result)
+	if placed["globex-db"] != "c1" || placed["web-0"] != "a1" || placed["web-1"] != "b1" {
+		t.Fatalf("expected web-0 and web-1 in zones a and b, got %v", placed)
+	}
+	if len(pending) != 1 || pending[0].Service != "web-2" || pending[0].ReasonCode != "topology_spread_unsatisfied" {
+		t.Fatalf("expected web-2 pending on the spread, got %#v", pending)
+	}
+}
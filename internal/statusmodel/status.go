@@ -42,6 +42,7 @@ type Condition struct {
 
 type ServiceStatus struct {
 	Name                string         `json:"name"`
+	Kind                string         `json:"kind,omitempty"`
 	VMState             string         `json:"vm_state"`
 	PID                 int            `json:"pid,omitempty"`
 	NetworkAddress      string         `json:"network_address,omitempty"`
@@ -50,12 +51,23 @@ type ServiceStatus struct {
 	HealthLastCheckedAt time.Time      `json:"health_last_checked_at,omitempty"`
 	HealthFailures      int            `json:"health_failures,omitempty"`
 	RestartCount        int            `json:"restart_count,omitempty"`
+	MemoryUsedMB        int            `json:"memory_used_mb,omitempty"`
+	BalloonMB           int            `json:"balloon_mb,omitempty"`
+	CPUPercent          *int           `json:"cpu_percent,omitempty"`
+	ExitCode            *int           `json:"exit_code,omitempty"`
+	FinishedAt          time.Time      `json:"finished_at,omitempty"`
 	LastTransitionAt    time.Time      `json:"last_transition_at,omitempty"`
 	ReasonCode          string         `json:"reason_code,omitempty"`
 	Message             string         `json:"message,omitempty"`
 	Volumes             []VolumeStatus `json:"volumes,omitempty"`
 }
 
+// Serving reports whether a service should receive traffic: its VM runs and
+// its health check, of whatever type, is not failing.
+func (s ServiceStatus) Serving() bool {
+	return s.VMState == "running" && s.Health != "unhealthy"
+}
+
 type VolumeStatus struct {
 	LogicalID        string `json:"logical_id"`
 	Type             string `json:"type"`
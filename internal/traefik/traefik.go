@@ -3,14 +3,19 @@
 // small YAML file per service when a service is created and deletes it
 // when the service is removed. Traefik watches the directory and picks
 // up changes without a reload.
+//
+// The replicas of a service share one file: a single router whose
+// load balancer lists every serving replica, local and remote.
 package traefik
 
 import (
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/artemnikitin/firework/internal/config"
@@ -37,6 +42,9 @@ type Manager struct {
 	configDir     string
 	ingressDomain string
 	logger        *slog.Logger
+	// serving reports whether a replica receives traffic; nil serves every
+	// replica.
+	serving func(service string) bool
 }
 
 // NewManager creates a Manager that writes files to configDir. ingressDomain is
@@ -49,6 +57,13 @@ func NewManager(configDir, ingressDomain string, logger *slog.Logger) *Manager {
 	return &Manager{configDir: filepath.Clean(configDir), ingressDomain: ingressDomain, logger: logger}
 }
 
+// SetReplicaHealth makes the load balancer of a replicated service list only
+// the replicas for which serving returns true. It must be called before the
+// first Sync.
+func (m *Manager) SetReplicaHealth(serving func(service string) bool) {
+	m.serving = serving
+}
+
 // Sync brings the Traefik dynamic config directory in line with services.
 //
 // Local routes are strict: invalid routing metadata, an unroutable local
@@ -68,12 +83,15 @@ func NewManager(configDir, ingressDomain string, logger *slog.Logger) *Manager {
 // successfully; if application fails partway through, no stale file is deleted
 // and the next tick converges.
 func (m *Manager) Sync(services []config.ServiceConfig, remoteNodes []config.NodeConfig) error {
-	desired, owner, err := m.renderLocal(services)
+	routes, err := m.renderLocal(services)
 	if err != nil {
 		return err
 	}
-	m.renderRemote(desired, owner, remoteNodes)
-	return m.apply(desired, false)
+	m.renderRemote(routes, remoteNodes)
+	if err := m.renderReplicas(routes); err != nil {
+		return err
+	}
+	return m.apply(routes.desired, false)
 }
 
 // SyncLocal applies routes for local services only and leaves every existing
@@ -82,50 +100,109 @@ func (m *Manager) Sync(services []config.ServiceConfig, remoteNodes []config.Nod
 // against an empty peer set would delete valid routes) while local routes stay
 // current.
 func (m *Manager) SyncLocal(services []config.ServiceConfig) error {
-	desired, _, err := m.renderLocal(services)
+	routes, err := m.renderLocal(services)
 	if err != nil {
 		return err
 	}
-	return m.apply(desired, true)
+	if err := m.renderReplicas(routes); err != nil {
+		return err
+	}
+	return m.apply(routes.desired, true)
 }
 
-// renderLocal resolves and validates local routes into a filename -> YAML
-// document map plus the hostname -> owner claims made so far. It returns an
-// error (mutating nothing) for invalid routing metadata, an unroutable
-// service, or a duplicate hostname among local services.
-func (m *Manager) renderLocal(services []config.ServiceConfig) (map[string][]byte, map[string]string, error) {
-	desired := make(map[string][]byte)
-	owner := make(map[string]string) // final hostname -> service identity
+// routeSet is the route files being rendered.
+type routeSet struct {
+	desired map[string][]byte // filename -> YAML document
+	owner   map[string]string // final hostname -> service identity
+	// replicas collects the servers of replicated services by the service
+	// they are replicas of; renderReplicas turns them into files.
+	replicas map[string]*replicaRoute
+}
+
+// replicaRoute is the shared route of a replicated service.
+type replicaRoute struct {
+	host    string
+	local   bool
+	servers []serverDef
+	health  *healthCheckDef
+}
+
+// addReplica adds a server to the route of the service svc is a replica of,
+// unless the replica is not serving. The route is kept even when no replica
+// serves, so the hostname answers with an error instead of disappearing. It
+// fails when the replica resolves to another hostname than its siblings.
+func (r *routeSet) addReplica(svc config.ServiceConfig, host, url string, local, serving bool) error {
+	route := r.replicas[svc.ReplicaOf]
+	if route == nil {
+		route = &replicaRoute{host: host, health: lbHealthCheck(svc.HealthCheck)}
+		r.replicas[svc.ReplicaOf] = route
+	}
+	if route.host != host {
+		return fmt.Errorf("replica %s resolves to hostname %q, its siblings to %q", svc.Name, host, route.host)
+	}
+	route.local = route.local || local
+	if serving {
+		route.servers = append(route.servers, serverDef{URL: url})
+	}
+	return nil
+}
+
+// renderLocal resolves and validates local routes. It returns an error
+// (mutating nothing) for invalid routing metadata, an unroutable service, or
+// a duplicate hostname among local services.
+func (m *Manager) renderLocal(services []config.ServiceConfig) (*routeSet, error) {
+	routes := &routeSet{desired: make(map[string][]byte), owner: make(map[string]string), replicas: make(map[string]*replicaRoute)}
 
 	// Local services: proxy to the VM guest IP.
 	for _, svc := range services {
 		host, err := ingress.Resolve(svc.Name, svc.Metadata, m.ingressDomain)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		if host == "" {
 			continue
 		}
 		if svc.Network == nil || svc.Network.GuestIP == "" {
-			return nil, nil, fmt.Errorf("service %s requests routing (host %q) but has no resolved guest network", svc.Name, host)
+			return nil, fmt.Errorf("service %s requests routing (host %q) but has no resolved guest network", svc.Name, host)
 		}
 		port := backendPort(svc)
 		if port == 0 {
-			return nil, nil, fmt.Errorf("service %s requests routing (host %q) but has no usable backend port", svc.Name, host)
+			return nil, fmt.Errorf("service %s requests routing (host %q) but has no usable backend port", svc.Name, host)
 		}
-		identity := "local service " + svc.Name
-		if prev, ok := owner[host]; ok {
-			return nil, nil, fmt.Errorf("duplicate Traefik hostname %q requested by %s and %s", host, prev, identity)
+		identity := serviceIdentity(svc, "local service "+svc.Name)
+		if prev, ok := routes.owner[host]; ok && prev != identity {
+			return nil, fmt.Errorf("duplicate Traefik hostname %q requested by %s and %s", host, prev, identity)
 		}
-		owner[host] = identity
-		data, err := marshalConfig(svc.Name, host, svc.Network.GuestIP, port)
+		routes.owner[host] = identity
+		url := "http://" + net.JoinHostPort(svc.Network.GuestIP, strconv.Itoa(port))
+		if svc.ReplicaOf != "" {
+			if err := routes.addReplica(svc, host, url, true, m.replicaServing(svc.Name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data, err := marshalConfig(svc.Name, host, lbDef{Servers: []serverDef{{URL: url}}})
 		if err != nil {
-			return nil, nil, fmt.Errorf("marshaling traefik config for %s: %w", svc.Name, err)
+			return nil, fmt.Errorf("marshaling traefik config for %s: %w", svc.Name, err)
 		}
-		desired[configFileName(svc.Name)] = data
+		routes.desired[configFileName(svc.Name)] = data
 	}
 
-	return desired, owner, nil
+	return routes, nil
+}
+
+// replicaServing reports whether the replica name receives traffic.
+func (m *Manager) replicaServing(name string) bool {
+	return m.serving == nil || m.serving(name)
+}
+
+// serviceIdentity names the owner of a hostname in conflict messages. All
+// replicas of a service share one identity, so they may share a hostname.
+func serviceIdentity(svc config.ServiceConfig, single string) string {
+	if svc.ReplicaOf != "" {
+		return "replicas of service " + svc.ReplicaOf
+	}
+	return single
 }
 
 // renderRemote adds routes for peer-node services to desired. Peer configs are
@@ -134,7 +211,7 @@ func (m *Manager) renderLocal(services []config.ServiceConfig) (map[string][]byt
 // or that lose a hostname/filename claim are skipped with a warning. Peers are
 // visited in node-name order and services in name order, so the outcome is
 // deterministic for a given input set.
-func (m *Manager) renderRemote(desired map[string][]byte, owner map[string]string, remoteNodes []config.NodeConfig) {
+func (m *Manager) renderRemote(routes *routeSet, remoteNodes []config.NodeConfig) {
 	nodes := append([]config.NodeConfig(nil), remoteNodes...)
 	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
 
@@ -163,30 +240,70 @@ func (m *Manager) renderRemote(desired map[string][]byte, owner map[string]strin
 					"service", svc.Name, "node", nc.Node, "host", host)
 				continue
 			}
-			identity := "remote service " + svc.Name + " on node " + nc.Node
-			if prev, ok := owner[host]; ok {
+			identity := serviceIdentity(svc, "remote service "+svc.Name+" on node "+nc.Node)
+			if prev, ok := routes.owner[host]; ok && prev != identity {
 				m.logger.Warn("skipping remote route for already-claimed hostname",
 					"host", host, "kept", prev, "skipped", identity)
 				continue
 			}
+			url := "http://" + net.JoinHostPort(nc.HostIP, strconv.Itoa(svc.PortForwards[0].HostPort))
+			if svc.ReplicaOf != "" {
+				if err := routes.addReplica(svc, host, url, false, m.replicaServing(svc.Name)); err != nil {
+					m.logger.Warn("skipping remote replica", "service", svc.Name, "node", nc.Node, "error", err)
+					continue
+				}
+				routes.owner[host] = identity
+				continue
+			}
 			filename := remoteConfigFileName(svc.Name)
-			if _, ok := desired[filename]; ok {
+			if _, ok := routes.desired[filename]; ok {
 				m.logger.Warn("skipping remote route with conflicting file name",
 					"file", filename, "skipped", identity)
 				continue
 			}
-			data, err := marshalConfig(svc.Name, host, nc.HostIP, svc.PortForwards[0].HostPort)
+			data, err := marshalConfig(svc.Name, host, lbDef{Servers: []serverDef{{URL: url}}})
 			if err != nil {
 				m.logger.Warn("skipping remote route that failed to marshal",
 					"service", svc.Name, "node", nc.Node, "error", err)
 				continue
 			}
-			owner[host] = identity
-			desired[filename] = data
+			routes.owner[host] = identity
+			routes.desired[filename] = data
 		}
 	}
 }
 
+// renderReplicas adds one file per replicated service: a local route file
+// when a replica runs on this node, a remote one otherwise, so SyncLocal
+// keeps the last-known-good route of a service with only remote replicas.
+func (m *Manager) renderReplicas(routes *routeSet) error {
+	names := make([]string, 0, len(routes.replicas))
+	for name := range routes.replicas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		route := routes.replicas[name]
+		filename := remoteConfigFileName(name)
+		if route.local {
+			filename = configFileName(name)
+		}
+		if _, ok := routes.desired[filename]; ok {
+			if route.local {
+				return fmt.Errorf("route file %s of the replicas of %s is taken by another service", filename, name)
+			}
+			m.logger.Warn("skipping remote replica route with conflicting file name", "file", filename, "service", name)
+			continue
+		}
+		data, err := marshalConfig(name, route.host, lbDef{Servers: route.servers, HealthCheck: route.health})
+		if err != nil {
+			return fmt.Errorf("marshaling traefik config for %s: %w", name, err)
+		}
+		routes.desired[filename] = data
+	}
+	return nil
+}
+
 // apply writes the desired documents through a staging directory and removes
 // stale files only after every desired file has been renamed into place. When
 // preserveRemotes is set, existing remote-* files are exempt from the stale
@@ -239,7 +356,11 @@ func (m *Manager) apply(desired map[string][]byte, preserveRemotes bool) error {
 			continue
 		}
 		if preserveRemotes && strings.HasPrefix(entry.Name(), remoteFilePrefix) {
-			continue
+			// A service now routed locally drops its remote route, so the
+			// two never define the same router.
+			if _, local := desired[strings.TrimPrefix(entry.Name(), remoteFilePrefix)]; !local {
+				continue
+			}
 		}
 		if err := os.Remove(filepath.Join(m.configDir, entry.Name())); err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("removing stale traefik config %s: %w", entry.Name(), err)
@@ -271,14 +392,44 @@ type serviceDef struct {
 }
 
 type lbDef struct {
-	Servers []serverDef `yaml:"servers"`
+	Servers     []serverDef     `yaml:"servers"`
+	HealthCheck *healthCheckDef `yaml:"healthCheck,omitempty"`
 }
 
 type serverDef struct {
 	URL string `yaml:"url"`
 }
 
-func marshalConfig(name, host, guestIP string, port int) ([]byte, error) {
+// healthCheckDef makes Traefik probe each server and take failing ones out
+// of rotation.
+type healthCheckDef struct {
+	Path     string `yaml:"path"`
+	Interval string `yaml:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty"`
+}
+
+// lbHealthCheck derives Traefik's health check of a replicated service from
+// its own HTTP health check, so a failing replica leaves rotation before its
+// agent reports it. Other check types have no Traefik equivalent and rely on
+// the reported health alone.
+func lbHealthCheck(hc *config.HealthCheckConfig) *healthCheckDef {
+	if hc == nil || hc.Type != "http" {
+		return nil
+	}
+	def := &healthCheckDef{Path: hc.Path}
+	if def.Path == "" {
+		def.Path = "/"
+	}
+	if hc.Interval > 0 {
+		def.Interval = hc.Interval.String()
+	}
+	if hc.Timeout > 0 {
+		def.Timeout = hc.Timeout.String()
+	}
+	return def
+}
+
+func marshalConfig(name, host string, lb lbDef) ([]byte, error) {
 	cfg := fileConfig{
 		HTTP: httpSection{
 			Routers: map[string]routerDef{
@@ -289,13 +440,7 @@ func marshalConfig(name, host, guestIP string, port int) ([]byte, error) {
 				},
 			},
 			Services: map[string]serviceDef{
-				name: {
-					LoadBalancer: lbDef{
-						Servers: []serverDef{
-							{URL: fmt.Sprintf("http://%s:%d", guestIP, port)},
-						},
-					},
-				},
+				name: {LoadBalancer: lb},
 			},
 		},
 	}
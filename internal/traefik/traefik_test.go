@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
 )
@@ -495,3 +496,93 @@ func TestSync_WriteFailureKeepsStaleThenConverges(t *testing.T) {
 		t.Error("expected stale file deleted after successful convergence")
 	}
 }
+
+func TestSync_ReplicasShareLoadBalancer(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir, "example.com", testLogger())
+
+	replica := func(svc config.ServiceConfig) config.ServiceConfig {
+		svc.ReplicaOf = "web"
+		svc.HealthCheck = &config.HealthCheckConfig{Type: "http", Path: "/healthz", Interval: 5 * time.Second}
+		return svc
+	}
+	meta := map[string]string{"subdomain": "web"}
+	local := replica(localSvc("web-0", "172.16.0.2", 8080, 80, meta))
+	remote := []config.NodeConfig{
+		{Node: "node-2", HostIP: "10.0.1.5", Services: []config.ServiceConfig{replica(localSvc("web-1", "172.16.0.9", 8080, 80, meta))}},
+		{Node: "node-3", HostIP: "10.0.1.6", Services: []config.ServiceConfig{replica(localSvc("web-2", "172.16.0.9", 8080, 80, meta))}},
+	}
+	if err := m.Sync([]config.ServiceConfig{local}, remote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content := read(t, dir, "web.yaml")
+	for _, want := range []string{"Host(`web.example.com`)", "http://172.16.0.2:80", "http://10.0.1.5:8080", "http://10.0.1.6:8080", "path: /healthz", "interval: 5s"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("expected %q in shared route, got:\n%s", want, content)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the shared route file, got %v", entries)
+	}
+
+	// Without a local replica the route is a remote one.
+	if err := m.Sync(nil, remote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content := read(t, dir, "remote-web.yaml"); !strings.Contains(content, "10.0.1.5:8080") || !strings.Contains(content, "10.0.1.6:8080") {
+		t.Errorf("expected both remote replicas, got:\n%s", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web.yaml")); !os.IsNotExist(err) {
+		t.Error("expected the local route file to be removed")
+	}
+
+	// Once a replica runs here again, SyncLocal replaces the remote route
+	// instead of keeping both.
+	if err := m.SyncLocal([]config.ServiceConfig{local}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "remote-web.yaml")); !os.IsNotExist(err) {
+		t.Error("expected the remote route of a locally routed service to be removed")
+	}
+	read(t, dir, "web.yaml")
+}
+
+func TestSync_ReplicasLeaveRotationWhenNotServing(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir, "example.com", testLogger())
+	failing := map[string]bool{"web-1": true}
+	m.SetReplicaHealth(func(name string) bool { return !failing[name] })
+
+	replica := func(svc config.ServiceConfig) config.ServiceConfig {
+		svc.ReplicaOf = "web"
+		svc.HealthCheck = &config.HealthCheckConfig{Type: "tcp", Port: 80}
+		return svc
+	}
+	meta := map[string]string{"subdomain": "web"}
+	local := replica(localSvc("web-0", "172.16.0.2", 8080, 80, meta))
+	remote := []config.NodeConfig{
+		{Node: "node-2", HostIP: "10.0.1.5", Services: []config.ServiceConfig{replica(localSvc("web-1", "172.16.0.9", 8080, 80, meta))}},
+	}
+	// web-1 fails its tcp check, which Traefik cannot probe itself.
+	if err := m.Sync([]config.ServiceConfig{local}, remote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content := read(t, dir, "web.yaml")
+	if !strings.Contains(content, "http://172.16.0.2:80") || strings.Contains(content, "10.0.1.5") || strings.Contains(content, "healthCheck") {
+		t.Errorf("expected only web-0 in rotation, got:\n%s", content)
+	}
+
+	// With no replica serving, the route stays without servers.
+	failing["web-0"] = true
+	if err := m.Sync([]config.ServiceConfig{local}, remote); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content := read(t, dir, "web.yaml"); !strings.Contains(content, "Host(`web.example.com`)") || strings.Contains(content, "url:") {
+		t.Errorf("expected the route without servers, got:\n%s", content)
+	}
+}
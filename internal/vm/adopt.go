@@ -0,0 +1,323 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/volume"
+)
+
+// instanceRecordFile is written next to vm-config.json once Firecracker has
+// started. It is the only durable link between a VM directory and the process
+// serving it, so a restarted agent can find the survivor again.
+const instanceRecordFile = "instance.json"
+
+// adoptPollInterval is how often an adopted (non-child) process is probed for
+// exit. Adopted processes cannot be waited on, so exit is observed by polling.
+const adoptPollInterval = time.Second
+
+// instanceRecord is the persisted identity of a started VM. StartTime is the
+// process start time in clock ticks from /proc/<pid>/stat; together with PID it
+// guards against adopting an unrelated process that reused the PID.
+type instanceRecord struct {
+	PID       int                     `json:"pid"`
+	StartTime uint64                  `json:"start_time"`
+	Config    config.ServiceConfig    `json:"config"`
+	Volumes   []volume.PreparedVolume `json:"volumes,omitempty"`
+	JailRoot  string                  `json:"jail_root,omitempty"`
+}
+
+// Adopt restores instances for Firecracker processes that survived an agent
+// restart. Every directory under state_dir/vms with an instance record is
+// inspected: the recorded process must still be alive with the recorded start
+// time, its API socket and vm-config.json must exist, and the recorded config
+// must equal the desired ServiceConfig of the same name apart from its rate
+// limits, which can be changed without a restart. Matching survivors are
+// put back into the inventory as running and their names are returned sorted.
+//
+// Survivors that are alive but not desired, or whose config no longer matches,
+// are stopped so the reconciler can recreate them from a clean slate; leaving
+// them running would hold the TAP device and block the replacement. Records
+// for processes that are already gone are ignored, except for desired jobs,
+// which are restored as finished.
+func (m *Manager) Adopt(desired []config.ServiceConfig) []string {
+	desiredByName := make(map[string]config.ServiceConfig, len(desired))
+	for _, svc := range desired {
+		desiredByName[svc.Name] = svc
+	}
+
+	entries, err := os.ReadDir(filepath.Join(m.stateDir, "vms"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.logger.Warn("cannot scan VM state directory for survivors", "error", err)
+		}
+		return nil
+	}
+
+	var adopted []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		vmDir := filepath.Join(m.stateDir, "vms", name)
+		rec, err := readInstanceRecord(vmDir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				m.logger.Warn("ignoring unreadable VM instance record", "service", name, "error", err)
+			}
+			continue
+		}
+		if rec.Config.Name != name {
+			continue
+		}
+		want, isDesired := desiredByName[name]
+		if !processMatches(rec.PID, rec.StartTime) {
+			if isDesired && rec.Config.Kind == config.KindJob && configsEqual(withoutRateLimits(rec.Config), withoutRateLimits(want)) {
+				m.restoreFinishedJob(vmDir, rec)
+			}
+			continue
+		}
+
+		socketPath := filepath.Join(runDir(vmDir, rec.JailRoot), apiSocketName)
+		reason := ""
+		switch {
+		case !isDesired:
+			reason = "service is no longer desired on this node"
+		case !fileExists(socketPath) || !fileExists(filepath.Join(vmDir, "vm-config.json")):
+			reason = "API socket or vm-config.json is missing"
+		case !configsEqual(withoutRateLimits(rec.Config), withoutRateLimits(want)):
+			reason = "recorded config differs from desired config"
+		}
+		if reason != "" {
+			m.logger.Warn("stopping surviving microVM that cannot be adopted",
+				"service", name, "pid", rec.PID, "reason", reason)
+			terminatePID(rec.PID)
+			continue
+		}
+
+		m.mu.Lock()
+		if inst, exists := m.instances[name]; exists && inst.State == StateRunning {
+			m.mu.Unlock()
+			continue
+		}
+		// The VM keeps the limits it was last given; the reconciler brings
+		// them up to date.
+		cfg := want
+		cfg.RateLimits = rec.Config.RateLimits
+		inst := &Instance{
+			Name:       name,
+			Config:     cfg,
+			State:      StateRunning,
+			PID:        rec.PID,
+			SocketPath: socketPath,
+			Volumes:    append([]volume.PreparedVolume(nil), rec.Volumes...),
+			JailRoot:   rec.JailRoot,
+		}
+		// Pick Firecracker's log and metrics up where the previous agent
+		// left them. A VM started before they were captured has no FIFOs
+		// and only gets its console log rotated.
+		logFIFO, _ := openFIFO(filepath.Join(runDir(vmDir, rec.JailRoot), vmmFIFOName))
+		metricsFIFO, _ := openFIFO(filepath.Join(runDir(vmDir, rec.JailRoot), metricsFIFOName))
+		inst.logs = m.startLogCapture(vmDir, logFIFO)
+		inst.metrics = startMetricsCapture(metricsFIFO)
+		m.instances[name] = inst
+		delete(m.volumeErrors, name)
+		m.mu.Unlock()
+
+		go m.watchAdopted(inst, rec.PID, rec.StartTime)
+
+		m.logger.Info("adopted surviving microVM", "service", name, "pid", rec.PID)
+		adopted = append(adopted, name)
+	}
+	sort.Strings(adopted)
+	return adopted
+}
+
+// restoreFinishedJob puts a job whose VM exited while no agent watched it
+// back into the inventory as finished, so it does not run again for the same
+// revision.
+func (m *Manager) restoreFinishedJob(vmDir string, rec instanceRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.instances[rec.Config.Name]; exists {
+		return
+	}
+	inst := &Instance{
+		Name:     rec.Config.Name,
+		Config:   rec.Config,
+		Volumes:  append([]volume.PreparedVolume(nil), rec.Volumes...),
+		JailRoot: rec.JailRoot,
+	}
+	m.finishJob(inst, vmDir)
+	if info, err := os.Stat(filepath.Join(vmDir, consoleLogName)); err == nil {
+		inst.FinishedAt = info.ModTime()
+	}
+	m.instances[inst.Name] = inst
+}
+
+// SurvivorNetworks returns the recorded network of every VM whose Firecracker
+// process is still alive, keyed by service name. The agent consults it before
+// adoption so services keep the addresses their surviving VMs booted with.
+func (m *Manager) SurvivorNetworks() map[string]config.NetworkConfig {
+	entries, err := os.ReadDir(filepath.Join(m.stateDir, "vms"))
+	if err != nil {
+		return nil
+	}
+	out := make(map[string]config.NetworkConfig)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		rec, err := readInstanceRecord(filepath.Join(m.stateDir, "vms", entry.Name()))
+		if err != nil || rec.Config.Name != entry.Name() || rec.Config.Network == nil {
+			continue
+		}
+		if processMatches(rec.PID, rec.StartTime) {
+			out[entry.Name()] = *rec.Config.Network
+		}
+	}
+	return out
+}
+
+// watchAdopted polls an adopted process until it exits and updates state the
+// same way monitor does for child processes. The exit status of a non-child is
+// not observable, so an unexpected exit is always recorded as a failure.
+func (m *Manager) watchAdopted(inst *Instance, pid int, startTime uint64) {
+	for processMatches(pid, startTime) {
+		time.Sleep(adoptPollInterval)
+	}
+	inst.logs.close()
+	inst.metrics.close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := inst.Name
+	if m.instances[name] != inst || inst.PID != pid {
+		return
+	}
+	if inst.State == StateStopped {
+		m.logger.Debug("adopted microVM exited after stop", "service", name)
+		return
+	}
+	if inst.Config.Kind == config.KindJob {
+		m.finishJob(inst, filepath.Join(m.stateDir, "vms", name))
+		inst.PID = 0
+		return
+	}
+	m.logger.Error("adopted microVM exited", "service", name, "pid", pid)
+	inst.State = StateFailed
+	inst.LastError = fmt.Sprintf("adopted firecracker process %d exited", pid)
+	inst.PID = 0
+}
+
+// writeInstanceRecord persists the identity of a freshly started VM.
+func writeInstanceRecord(vmDir string, pid int, svc config.ServiceConfig, prepared []volume.PreparedVolume, jailRoot string) error {
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(instanceRecord{
+		PID: pid, StartTime: startTime, Config: svc, Volumes: prepared, JailRoot: jailRoot,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal instance record: %w", err)
+	}
+	path := filepath.Join(vmDir, instanceRecordFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readInstanceRecord(vmDir string) (instanceRecord, error) {
+	var rec instanceRecord
+	data, err := os.ReadFile(filepath.Join(vmDir, instanceRecordFile))
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("decode instance record: %w", err)
+	}
+	if rec.PID <= 0 {
+		return rec, errors.New("instance record has no pid")
+	}
+	return rec, nil
+}
+
+// processMatches reports whether pid is alive and still the process that was
+// recorded, i.e. its start time has not changed.
+func processMatches(pid int, startTime uint64) bool {
+	if pid <= 0 || syscall.Kill(pid, syscall.Signal(0)) != nil {
+		return false
+	}
+	got, err := processStartTime(pid)
+	return err == nil && got == startTime
+}
+
+// processStartTime returns field 22 (starttime) of /proc/<pid>/stat. The comm
+// field may contain spaces and parentheses, so parsing starts after the last
+// closing parenthesis.
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	return parseProcStatStartTime(string(data))
+}
+
+func parseProcStatStartTime(stat string) (uint64, error) {
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, errors.New("malformed /proc stat: missing comm terminator")
+	}
+	// Fields after comm start at field 3 (state); starttime is field 22.
+	fields := strings.Fields(stat[end+1:])
+	const startTimeIndex = 22 - 3
+	if len(fields) <= startTimeIndex {
+		return 0, fmt.Errorf("malformed /proc stat: %d fields after comm", len(fields))
+	}
+	return strconv.ParseUint(fields[startTimeIndex], 10, 64)
+}
+
+// terminatePID stops a non-child process with the same SIGTERM-then-SIGKILL
+// escalation Stop uses.
+func terminatePID(pid int) {
+	_ = syscall.Kill(pid, syscall.SIGTERM)
+	if !waitForPIDExit(pid, 5*time.Second) {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		_ = waitForPIDExit(pid, 2*time.Second)
+	}
+}
+
+// configsEqual compares service configs by their canonical JSON encoding. The
+// agent derives networking, links, and env kernel args deterministically, so
+// an unchanged desired state reproduces the recorded config exactly.
+func configsEqual(a, b config.ServiceConfig) bool {
+	aj, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bj, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aj, bj)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
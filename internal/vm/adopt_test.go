@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// survivorBinary writes a fake firecracker that creates its API socket path
+// and keeps running under the same PID.
+func survivorBinary(t *testing.T, dir string) string {
+	t.Helper()
+	binary := filepath.Join(dir, "fake-firecracker")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n: > \"$2\"\nexec sleep 60\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
+
+func startSurvivor(t *testing.T, dir string, svc config.ServiceConfig) int {
+	t.Helper()
+	previous := NewManager(survivorBinary(t, dir), dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := previous.Start(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	pid := previous.List()[svc.Name].PID
+	t.Cleanup(func() { _ = previous.Stop(svc.Name) })
+
+	socket := filepath.Join(dir, "vms", svc.Name, "firecracker.sock")
+	deadline := time.Now().Add(5 * time.Second)
+	for !fileExists(socket) {
+		if time.Now().After(deadline) {
+			t.Fatal("fake firecracker did not create its socket")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := readInstanceRecord(filepath.Join(dir, "vms", svc.Name)); err != nil {
+		t.Skipf("instance record unavailable on this host: %v", err)
+	}
+	return pid
+}
+
+func TestAdoptRestoresMatchingSurvivor(t *testing.T) {
+	dir := t.TempDir()
+	svc := config.ServiceConfig{Name: "app", Image: "/image", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128,
+		Network: &config.NetworkConfig{Interface: "tap-app", GuestIP: "172.16.0.2"}}
+	pid := startSurvivor(t, dir, svc)
+
+	restarted := NewManager("/bin/false", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	adopted := restarted.Adopt([]config.ServiceConfig{svc})
+	if len(adopted) != 1 || adopted[0] != "app" {
+		t.Fatalf("adopted = %v, want [app]", adopted)
+	}
+	inst := restarted.List()["app"]
+	if inst == nil || inst.State != StateRunning || inst.PID != pid {
+		t.Fatalf("unexpected adopted instance: %#v", inst)
+	}
+	if !restarted.IsRunning("app") {
+		t.Fatal("adopted instance is not reported as running")
+	}
+}
+
+func TestAdoptStopsSurvivorWithChangedConfig(t *testing.T) {
+	dir := t.TempDir()
+	svc := config.ServiceConfig{Name: "app", Image: "/image", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128}
+	pid := startSurvivor(t, dir, svc)
+
+	changed := svc
+	changed.MemoryMB = 256
+	restarted := NewManager("/bin/false", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if adopted := restarted.Adopt([]config.ServiceConfig{changed}); len(adopted) != 0 {
+		t.Fatalf("adopted = %v, want none", adopted)
+	}
+	if _, ok := restarted.List()["app"]; ok {
+		t.Fatal("mismatched survivor entered the inventory")
+	}
+	// The original manager reaps its child; poll until the process is gone.
+	if !waitForPIDExit(pid, 5*time.Second) {
+		t.Fatalf("mismatched survivor %d is still running", pid)
+	}
+}
+
+func TestAdoptIgnoresRecordForExitedProcess(t *testing.T) {
+	dir := t.TempDir()
+	svc := config.ServiceConfig{Name: "app", Image: "/image", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128}
+	vmDir := filepath.Join(dir, "vms", "app")
+	if err := os.MkdirAll(vmDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(vmDir, instanceRecordFile),
+		[]byte(`{"pid": 2147483646, "start_time": 1, "config": {"Name": "app"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manager := NewManager("/bin/false", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if adopted := manager.Adopt([]config.ServiceConfig{svc}); len(adopted) != 0 {
+		t.Fatalf("adopted = %v, want none", adopted)
+	}
+}
+
+func TestParseProcStatStartTimeHandlesParenthesesInComm(t *testing.T) {
+	stat := "4242 (fire cracker) (x)) S 1 4242 4242 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 987654 1000 10\n"
+	got, err := parseProcStatStartTime(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 987654 {
+		t.Fatalf("start time = %d, want 987654", got)
+	}
+	if _, err := parseProcStatStartTime("4242 (short) S 1"); err == nil {
+		t.Fatal("expected error for truncated stat line")
+	}
+}
@@ -0,0 +1,160 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// apiTimeout bounds a single Firecracker API call. Snapshot creation writes
+// the whole guest memory to disk, so it gets a longer budget.
+const (
+	apiTimeout      = 10 * time.Second
+	snapshotTimeout = 10 * time.Minute
+)
+
+// apiClient talks to the Firecracker HTTP API over its Unix socket.
+type apiClient struct {
+	http *http.Client
+}
+
+func newAPIClient(socketPath string) *apiClient {
+	return &apiClient{http: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}}}
+}
+
+func (c *apiClient) pause(ctx context.Context) error {
+	return c.call(ctx, http.MethodPatch, "/vm", map[string]string{"state": "Paused"}, apiTimeout)
+}
+
+func (c *apiClient) resume(ctx context.Context) error {
+	return c.call(ctx, http.MethodPatch, "/vm", map[string]string{"state": "Resumed"}, apiTimeout)
+}
+
+func (c *apiClient) createSnapshot(ctx context.Context, statePath, memPath string) error {
+	return c.call(ctx, http.MethodPut, "/snapshot/create", map[string]string{
+		"snapshot_type": "Full",
+		"snapshot_path": statePath,
+		"mem_file_path": memPath,
+	}, snapshotTimeout)
+}
+
+func (c *apiClient) loadSnapshot(ctx context.Context, statePath, memPath string) error {
+	return c.call(ctx, http.MethodPut, "/snapshot/load", map[string]any{
+		"snapshot_path": statePath,
+		"mem_backend":   map[string]string{"backend_type": "File", "backend_path": memPath},
+		"resume_vm":     true,
+	}, snapshotTimeout)
+}
+
+func (c *apiClient) patchDrive(ctx context.Context, id string, limiter *firecrackerRateLimiter) error {
+	return c.call(ctx, http.MethodPatch, "/drives/"+id, map[string]any{
+		"drive_id":     id,
+		"rate_limiter": limiter,
+	}, apiTimeout)
+}
+
+func (c *apiClient) patchNetworkInterface(ctx context.Context, id string, limiter *firecrackerRateLimiter) error {
+	return c.call(ctx, http.MethodPatch, "/network-interfaces/"+id, map[string]any{
+		"iface_id":        id,
+		"rx_rate_limiter": limiter,
+		"tx_rate_limiter": limiter,
+	}, apiTimeout)
+}
+
+func (c *apiClient) setBalloon(ctx context.Context, amountMiB int) error {
+	return c.call(ctx, http.MethodPatch, "/balloon", map[string]int{"amount_mib": amountMiB}, apiTimeout)
+}
+
+func (c *apiClient) balloonStats(ctx context.Context) (BalloonStats, error) {
+	var stats BalloonStats
+	err := c.get(ctx, "/balloon/statistics", &stats)
+	return stats, err
+}
+
+// call sends one API request. Firecracker answers 204 on success and a JSON
+// fault_message otherwise.
+func (c *apiClient) call(ctx context.Context, method, path string, body any, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshal %s %s: %w", method, path, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(method, path, resp)
+}
+
+// get reads one API resource into out.
+func (c *apiClient) get(ctx context.Context, path string, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(http.MethodGet, path, resp); err != nil {
+		return err
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("GET %s: decode: %w", path, err)
+	}
+	return nil
+}
+
+// checkResponse turns a non-2xx answer into an error carrying Firecracker's
+// fault_message.
+func checkResponse(method, path string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	payload, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var fault struct {
+		FaultMessage string `json:"fault_message"`
+	}
+	if json.Unmarshal(payload, &fault) == nil && fault.FaultMessage != "" {
+		return fmt.Errorf("%s %s: %s", method, path, fault.FaultMessage)
+	}
+	return fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+}
+
+// waitForSocket polls until path exists, the deadline passes, or the process
+// behind it exits (reported by exited).
+func waitForSocket(path string, timeout time.Duration, exited func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !fileExists(path) {
+		if exited() {
+			return fmt.Errorf("firecracker exited before creating %s", path)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+)
+
+// balloonStatsIntervalSeconds is how often the guest balloon driver refreshes
+// the statistics the agent reads.
+const balloonStatsIntervalSeconds = 5
+
+type firecrackerBalloon struct {
+	AmountMiB             int  `json:"amount_mib"`
+	DeflateOnOOM          bool `json:"deflate_on_oom"`
+	StatsPollingIntervalS int  `json:"stats_polling_interval_s"`
+}
+
+// BalloonStats is the subset of Firecracker's balloon statistics the agent
+// uses. Memory figures are in bytes as reported by the guest.
+type BalloonStats struct {
+	// TargetMiB is the size the balloon was last asked to reach.
+	TargetMiB int `json:"target_mib"`
+	// ActualMiB is the size the guest has inflated the balloon to so far.
+	ActualMiB int `json:"actual_mib"`
+	// TotalMemory is the memory the guest sees, which shrinks as the balloon
+	// inflates.
+	TotalMemory uint64 `json:"total_memory"`
+	// AvailableMemory is what the guest could still allocate without
+	// swapping.
+	AvailableMemory uint64 `json:"available_memory"`
+}
+
+// UsedMiB returns the guest memory in use, or 0 when the guest has not
+// reported statistics yet.
+func (s BalloonStats) UsedMiB() int {
+	if s.TotalMemory < s.AvailableMemory {
+		return 0
+	}
+	return int((s.TotalMemory - s.AvailableMemory) >> 20)
+}
+
+// AvailableMiB returns the guest's available memory in MiB.
+func (s BalloonStats) AvailableMiB() int {
+	return int(s.AvailableMemory >> 20)
+}
+
+// BalloonStats reads the balloon statistics of a running VM.
+func (m *Manager) BalloonStats(ctx context.Context, name string) (BalloonStats, error) {
+	client, err := m.balloonClient(name)
+	if err != nil {
+		return BalloonStats{}, err
+	}
+	return client.balloonStats(ctx)
+}
+
+// SetBalloon asks the guest of a running VM to inflate or deflate its
+// balloon to amountMiB.
+func (m *Manager) SetBalloon(ctx context.Context, name string, amountMiB int) error {
+	client, err := m.balloonClient(name)
+	if err != nil {
+		return err
+	}
+	return client.setBalloon(ctx, amountMiB)
+}
+
+// balloonClient returns an API client for a running VM with a balloon. The
+// API call itself runs without m.mu held.
+func (m *Manager) balloonClient(name string) (*apiClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inst, exists := m.instances[name]
+	if !exists || inst.State != StateRunning {
+		return nil, fmt.Errorf("service %s is not running", name)
+	}
+	if inst.Config.Balloon == nil {
+		return nil, fmt.Errorf("service %s has no balloon", name)
+	}
+	return newAPIClient(inst.SocketPath), nil
+}
@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func TestWriteVMConfigRendersBalloon(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager("/bin/true", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc := config.ServiceConfig{Name: "cache", Image: "/root.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 512}
+	for _, balloon := range []*config.BalloonConfig{nil, {MinMemoryMB: 256}} {
+		svc.Balloon = balloon
+		path, err := manager.writeVMConfig(dir, "", svc, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cfg firecrackerConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if balloon == nil {
+			if cfg.Balloon != nil {
+				t.Fatalf("service without a balloon rendered %+v", cfg.Balloon)
+			}
+			continue
+		}
+		// The balloon starts deflated; the agent inflates it under pressure.
+		want := firecrackerBalloon{DeflateOnOOM: true, StatsPollingIntervalS: balloonStatsIntervalSeconds}
+		if cfg.Balloon == nil || *cfg.Balloon != want {
+			t.Fatalf("balloon = %+v, want %+v", cfg.Balloon, want)
+		}
+	}
+}
+
+func TestBalloonStatsAndResizeOnRunningVM(t *testing.T) {
+	m := newSnapshotTestManager(t, t.TempDir())
+	svc := snapshotTestService(t)
+	if _, err := m.BalloonStats(context.Background(), svc.Name); err == nil {
+		t.Fatal("expected an error for a service that is not running")
+	}
+	startAndWait(t, m, svc)
+	if _, err := m.BalloonStats(context.Background(), svc.Name); err == nil {
+		t.Fatal("expected an error for a VM without a balloon")
+	}
+
+	svc.Name = "cache"
+	svc.Balloon = &config.BalloonConfig{MinMemoryMB: 64}
+	startAndWait(t, m, svc)
+	stats, err := m.BalloonStats(context.Background(), svc.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TargetMiB != 64 || stats.UsedMiB() != 56 || stats.AvailableMiB() != 40 {
+		t.Fatalf("unexpected balloon statistics %+v", stats)
+	}
+	if err := m.SetBalloon(context.Background(), svc.Name, 48); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(m.stateDir, "vms", svc.Name, patchLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != `/balloon {"amount_mib":48}` {
+		t.Fatalf("unexpected balloon patch %s", got)
+	}
+}
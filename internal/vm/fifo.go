@@ -0,0 +1,80 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fifoDrainTimeout bounds how long a FIFO is still read after Firecracker
+// has exited.
+const fifoDrainTimeout = 200 * time.Millisecond
+
+// createFIFO creates a named pipe for Firecracker to write to in the VM's
+// run directory and opens the agent's end of it. A jailed Firecracker opens
+// it as the jail user.
+//
+// Firecracker opens its log and metrics pipes read-write and non-blocking,
+// so it never waits for the agent: while no agent reads a pipe, whatever
+// does not fit in its buffer is dropped.
+func (m *Manager) createFIFO(path string, jailed bool) (*os.File, error) {
+	_ = os.Remove(path)
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		return nil, fmt.Errorf("creating fifo %s: %w", path, err)
+	}
+	if jailed {
+		if err := os.Chown(path, m.jail.uid, m.jail.gid); err != nil {
+			return nil, fmt.Errorf("chown fifo %s: %w", path, err)
+		}
+	}
+	return openFIFO(path)
+}
+
+// openFIFO opens the agent's end of a FIFO. Opening it read-write never
+// blocks and never sees end of file, whether or not Firecracker has the pipe
+// open.
+func openFIFO(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// fifoReader hands each line Firecracker writes to a FIFO to a callback.
+type fifoReader struct {
+	file *os.File
+	done chan struct{}
+}
+
+// readFIFO starts reading file. A nil file yields a nil reader, which is
+// safe to close.
+func readFIFO(file *os.File, handle func(line []byte)) *fifoReader {
+	if file == nil {
+		return nil
+	}
+	r := &fifoReader{file: file, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				handle(line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// close reads what is left in the FIFO once Firecracker has exited and
+// closes it. No callback runs after close returns.
+func (r *fifoReader) close() {
+	if r == nil {
+		return
+	}
+	_ = r.file.SetReadDeadline(time.Now().Add(fifoDrainTimeout))
+	<-r.done
+	r.file.Close()
+}
@@ -0,0 +1,250 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+const (
+	// cpuPeriodUS is the cgroup v2 CPU bandwidth period. A VM may use VCPUs
+	// full periods of CPU time per period.
+	cpuPeriodUS = 100000
+	// vmmMemoryOverheadMiB is added to the guest memory for Firecracker's own
+	// allocations, so the memory limit does not OOM-kill a guest using all
+	// of its RAM.
+	vmmMemoryOverheadMiB = 64
+	// seccompFileName is the in-jail name of a custom seccomp filter.
+	seccompFileName = "seccomp.bpf"
+	// jailRootfsName is the in-jail name of the VM's copy of its rootfs.
+	jailRootfsName = "rootfs.ext4"
+	// imageSourceSuffix names the file next to a VM's image copy that
+	// records which version of the host image it was copied from.
+	imageSourceSuffix = ".source"
+)
+
+// jailIDPattern matches the ids the jailer accepts.
+var jailIDPattern = regexp.MustCompile(`^[a-zA-Z0-9-]{1,64}$`)
+
+// jailer starts Firecracker through Firecracker's jailer. Each VM gets the
+// jail <state_dir>/jail/<firecracker>/<service>/root, which the jailer
+// chroots into before dropping to uid:gid, and a cgroup v2 group
+// <parent>/<service> with limits derived from the service resources. Kernels
+// and images are shared with other VMs, so each VM gets its own copy in its
+// VM directory, refreshed only when the host file changes. Those copies and
+// the other files that belong to the VM alone are exposed in the jail root by
+// hard link, or by bind mount when they live on another filesystem.
+type jailer struct {
+	binary        string
+	uid, gid      int
+	baseDir       string
+	parentCgroup  string
+	seccompFilter string
+	cgroupRoot    string
+}
+
+// SetJailer makes the manager start VMs through Firecracker's jailer. It must
+// be called before the first Start.
+func (m *Manager) SetJailer(cfg config.JailerConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jail = &jailer{
+		binary:        cfg.Binary,
+		uid:           cfg.UID,
+		gid:           cfg.GID,
+		baseDir:       filepath.Join(m.stateDir, "jail"),
+		parentCgroup:  cfg.ParentCgroup,
+		seccompFilter: cfg.SeccompFilter,
+		cgroupRoot:    "/sys/fs/cgroup",
+	}
+}
+
+// root returns the jail root of the VM with the given jail id.
+func (j *jailer) root(firecrackerBin, id string) string {
+	return filepath.Join(j.baseDir, filepath.Base(firecrackerBin), id, "root")
+}
+
+// command returns the jailer invocation that runs firecracker with fcArgs
+// inside the jail at root. The jailer execs Firecracker in place, so the
+// started PID is Firecracker's and adoption works unchanged.
+func (j *jailer) command(firecrackerBin, root string, svc config.ServiceConfig, fcArgs []string) *exec.Cmd {
+	args := []string{
+		"--id", filepath.Base(filepath.Dir(root)),
+		"--exec-file", firecrackerBin,
+		"--uid", strconv.Itoa(j.uid),
+		"--gid", strconv.Itoa(j.gid),
+		"--chroot-base-dir", j.baseDir,
+		"--cgroup-version", "2",
+		"--parent-cgroup", j.parentCgroup,
+	}
+	for _, limit := range cgroupLimits(svc) {
+		args = append(args, "--cgroup", limit)
+	}
+	args = append(args, "--")
+	args = append(args, fcArgs...)
+	if j.seccompFilter != "" {
+		args = append(args, "--seccomp-filter", "/"+seccompFileName)
+	}
+	return exec.Command(j.binary, args...)
+}
+
+// cgroupLimits converts the service resources into cgroup v2 settings.
+func cgroupLimits(svc config.ServiceConfig) []string {
+	return []string{
+		fmt.Sprintf("cpu.max=%d %d", svc.VCPUs*cpuPeriodUS, cpuPeriodUS),
+		fmt.Sprintf("memory.max=%d", int64(svc.MemoryMB+vmmMemoryOverheadMiB)<<20),
+	}
+}
+
+// prepare rebuilds a clean jail root, left over state from a previous run
+// included, and exposes each of the VM's own files under its in-jail name.
+// The root is owned by the jail user so Firecracker can create its sockets
+// there.
+func (j *jailer) prepare(root string, files map[string]string) error {
+	if err := checkJailID(root); err != nil {
+		return err
+	}
+	j.release(root)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return fmt.Errorf("creating jail root: %w", err)
+	}
+	if err := os.Chown(root, j.uid, j.gid); err != nil {
+		return fmt.Errorf("chown jail root: %w", err)
+	}
+	if j.seccompFilter != "" {
+		if err := j.install(root, j.seccompFilter, seccompFileName); err != nil {
+			return err
+		}
+	}
+	for name, hostPath := range files {
+		if err := j.expose(root, hostPath, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// install copies hostPath, a small file shared by every VM such as the
+// seccomp filter, to /name inside the jail, so the jail user gets its own
+// copy rather than ownership of the host file through a hard link.
+func (j *jailer) install(root, hostPath, name string) error {
+	target := filepath.Join(root, name)
+	unmountFile(target)
+	_ = os.Remove(target)
+	if err := copyFileAtomic(hostPath, target); err != nil {
+		return fmt.Errorf("copying %s into jail: %w", hostPath, err)
+	}
+	if err := os.Chown(target, j.uid, j.gid); err != nil {
+		return fmt.Errorf("chown %s: %w", target, err)
+	}
+	return nil
+}
+
+// expose makes hostPath, a file only this VM uses, available as /name inside
+// the jail. A hard link shares the inode, so the file is chowned to the jail
+// user either way.
+func (j *jailer) expose(root, hostPath, name string) error {
+	target := filepath.Join(root, name)
+	unmountFile(target)
+	_ = os.Remove(target)
+	if err := os.Link(hostPath, target); err != nil {
+		if !isCrossDevice(err) {
+			return fmt.Errorf("linking %s into jail: %w", hostPath, err)
+		}
+		if err := bindMountFile(hostPath, target); err != nil {
+			return fmt.Errorf("bind mounting %s into jail: %w", hostPath, err)
+		}
+	}
+	if err := os.Chown(target, j.uid, j.gid); err != nil {
+		return fmt.Errorf("chown %s: %w", hostPath, err)
+	}
+	return nil
+}
+
+// checkJailID reports an error when the id of the jail at root, the
+// service name, is not one the jailer accepts.
+func checkJailID(root string) error {
+	if id := filepath.Base(filepath.Dir(root)); !jailIDPattern.MatchString(id) {
+		return fmt.Errorf("service name %q is not a valid jail id (letters, digits and dashes, at most 64)", id)
+	}
+	return nil
+}
+
+// jailImagePath returns where a VM keeps its own copy of the shared host
+// file with the given in-jail name.
+func jailImagePath(vmDir, name string) string {
+	return filepath.Join(vmDir, "jail-"+name)
+}
+
+// syncImageCopy keeps dst, a VM's own copy of the host file src, in step
+// with it. It copies only when src differs from the version recorded next to
+// dst by path, size and modification time, so restarts neither copy large
+// images again nor lose what the guest wrote to its rootfs copy.
+func syncImageCopy(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	source := fmt.Sprintf("%s %d %d\n", src, info.Size(), info.ModTime().UnixNano())
+	if recorded, err := os.ReadFile(dst + imageSourceSuffix); err == nil && string(recorded) == source && fileExists(dst) {
+		return nil
+	}
+	if err := copyFileAtomic(src, dst); err != nil {
+		return err
+	}
+	return os.WriteFile(dst+imageSourceSuffix, []byte(source), 0o644)
+}
+
+// release removes a jail and its cgroup after the VM has exited. Bind
+// mounts are detached first; unlinking a file that is still a mount point
+// fails instead of touching the mounted file.
+func (j *jailer) release(root string) {
+	if entries, err := os.ReadDir(root); err == nil {
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				unmountFile(filepath.Join(root, entry.Name()))
+			}
+		}
+	}
+	_ = os.RemoveAll(filepath.Dir(root))
+	if j.cgroupRoot != "" && j.parentCgroup != "" {
+		_ = os.Remove(filepath.Join(j.cgroupRoot, j.parentCgroup, filepath.Base(filepath.Dir(root))))
+	}
+}
+
+// releaseJail removes a VM's jail. VMs adopted from a jailed agent are still
+// cleaned up after the jailer has been disabled, minus their cgroup.
+func (m *Manager) releaseJail(root string) {
+	j := m.jail
+	if j == nil {
+		j = &jailer{}
+	}
+	j.release(root)
+}
+
+// runDir returns the directory holding a VM's sockets: its jail root when it
+// is jailed, its VM directory otherwise.
+func runDir(vmDir, jailRoot string) string {
+	if jailRoot != "" {
+		return jailRoot
+	}
+	return vmDir
+}
+
+// fcPath returns hostPath as the VM's Firecracker process sees it. Inside a
+// jail, files are only reachable relative to the jail root.
+func fcPath(jailRoot, hostPath string) string {
+	if jailRoot == "" {
+		return hostPath
+	}
+	rel, err := filepath.Rel(jailRoot, hostPath)
+	if err != nil {
+		return hostPath
+	}
+	return "/" + rel
+}
@@ -0,0 +1,209 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// newJailerTestManager returns a manager whose jailer is a script that
+// records its arguments and stays alive. The jail user is the current user so
+// the test does not need root.
+func newJailerTestManager(t *testing.T) (*Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "jailer-args")
+	jailerBin := filepath.Join(dir, "jailer")
+	script := "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done > " + argsFile + "\nexec sleep 30\n"
+	if err := os.WriteFile(jailerBin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager("/usr/bin/firecracker", filepath.Join(dir, "state"), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.SetJailer(config.JailerConfig{Binary: jailerBin, UID: os.Getuid(), GID: os.Getgid(), ParentCgroup: "firework"})
+	m.jail.cgroupRoot = filepath.Join(dir, "cgroup")
+	return m, argsFile
+}
+
+func waitForJailerArgs(t *testing.T, path string) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(path); err == nil && strings.HasSuffix(string(data), "\n") {
+			return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("jailer was not started")
+	return nil
+}
+
+func TestStartRunsFirecrackerThroughJailer(t *testing.T) {
+	m, argsFile := newJailerTestManager(t)
+	assets := t.TempDir()
+	kernel := filepath.Join(assets, "vmlinux")
+	image := filepath.Join(assets, "app-rootfs.ext4")
+	for _, path := range []string{kernel, image} {
+		if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := config.ServiceConfig{
+		Name: "app", Image: image, Kernel: kernel, VCPUs: 2, MemoryMB: 256,
+		HealthCheck: &config.HealthCheckConfig{Type: "exec", Command: []string{"true"}},
+	}
+	if err := m.Start(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.Remove(svc.Name) })
+
+	args := strings.Join(waitForJailerArgs(t, argsFile), " ")
+	for _, want := range []string{
+		"--id app --exec-file /usr/bin/firecracker",
+		"--chroot-base-dir " + filepath.Join(m.stateDir, "jail"),
+		"--cgroup-version 2 --parent-cgroup firework",
+		"--cgroup cpu.max=200000 100000",
+		"--cgroup memory.max=335544320",
+		"-- --api-sock /firecracker.sock --log-path /vmm.fifo --level Info --metrics-path /metrics.fifo --config-file /vm-config.json",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("jailer args %q missing %q", args, want)
+		}
+	}
+
+	root := filepath.Join(m.stateDir, "jail", "firecracker", "app", "root")
+	for _, name := range []string{"vmlinux", "rootfs.ext4", "vm-config.json"} {
+		if !fileExists(filepath.Join(root, name)) {
+			t.Errorf("%s not exposed in jail", name)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(root, "vm-config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg firecrackerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BootSource.KernelImagePath != "/vmlinux" || cfg.Drives[0].PathOnHost != "/rootfs.ext4" {
+		t.Fatalf("config must use in-jail paths: kernel %q, rootfs %q", cfg.BootSource.KernelImagePath, cfg.Drives[0].PathOnHost)
+	}
+	if cfg.Vsock == nil || cfg.Vsock.UDSPath != "/"+vsockSocketName {
+		t.Fatalf("vsock = %+v, want in-jail socket", cfg.Vsock)
+	}
+	if got, want := m.ExecSocketPath("app"), filepath.Join(root, vsockSocketName); got != want {
+		t.Fatalf("ExecSocketPath = %q, want %q", got, want)
+	}
+	if got := m.List()["app"].SocketPath; got != filepath.Join(root, apiSocketName) {
+		t.Fatalf("SocketPath = %q, want socket inside the jail", got)
+	}
+
+	if err := m.Remove(svc.Name); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Dir(root)) {
+		t.Fatal("jail must be removed with the service")
+	}
+	if !fileExists(image) {
+		t.Fatal("removing the jail must not remove the linked rootfs")
+	}
+}
+
+func TestJailerRejectsInvalidJailID(t *testing.T) {
+	m, _ := newJailerTestManager(t)
+	err := m.Start(context.Background(), config.ServiceConfig{Name: "bad_name", Image: "/image", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128})
+	if err == nil || !strings.Contains(err.Error(), "not a valid jail id") {
+		t.Fatalf("expected invalid jail id error, got %v", err)
+	}
+}
+
+func TestJailerSeccompFilterIsExposedAndPassed(t *testing.T) {
+	dir := t.TempDir()
+	filter := filepath.Join(dir, "filter.bpf")
+	if err := os.WriteFile(filter, []byte("bpf"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	j := &jailer{binary: "/usr/bin/jailer", uid: os.Getuid(), gid: os.Getgid(), baseDir: dir, parentCgroup: "firework", seccompFilter: filter}
+	root := j.root("/usr/bin/firecracker", "app")
+	if err := j.prepare(root, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(root, seccompFileName)) {
+		t.Fatal("seccomp filter not exposed in jail")
+	}
+	cmd := j.command("/usr/bin/firecracker", root, config.ServiceConfig{VCPUs: 1, MemoryMB: 128}, []string{"--api-sock", "/firecracker.sock"})
+	if args := strings.Join(cmd.Args, " "); !strings.HasSuffix(args, "--seccomp-filter /"+seccompFileName) {
+		t.Fatalf("args %q do not pass the seccomp filter", args)
+	}
+}
+
+func TestJailerCopiesSharedImagesOncePerVersion(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "rootfs.ext4")
+	if err := os.WriteFile(image, []byte("image-v1"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmDir := filepath.Join(dir, "vms", "app")
+	if err := os.MkdirAll(vmDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	own := jailImagePath(vmDir, jailRootfsName)
+	if err := syncImageCopy(image, own); err != nil {
+		t.Fatal(err)
+	}
+	j := &jailer{binary: "/usr/bin/jailer", uid: os.Getuid(), gid: os.Getgid(), baseDir: filepath.Join(dir, "jail")}
+	root := j.root("/usr/bin/firecracker", "app")
+	if err := j.prepare(root, map[string]string{jailRootfsName: own}); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.Stat(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Mode() != before.Mode() || after.Sys().(*syscall.Stat_t).Uid != before.Sys().(*syscall.Stat_t).Uid ||
+		after.Sys().(*syscall.Stat_t).Gid != before.Sys().(*syscall.Stat_t).Gid {
+		t.Fatalf("host image changed from %v to %v", before, after)
+	}
+	jailed, err := os.Stat(filepath.Join(root, jailRootfsName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, jailed) {
+		t.Fatal("shared image must be copied for the jail, not linked")
+	}
+
+	// A restart keeps what the guest wrote to its copy.
+	if err := os.WriteFile(filepath.Join(root, jailRootfsName), []byte("guest-writes"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncImageCopy(image, own); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(own); string(data) != "guest-writes" {
+		t.Fatalf("unchanged image was copied again: %q", data)
+	}
+
+	// A new image version replaces the copy.
+	if err := os.WriteFile(image, []byte("image-v2-longer"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncImageCopy(image, own); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(own); string(data) != "image-v2-longer" {
+		t.Fatalf("changed image was not copied: %q", data)
+	}
+}
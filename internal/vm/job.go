@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jobKernelArg tells fc-init to run the service as a job: once the service
+// exits, fc-init prints its exit status on the console and reboots, which
+// makes Firecracker exit.
+const jobKernelArg = "firework.job=1"
+
+// exitStatusPrefix starts the console line fc-init reports a job's exit
+// status on. Firecracker exits cleanly on any guest reboot, so the console is
+// the only place the status survives.
+const exitStatusPrefix = "firework.exit_status="
+
+// exitStatusTailBytes is how much of the end of the console log is searched
+// for the exit status. fc-init prints it last, followed only by the
+// kernel's reboot messages.
+const exitStatusTailBytes = 64 << 10
+
+// finishJob records how the job run by inst ended, from the exit status fc-init
+// printed on the console. A job that exited 0 is stopped; any other status,
+// or none at all because the guest crashed, fails it. Callers hold m.mu.
+func (m *Manager) finishJob(inst *Instance, vmDir string) {
+	inst.FinishedAt = time.Now()
+	code, ok := jobExitStatus(filepath.Join(vmDir, consoleLogName))
+	if !ok {
+		inst.State = StateFailed
+		inst.LastError = "job exited without reporting an exit status"
+		m.logger.Error("job exited without reporting an exit status", "service", inst.Name)
+		return
+	}
+	inst.ExitCode = &code
+	if code != 0 {
+		inst.State = StateFailed
+		inst.LastError = fmt.Sprintf("job exited with status %d", code)
+		m.logger.Error("job failed", "service", inst.Name, "exit_code", code)
+		return
+	}
+	inst.State = StateStopped
+	m.logger.Info("job completed", "service", inst.Name)
+}
+
+// jobExitStatus returns the last exit status reported in a console log.
+func jobExitStatus(path string) (int, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > exitStatusTailBytes {
+		if _, err := f.Seek(-exitStatusTailBytes, io.SeekEnd); err != nil {
+			return 0, false
+		}
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, false
+	}
+
+	code, found := 0, false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), exitStatusPrefix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err == nil {
+			code, found = n, true
+		}
+	}
+	return code, found
+}
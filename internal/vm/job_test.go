@@ -0,0 +1,100 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func TestJobExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		console string
+		code    int
+		ok      bool
+	}{
+		"success":          {console: "booting\nmigrated\n\nfirework.exit_status=0\nreboot: Restarting system\n", code: 0, ok: true},
+		"failure":          {console: "firework.exit_status=1\nfirework.exit_status=3\r\n", code: 3, ok: true},
+		"missing":          {console: "Kernel panic - not syncing\n", ok: false},
+		"malformed status": {console: "firework.exit_status=done\n", ok: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".log")
+			if err := os.WriteFile(path, []byte(tc.console), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			code, ok := jobExitStatus(path)
+			if ok != tc.ok || code != tc.code {
+				t.Fatalf("jobExitStatus = %d, %v; want %d, %v", code, ok, tc.code, tc.ok)
+			}
+		})
+	}
+	if _, ok := jobExitStatus(filepath.Join(dir, "absent.log")); ok {
+		t.Fatal("expected no status without a console log")
+	}
+}
+
+func TestManagerRecordsJobExitStatus(t *testing.T) {
+	for _, tc := range []struct {
+		exitStatus int
+		want       State
+	}{
+		{exitStatus: 0, want: StateStopped},
+		{exitStatus: 2, want: StateFailed},
+	} {
+		dir := t.TempDir()
+		binary := filepath.Join(dir, "fake-firecracker")
+		// Firecracker exits cleanly when the guest reboots after the job.
+		script := "#!/bin/sh\nprintf 'job output\\nfirework.exit_status=" + strconv.Itoa(tc.exitStatus) + "\\n'\nexit 0\n"
+		if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		m := NewManager(binary, dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		svc := config.ServiceConfig{Name: "migrate", Kind: config.KindJob, Image: "/image", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128}
+		if err := m.Start(context.Background(), svc); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		var inst *Instance
+		for time.Now().Before(deadline) {
+			if inst = m.List()["migrate"]; inst != nil && !inst.FinishedAt.IsZero() {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if inst == nil || inst.FinishedAt.IsZero() {
+			t.Fatalf("job never finished: %#v", inst)
+		}
+		if inst.State != tc.want || inst.ExitCode == nil || *inst.ExitCode != tc.exitStatus || inst.PID != 0 {
+			t.Fatalf("job exiting %d = state %s, exit code %v, pid %d; want %s", tc.exitStatus, inst.State, inst.ExitCode, inst.PID, tc.want)
+		}
+	}
+}
+
+func TestWriteVMConfigMarksJobs(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager("/bin/true", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	path, err := m.writeVMConfig(dir, "", config.ServiceConfig{
+		Name: "migrate", Kind: config.KindJob, Image: "/root.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128,
+		KernelArgs: "console=ttyS0 init=/sbin/fc-init /bin/migrate -- up",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), jobKernelArg+" -- up") {
+		t.Fatalf("job kernel arg missing before the application separator: %s", data)
+	}
+}
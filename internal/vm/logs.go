@@ -0,0 +1,257 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// Log streams of a VM. The console stream is the guest serial console and
+// anything else Firecracker prints to stdout and stderr; the VMM stream is
+// Firecracker's own log.
+const (
+	LogStreamConsole = "console"
+	LogStreamVMM     = "vmm"
+)
+
+const (
+	consoleLogName = "console.log"
+	vmmLogName     = "vmm.log"
+	// vmmFIFOName is the named pipe Firecracker logs to.
+	vmmFIFOName = "vmm.fifo"
+)
+
+// Rotation defaults used until SetLogLimits is called.
+const (
+	defaultLogMaxBytes = 10 * config.MiB
+	defaultLogMaxFiles = 5
+)
+
+// consoleCheckInterval is how often the console log is checked against the
+// size limit. Firecracker writes it directly, so it may overshoot the limit
+// by whatever the guest prints in between.
+const consoleCheckInterval = 5 * time.Second
+
+type logLimits struct {
+	maxBytes int64
+	maxFiles int
+}
+
+// SetLogLimits sets the size at which a VM's log files are rotated and how
+// many rotated files of each stream are kept. Each boot starts new files, so
+// the rotated ones also hold previous boots.
+func (m *Manager) SetLogLimits(maxBytes int64, maxFiles int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logLimits = logLimits{maxBytes: maxBytes, maxFiles: maxFiles}
+}
+
+// LogFiles returns the log files of a service's stream, oldest first. The
+// last one is the file currently written to, which may not exist yet. It
+// reports false for services the manager does not know.
+func (m *Manager) LogFiles(name, stream string) ([]string, bool) {
+	m.mu.Lock()
+	_, exists := m.instances[name]
+	maxFiles := m.logLimits.maxFiles
+	m.mu.Unlock()
+	if !exists {
+		return nil, false
+	}
+
+	base := consoleLogName
+	if stream == LogStreamVMM {
+		base = vmmLogName
+	}
+	path := filepath.Join(m.stateDir, "vms", name, base)
+	var files []string
+	for i := maxFiles; i >= 1; i-- {
+		if fileExists(rotatedLogName(path, i)) {
+			files = append(files, rotatedLogName(path, i))
+		}
+	}
+	return append(files, path), true
+}
+
+// logCapture keeps one VM's log files within their limits and copies
+// Firecracker's log from its FIFO into vmm.log.
+type logCapture struct {
+	limits logLimits
+	logger *slog.Logger
+
+	mu  sync.Mutex
+	dir string // VM directory; moves with Rename
+
+	vmm *fifoReader
+	// vmmOut and vmmSize belong to the FIFO reader.
+	vmmOut  *os.File
+	vmmSize int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openConsoleLog rotates the console log of the previous boot away and opens
+// a fresh one for Firecracker's stdout and stderr. It is opened for append
+// so truncating it on rotation does not leave Firecracker writing past the
+// end.
+func openConsoleLog(vmDir string, limits logLimits) (*os.File, error) {
+	path := filepath.Join(vmDir, consoleLogName)
+	rotateLog(path, limits.maxFiles)
+	rotateLog(filepath.Join(vmDir, vmmLogName), limits.maxFiles)
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+}
+
+// startLogCapture starts capturing the logs of the VM in vmDir. fifo may be
+// nil for a VM started without one; its console log is still rotated.
+func (m *Manager) startLogCapture(vmDir string, fifo *os.File) *logCapture {
+	c := &logCapture{
+		limits: m.logLimits,
+		logger: m.logger,
+		dir:    vmDir,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	c.vmm = readFIFO(fifo, c.writeVMMLine)
+	go c.run()
+	return c
+}
+
+// setDir points the capture at the VM directory after a rename.
+func (c *logCapture) setDir(dir string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dir = dir
+}
+
+func (c *logCapture) path(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return filepath.Join(c.dir, name)
+}
+
+// close stops the capture once Firecracker has exited, after copying what
+// is left in the FIFO.
+func (c *logCapture) close() {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+}
+
+func (c *logCapture) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(consoleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := copyTruncateLog(c.path(consoleLogName), c.limits); err != nil {
+				c.logger.Warn("failed to rotate console log", "path", c.path(consoleLogName), "error", err)
+			}
+		case <-c.stop:
+			c.vmm.close()
+			if c.vmmOut != nil {
+				c.vmmOut.Close()
+			}
+			return
+		}
+	}
+}
+
+// writeVMMLine appends a line of Firecracker's log to vmm.log, rotating it
+// when it grows past the size limit.
+func (c *logCapture) writeVMMLine(line []byte) {
+	if c.vmmOut == nil {
+		path := c.path(vmmLogName)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			c.logger.Warn("failed to open VMM log", "path", path, "error", err)
+			return
+		}
+		c.vmmOut, c.vmmSize = f, 0
+		if info, err := f.Stat(); err == nil {
+			c.vmmSize = info.Size()
+		}
+	}
+	n, _ := c.vmmOut.Write(line)
+	c.vmmSize += int64(n)
+	if c.vmmSize >= c.limits.maxBytes {
+		c.vmmOut.Close()
+		c.vmmOut = nil
+		rotateLog(c.path(vmmLogName), c.limits.maxFiles)
+	}
+}
+
+// rotateLog shifts path to path.1, path.1 to path.2 and so on, dropping the
+// file that would exceed maxFiles.
+func rotateLog(path string, maxFiles int) {
+	_ = os.Remove(rotatedLogName(path, maxFiles))
+	for i := maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(rotatedLogName(path, i), rotatedLogName(path, i+1))
+	}
+	if maxFiles == 0 {
+		_ = os.Remove(path)
+		return
+	}
+	_ = os.Rename(path, rotatedLogName(path, 1))
+}
+
+// copyTruncateLog rotates a log file that another process keeps open once it
+// exceeds the size limit: the content is copied to path.1 and the file
+// truncated in place. Lines written between the copy and the truncate are
+// lost.
+func copyTruncateLog(path string, limits logLimits) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if info.Size() < limits.maxBytes {
+		return nil
+	}
+	if limits.maxFiles > 0 {
+		_ = os.Remove(rotatedLogName(path, limits.maxFiles))
+		for i := limits.maxFiles - 1; i >= 1; i-- {
+			_ = os.Rename(rotatedLogName(path, i), rotatedLogName(path, i+1))
+		}
+		if err := copyFile(path, rotatedLogName(path, 1)); err != nil {
+			return err
+		}
+	}
+	return os.Truncate(path, 0)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func rotatedLogName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
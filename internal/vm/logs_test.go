@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func TestRotateLogKeepsMaxFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.log")
+	for _, boot := range []string{"one", "two", "three"} {
+		rotateLog(path, 2)
+		if err := os.WriteFile(path, []byte(boot), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{path: "three", path + ".1": "two", path + ".2": "one"} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+	rotateLog(path, 2)
+	if data, _ := os.ReadFile(path + ".2"); string(data) != "two" || fileExists(path) {
+		t.Fatalf("expected the oldest file dropped, .2 = %q", data)
+	}
+}
+
+func TestCopyTruncateLogRotatesInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("0123456789"); err != nil {
+		t.Fatal(err)
+	}
+
+	limits := logLimits{maxBytes: 10, maxFiles: 1}
+	if err := copyTruncateLog(path, limits); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("after\n"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "0123456789" {
+		t.Fatalf("rotated content = %q", data)
+	}
+	// The writer keeps appending at the start of the truncated file.
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Fatalf("current content = %q", data)
+	}
+	if err := copyTruncateLog(path, limits); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "0123456789" {
+		t.Fatalf("file below the limit must not be rotated, .1 = %q", data)
+	}
+}
+
+func TestLaunchSeparatesConsoleAndVMMLogsPerBoot(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "fake-firecracker")
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  [ "$1" = --log-path ] && log="$2"
+  shift
+done
+echo "2024-05-01T10:00:00.000000000 [fc:main] Running Firecracker" > "$log"
+echo "guest console"
+exec sleep 60
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(binary, filepath.Join(dir, "state"), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc := config.ServiceConfig{Name: "app", Image: "/rootfs.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128}
+	vmDir := filepath.Join(m.stateDir, "vms", svc.Name)
+
+	for range 2 {
+		if err := m.Start(context.Background(), svc); err != nil {
+			t.Fatal(err)
+		}
+		waitForLog(t, filepath.Join(vmDir, vmmLogName), "Running Firecracker")
+		waitForLog(t, filepath.Join(vmDir, consoleLogName), "guest console")
+		if err := m.Stop(svc.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	console, ok := m.LogFiles(svc.Name, LogStreamConsole)
+	want := []string{filepath.Join(vmDir, consoleLogName+".1"), filepath.Join(vmDir, consoleLogName)}
+	if !ok || strings.Join(console, ",") != strings.Join(want, ",") {
+		t.Fatalf("console log files = %v, want %v", console, want)
+	}
+	if data, _ := os.ReadFile(want[0]); strings.Contains(string(data), "Running Firecracker") {
+		t.Fatalf("VMM messages leaked into the console log: %q", data)
+	}
+	vmm, _ := m.LogFiles(svc.Name, LogStreamVMM)
+	if len(vmm) != 2 || !fileExists(vmm[0]) {
+		t.Fatalf("expected the previous boot's VMM log to be kept, got %v", vmm)
+	}
+	if _, ok := m.LogFiles("unknown", LogStreamConsole); ok {
+		t.Fatal("expected unknown service to be reported")
+	}
+}
+
+func waitForLog(t *testing.T, path, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, _ := os.ReadFile(path); strings.Contains(string(data), want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, _ := os.ReadFile(path)
+	t.Fatalf("%s never contained %q: %q", filepath.Base(path), want, data)
+}
@@ -16,11 +16,20 @@ import (
 	"time"
 
 	"github.com/artemnikitin/firework/internal/config"
+	"github.com/artemnikitin/firework/internal/guestexec"
 	"github.com/artemnikitin/firework/internal/volume"
 )
 
 const maxKernelCommandLineBytes = 2047
 
+// vsockSocketName is the host side of the guest command channel, created by
+// Firecracker in the VM directory for services with an exec health check.
+const vsockSocketName = "vsock.sock"
+
+// apiSocketName is the Firecracker API socket, created next to the vsock
+// socket.
+const apiSocketName = "firecracker.sock"
+
 // State represents the lifecycle state of a microVM.
 type State string
 
@@ -46,6 +55,16 @@ type Instance struct {
 	SocketPath string
 	// Volumes is the last successfully prepared persistent-volume set.
 	Volumes []volume.PreparedVolume
+	// JailRoot is the chroot the VM runs in when started through the jailer.
+	JailRoot string
+	// ExitCode is the exit status a job reported before its VM exited. It
+	// is nil for services and for jobs that are running or reported none.
+	ExitCode *int
+	// FinishedAt is when a job's VM exited.
+	FinishedAt time.Time
+
+	logs    *logCapture
+	metrics *metricsCapture
 }
 
 // Manager manages the lifecycle of Firecracker microVMs on the local host.
@@ -54,8 +73,10 @@ type Manager struct {
 	stateDir       string
 	logger         *slog.Logger
 	volumeManager  *volume.Manager
+	jail           *jailer
 
 	mu           sync.Mutex
+	logLimits    logLimits
 	instances    map[string]*Instance
 	volumeErrors map[string]string
 }
@@ -74,6 +95,7 @@ func NewManagerWithVolumes(firecrackerBin, stateDir string, logger *slog.Logger,
 		volumeManager:  volumeManager,
 		instances:      make(map[string]*Instance),
 		volumeErrors:   make(map[string]string),
+		logLimits:      logLimits{maxBytes: defaultLogMaxBytes, maxFiles: defaultLogMaxFiles},
 	}
 }
 
@@ -173,9 +195,19 @@ func (m *Manager) Start(ctx context.Context, svc config.ServiceConfig) error {
 		return fmt.Errorf("creating vm dir: %w", err)
 	}
 
-	socketPath := filepath.Join(vmDir, "firecracker.sock")
-	// Remove stale socket if it exists.
-	_ = os.Remove(socketPath)
+	var jailRoot string
+	if m.jail != nil {
+		jailRoot = m.jail.root(m.firecrackerBin, svc.Name)
+	}
+	// A promoted replacement VM keeps the jail it was started in; it is not
+	// needed once the service starts again under its own name.
+	if prev, exists := m.instances[svc.Name]; exists && prev.JailRoot != "" && prev.JailRoot != jailRoot {
+		m.releaseJail(prev.JailRoot)
+	}
+
+	// Remove stale sockets if they exist; Firecracker refuses to bind over them.
+	_ = os.Remove(filepath.Join(runDir(vmDir, jailRoot), apiSocketName))
+	_ = os.Remove(filepath.Join(runDir(vmDir, jailRoot), vsockSocketName))
 
 	var prepared []volume.PreparedVolume
 	var err error
@@ -192,46 +224,150 @@ func (m *Manager) Start(ctx context.Context, svc config.ServiceConfig) error {
 		}
 	}
 
-	configPath, err := m.writeVMConfig(vmDir, svc, prepared)
+	configPath, err := m.prepareBoot(vmDir, jailRoot, svc, prepared)
 	if err != nil {
-		return fmt.Errorf("writing vm config: %w", err)
+		return err
+	}
+
+	// A snapshot-mode service resumes from its last snapshot when one matches;
+	// any failure falls through to a cold boot.
+	if svc.Snapshot && len(prepared) == 0 {
+		if m.restoreSnapshot(ctx, vmDir, jailRoot, svc) {
+			return nil
+		}
+		// A failed restore leaves the jail populated; boot from a clean one.
+		if jailRoot != "" {
+			if configPath, err = m.prepareBoot(vmDir, jailRoot, svc, prepared); err != nil {
+				return err
+			}
+		}
+	}
+
+	inst, err := m.launch(vmDir, jailRoot, svc, prepared, "--config-file", configPath)
+	if err != nil {
+		return err
+	}
+	m.logger.Info("microVM started", "service", svc.Name, "pid", inst.PID)
+	return nil
+}
+
+// prepareBoot writes the Firecracker config for svc and returns its path as
+// Firecracker sees it. A jailed VM gets a fresh jail with its own copies of
+// the kernel and rootfs, its volume images and its config exposed inside it,
+// and a config that refers to them by their in-jail paths.
+func (m *Manager) prepareBoot(vmDir, jailRoot string, svc config.ServiceConfig, prepared []volume.PreparedVolume) (string, error) {
+	if jailRoot == "" {
+		configPath, err := m.writeVMConfig(vmDir, "", svc, prepared)
+		if err != nil {
+			return "", fmt.Errorf("writing vm config: %w", err)
+		}
+		return configPath, nil
 	}
 
-	cmd := exec.CommandContext(ctx, m.firecrackerBin,
-		"--api-sock", socketPath,
-		"--config-file", configPath,
-	)
+	if err := checkJailID(jailRoot); err != nil {
+		return "", err
+	}
+	files := make(map[string]string)
+	for name, hostPath := range map[string]string{"vmlinux": svc.Kernel, jailRootfsName: svc.Image} {
+		files[name] = jailImagePath(vmDir, name)
+		if err := syncImageCopy(hostPath, files[name]); err != nil {
+			return "", fmt.Errorf("copying %s for the jail of %s: %w", hostPath, svc.Name, err)
+		}
+	}
+	jailed := svc
+	jailed.Kernel = "/vmlinux"
+	jailed.Image = "/" + jailRootfsName
+	jailedVolumes := make([]volume.PreparedVolume, len(prepared))
+	for i, preparedVolume := range prepared {
+		name := fmt.Sprintf("volume-%d.img", i)
+		files[name] = preparedVolume.PathOnHost
+		jailedVolumes[i] = preparedVolume
+		jailedVolumes[i].PathOnHost = "/" + name
+	}
+	configPath, err := m.writeVMConfig(vmDir, jailRoot, jailed, jailedVolumes)
+	if err != nil {
+		return "", fmt.Errorf("writing vm config: %w", err)
+	}
+	files["vm-config.json"] = configPath
+	if err := m.jail.prepare(jailRoot, files); err != nil {
+		return "", fmt.Errorf("preparing jail for %s: %w", svc.Name, err)
+	}
+	return "/vm-config.json", nil
+}
 
-	logFile, err := os.Create(filepath.Join(vmDir, "firecracker.log"))
+// launch starts Firecracker for svc with the API socket plus extraArgs and
+// records it as a running instance. With a jailRoot Firecracker is started
+// through the jailer. The caller holds m.mu.
+func (m *Manager) launch(vmDir, jailRoot string, svc config.ServiceConfig, prepared []volume.PreparedVolume, extraArgs ...string) (*Instance, error) {
+	socketPath := filepath.Join(runDir(vmDir, jailRoot), apiSocketName)
+	logPath := filepath.Join(runDir(vmDir, jailRoot), vmmFIFOName)
+	metricsPath := filepath.Join(runDir(vmDir, jailRoot), metricsFIFOName)
+	args := append([]string{
+		"--api-sock", fcPath(jailRoot, socketPath),
+		"--log-path", fcPath(jailRoot, logPath), "--level", "Info",
+		"--metrics-path", fcPath(jailRoot, metricsPath),
+	}, extraArgs...)
+
+	// Not bound to ctx: the agent's context ends on shutdown, and the VM must
+	// outlive the agent process to be adopted by the next one.
+	cmd := exec.Command(m.firecrackerBin, args...)
+	if jailRoot != "" {
+		cmd = m.jail.command(m.firecrackerBin, jailRoot, svc, args)
+	}
+
+	logFile, err := openConsoleLog(vmDir, m.logLimits)
 	if err != nil {
-		return fmt.Errorf("creating log file: %w", err)
+		return nil, fmt.Errorf("creating log file: %w", err)
+	}
+	logFIFO, err := m.createFIFO(logPath, jailRoot != "")
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	metricsFIFO, err := m.createFIFO(metricsPath, jailRoot != "")
+	if err != nil {
+		logFile.Close()
+		logFIFO.Close()
+		return nil, err
 	}
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
-	// Start firecracker in its own process group so it survives agent restart.
+	// Start firecracker in its own process group so it survives agent restart
+	// and can be adopted by the next agent process (see Adopt).
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	if err := cmd.Start(); err != nil {
 		logFile.Close()
-		return fmt.Errorf("starting firecracker: %w", err)
+		logFIFO.Close()
+		metricsFIFO.Close()
+		return nil, fmt.Errorf("starting firecracker: %w", err)
 	}
 
-	m.instances[svc.Name] = &Instance{
+	inst := &Instance{
 		Name:       svc.Name,
 		Config:     svc,
 		State:      StateRunning,
 		PID:        cmd.Process.Pid,
 		SocketPath: socketPath,
 		Volumes:    append([]volume.PreparedVolume(nil), prepared...),
+		JailRoot:   jailRoot,
+		logs:       m.startLogCapture(vmDir, logFIFO),
+		metrics:    startMetricsCapture(metricsFIFO),
 	}
+	m.instances[svc.Name] = inst
 	delete(m.volumeErrors, svc.Name)
 
-	// Monitor the process in a goroutine.
-	go m.monitor(svc.Name, cmd, logFile)
+	// Persist the process identity so a restarted agent can adopt this VM.
+	// Failing to do so only costs adoption, not the running VM.
+	if err := writeInstanceRecord(vmDir, cmd.Process.Pid, svc, prepared, jailRoot); err != nil {
+		m.logger.Warn("failed to write VM instance record; VM will not be adoptable after agent restart",
+			"service", svc.Name, "error", err)
+	}
 
-	m.logger.Info("microVM started", "service", svc.Name, "pid", cmd.Process.Pid)
-	return nil
+	// Monitor the process in a goroutine.
+	go m.monitor(inst, cmd, logFile)
+	return inst, nil
 }
 
 // Stop gracefully shuts down a running microVM.
@@ -296,6 +432,10 @@ func (m *Manager) Remove(name string) error {
 	delete(m.instances, name)
 	m.mu.Unlock()
 
+	if exists && inst.JailRoot != "" {
+		m.releaseJail(inst.JailRoot)
+	}
+
 	vmDir := filepath.Join(m.stateDir, "vms", name)
 	if err := os.RemoveAll(vmDir); err != nil {
 		return fmt.Errorf("removing vm dir: %w", err)
@@ -304,6 +444,60 @@ func (m *Manager) Remove(name string) error {
 	return nil
 }
 
+// Rename moves a running instance to svc.Name and records svc as its config.
+// It promotes a replacement VM started under a temporary name once the VM it
+// replaces has been removed. The VM directory is renamed with it; Firecracker
+// keeps its open files and socket across the rename. A jailed VM stays in the
+// jail it was started in.
+func (m *Manager) Rename(from string, svc config.ServiceConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, exists := m.instances[from]
+	if !exists || inst.State != StateRunning {
+		return fmt.Errorf("service %s is not running", from)
+	}
+	if other, exists := m.instances[svc.Name]; exists && other != inst {
+		return fmt.Errorf("service %s already exists", svc.Name)
+	}
+
+	fromDir := filepath.Join(m.stateDir, "vms", from)
+	toDir := filepath.Join(m.stateDir, "vms", svc.Name)
+	if _, err := os.Stat(toDir); err == nil {
+		return fmt.Errorf("vm dir for %s already exists", svc.Name)
+	}
+	if err := os.Rename(fromDir, toDir); err != nil {
+		return fmt.Errorf("renaming vm dir: %w", err)
+	}
+
+	delete(m.instances, from)
+	inst.Name = svc.Name
+	inst.logs.setDir(toDir)
+	inst.Config = svc
+	if inst.JailRoot == "" {
+		inst.SocketPath = filepath.Join(toDir, apiSocketName)
+	}
+	m.instances[svc.Name] = inst
+
+	if err := writeInstanceRecord(toDir, inst.PID, svc, inst.Volumes, inst.JailRoot); err != nil {
+		m.logger.Warn("failed to rewrite VM instance record after rename",
+			"service", svc.Name, "error", err)
+	}
+	m.logger.Info("microVM renamed", "from", from, "service", svc.Name, "pid", inst.PID)
+	return nil
+}
+
+// ExecSocketPath returns the host-side vsock socket of a service's VM. It
+// only exists for services whose health check type is "exec".
+func (m *Manager) ExecSocketPath(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if inst, exists := m.instances[name]; exists && inst.JailRoot != "" {
+		return filepath.Join(inst.JailRoot, vsockSocketName)
+	}
+	return filepath.Join(m.stateDir, "vms", name, vsockSocketName)
+}
+
 // IsRunning checks if the process for a service is still alive.
 func (m *Manager) IsRunning(name string) bool {
 	m.mu.Lock()
@@ -323,20 +517,28 @@ func (m *Manager) IsRunning(name string) bool {
 	return proc.Signal(syscall.Signal(0)) == nil
 }
 
-// monitor waits for the firecracker process to exit and updates state.
-func (m *Manager) monitor(name string, cmd *exec.Cmd, logFile *os.File) {
+// monitor waits for the firecracker process to exit and updates state. It
+// tracks the instance rather than its name so a Rename does not detach it.
+func (m *Manager) monitor(inst *Instance, cmd *exec.Cmd, logFile *os.File) {
 	defer logFile.Close()
 
 	err := cmd.Wait()
+	inst.logs.close()
+	inst.metrics.close()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	inst, exists := m.instances[name]
-	if !exists {
+	name := inst.Name
+	if m.instances[name] != inst {
 		return
 	}
 
+	if inst.Config.Kind == config.KindJob && inst.State != StateStopped {
+		m.finishJob(inst, filepath.Join(m.stateDir, "vms", name))
+		inst.PID = 0
+		return
+	}
 	if err != nil {
 		// Stop() marks instances as stopped before process exit. In that case
 		// a non-zero Wait result is expected and should not flip state to failed.
@@ -370,14 +572,19 @@ func waitForPIDExit(pid int, timeout time.Duration) bool {
 }
 
 // writeVMConfig writes a Firecracker JSON config file for the given service.
-func (m *Manager) writeVMConfig(vmDir string, svc config.ServiceConfig, prepared []volume.PreparedVolume) (string, error) {
+// Paths in svc and prepared are written as given; for a jailed VM (jailRoot
+// set) they must already be in-jail paths.
+func (m *Manager) writeVMConfig(vmDir, jailRoot string, svc config.ServiceConfig, prepared []volume.PreparedVolume) (string, error) {
 	kernelArgs := svc.KernelArgs
 	if kernelArgs == "" {
 		kernelArgs = "console=ttyS0 reboot=k panic=1 pci=off"
 	}
 
 	sort.Slice(prepared, func(i, j int) bool { return prepared[i].LogicalID < prepared[j].LogicalID })
-	drives := []firecrackerDrive{{DriveID: "rootfs", PathOnHost: svc.Image, IsRootDevice: true, IsReadOnly: false}}
+	drives := []firecrackerDrive{{
+		DriveID: rootfsDriveID, PathOnHost: svc.Image, IsRootDevice: true, IsReadOnly: false,
+		RateLimiter: bootRateLimiter(svc.RateLimits.Rootfs),
+	}}
 	guestVolumes := make([]guestVolume, 0, len(prepared))
 	for i, preparedVolume := range prepared {
 		device, err := guestBlockDevice(i)
@@ -385,8 +592,9 @@ func (m *Manager) writeVMConfig(vmDir string, svc config.ServiceConfig, prepared
 			return "", err
 		}
 		drives = append(drives, firecrackerDrive{
-			DriveID: fmt.Sprintf("volume-%d", i), PathOnHost: preparedVolume.PathOnHost,
+			DriveID: volumeDriveID(i), PathOnHost: preparedVolume.PathOnHost,
 			IsRootDevice: false, IsReadOnly: false,
+			RateLimiter: bootRateLimiter(svc.RateLimits.Volumes),
 		})
 		guestVolumes = append(guestVolumes, guestVolume{
 			Name: filepath.Base(preparedVolume.LogicalID), Device: device,
@@ -402,13 +610,36 @@ func (m *Manager) writeVMConfig(vmDir string, svc config.ServiceConfig, prepared
 		kernelArgs = insertBeforeApplicationSeparator(kernelArgs, arg)
 	}
 
+	// Exec health checks run commands through fc-init's vsock command server,
+	// which only starts when the kernel command line names its port.
+	var vsock *firecrackerVsock
+	if svc.HealthCheck != nil && svc.HealthCheck.Type == "exec" {
+		vsock = &firecrackerVsock{GuestCID: guestexec.GuestCID, UDSPath: fcPath(jailRoot, filepath.Join(runDir(vmDir, jailRoot), vsockSocketName))}
+		kernelArgs = insertBeforeApplicationSeparator(kernelArgs, fmt.Sprintf("%s=%d", guestexec.KernelArg, guestexec.Port))
+	}
+	if svc.Kind == config.KindJob {
+		kernelArgs = insertBeforeApplicationSeparator(kernelArgs, jobKernelArg)
+	}
+
 	var networkInterfaces []firecrackerNetworkInterface
 	if svc.Network != nil {
 		guestMAC := svc.Network.GuestMAC
 		if guestMAC == "" {
 			guestMAC = "AA:FC:00:00:00:01"
 		}
-		networkInterfaces = []firecrackerNetworkInterface{{IfaceID: "eth0", GuestMAC: guestMAC, HostDevName: svc.Network.Interface}}
+		limiter := bootRateLimiter(svc.RateLimits.Network)
+		networkInterfaces = []firecrackerNetworkInterface{{
+			IfaceID: "eth0", GuestMAC: guestMAC, HostDevName: svc.Network.Interface,
+			RxRateLimiter: limiter, TxRateLimiter: limiter,
+		}}
+		// Additional networks follow in order, so the guest names them eth1,
+		// eth2, ... as the agent's firework.net arguments expect.
+		for i, att := range svc.Networks {
+			networkInterfaces = append(networkInterfaces, firecrackerNetworkInterface{
+				IfaceID: fmt.Sprintf("eth%d", i+1), GuestMAC: att.GuestMAC, HostDevName: att.Interface,
+				RxRateLimiter: limiter, TxRateLimiter: limiter,
+			})
+		}
 	}
 
 	vmConfig := firecrackerConfig{
@@ -416,6 +647,10 @@ func (m *Manager) writeVMConfig(vmDir string, svc config.ServiceConfig, prepared
 		Drives:            drives,
 		MachineConfig:     firecrackerMachineConfig{VCPUCount: svc.VCPUs, MemSizeMiB: svc.MemoryMB},
 		NetworkInterfaces: networkInterfaces,
+		Vsock:             vsock,
+	}
+	if svc.Balloon != nil {
+		vmConfig.Balloon = &firecrackerBalloon{DeflateOnOOM: true, StatsPollingIntervalS: balloonStatsIntervalSeconds}
 	}
 	configJSON, err := json.MarshalIndent(vmConfig, "", "  ")
 	if err != nil {
@@ -434,6 +669,8 @@ type firecrackerConfig struct {
 	Drives            []firecrackerDrive            `json:"drives"`
 	MachineConfig     firecrackerMachineConfig      `json:"machine-config"`
 	NetworkInterfaces []firecrackerNetworkInterface `json:"network-interfaces,omitempty"`
+	Vsock             *firecrackerVsock             `json:"vsock,omitempty"`
+	Balloon           *firecrackerBalloon           `json:"balloon,omitempty"`
 }
 
 type firecrackerBootSource struct {
@@ -442,10 +679,11 @@ type firecrackerBootSource struct {
 }
 
 type firecrackerDrive struct {
-	DriveID      string `json:"drive_id"`
-	PathOnHost   string `json:"path_on_host"`
-	IsRootDevice bool   `json:"is_root_device"`
-	IsReadOnly   bool   `json:"is_read_only"`
+	DriveID      string                  `json:"drive_id"`
+	PathOnHost   string                  `json:"path_on_host"`
+	IsRootDevice bool                    `json:"is_root_device"`
+	IsReadOnly   bool                    `json:"is_read_only"`
+	RateLimiter  *firecrackerRateLimiter `json:"rate_limiter,omitempty"`
 }
 
 type firecrackerMachineConfig struct {
@@ -454,9 +692,16 @@ type firecrackerMachineConfig struct {
 }
 
 type firecrackerNetworkInterface struct {
-	IfaceID     string `json:"iface_id"`
-	GuestMAC    string `json:"guest_mac"`
-	HostDevName string `json:"host_dev_name"`
+	IfaceID       string                  `json:"iface_id"`
+	GuestMAC      string                  `json:"guest_mac"`
+	HostDevName   string                  `json:"host_dev_name"`
+	RxRateLimiter *firecrackerRateLimiter `json:"rx_rate_limiter,omitempty"`
+	TxRateLimiter *firecrackerRateLimiter `json:"tx_rate_limiter,omitempty"`
+}
+
+type firecrackerVsock struct {
+	GuestCID int    `json:"guest_cid"`
+	UDSPath  string `json:"uds_path"`
 }
 
 type guestVolumePayload struct {
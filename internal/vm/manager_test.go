@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -50,7 +51,7 @@ func TestManagerClearsPIDAndRecordsProcessFailure(t *testing.T) {
 func TestWriteVMConfigAddsDeterministicVolumeDrivesAndPayload(t *testing.T) {
 	dir := t.TempDir()
 	manager := NewManager("/bin/true", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
-	path, err := manager.writeVMConfig(dir, config.ServiceConfig{
+	path, err := manager.writeVMConfig(dir, "", config.ServiceConfig{
 		Name: "app", Image: "/root.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128,
 		KernelArgs: "console=ttyS0 init=/sbin/fc-init /bin/app -- flag",
 	}, []volume.PreparedVolume{
@@ -118,3 +119,70 @@ func TestPreflightRetainsVisibleVolumeError(t *testing.T) {
 		t.Fatalf("unexpected retained volume error %q", got)
 	}
 }
+
+func TestWriteVMConfigAddsVsockForExecHealthCheck(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager("/bin/true", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	path, err := manager.writeVMConfig(dir, "", config.ServiceConfig{
+		Name: "app", Image: "/root.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128,
+		KernelArgs:  "console=ttyS0 init=/sbin/fc-init /bin/app -- flag",
+		HealthCheck: &config.HealthCheckConfig{Type: "exec", Command: []string{"/bin/check"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg firecrackerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Vsock == nil || cfg.Vsock.GuestCID != 3 || cfg.Vsock.UDSPath != filepath.Join(dir, "vsock.sock") {
+		t.Fatalf("unexpected vsock device: %#v", cfg.Vsock)
+	}
+	if !strings.Contains(cfg.BootSource.BootArgs, "firework.exec_port=52 -- flag") {
+		t.Fatalf("exec port not passed before application separator: %q", cfg.BootSource.BootArgs)
+	}
+
+	path, err = manager.writeVMConfig(dir, "", config.ServiceConfig{
+		Name: "app", Image: "/root.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128,
+		HealthCheck: &config.HealthCheckConfig{Type: "tcp", Port: 80},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(path)
+	if strings.Contains(string(data), "vsock") || strings.Contains(string(data), "exec_port") {
+		t.Fatalf("vsock configured for non-exec health check: %s", data)
+	}
+}
+
+func TestWriteVMConfigAddsInterfacePerNetwork(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager("/bin/true", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	path, err := manager.writeVMConfig(dir, "", config.ServiceConfig{
+		Name: "db", Image: "/root.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128,
+		Network:  &config.NetworkConfig{Interface: "tap-db", GuestMAC: "AA:FC:00:00:00:01"},
+		Networks: []config.NetworkConfig{{Name: "data", Interface: "fwn-db", GuestMAC: "AA:FC:01:00:00:01"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg firecrackerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := []firecrackerNetworkInterface{
+		{IfaceID: "eth0", GuestMAC: "AA:FC:00:00:00:01", HostDevName: "tap-db"},
+		{IfaceID: "eth1", GuestMAC: "AA:FC:01:00:00:01", HostDevName: "fwn-db"},
+	}
+	if !slices.Equal(cfg.NetworkInterfaces, want) {
+		t.Fatalf("unexpected network interfaces: %#v", cfg.NetworkInterfaces)
+	}
+}
@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// metricsFIFOName is the named pipe Firecracker writes its metrics to. It
+// flushes them as one JSON object per line every minute.
+const metricsFIFOName = "metrics.fifo"
+
+// Metrics are the Firecracker VMM counters the agent exports for a VM,
+// summed since it booted or since the agent adopted it.
+type Metrics struct {
+	// VCPUExits counts vCPU exits to the VMM for port and MMIO accesses.
+	VCPUExits uint64
+	// VCPUFailures counts vCPU errors.
+	VCPUFailures    uint64
+	BlockReadBytes  uint64
+	BlockWriteBytes uint64
+	// BlockErrors counts block requests that were invalid or failed.
+	BlockErrors uint64
+	// BlockThrottled counts block requests held back by a rate limiter.
+	BlockThrottled uint64
+	NetRxBytes     uint64
+	NetTxBytes     uint64
+	// NetErrors counts failed receives and transmits, TAP errors included.
+	NetErrors uint64
+	// NetThrottled counts network events held back by a rate limiter.
+	NetThrottled uint64
+}
+
+// firecrackerMetrics is the subset of a Firecracker metrics flush the agent
+// reads. Counters are reported as the change since the previous flush; the
+// "block" and "net" objects aggregate all devices.
+type firecrackerMetrics struct {
+	VCPU struct {
+		ExitIOIn      uint64 `json:"exit_io_in"`
+		ExitIOOut     uint64 `json:"exit_io_out"`
+		ExitMMIORead  uint64 `json:"exit_mmio_read"`
+		ExitMMIOWrite uint64 `json:"exit_mmio_write"`
+		Failures      uint64 `json:"failures"`
+	} `json:"vcpu"`
+	Block struct {
+		ReadBytes    uint64 `json:"read_bytes"`
+		WriteBytes   uint64 `json:"write_bytes"`
+		ExecuteFails uint64 `json:"execute_fails"`
+		InvalidReqs  uint64 `json:"invalid_reqs_count"`
+		Throttled    uint64 `json:"rate_limiter_throttled_events"`
+	} `json:"block"`
+	Net struct {
+		RxBytes       uint64 `json:"rx_bytes_count"`
+		TxBytes       uint64 `json:"tx_bytes_count"`
+		RxFails       uint64 `json:"rx_fails"`
+		TxFails       uint64 `json:"tx_fails"`
+		TapReadFails  uint64 `json:"tap_read_fails"`
+		TapWriteFails uint64 `json:"tap_write_fails"`
+		RxThrottled   uint64 `json:"rx_rate_limiter_throttled"`
+		TxThrottled   uint64 `json:"tx_rate_limiter_throttled"`
+	} `json:"net"`
+}
+
+// metricsCapture sums the metrics flushes of one VM.
+type metricsCapture struct {
+	reader *fifoReader
+
+	mu     sync.Mutex
+	totals Metrics
+}
+
+// startMetricsCapture starts reading a VM's metrics FIFO. fifo may be nil
+// for a VM started without one.
+func startMetricsCapture(fifo *os.File) *metricsCapture {
+	c := &metricsCapture{}
+	c.reader = readFIFO(fifo, c.add)
+	return c
+}
+
+// add adds one flush to the totals. Lines that are not a metrics object are
+// ignored.
+func (c *metricsCapture) add(line []byte) {
+	var flush firecrackerMetrics
+	if err := json.Unmarshal(line, &flush); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &c.totals
+	t.VCPUExits += flush.VCPU.ExitIOIn + flush.VCPU.ExitIOOut + flush.VCPU.ExitMMIORead + flush.VCPU.ExitMMIOWrite
+	t.VCPUFailures += flush.VCPU.Failures
+	t.BlockReadBytes += flush.Block.ReadBytes
+	t.BlockWriteBytes += flush.Block.WriteBytes
+	t.BlockErrors += flush.Block.ExecuteFails + flush.Block.InvalidReqs
+	t.BlockThrottled += flush.Block.Throttled
+	t.NetRxBytes += flush.Net.RxBytes
+	t.NetTxBytes += flush.Net.TxBytes
+	t.NetErrors += flush.Net.RxFails + flush.Net.TxFails + flush.Net.TapReadFails + flush.Net.TapWriteFails
+	t.NetThrottled += flush.Net.RxThrottled + flush.Net.TxThrottled
+}
+
+func (c *metricsCapture) snapshot() Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.totals
+}
+
+// close stops the capture once Firecracker has exited.
+func (c *metricsCapture) close() {
+	if c == nil {
+		return
+	}
+	c.reader.close()
+}
+
+// Metrics returns the VMM counters of every running VM, keyed by service
+// name.
+func (m *Manager) Metrics() map[string]Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]Metrics, len(m.instances))
+	for name, inst := range m.instances {
+		if inst.State == StateRunning && inst.metrics != nil {
+			out[name] = inst.metrics.snapshot()
+		}
+	}
+	return out
+}
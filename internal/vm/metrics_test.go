@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func TestMetricsSumsFirecrackerFlushes(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "fake-firecracker")
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  [ "$1" = --metrics-path ] && metrics="$2"
+  shift
+done
+echo '{"utc_timestamp_ms":1,"vcpu":{"exit_io_in":3,"exit_mmio_write":2,"failures":0},"block":{"read_bytes":4096,"invalid_reqs_count":1},"net":{"tx_bytes_count":100,"tx_rate_limiter_throttled":2}}' > "$metrics"
+echo 'not json' > "$metrics"
+echo '{"utc_timestamp_ms":2,"vcpu":{"exit_io_out":5},"block":{"write_bytes":512,"rate_limiter_throttled_events":4},"net":{"rx_bytes_count":50,"tap_read_fails":1}}' > "$metrics"
+exec sleep 60
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(binary, filepath.Join(dir, "state"), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	svc := config.ServiceConfig{Name: "app", Image: "/rootfs.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128}
+	if err := m.Start(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.Stop(svc.Name) })
+
+	want := Metrics{
+		VCPUExits: 10, BlockReadBytes: 4096, BlockWriteBytes: 512, BlockErrors: 1, BlockThrottled: 4,
+		NetRxBytes: 50, NetTxBytes: 100, NetErrors: 1, NetThrottled: 2,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got := m.Metrics()[svc.Name]; got == want {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := m.Metrics()[svc.Name]; got != want {
+		t.Fatalf("metrics = %+v, want %+v", got, want)
+	}
+
+	if err := m.Stop(svc.Name); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Metrics()[svc.Name]; ok {
+		t.Fatal("a stopped VM must not report metrics")
+	}
+}
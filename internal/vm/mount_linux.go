@@ -0,0 +1,31 @@
+package vm
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// bindMountFile bind mounts the file src onto dst, creating dst first.
+func bindMountFile(src, dst string) error {
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := syscall.Mount(src, dst, "", syscall.MS_BIND, ""); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// unmountFile detaches a bind mount at path. Paths that are not mount points
+// are left alone.
+func unmountFile(path string) {
+	_ = syscall.Unmount(path, syscall.MNT_DETACH)
+}
+
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
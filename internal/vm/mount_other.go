@@ -0,0 +1,13 @@
+//go:build !linux
+
+package vm
+
+import "errors"
+
+func bindMountFile(src, dst string) error {
+	return errors.New("bind mounts are only supported on linux")
+}
+
+func unmountFile(path string) {}
+
+func isCrossDevice(err error) bool { return false }
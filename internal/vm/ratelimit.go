@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// rateLimitRefillMillis is the refill period of every token bucket. Bucket
+// sizes are the configured per-second rates, so a device may burst up to one
+// second's worth of I/O before it is throttled.
+const rateLimitRefillMillis = 1000
+
+// rootfsDriveID is the Firecracker drive ID of the root filesystem.
+const rootfsDriveID = "rootfs"
+
+// volumeDriveID returns the drive ID of the i-th volume in LogicalID order.
+func volumeDriveID(i int) string {
+	return fmt.Sprintf("volume-%d", i)
+}
+
+type firecrackerRateLimiter struct {
+	Bandwidth *firecrackerTokenBucket `json:"bandwidth,omitempty"`
+	Ops       *firecrackerTokenBucket `json:"ops,omitempty"`
+}
+
+type firecrackerTokenBucket struct {
+	Size       int64 `json:"size"`
+	RefillTime int64 `json:"refill_time"`
+}
+
+// bootRateLimiter renders limit for the VM config file. Unlimited devices get
+// no rate limiter at all.
+func bootRateLimiter(limit config.RateLimit) *firecrackerRateLimiter {
+	if limit == (config.RateLimit{}) {
+		return nil
+	}
+	rl := &firecrackerRateLimiter{}
+	if limit.BandwidthBytes > 0 {
+		rl.Bandwidth = &firecrackerTokenBucket{Size: limit.BandwidthBytes, RefillTime: rateLimitRefillMillis}
+	}
+	if limit.Ops > 0 {
+		rl.Ops = &firecrackerTokenBucket{Size: limit.Ops, RefillTime: rateLimitRefillMillis}
+	}
+	return rl
+}
+
+// patchRateLimiter renders limit for a PATCH on a running VM. Firecracker
+// leaves a bucket missing from the request unchanged and disables one of
+// size zero, so both buckets are always sent.
+func patchRateLimiter(limit config.RateLimit) *firecrackerRateLimiter {
+	rl := &firecrackerRateLimiter{Bandwidth: &firecrackerTokenBucket{}, Ops: &firecrackerTokenBucket{}}
+	if limit.BandwidthBytes > 0 {
+		rl.Bandwidth = &firecrackerTokenBucket{Size: limit.BandwidthBytes, RefillTime: rateLimitRefillMillis}
+	}
+	if limit.Ops > 0 {
+		rl.Ops = &firecrackerTokenBucket{Size: limit.Ops, RefillTime: rateLimitRefillMillis}
+	}
+	return rl
+}
+
+// UpdateRateLimits applies the rate limits of svc to its running VM through
+// the Firecracker API, without restarting it, and records them as part of
+// the instance's config. Devices whose update fails keep their previous
+// limits; the recorded config is only updated when every device succeeded,
+// so the reconciler retries on its next pass.
+func (m *Manager) UpdateRateLimits(ctx context.Context, svc config.ServiceConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, exists := m.instances[svc.Name]
+	if !exists || inst.State != StateRunning {
+		return fmt.Errorf("service %s is not running", svc.Name)
+	}
+	if err := applyRateLimits(ctx, newAPIClient(inst.SocketPath), inst.Config, len(inst.Volumes), svc.RateLimits); err != nil {
+		return err
+	}
+
+	inst.Config.RateLimits = svc.RateLimits
+	vmDir := filepath.Join(m.stateDir, "vms", svc.Name)
+	if err := writeInstanceRecord(vmDir, inst.PID, inst.Config, inst.Volumes, inst.JailRoot); err != nil {
+		m.logger.Warn("failed to rewrite VM instance record after rate limit update",
+			"service", svc.Name, "error", err)
+	}
+	m.logger.Info("microVM rate limits updated", "service", svc.Name)
+	return nil
+}
+
+// applyRateLimits patches every drive and network interface of a VM booted
+// from running to limits.
+func applyRateLimits(ctx context.Context, client *apiClient, running config.ServiceConfig, volumes int, limits config.RateLimits) error {
+	var errs []error
+	if err := client.patchDrive(ctx, rootfsDriveID, patchRateLimiter(limits.Rootfs)); err != nil {
+		errs = append(errs, err)
+	}
+	for i := range volumes {
+		if err := client.patchDrive(ctx, volumeDriveID(i), patchRateLimiter(limits.Volumes)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if running.Network != nil {
+		limiter := patchRateLimiter(limits.Network)
+		for i := range len(running.Networks) + 1 {
+			if err := client.patchNetworkInterface(ctx, fmt.Sprintf("eth%d", i), limiter); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withoutRateLimits returns svc with its rate limits cleared. Limits can be
+// changed on a running VM, so they do not decide whether a surviving VM or a
+// snapshot still matches its config.
+func withoutRateLimits(svc config.ServiceConfig) config.ServiceConfig {
+	svc.RateLimits = config.RateLimits{}
+	return svc
+}
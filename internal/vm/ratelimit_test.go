@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+func TestWriteVMConfigRendersRateLimiters(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager("/bin/true", dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	path, err := manager.writeVMConfig(dir, "", config.ServiceConfig{
+		Name: "db", Image: "/root.ext4", Kernel: "/kernel", VCPUs: 1, MemoryMB: 128,
+		Network: &config.NetworkConfig{Interface: "tap-db", GuestMAC: "AA:FC:00:00:00:01"},
+		RateLimits: config.RateLimits{
+			Rootfs:  config.RateLimit{BandwidthBytes: 50 * config.MiB, Ops: 1000},
+			Network: config.RateLimit{BandwidthBytes: 10 * config.MiB},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg firecrackerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	rootfs := cfg.Drives[0].RateLimiter
+	if rootfs == nil || *rootfs.Bandwidth != (firecrackerTokenBucket{Size: 50 * config.MiB, RefillTime: 1000}) ||
+		*rootfs.Ops != (firecrackerTokenBucket{Size: 1000, RefillTime: 1000}) {
+		t.Fatalf("unexpected rootfs rate limiter %+v", rootfs)
+	}
+	nic := cfg.NetworkInterfaces[0]
+	if nic.RxRateLimiter == nil || nic.TxRateLimiter == nil || nic.RxRateLimiter.Ops != nil ||
+		nic.RxRateLimiter.Bandwidth.Size != 10*config.MiB {
+		t.Fatalf("unexpected network rate limiters %+v / %+v", nic.RxRateLimiter, nic.TxRateLimiter)
+	}
+	if strings.Contains(string(data), `"rate_limiter": null`) {
+		t.Fatal("unlimited devices must not render a rate limiter")
+	}
+}
+
+func TestUpdateRateLimitsPatchesRunningVM(t *testing.T) {
+	m := newSnapshotTestManager(t, t.TempDir())
+	svc := snapshotTestService(t)
+	svc.RateLimits.Rootfs = config.RateLimit{BandwidthBytes: config.MiB}
+	startAndWait(t, m, svc)
+
+	svc.RateLimits = config.RateLimits{Network: config.RateLimit{Ops: 500}}
+	if err := m.UpdateRateLimits(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.stateDir, "vms", svc.Name, patchLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		// The previous rootfs limit is lifted with disabled buckets.
+		`/drives/rootfs {"drive_id":"rootfs","rate_limiter":{"bandwidth":{"size":0,"refill_time":0},"ops":{"size":0,"refill_time":0}}}`,
+		`/network-interfaces/eth0 {"iface_id":"eth0","rx_rate_limiter":{"bandwidth":{"size":0,"refill_time":0},"ops":{"size":500,"refill_time":1000}},"tx_rate_limiter":{"bandwidth":{"size":0,"refill_time":0},"ops":{"size":500,"refill_time":1000}}}`,
+	}
+	if strings.Join(patches, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected patches:\n%s", data)
+	}
+	if got := m.List()[svc.Name].Config.RateLimits; got != svc.RateLimits {
+		t.Fatalf("recorded rate limits = %+v, want %+v", got, svc.RateLimits)
+	}
+	rec, err := readInstanceRecord(filepath.Join(m.stateDir, "vms", svc.Name))
+	if err != nil || rec.Config.RateLimits != svc.RateLimits {
+		t.Fatalf("instance record not updated: %+v, %v", rec.Config.RateLimits, err)
+	}
+}
@@ -0,0 +1,402 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// Files of a snapshot directory. The rootfs copy and its image-sync token are
+// only present in exported snapshots.
+const (
+	snapshotStateFile  = "vmstate"
+	snapshotMemoryFile = "memory"
+	snapshotMetaFile   = "snapshot.json"
+	snapshotRootfsFile = "rootfs.ext4"
+	snapshotTokenFile  = "rootfs.token"
+)
+
+// snapshotMeta describes the VM a snapshot was taken from. The rootfs keeps
+// being written while a VM runs, so the guest's page cache in the memory file
+// is only consistent with the image as it was when the VM stopped; its size
+// and modification time are recorded to detect later changes.
+type snapshotMeta struct {
+	Config       config.ServiceConfig `json:"config"`
+	ImageSize    int64                `json:"image_size"`
+	ImageModTime time.Time            `json:"image_mod_time"`
+	CreatedAt    time.Time            `json:"created_at"`
+}
+
+// Suspend pauses a running snapshot-mode VM, writes its memory and VM state
+// under state_dir/snapshots, and stops it. The next Start with an unchanged
+// config resumes from the snapshot instead of booting. If the snapshot cannot
+// be written the VM is resumed and keeps running.
+func (m *Manager) Suspend(ctx context.Context, name string) error {
+	return m.suspendTo(ctx, name, m.snapshotDir(name), false)
+}
+
+// ExportSnapshot suspends a snapshot-mode VM like Suspend but writes the
+// snapshot, together with a copy of the stopped VM's rootfs, into dir so
+// another node can import it with ImportSnapshot.
+func (m *Manager) ExportSnapshot(ctx context.Context, name, dir string) error {
+	return m.suspendTo(ctx, name, dir, true)
+}
+
+func (m *Manager) suspendTo(ctx context.Context, name, dir string, withRootfs bool) error {
+	m.mu.Lock()
+	inst, exists := m.instances[name]
+	if !exists || inst.State != StateRunning {
+		m.mu.Unlock()
+		return fmt.Errorf("service %s is not running", name)
+	}
+	svc := inst.Config
+	socketPath := inst.SocketPath
+	jailRoot := inst.JailRoot
+	hasVolumes := len(inst.Volumes) > 0
+	m.mu.Unlock()
+
+	if !svc.Snapshot {
+		return fmt.Errorf("service %s does not have snapshot mode enabled", name)
+	}
+	if hasVolumes {
+		return fmt.Errorf("service %s has volumes; snapshots are not supported", name)
+	}
+
+	// Write into a sibling directory first so a crash never leaves a
+	// half-written snapshot where Start would find it.
+	partial := dir + ".partial"
+	if err := os.RemoveAll(partial); err != nil {
+		return fmt.Errorf("clearing partial snapshot: %w", err)
+	}
+	if err := os.MkdirAll(partial, 0o755); err != nil {
+		return fmt.Errorf("creating snapshot dir: %w", err)
+	}
+
+	m.logger.Info("snapshotting microVM", "service", name, "dir", dir)
+	client := newAPIClient(socketPath)
+	if err := client.pause(ctx); err != nil {
+		_ = os.RemoveAll(partial)
+		return fmt.Errorf("pausing %s: %w", name, err)
+	}
+	// A jailed Firecracker can only write inside its jail; the files are moved
+	// out once it has stopped.
+	writeDir := partial
+	if jailRoot != "" {
+		writeDir = jailRoot
+	}
+	err := client.createSnapshot(ctx,
+		fcPath(jailRoot, filepath.Join(writeDir, snapshotStateFile)),
+		fcPath(jailRoot, filepath.Join(writeDir, snapshotMemoryFile)))
+	if err != nil {
+		if resumeErr := client.resume(context.Background()); resumeErr != nil {
+			m.logger.Warn("failed to resume microVM after snapshot failure", "service", name, "error", resumeErr)
+		}
+		_ = os.RemoveAll(partial)
+		return fmt.Errorf("snapshotting %s: %w", name, err)
+	}
+
+	// The VM stays paused until it is stopped, so the rootfs cannot change
+	// after this point.
+	if err := m.Stop(name); err != nil {
+		_ = os.RemoveAll(partial)
+		return fmt.Errorf("stopping %s after snapshot: %w", name, err)
+	}
+	rootfs := svc.Image
+	if jailRoot != "" {
+		for _, file := range []string{snapshotStateFile, snapshotMemoryFile} {
+			if err := moveFile(filepath.Join(jailRoot, file), filepath.Join(partial, file)); err != nil {
+				_ = os.RemoveAll(partial)
+				return fmt.Errorf("moving snapshot of %s out of its jail: %w", name, err)
+			}
+		}
+		// A jailed VM writes to its own copy of the rootfs, which stays in
+		// place for a local resume; another node needs that copy.
+		rootfs = jailImagePath(filepath.Join(m.stateDir, "vms", name), jailRootfsName)
+	}
+
+	if withRootfs {
+		if err := copyFileAtomic(rootfs, filepath.Join(partial, snapshotRootfsFile)); err != nil {
+			_ = os.RemoveAll(partial)
+			return fmt.Errorf("copying rootfs of %s: %w", name, err)
+		}
+		// Carry the image-sync token along so the receiving node does not
+		// replace the copied rootfs with a fresh download.
+		if err := copyFileAtomic(svc.Image+".token", filepath.Join(partial, snapshotTokenFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = os.RemoveAll(partial)
+			return fmt.Errorf("copying image token of %s: %w", name, err)
+		}
+	}
+	if err := writeSnapshotMeta(partial, svc); err != nil {
+		_ = os.RemoveAll(partial)
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		_ = os.RemoveAll(partial)
+		return fmt.Errorf("replacing snapshot dir: %w", err)
+	}
+	if err := os.Rename(partial, dir); err != nil {
+		_ = os.RemoveAll(partial)
+		return fmt.Errorf("publishing snapshot: %w", err)
+	}
+	m.logger.Info("microVM suspended to snapshot", "service", name)
+	return nil
+}
+
+// ImportSnapshot installs a snapshot exported by ExportSnapshot on another
+// node: the rootfs copy replaces the service's image and the memory and VM
+// state become the service's local snapshot, so the next Start resumes the VM
+// where the other node stopped it. The service must not be running here.
+func (m *Manager) ImportSnapshot(name, dir string) error {
+	meta, err := readSnapshotMeta(dir)
+	if err != nil {
+		return err
+	}
+	if meta.Config.Name != name {
+		return fmt.Errorf("snapshot in %s belongs to %s, not %s", dir, meta.Config.Name, name)
+	}
+	if m.IsRunning(name) {
+		return fmt.Errorf("service %s is already running", name)
+	}
+
+	if err := copyFileAtomic(filepath.Join(dir, snapshotRootfsFile), meta.Config.Image); err != nil {
+		return fmt.Errorf("installing rootfs: %w", err)
+	}
+	if err := copyFileAtomic(filepath.Join(dir, snapshotTokenFile), meta.Config.Image+".token"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("installing image token: %w", err)
+	}
+
+	local := m.snapshotDir(name)
+	partial := local + ".partial"
+	if err := os.RemoveAll(partial); err != nil {
+		return fmt.Errorf("clearing partial snapshot: %w", err)
+	}
+	if err := os.MkdirAll(partial, 0o755); err != nil {
+		return fmt.Errorf("creating snapshot dir: %w", err)
+	}
+	for _, file := range []string{snapshotStateFile, snapshotMemoryFile} {
+		if err := copyFileAtomic(filepath.Join(dir, file), filepath.Join(partial, file)); err != nil {
+			_ = os.RemoveAll(partial)
+			return fmt.Errorf("copying %s: %w", file, err)
+		}
+	}
+	// Record the installed image, not the source node's copy, as the one the
+	// snapshot is consistent with.
+	if err := writeSnapshotMeta(partial, meta.Config); err != nil {
+		_ = os.RemoveAll(partial)
+		return err
+	}
+	if err := os.RemoveAll(local); err != nil {
+		_ = os.RemoveAll(partial)
+		return fmt.Errorf("replacing snapshot dir: %w", err)
+	}
+	if err := os.Rename(partial, local); err != nil {
+		_ = os.RemoveAll(partial)
+		return fmt.Errorf("publishing snapshot: %w", err)
+	}
+	m.logger.Info("imported microVM snapshot", "service", name, "from", dir)
+	return nil
+}
+
+// SuspendedNetworks returns the network recorded in every local snapshot,
+// keyed by service name. The agent keeps these addresses reserved so the
+// restored VM's config, which must match the snapshot, is reproduced.
+func (m *Manager) SuspendedNetworks() map[string]config.NetworkConfig {
+	entries, err := os.ReadDir(filepath.Join(m.stateDir, "snapshots"))
+	if err != nil {
+		return nil
+	}
+	out := make(map[string]config.NetworkConfig)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		meta, err := readSnapshotMeta(m.snapshotDir(entry.Name()))
+		if err != nil || meta.Config.Name != entry.Name() || meta.Config.Network == nil {
+			continue
+		}
+		out[entry.Name()] = *meta.Config.Network
+	}
+	return out
+}
+
+// PruneSnapshots deletes local snapshots of services that are no longer
+// desired on this node, along with leftovers of interrupted snapshots.
+func (m *Manager) PruneSnapshots(desired []config.ServiceConfig) {
+	keep := make(map[string]bool, len(desired))
+	for _, svc := range desired {
+		if svc.Snapshot {
+			keep[svc.Name] = true
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(m.stateDir, "snapshots"))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if keep[entry.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(m.stateDir, "snapshots", entry.Name())); err != nil {
+			m.logger.Warn("failed to remove stale snapshot", "name", entry.Name(), "error", err)
+			continue
+		}
+		m.logger.Info("removed stale snapshot", "name", entry.Name())
+	}
+}
+
+// restoreSnapshot starts svc from its local snapshot and reports whether the
+// VM is running. A snapshot is used at most once: it is deleted whether or
+// not the restore succeeds, because the resumed guest immediately diverges
+// from it. The caller holds m.mu.
+func (m *Manager) restoreSnapshot(ctx context.Context, vmDir, jailRoot string, svc config.ServiceConfig) bool {
+	dir := m.snapshotDir(svc.Name)
+	meta, err := readSnapshotMeta(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			m.logger.Warn("unreadable snapshot; cold booting", "service", svc.Name, "error", err)
+			_ = os.RemoveAll(dir)
+		}
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	if !configsEqual(withoutRateLimits(meta.Config), withoutRateLimits(svc)) {
+		m.logger.Info("service config changed since snapshot; cold booting", "service", svc.Name)
+		return false
+	}
+	if info, err := os.Stat(svc.Image); err != nil || info.Size() != meta.ImageSize || !info.ModTime().Equal(meta.ImageModTime) {
+		m.logger.Info("rootfs changed since snapshot; cold booting", "service", svc.Name)
+		return false
+	}
+
+	statePath := filepath.Join(dir, snapshotStateFile)
+	memPath := filepath.Join(dir, snapshotMemoryFile)
+	if jailRoot != "" {
+		// The jail already holds the VM's own rootfs copy, which is the disk
+		// the snapshot was taken with; an imported snapshot's rootfs was
+		// installed as the service's image and copied from there.
+		for _, file := range []string{snapshotStateFile, snapshotMemoryFile} {
+			if err := m.jail.expose(jailRoot, filepath.Join(dir, file), file); err != nil {
+				m.logger.Warn("snapshot restore failed; cold booting", "service", svc.Name, "error", err)
+				return false
+			}
+		}
+		statePath, memPath = "/"+snapshotStateFile, "/"+snapshotMemoryFile
+	}
+
+	inst, err := m.launch(vmDir, jailRoot, svc, nil)
+	if err != nil {
+		m.logger.Warn("snapshot restore failed; cold booting", "service", svc.Name, "error", err)
+		return false
+	}
+	pid := inst.PID
+	socketPath := inst.SocketPath
+	err = waitForSocket(socketPath, apiTimeout, func() bool { return syscall.Kill(pid, 0) != nil })
+	if err == nil {
+		err = newAPIClient(socketPath).loadSnapshot(ctx, statePath, memPath)
+	}
+	if err != nil {
+		m.logger.Warn("snapshot restore failed; cold booting", "service", svc.Name, "error", err)
+		// Mark the instance stopped first so monitor treats the exit as expected.
+		inst.State = StateStopped
+		delete(m.instances, svc.Name)
+		terminatePID(pid)
+		_ = os.Remove(socketPath)
+		_ = os.Remove(filepath.Join(runDir(vmDir, jailRoot), vsockSocketName))
+		return false
+	}
+	// The snapshot carries the limits the VM was suspended with.
+	if svc.RateLimits != meta.Config.RateLimits {
+		if err := applyRateLimits(ctx, newAPIClient(socketPath), svc, 0, svc.RateLimits); err != nil {
+			m.logger.Warn("failed to apply rate limits to restored microVM", "service", svc.Name, "error", err)
+			inst.Config.RateLimits = meta.Config.RateLimits
+		}
+	}
+	m.logger.Info("microVM restored from snapshot", "service", svc.Name, "pid", pid,
+		"snapshot_age", time.Since(meta.CreatedAt).Round(time.Second))
+	return true
+}
+
+func (m *Manager) snapshotDir(name string) string {
+	return filepath.Join(m.stateDir, "snapshots", name)
+}
+
+// writeSnapshotMeta records svc and the current state of its rootfs in dir.
+func writeSnapshotMeta(dir string, svc config.ServiceConfig) error {
+	info, err := os.Stat(svc.Image)
+	if err != nil {
+		return fmt.Errorf("stat rootfs: %w", err)
+	}
+	data, err := json.MarshalIndent(snapshotMeta{
+		Config: svc, ImageSize: info.Size(), ImageModTime: info.ModTime(), CreatedAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal snapshot metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotMetaFile), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing snapshot metadata: %w", err)
+	}
+	return nil
+}
+
+func readSnapshotMeta(dir string) (snapshotMeta, error) {
+	var meta snapshotMeta
+	data, err := os.ReadFile(filepath.Join(dir, snapshotMetaFile))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("decode snapshot metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// copyFileAtomic copies src to dst through a temporary file in dst's
+// directory, so readers of dst never see a partial copy.
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// moveFile renames src to dst, copying when they are on different
+// filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil || !isCrossDevice(err) {
+		return err
+	}
+	if err := copyFileAtomic(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
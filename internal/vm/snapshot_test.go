@@ -0,0 +1,248 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artemnikitin/firework/internal/config"
+)
+
+// fakeAPIEnv makes the test binary act as Firecracker: it serves the subset
+// of the API used for snapshots and rate limits on --api-sock.
+const fakeAPIEnv = "FIREWORK_TEST_FAKE_FIRECRACKER"
+
+// restoredMarker is written next to the API socket by the fake when a
+// snapshot is loaded, holding the loaded state path.
+const restoredMarker = "restored-from"
+
+// patchLog is appended to next to the API socket by the fake for every
+// device PATCH, one "PATH BODY" line each.
+const patchLog = "patches"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeAPIEnv) == "1" {
+		fakeFirecrackerAPI()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func fakeFirecrackerAPI() {
+	var socketPath string
+	for i, arg := range os.Args {
+		if arg == "--api-sock" && i+1 < len(os.Args) {
+			socketPath = os.Args[i+1]
+		}
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		os.Exit(1)
+	}
+	fault := func(w http.ResponseWriter, msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"fault_message": msg})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /vm", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	logPatch := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f, err := os.OpenFile(filepath.Join(filepath.Dir(socketPath), patchLog), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			fault(w, "cannot log patch")
+			return
+		}
+		defer f.Close()
+		_, _ = f.Write(append([]byte(r.URL.Path+" "), append(bytes.TrimSpace(body), '\n')...))
+		w.WriteHeader(http.StatusNoContent)
+	}
+	mux.HandleFunc("PATCH /drives/{id}", logPatch)
+	mux.HandleFunc("PATCH /network-interfaces/{id}", logPatch)
+	mux.HandleFunc("PATCH /balloon", logPatch)
+	mux.HandleFunc("GET /balloon/statistics", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]uint64{"target_mib": 64, "actual_mib": 32, "total_memory": 96 << 20, "available_memory": 40 << 20})
+	})
+	mux.HandleFunc("PUT /snapshot/create", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SnapshotPath string `json:"snapshot_path"`
+			MemFilePath  string `json:"mem_file_path"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if os.WriteFile(req.SnapshotPath, []byte("state"), 0o644) != nil || os.WriteFile(req.MemFilePath, []byte("memory"), 0o644) != nil {
+			fault(w, "cannot write snapshot")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("PUT /snapshot/load", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SnapshotPath string `json:"snapshot_path"`
+			MemBackend   struct {
+				BackendPath string `json:"backend_path"`
+			} `json:"mem_backend"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if _, err := os.Stat(req.MemBackend.BackendPath); err != nil {
+			fault(w, "memory file missing")
+			return
+		}
+		_ = os.WriteFile(filepath.Join(filepath.Dir(socketPath), restoredMarker), []byte(req.SnapshotPath), 0o644)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	_ = http.Serve(ln, mux)
+}
+
+func newSnapshotTestManager(t *testing.T, stateDir string) *Manager {
+	t.Helper()
+	t.Setenv(fakeAPIEnv, "1")
+	return NewManager(os.Args[0], stateDir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func snapshotTestService(t *testing.T) config.ServiceConfig {
+	t.Helper()
+	image := filepath.Join(t.TempDir(), "app-rootfs.ext4")
+	if err := os.WriteFile(image, []byte("rootfs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return config.ServiceConfig{
+		Name: "app", Image: image, Kernel: "/kernel", VCPUs: 1, MemoryMB: 128, Snapshot: true,
+		Network: &config.NetworkConfig{Interface: "tap-app", GuestIP: "172.16.0.2", GuestMAC: "AA:FC:00:00:00:01"},
+	}
+}
+
+func startAndWait(t *testing.T, m *Manager, svc config.ServiceConfig) {
+	t.Helper()
+	if err := m.Start(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if m.IsRunning(svc.Name) {
+			_ = m.Stop(svc.Name)
+		}
+	})
+	socket := filepath.Join(m.stateDir, "vms", svc.Name, "firecracker.sock")
+	if err := waitForSocket(socket, 5*time.Second, func() bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func restoredFrom(m *Manager, name string) string {
+	data, _ := os.ReadFile(filepath.Join(m.stateDir, "vms", name, restoredMarker))
+	return string(data)
+}
+
+func TestSuspendThenStartResumesFromSnapshot(t *testing.T) {
+	m := newSnapshotTestManager(t, t.TempDir())
+	svc := snapshotTestService(t)
+	startAndWait(t, m, svc)
+
+	if err := m.Suspend(context.Background(), svc.Name); err != nil {
+		t.Fatal(err)
+	}
+	if m.IsRunning(svc.Name) {
+		t.Fatal("VM still running after suspend")
+	}
+	dir := m.snapshotDir(svc.Name)
+	for _, file := range []string{snapshotStateFile, snapshotMemoryFile, snapshotMetaFile} {
+		if !fileExists(filepath.Join(dir, file)) {
+			t.Fatalf("snapshot file %s missing", file)
+		}
+	}
+	if got := m.SuspendedNetworks()[svc.Name]; got != *svc.Network {
+		t.Fatalf("suspended network = %+v, want %+v", got, *svc.Network)
+	}
+
+	startAndWait(t, m, svc)
+	if got := restoredFrom(m, svc.Name); got != filepath.Join(dir, snapshotStateFile) {
+		t.Fatalf("VM was not restored from the snapshot (marker %q)", got)
+	}
+	if fileExists(dir) {
+		t.Fatal("snapshot must be discarded after use")
+	}
+}
+
+func TestStartColdBootsWhenConfigChangedSinceSnapshot(t *testing.T) {
+	m := newSnapshotTestManager(t, t.TempDir())
+	svc := snapshotTestService(t)
+	startAndWait(t, m, svc)
+	if err := m.Suspend(context.Background(), svc.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.MemoryMB = 256
+	startAndWait(t, m, svc)
+	if got := restoredFrom(m, svc.Name); got != "" {
+		t.Fatalf("changed config must cold boot, but VM was restored from %q", got)
+	}
+	if fileExists(m.snapshotDir(svc.Name)) {
+		t.Fatal("stale snapshot must be discarded")
+	}
+}
+
+func TestSuspendRejectsServiceWithoutSnapshotMode(t *testing.T) {
+	m := newSnapshotTestManager(t, t.TempDir())
+	svc := snapshotTestService(t)
+	svc.Snapshot = false
+	startAndWait(t, m, svc)
+
+	err := m.Suspend(context.Background(), svc.Name)
+	if err == nil || !strings.Contains(err.Error(), "snapshot mode") {
+		t.Fatalf("expected snapshot mode error, got %v", err)
+	}
+	if !m.IsRunning(svc.Name) {
+		t.Fatal("VM must keep running when suspend is rejected")
+	}
+}
+
+func TestExportAndImportSnapshotMovesServiceBetweenManagers(t *testing.T) {
+	source := newSnapshotTestManager(t, t.TempDir())
+	dest := newSnapshotTestManager(t, t.TempDir())
+	svc := snapshotTestService(t)
+	startAndWait(t, source, svc)
+
+	shared := filepath.Join(t.TempDir(), "handoff")
+	if err := source.ExportSnapshot(context.Background(), svc.Name, shared); err != nil {
+		t.Fatal(err)
+	}
+	if source.IsRunning(svc.Name) {
+		t.Fatal("source VM still running after export")
+	}
+	if !fileExists(filepath.Join(shared, snapshotRootfsFile)) {
+		t.Fatal("export must include the rootfs")
+	}
+
+	if err := dest.ImportSnapshot(svc.Name, shared); err != nil {
+		t.Fatal(err)
+	}
+	startAndWait(t, dest, svc)
+	if got := restoredFrom(dest, svc.Name); got == "" {
+		t.Fatal("imported service was not restored from the snapshot")
+	}
+}
+
+func TestPruneSnapshotsKeepsOnlyDesiredSnapshotServices(t *testing.T) {
+	m := NewManager("/bin/false", t.TempDir(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, name := range []string{"keep", "gone", "off", "keep.partial"} {
+		if err := os.MkdirAll(m.snapshotDir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.PruneSnapshots([]config.ServiceConfig{{Name: "keep", Snapshot: true}, {Name: "off"}})
+
+	for name, want := range map[string]bool{"keep": true, "gone": false, "off": false, "keep.partial": false} {
+		if got := fileExists(m.snapshotDir(name)); got != want {
+			t.Errorf("snapshot %s exists = %v, want %v", name, got, want)
+		}
+	}
+}